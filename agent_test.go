@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A fakeAgent is a minimal SNMP v1 and v2c agent for tests, answering GET,
+// GETNEXT and GETBULK requests for integer objects.
+type fakeAgent struct {
+	conn *net.UDPConn
+	oids []string
+
+	mtx    sync.Mutex
+	values map[string]int
+	// GETBULK requests for more repetitions than this are answered with
+	// tooBig, or as overMax says: not at all if "drop", or with only this
+	// many repetitions if "truncate". 0 for no limit.
+	maxRepetitions int
+	overMax        string
+	// Requests for OIDs in this subtree aren't answered, if set.
+	unanswered string
+	// How long to take to answer each request.
+	delay time.Duration
+	// OIDs wrongly returned after others by GETBULK, out of order.
+	extra map[string]string
+	// GET requests are answered with this error status, if set.
+	errorStatus int
+	// How many of the next requests not to answer.
+	lose     int
+	requests int
+}
+
+func newFakeAgent(t *testing.T, values map[string]int) *fakeAgent {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &fakeAgent{conn: conn, values: values}
+	for oid := range values {
+		a.oids = append(a.oids, oid)
+	}
+	sort.Slice(a.oids, func(i, j int) bool {
+		return oidLess(oidToList(a.oids[i]), oidToList(a.oids[j]))
+	})
+	go a.serve()
+	return a
+}
+
+func (a *fakeAgent) addr() string {
+	return a.conn.LocalAddr().String()
+}
+
+func (a *fakeAgent) close() {
+	a.conn.Close()
+}
+
+func (a *fakeAgent) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if resp := a.respond(buf[:n]); resp != nil {
+			a.conn.WriteToUDP(resp, from)
+		}
+	}
+}
+
+func (a *fakeAgent) respond(req []byte) []byte {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	time.Sleep(a.delay)
+	a.requests++
+	if a.lose > 0 {
+		a.lose--
+		return nil
+	}
+	_, msg, _ := berRead(req)
+	_, version, rest := berRead(msg)
+	_, community, rest := berRead(rest)
+	pduType, pdu, _ := berRead(rest)
+	_, reqID, rest := berRead(pdu)
+	// Non-repeaters aren't supported.
+	_, _, rest = berRead(rest)
+	_, maxRepetitions, rest := berRead(rest)
+	_, varbinds, _ := berRead(rest)
+	var oids []string
+	for len(varbinds) > 0 {
+		var varbind []byte
+		_, varbind, varbinds = berRead(varbinds)
+		_, oid, _ := berRead(varbind)
+		oids = append(oids, berDecodeOid(oid))
+	}
+
+	for _, oid := range oids {
+		if a.unanswered != "" && strings.HasPrefix(oid+".", a.unanswered+".") {
+			return nil
+		}
+	}
+
+	errorStatus := 0
+	var out []string
+	switch pduType {
+	case 0xa0: // GetRequest
+		out = oids
+		if a.errorStatus != 0 {
+			errorStatus = a.errorStatus
+		} else if berDecodeInt(version) == 0 {
+			// SNMPv1 has no noSuchObject, the request fails with noSuchName.
+			for _, oid := range oids {
+				if _, ok := a.values[oid]; !ok {
+					errorStatus = 2
+				}
+			}
+		}
+	case 0xa1: // GetNextRequest
+		for _, oid := range oids {
+			out = append(out, a.next(oid))
+		}
+	case 0xa5: // GetBulkRequest
+		reps := berDecodeInt(maxRepetitions)
+		if a.maxRepetitions > 0 && reps > a.maxRepetitions {
+			switch a.overMax {
+			case "drop":
+				return nil
+			case "truncate":
+				reps = a.maxRepetitions
+			default:
+				// With the varbinds of the request, as gosnmp ignores
+				// responses without any.
+				errorStatus = 1
+				out = oids
+				reps = 0
+			}
+		}
+		oid := oids[0]
+		for i := 0; i < reps; i++ {
+			oid = a.next(oid)
+			out = append(out, oid)
+			if oid == "" {
+				break
+			}
+			if e, ok := a.extra[oid]; ok {
+				out = append(out, e)
+			}
+		}
+	}
+
+	var vbs bytes.Buffer
+	for _, oid := range out {
+		var vb bytes.Buffer
+		if oid == "" {
+			// endOfMibView, after the last OID.
+			vb.Write(berTLV(0x06, berEncodeOid(oids[len(oids)-1])))
+			vb.Write([]byte{0x82, 0x00})
+		} else if v, ok := a.values[oid]; ok {
+			vb.Write(berTLV(0x06, berEncodeOid(oid)))
+			vb.Write(berTLV(0x02, berEncodeInt(v)))
+		} else {
+			// noSuchObject.
+			vb.Write(berTLV(0x06, berEncodeOid(oid)))
+			vb.Write([]byte{0x80, 0x00})
+		}
+		vbs.Write(berTLV(0x30, vb.Bytes()))
+	}
+	var resp bytes.Buffer
+	resp.Write(berTLV(0x02, reqID))
+	resp.Write(berTLV(0x02, berEncodeInt(errorStatus)))
+	resp.Write(berTLV(0x02, berEncodeInt(0)))
+	resp.Write(berTLV(0x30, vbs.Bytes()))
+	var m bytes.Buffer
+	m.Write(berTLV(0x02, version))
+	m.Write(berTLV(0x04, community))
+	m.Write(berTLV(0xa2, resp.Bytes()))
+	return berTLV(0x30, m.Bytes())
+}
+
+// The OID after this one, or "" if there's none.
+func (a *fakeAgent) next(oid string) string {
+	list := oidToList(oid)
+	for _, o := range a.oids {
+		if oidLess(list, oidToList(o)) {
+			return o
+		}
+	}
+	return ""
+}
+
+func berRead(b []byte) (byte, []byte, []byte) {
+	if len(b) < 2 {
+		return 0, nil, nil
+	}
+	length, header := int(b[1]), 2
+	if b[1]&0x80 != 0 {
+		octets := int(b[1] & 0x7f)
+		length = 0
+		for _, o := range b[2 : 2+octets] {
+			length = length<<8 | int(o)
+		}
+		header += octets
+	}
+	return b[0], b[header : header+length], b[header+length:]
+}
+
+func berTLV(tag byte, value []byte) []byte {
+	var b bytes.Buffer
+	b.WriteByte(tag)
+	if len(value) < 0x80 {
+		b.WriteByte(byte(len(value)))
+	} else {
+		b.Write([]byte{0x82, byte(len(value) >> 8), byte(len(value))})
+	}
+	b.Write(value)
+	return b.Bytes()
+}
+
+func berEncodeInt(v int) []byte {
+	b := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func berDecodeInt(b []byte) int {
+	v := 0
+	for _, o := range b {
+		v = v<<8 | int(o)
+	}
+	return v
+}
+
+func berEncodeOid(oid string) []byte {
+	list := oidToList(strings.TrimPrefix(oid, "."))
+	b := []byte{byte(list[0]*40 + list[1])}
+	for _, o := range list[2:] {
+		enc := []byte{byte(o & 0x7f)}
+		for o >>= 7; o > 0; o >>= 7 {
+			enc = append([]byte{byte(o&0x7f) | 0x80}, enc...)
+		}
+		b = append(b, enc...)
+	}
+	return b
+}
+
+func berDecodeOid(b []byte) string {
+	parts := []string{strconv.Itoa(int(b[0]) / 40), strconv.Itoa(int(b[0]) % 40)}
+	v := 0
+	for _, o := range b[1:] {
+		v = v<<7 | int(o&0x7f)
+		if o&0x80 == 0 {
+			parts = append(parts, strconv.Itoa(v))
+			v = 0
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+func TestFakeAgent(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100, "1.3.6.1.2.1.2.2.1.1.1": 1, "1.3.6.1.2.1.2.2.1.1.2": 2})
+	defer a.close()
+	if got := fmt.Sprint(a.oids); got != "[1.3.6.1.2.1.1.3.0 1.3.6.1.2.1.2.2.1.1.1 1.3.6.1.2.1.2.2.1.1.2]" {
+		t.Errorf("Wrong OIDs: %s", got)
+	}
+	if got := berDecodeOid(berEncodeOid("1.3.6.1.4.1.9999.300")); got != "1.3.6.1.4.1.9999.300" {
+		t.Errorf("Wrong OID round trip: %s", got)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+// Scrapes of modules with a scrape_cache_ttl, shared by the scrapes of the
+// same target and module until it expires.
+var scrapes = newScrapeCache()
+
+// A scrapeCache keeps the PDUs of scrapes, so that a device which can only
+// be polled every few minutes can be scraped more often, such as by several
+// Prometheus servers. Concurrent scrapes wait for the one in progress rather
+// than all polling the device.
+type scrapeCache struct {
+	now func() time.Time
+
+	mtx     sync.Mutex
+	entries map[string]*cachedScrape
+}
+
+type cachedScrape struct {
+	ttl   time.Duration
+	pdus  []gosnmp.SnmpPDU
+	stats *scrapeStats
+	err   error
+	time  time.Time
+	// Closed once the scrape is done.
+	done chan struct{}
+}
+
+func newScrapeCache() *scrapeCache {
+	return &scrapeCache{
+		now:     time.Now,
+		entries: map[string]*cachedScrape{},
+	}
+}
+
+// Get the PDUs and stats of a scrape with the key, scraping if there's none
+// cached within the TTL. Also returns how long ago the scrape was. Errors are
+// cached too, so that a struggling device isn't polled more often.
+func (c *scrapeCache) get(key string, ttl time.Duration, scrape func() ([]gosnmp.SnmpPDU, *scrapeStats, error)) ([]gosnmp.SnmpPDU, *scrapeStats, time.Duration, error) {
+	c.mtx.Lock()
+	now := c.now()
+	for k, e := range c.entries {
+		select {
+		case <-e.done:
+			if now.Sub(e.time) >= e.ttl {
+				delete(c.entries, k)
+			}
+		default:
+			// Still scraping.
+		}
+	}
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cachedScrape{ttl: ttl, done: make(chan struct{})}
+		c.entries[key] = e
+		c.mtx.Unlock()
+		pdus, stats, err := scrape()
+		c.mtx.Lock()
+		e.pdus, e.stats, e.err, e.time = pdus, stats, err, c.now()
+		close(e.done)
+		c.mtx.Unlock()
+		return pdus, stats, 0, err
+	}
+	c.mtx.Unlock()
+	<-e.done
+	return e.pdus, e.stats, c.now().Sub(e.time), e.err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soniah/gosnmp"
+)
+
+func TestScrapeCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	var mtx sync.Mutex
+	c := newScrapeCache()
+	c.now = func() time.Time {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return now
+	}
+	scrapes := 0
+	scrape := func() ([]gosnmp.SnmpPDU, *scrapeStats, error) {
+		scrapes++
+		return []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: scrapes}}, newScrapeStats(), nil
+	}
+
+	pdus, _, age, err := c.get("a", time.Minute, scrape)
+	if err != nil || len(pdus) != 1 || age != 0 {
+		t.Fatalf("Wrong first scrape: %v %s %v", pdus, age, err)
+	}
+	mtx.Lock()
+	now = now.Add(30 * time.Second)
+	mtx.Unlock()
+	pdus, _, age, _ = c.get("a", time.Minute, scrape)
+	if scrapes != 1 || pdus[0].Value != 1 || age != 30*time.Second {
+		t.Errorf("Cached scrape not used: %v %s", pdus, age)
+	}
+	// Other targets and modules have their own.
+	c.get("b", time.Minute, scrape)
+	if scrapes != 2 {
+		t.Errorf("Cached scrape used for another key")
+	}
+
+	// Scraped again once expired.
+	mtx.Lock()
+	now = now.Add(time.Minute)
+	mtx.Unlock()
+	pdus, _, age, _ = c.get("a", time.Minute, scrape)
+	if scrapes != 3 || pdus[0].Value != 3 || age != 0 {
+		t.Errorf("Expired scrape used: %v %s", pdus, age)
+	}
+
+	// Errors are cached too.
+	failed := func() ([]gosnmp.SnmpPDU, *scrapeStats, error) {
+		scrapes++
+		return nil, nil, fmt.Errorf("timeout")
+	}
+	c.get("c", time.Minute, failed)
+	if _, _, _, err := c.get("c", time.Minute, failed); err == nil || scrapes != 4 {
+		t.Errorf("Failed scrape not cached: %v", err)
+	}
+}
+
+func TestScrapeCacheConcurrent(t *testing.T) {
+	c := newScrapeCache()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	scrapes := 0
+	scrape := func() ([]gosnmp.SnmpPDU, *scrapeStats, error) {
+		scrapes++
+		close(started)
+		<-release
+		return []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: 1}}, newScrapeStats(), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]gosnmp.SnmpPDU, 3)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _, _, _ = c.get("a", time.Minute, scrape)
+	}()
+	<-started
+	// Scrapes while the first is in progress wait for it.
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, _, _ = c.get("a", time.Minute, scrape)
+		}(i)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if scrapes != 1 {
+		t.Errorf("Expected 1 scrape, got %d", scrapes)
+	}
+	for i, pdus := range results {
+		if len(pdus) != 1 {
+			t.Errorf("Wrong PDUs for scrape %d: %v", i, pdus)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/soniah/gosnmp"
 
 	"github.com/prometheus/snmp_exporter/config"
@@ -36,55 +44,496 @@ func oidToList(oid string) []int {
 	return result
 }
 
-func ScrapeTarget(target string, config *config.Module) ([]gosnmp.SnmpPDU, error) {
-	// Set the options.
-	snmp := gosnmp.GoSNMP{}
-	snmp.MaxRepetitions = config.WalkParams.MaxRepetitions
-	// User specifies timeout of each retry attempt but GoSNMP expects total timeout for all attemtps.
-	snmp.Retries = config.WalkParams.Retries
-	snmp.Timeout = config.WalkParams.Timeout * time.Duration(snmp.Retries)
-
-	snmp.Target = target
-	snmp.Port = 161
-	if host, port, err := net.SplitHostPort(target); err == nil {
-		snmp.Target = host
-		p, err := strconv.Atoi(port)
-		if err != nil {
-			return nil, fmt.Errorf("Error converting port number to int for target %s: %s", target, err)
+// Whether an OID is before another, in the order of a walk.
+func oidLess(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
 		}
-		snmp.Port = uint16(p)
 	}
+	return len(a) < len(b)
+}
+
+// Returned by walks cut off by the walk_budget of the module, along with
+// what they fetched before.
+var errWalkBudget = errors.New("walk budget exceeded")
+
+// A walkBudgetError is returned by a scrape which completed, but with walks
+// cut off by the walk_budget of the module.
+type walkBudgetError struct {
+	subtrees []string
+}
+
+func (e walkBudgetError) Error() string {
+	return fmt.Sprintf("walks of %s exceeded the walk budget", strings.Join(e.subtrees, ", "))
+}
+
+// The usmStats counters an SNMP v3 agent reports when the engine of a
+// request is out of date.
+const (
+	usmStatsNotInTimeWindowsOid = ".1.3.6.1.6.3.15.1.1.2.0"
+	usmStatsUnknownEngineIDsOid = ".1.3.6.1.6.3.15.1.1.4.0"
+)
 
-	// Configure auth.
-	config.WalkParams.ConfigureSNMP(&snmp)
+// An engineReportError is returned by a scrape which the agent answered
+// with a report that the engine of the session is out of date, such as
+// after the agent restarted with a new engine. gosnmp has already retried
+// the request with the reported engine by then.
+type engineReportError struct {
+	target string
+	oid    string
+}
+
+func (e engineReportError) Error() string {
+	return fmt.Sprintf("Error scraping target %s: engine out of date, reported %s", e.target, e.oid)
+}
 
-	// Do the actual walk.
-	err := snmp.Connect()
+// An engineReportError if the response is a report that the engine of the
+// session is out of date.
+func checkEngineReport(snmp *gosnmp.GoSNMP, packet *gosnmp.SnmpPacket) error {
+	if packet.PDUType != gosnmp.Report || len(packet.Variables) != 1 {
+		return nil
+	}
+	switch oid := packet.Variables[0].Name; oid {
+	case usmStatsNotInTimeWindowsOid, usmStatsUnknownEngineIDsOid:
+		return engineReportError{target: snmp.Target, oid: oid}
+	}
+	return nil
+}
+
+// Scrape the target. If there's an error partway, the PDUs fetched before
+// it are also returned.
+func ScrapeTarget(target string, config *config.Module) ([]gosnmp.SnmpPDU, error) {
+	return scrapeTarget(target, config, newScrapeStats())
+}
+
+// Scrape the target, counting what's sent and received in the stats.
+func scrapeTarget(target string, config *config.Module, stats *scrapeStats) ([]gosnmp.SnmpPDU, error) {
+	release, err := targetLimits.acquire(target)
 	if err != nil {
-		return nil, fmt.Errorf("Error connecting to target %s: %s", target, err)
+		return nil, err
+	}
+	defer release()
+	snmp, key, err := sessions.get(target, config)
+	if err != nil {
+		return nil, err
+	}
+	conn := sessionStatsConn(snmp)
+	if conn != nil {
+		conn.stats = stats
+	}
+	result, err := scrapeSession(snmp, config, key)
+	if conn != nil {
+		conn.stats = nil
+	}
+	sessions.put(key, snmp, err)
+	return result, err
+}
+
+// The cache TTLs of the lookups of a module, by OID.
+func lookupCacheTTLs(module *config.Module) map[string]time.Duration {
+	ttls := map[string]time.Duration{}
+	var add func(lookups []*config.Lookup)
+	add = func(lookups []*config.Lookup) {
+		for _, lookup := range lookups {
+			if ttl, ok := ttls[lookup.Oid]; lookup.CacheTTL > 0 && (!ok || lookup.CacheTTL < ttl) {
+				ttls[lookup.Oid] = lookup.CacheTTL
+			}
+			add(lookup.Lookups)
+		}
+	}
+	for _, metric := range module.Metrics {
+		add(metric.Lookups)
+	}
+	return ttls
+}
+
+// Scrape the target with a session, which has the key in the session pool.
+func scrapeSession(snmp *gosnmp.GoSNMP, config *config.Module, key string) ([]gosnmp.SnmpPDU, error) {
+	// Work out which rows of the filtered tables to keep.
+	allowedOids, filterWalks, err := filterAllowedOids(snmp, config)
+	if err != nil {
+		return nil, err
+	}
+	// The filter column an OID is in, if any, whose walk is reused.
+	filterColumn := func(oid string) (string, bool) {
+		for column := range filterWalks {
+			if oid == column || strings.HasPrefix(oid, column+".") {
+				return column, true
+			}
+		}
+		return "", false
 	}
-	defer snmp.Conn.Close()
 
 	result := []gosnmp.SnmpPDU{}
+	getOids := []string{}
+	cutOff := []string{}
+	cacheTTLs := lookupCacheTTLs(config)
 	for _, subtree := range config.Walk {
-		var pdus []gosnmp.SnmpPDU
-		log.Debugf("Walking target %q subtree %q", snmp.Target, subtree)
-		walkStart := time.Now()
-		if snmp.Version == gosnmp.Version1 {
-			pdus, err = snmp.WalkAll(subtree)
-		} else {
-			pdus, err = snmp.BulkWalkAll(subtree)
+		if oids, ok := allowedOids[subtree]; ok {
+			// Only get the allowed rows, rather than walking the whole table.
+			// The OIDs are sorted, so those of a column are together.
+			reused := []string{}
+			for _, oid := range oids {
+				if column, ok := filterColumn(oid); ok {
+					if len(reused) == 0 || reused[len(reused)-1] != column {
+						reused = append(reused, column)
+					}
+					continue
+				}
+				getOids = append(getOids, oid)
+			}
+			for _, column := range reused {
+				result = append(result, filterWalks[column]...)
+			}
+			continue
+		}
+		if pdus, ok := filterWalks[subtree]; ok {
+			result = append(result, pdus...)
+			continue
+		}
+		ttl, cache := cacheTTLs[subtree]
+		if cache {
+			if pdus, ok := sessions.cachedWalk(key, subtree); ok {
+				log.Debugf("Using cached walk of target %q subtree %q", snmp.Target, subtree)
+				result = append(result, pdus...)
+				continue
+			}
+		}
+		var deadline time.Time
+		if config.WalkBudget > 0 {
+			deadline = time.Now().Add(config.WalkBudget)
+		}
+		pdus, err := walkSubtree(snmp, subtree, deadline, config.AllowNonIncreasingOids)
+		if err == errWalkBudget {
+			cutOff = append(cutOff, subtree)
+		} else if err != nil {
+			return filterPdus(result, allowedOids), err
+		} else if cache {
+			sessions.cacheWalk(key, subtree, pdus, ttl)
+		}
+		result = append(result, pdus...)
+	}
+	result = filterPdus(result, allowedOids)
+
+	// Scalars are fetched with GET, rather than walked.
+	getOids = append(getOids, config.Get...)
+	if config.DetectReboots {
+		getUptime := true
+		for _, oid := range config.Get {
+			if oid == sysUpTimeOid {
+				getUptime = false
+			}
 		}
+		if getUptime {
+			getOids = append(getOids, sysUpTimeOid)
+		}
+	}
+	pdus, err := getOidsInChunks(snmp, getOids)
+	result = append(result, pdus...)
+	if err == nil && len(cutOff) > 0 {
+		err = walkBudgetError{subtrees: cutOff}
+	}
+	return result, err
+}
+
+// Walk a subtree. With a deadline, the walk is cut off once it has passed,
+// returning what was walked before along with errWalkBudget.
+func walkSubtree(snmp *gosnmp.GoSNMP, subtree string, deadline time.Time, allowNonIncreasing bool) ([]gosnmp.SnmpPDU, error) {
+	var pdus []gosnmp.SnmpPDU
+	var err error
+	log.Debugf("Walking target %q subtree %q", snmp.Target, subtree)
+	walkStart := time.Now()
+	stats := sessionStats(snmp)
+	defer func() {
+		stats.walkDurations[subtree] = time.Since(walkStart)
+	}()
+	if snmp.Version == gosnmp.Version1 {
+		pdus = []gosnmp.SnmpPDU{}
+		err = snmp.Walk(subtree, func(pdu gosnmp.SnmpPDU) error {
+			stats.varbinds++
+			pdus = append(pdus, pdu)
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return errWalkBudget
+			}
+			return nil
+		})
+	} else {
+		pdus, err = bulkWalk(snmp, subtree, deadline, allowNonIncreasing)
+	}
+	if err == errWalkBudget {
+		log.Infof("Walk of target %q subtree %q cut off by the walk budget after %d PDUs", snmp.Target, subtree, len(pdus))
+		return pdus, err
+	}
+	if _, ok := err.(engineReportError); ok {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error walking target %s: %s", snmp.Target, err)
+	}
+	log.Debugf("Walk of target %q subtree %q completed in %s", snmp.Target, subtree, time.Since(walkStart))
+	return pdus, nil
+}
+
+// Walk a subtree with GETBULK. When the agent can't answer a request in
+// full, as the response would be tooBig or it's truncated or empty,
+// max_repetitions is halved for the rest of the walk and later walks with
+// the session. Timeouts and other errors are left to the retries of the
+// session, as a lost request says nothing of the size of the response.
+// The walk is cut off once past the deadline, if
+// there is one. Varbinds whose OID isn't after the previous are skipped if
+// allowed, otherwise they fail the walk.
+func bulkWalk(snmp *gosnmp.GoSNMP, subtree string, deadline time.Time, allowNonIncreasing bool) ([]gosnmp.SnmpPDU, error) {
+	root := "." + strings.TrimPrefix(subtree, ".")
+	oid := root
+	last := oidToList(root[1:])
+	result := []gosnmp.SnmpPDU{}
+	stats := sessionStats(snmp)
+	for requests := 1; ; requests++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return result, errWalkBudget
+		}
+		packet, err := snmp.GetBulk([]string{oid}, 0, snmp.MaxRepetitions)
 		if err != nil {
-			return nil, fmt.Errorf("Error walking target %s: %s", snmp.Target, err)
-		} else {
-			log.Debugf("Walk of target %q subtree %q completed in %s", snmp.Target, subtree, time.Since(walkStart))
+			return nil, err
+		}
+		stats.response(packet)
+		if err := checkEngineReport(snmp, packet); err != nil {
+			return nil, err
+		}
+		repetitions := snmp.MaxRepetitions
+		if (packet.Error == gosnmp.TooBig || len(packet.Variables) == 0) && repetitions > 1 {
+			reduceMaxRepetitions(snmp)
+			requests--
+			continue
+		}
+		if packet.Error == gosnmp.TooBig {
+			return nil, fmt.Errorf("response to GETBULK of one repetition would be too big")
+		}
+		if len(packet.Variables) == 0 {
+			return result, nil
+		}
+		next := ""
+		for i, pdu := range packet.Variables {
+			if pdu.Type == gosnmp.EndOfMibView || pdu.Type == gosnmp.NoSuchObject || pdu.Type == gosnmp.NoSuchInstance {
+				return result, nil
+			}
+			if !strings.HasPrefix(pdu.Name, root+".") {
+				if requests == 1 && i == 0 {
+					// The subtree is a single object, such as sysUpTime.0.
+					return getOidsInChunks(snmp, []string{root[1:]})
+				}
+				return result, nil
+			}
+			if list := oidToList(pdu.Name[1:]); oidLess(last, list) {
+				last = list
+			} else if allowNonIncreasing {
+				log.Debugf("Skipping OID %s from target %q, which isn't after the previous", pdu.Name, snmp.Target)
+				snmpOidsNotIncreasing.Inc()
+				continue
+			} else {
+				return nil, fmt.Errorf("OID not increasing: %s", pdu.Name)
+			}
+			result = append(result, pdu)
+			next = pdu.Name
 		}
+		if next == "" {
+			// Walking on from an earlier OID could loop forever.
+			return nil, fmt.Errorf("OID not increasing: %s", packet.Variables[len(packet.Variables)-1].Name)
+		}
+		if len(packet.Variables) < int(repetitions) && repetitions > 1 {
+			// Truncated before the end of the subtree.
+			reduceMaxRepetitions(snmp)
+		}
+		oid = next
+	}
+}
+
+func reduceMaxRepetitions(snmp *gosnmp.GoSNMP) {
+	snmp.MaxRepetitions /= 2
+	log.Debugf("Reduced max_repetitions for target %q to %d", snmp.Target, snmp.MaxRepetitions)
+}
+
+// Get the given OIDs, in as few requests as the agent allows. On an error
+// those got before it are returned.
+func getOidsInChunks(snmp *gosnmp.GoSNMP, oids []string) ([]gosnmp.SnmpPDU, error) {
+	result := []gosnmp.SnmpPDU{}
+	for len(oids) > 0 {
+		chunk := oids
+		if len(chunk) > snmp.MaxOids {
+			chunk = oids[:snmp.MaxOids]
+		}
+		oids = oids[len(chunk):]
+		pdus, err := getOids(snmp, chunk)
 		result = append(result, pdus...)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Get a chunk of OIDs. On an error those got before it are returned.
+func getOids(snmp *gosnmp.GoSNMP, chunk []string) ([]gosnmp.SnmpPDU, error) {
+	log.Debugf("Getting %d OIDs from target %q", len(chunk), snmp.Target)
+	packet, err := snmp.Get(chunk)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting target %s: %s", snmp.Target, err)
+	}
+	sessionStats(snmp).response(packet)
+	if err := checkEngineReport(snmp, packet); err != nil {
+		return nil, err
+	}
+	if packet.Error == gosnmp.NoSuchName {
+		// SNMPv1 fails the whole request if any OID is missing. Those
+		// that are missing are found by getting them one at a time, and
+		// kept as noSuchObject for the missing_objects policy.
+		if len(chunk) == 1 {
+			return []gosnmp.SnmpPDU{{Name: "." + chunk[0], Type: gosnmp.NoSuchObject}}, nil
+		}
+		log.Debugf("Target %q has no such name for one of %d OIDs, getting them one at a time", snmp.Target, len(chunk))
+		result := []gosnmp.SnmpPDU{}
+		for _, oid := range chunk {
+			pdus, err := getOids(snmp, []string{oid})
+			result = append(result, pdus...)
+			if err != nil {
+				return result, err
+			}
+		}
+		return result, nil
+	}
+	if packet.Error != gosnmp.NoError {
+		return nil, fmt.Errorf("Error getting target %s: %s", snmp.Target, errorStatusName(packet.Error))
+	}
+	result := []gosnmp.SnmpPDU{}
+	for _, pdu := range packet.Variables {
+		// Missing objects are kept, for the collector to apply the
+		// missing_objects policy to.
+		if pdu.Type == gosnmp.EndOfMibView {
+			continue
+		}
+		result = append(result, pdu)
 	}
 	return result, nil
 }
 
+// Apply the dynamic filters of the module.
+//
+// Returns a map from each filter target to the OIDs of the metrics below
+// the target for the rows that passed all the filters on that target, and
+// the walks of the filter columns by OID, to reuse rather than fetching
+// them again.
+func filterAllowedOids(snmp *gosnmp.GoSNMP, module *config.Module) (map[string][]string, map[string][]gosnmp.SnmpPDU, error) {
+	targetIndexes := map[string]map[string]struct{}{}
+	restrict := func(targets []string, indexes map[string]struct{}) {
+		for _, t := range targets {
+			prev, ok := targetIndexes[t]
+			if !ok {
+				targetIndexes[t] = indexes
+				continue
+			}
+			// Rows must pass all the filters on a target.
+			both := map[string]struct{}{}
+			for i := range indexes {
+				if _, ok := prev[i]; ok {
+					both[i] = struct{}{}
+				}
+			}
+			targetIndexes[t] = both
+		}
+	}
+	// Static filters need no walk, their rows are known up front.
+	for _, filter := range module.Filters.Static {
+		indexes := map[string]struct{}{}
+		for _, i := range filter.Indices {
+			indexes[i] = struct{}{}
+		}
+		restrict(filter.Targets, indexes)
+	}
+	walks := map[string][]gosnmp.SnmpPDU{}
+	for _, filter := range module.Filters.Dynamic {
+		pdus, ok := walks[filter.Oid]
+		if !ok {
+			var err error
+			pdus, err = walkSubtree(snmp, filter.Oid, time.Time{}, module.AllowNonIncreasingOids)
+			if err != nil {
+				return nil, nil, err
+			}
+			walks[filter.Oid] = pdus
+		}
+		typ := ""
+		for _, metric := range module.Metrics {
+			if metric.Oid == filter.Oid {
+				typ = metric.Type
+			}
+		}
+		indexes := map[string]struct{}{}
+		for _, pdu := range pdus {
+			value := pduValueAsString(&pdu, typ)
+			for _, v := range filter.Values {
+				if v == value {
+					indexes[strings.TrimPrefix(pdu.Name[1:], filter.Oid+".")] = struct{}{}
+					break
+				}
+			}
+		}
+		log.Debugf("Filter on %s of target %q allowed %d of %d rows", filter.Oid, snmp.Target, len(indexes), len(pdus))
+		restrict(filter.Targets, indexes)
+	}
+
+	// Lookups may also be below a target.
+	columns := map[string]struct{}{}
+	for _, metric := range module.Metrics {
+		columns[metric.Oid] = struct{}{}
+		for _, lookup := range metric.Lookups {
+			columns[lookup.Oid] = struct{}{}
+		}
+	}
+
+	allowedOids := map[string][]string{}
+	for t, indexes := range targetIndexes {
+		oids := []string{}
+		for column := range columns {
+			if column != t && !strings.HasPrefix(column, t+".") {
+				continue
+			}
+			for i := range indexes {
+				oids = append(oids, column+"."+i)
+			}
+		}
+		sort.Strings(oids)
+		allowedOids[t] = oids
+	}
+	return allowedOids, walks, nil
+}
+
+// Drop walked PDUs of filtered targets which are not in an allowed row.
+func filterPdus(pdus []gosnmp.SnmpPDU, allowedOids map[string][]string) []gosnmp.SnmpPDU {
+	if len(allowedOids) == 0 {
+		return pdus
+	}
+	allowed := map[string]struct{}{}
+	for _, oids := range allowedOids {
+		for _, oid := range oids {
+			allowed[oid] = struct{}{}
+		}
+	}
+	result := make([]gosnmp.SnmpPDU, 0, len(pdus))
+PduLoop:
+	for _, pdu := range pdus {
+		oid := pdu.Name[1:]
+		for t := range allowedOids {
+			if oid == t || strings.HasPrefix(oid, t+".") {
+				if _, ok := allowed[oid]; !ok {
+					continue PduLoop
+				}
+			}
+		}
+		result = append(result, pdu)
+	}
+	return result
+}
+
 type MetricNode struct {
 	metric *config.Metric
 
@@ -108,8 +557,94 @@ func buildMetricTree(metrics []*config.Metric) *MetricNode {
 	return metricTree
 }
 
+// Find the metric an OID is an instance of, if any.
+func findMetric(metricTree *MetricNode, oidList []int) *config.Metric {
+	head := metricTree
+	for _, o := range oidList {
+		var ok bool
+		head, ok = head.children[o]
+		if !ok {
+			return nil
+		}
+		if head.metric != nil {
+			return head.metric
+		}
+	}
+	return nil
+}
+
+// Remove the PDUs for objects the target has no such object or instance
+// for, applying the missing_objects policy of their metric or module.
+// Returns the remaining PDUs, and the OIDs to report as missing.
+func missingObjects(pdus []gosnmp.SnmpPDU, metricTree *MetricNode, module *config.Module) ([]gosnmp.SnmpPDU, []string, error) {
+	result := make([]gosnmp.SnmpPDU, 0, len(pdus))
+	missing := []string{}
+	for _, pdu := range pdus {
+		if pdu.Type != gosnmp.NoSuchObject && pdu.Type != gosnmp.NoSuchInstance {
+			result = append(result, pdu)
+			continue
+		}
+		oid := strings.TrimPrefix(pdu.Name, ".")
+		policy := module.MissingObjects
+		if metric := findMetric(metricTree, oidToList(oid)); metric != nil && metric.MissingObjects != "" {
+			policy = metric.MissingObjects
+		}
+		switch policy {
+		case "metric":
+			missing = append(missing, oid)
+		case "fail":
+			return nil, nil, fmt.Errorf("target has no such object %s", oid)
+		}
+	}
+	return result, missing, nil
+}
+
+// Apply the max_series of the metrics, or of the module. The rows of a
+// metric beyond its limit are dropped, keeping those first in OID order so
+// that the same rows are kept from scrape to scrape. Returns the OIDs of
+// the dropped rows, and the metrics which exceeded their limit.
+func limitSeries(oidToPdu map[string]gosnmp.SnmpPDU, metricTree *MetricNode, module *config.Module) (map[string]struct{}, []*config.Metric) {
+	type row struct {
+		oid     string
+		oidList []int
+	}
+	maxSeries := func(metric *config.Metric) int {
+		if metric.MaxSeries > 0 {
+			return metric.MaxSeries
+		}
+		return module.MaxSeries
+	}
+	rows := map[*config.Metric][]row{}
+	for oid := range oidToPdu {
+		oidList := oidToList(oid)
+		if metric := findMetric(metricTree, oidList); metric != nil && maxSeries(metric) > 0 {
+			rows[metric] = append(rows[metric], row{oid: oid, oidList: oidList})
+		}
+	}
+
+	dropped := map[string]struct{}{}
+	exceeded := []*config.Metric{}
+	for _, metric := range module.Metrics {
+		r := rows[metric]
+		max := maxSeries(metric)
+		if len(r) <= max {
+			continue
+		}
+		sort.Slice(r, func(i, j int) bool {
+			return oidLess(r[i].oidList, r[j].oidList)
+		})
+		for _, dropRow := range r[max:] {
+			dropped[dropRow.oid] = struct{}{}
+		}
+		exceeded = append(exceeded, metric)
+	}
+	return dropped, exceeded
+}
+
 type collector struct {
 	target string
+	// The name of the module, for caching scrapes of it.
+	name   string
 	module *config.Module
 }
 
@@ -121,7 +656,43 @@ func (c collector) Describe(ch chan<- *prometheus.Desc) {
 // Collect implements Prometheus.Collector.
 func (c collector) Collect(ch chan<- prometheus.Metric) {
 	start := time.Now()
-	pdus, err := ScrapeTarget(c.target, c.module)
+	scrape := func() ([]gosnmp.SnmpPDU, *scrapeStats, error) {
+		stats := newScrapeStats()
+		pdus, err := scrapeTarget(c.target, c.module, stats)
+		stats.record(c.name)
+		return pdus, stats, err
+	}
+	var pdus []gosnmp.SnmpPDU
+	var stats *scrapeStats
+	var err error
+	if c.module.ScrapeCacheTTL > 0 {
+		// Scrapes with other auths or contexts don't share the cache.
+		key := sessionKey(c.target+" "+c.name, c.module.WalkParams)
+		var age time.Duration
+		pdus, stats, age, err = scrapes.get(key, c.module.ScrapeCacheTTL, scrape)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_cache_age_seconds", "How long ago the cached SNMP scrape of the target was.", nil, nil),
+			prometheus.GaugeValue,
+			age.Seconds())
+	} else {
+		pdus, stats, err = scrape()
+	}
+	// Walks cut off by their budget are expected, so are always partial.
+	_, cutOff := err.(walkBudgetError)
+	if err != nil && ((!c.module.PartialResults && !cutOff) || len(pdus) == 0) {
+		log.Infof("Error scraping target %s: %s", c.target, err)
+		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error scraping target", nil, nil), err)
+		return
+	}
+	if err != nil {
+		log.Infof("Scrape of target %s incomplete, exporting the %d PDUs fetched: %s", c.target, len(pdus), err)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_partial", "Whether the scrape stopped partway, with the reason.", []string{"reason"}, nil),
+			prometheus.GaugeValue,
+			1, partialReason(err))
+	}
+	metricTree := buildMetricTree(c.module.Metrics)
+	pdus, missing, err := missingObjects(pdus, metricTree, c.module)
 	if err != nil {
 		log.Infof("Error scraping target %s: %s", c.target, err)
 		ch <- prometheus.NewInvalidMetric(prometheus.NewDesc("snmp_error", "Error scraping target", nil, nil), err)
@@ -131,19 +702,36 @@ func (c collector) Collect(ch chan<- prometheus.Metric) {
 		prometheus.NewDesc("snmp_scrape_walk_duration_seconds", "Time SNMP walk/bulkwalk took.", nil, nil),
 		prometheus.GaugeValue,
 		float64(time.Since(start).Seconds()))
+	for _, oid := range missing {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_missing_object", "Objects the target has no such object or instance for.", []string{"oid"}, nil),
+			prometheus.GaugeValue,
+			1, oid)
+	}
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc("snmp_scrape_pdus_returned", "PDUs returned from walk.", nil, nil),
 		prometheus.GaugeValue,
 		float64(len(pdus)))
+	stats.collect(ch)
+	if c.module.DetectReboots {
+		uptimes.collect(ch, c.target+" "+c.name, pdus)
+	}
 	oidToPdu := make(map[string]gosnmp.SnmpPDU, len(pdus))
 	for _, pdu := range pdus {
 		oidToPdu[pdu.Name[1:]] = pdu
 	}
+	dropped, exceeded := limitSeries(oidToPdu, metricTree, c.module)
+	for _, metric := range exceeded {
+		log.Infof("Metric %s of target %s has more rows than its max_series, dropping the rest", metric.Name, c.target)
+		snmpCardinalityExceeded.WithLabelValues(metric.Name).Inc()
+	}
 
-	metricTree := buildMetricTree(c.module.Metrics)
 	// Look for metrics that match each pdu.
 PduLoop:
 	for oid, pdu := range oidToPdu {
+		if _, ok := dropped[oid]; ok {
+			continue
+		}
 		head := metricTree
 		oidList := oidToList(oid)
 		for i, o := range oidList {
@@ -154,7 +742,7 @@ PduLoop:
 			}
 			if head.metric != nil {
 				// Found a match.
-				samples := pduToSamples(oidList[i+1:], &pdu, head.metric, oidToPdu)
+				samples := pduToSamples(oidList[i+1:], &pdu, head.metric, oidToPdu, c.module)
 				for _, sample := range samples {
 					ch <- sample
 				}
@@ -168,34 +756,183 @@ PduLoop:
 		float64(time.Since(start).Seconds()))
 }
 
+// Why a scrape stopped partway, for the reason label of snmp_scrape_partial.
+func partialReason(err error) string {
+	if _, ok := err.(walkBudgetError); ok {
+		return "walk_budget"
+	}
+	if strings.Contains(err.Error(), "timeout") {
+		return "timeout"
+	}
+	return "error"
+}
+
 func getPduValue(pdu *gosnmp.SnmpPDU) float64 {
 	switch pdu.Type {
 	case gosnmp.Counter64:
 		return float64(gosnmp.ToBigInt(pdu.Value).Uint64())
+	case gosnmp.OpaqueFloat:
+		value, ok := pdu.Value.(float32)
+		if !ok {
+			log.Debugf("Got OpaqueFloat value of %s that isn't a float: %#v", pdu.Name, pdu.Value)
+			return math.NaN()
+		}
+		return float64(value)
+	case gosnmp.OpaqueDouble:
+		value, ok := pdu.Value.(float64)
+		if !ok {
+			log.Debugf("Got OpaqueDouble value of %s that isn't a float: %#v", pdu.Name, pdu.Value)
+			return math.NaN()
+		}
+		return value
+	case gosnmp.OctetString:
+		// A number stored as a string, for metrics which had their type overridden.
+		b, _ := pdu.Value.([]byte)
+		value, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+		if err != nil {
+			log.Debugf("Error parsing float64 from OctetString value of %s: %s", pdu.Name, err)
+			return math.NaN()
+		}
+		return value
 	default:
 		return float64(gosnmp.ToBigInt(pdu.Value).Int64())
 	}
 }
 
-func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU) []prometheus.Metric {
+// Whether the labels of a sample pass the keep_if_label_matches and
+// drop_if_label_matches of the metric. A missing label has an empty value.
+func labelsMatch(labels map[string]string, metric *config.Metric) bool {
+	for name, re := range metric.KeepIfLabelMatches {
+		if !re.MatchString(labels[name]) {
+			return false
+		}
+	}
+	for name, re := range metric.DropIfLabelMatches {
+		if re.MatchString(labels[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU, module *config.Module) []prometheus.Metric {
 	// The part of the OID that is the indexes.
-	labels := indexesToLabels(indexOids, metric, oidToPdu)
+	labels := indexesToLabels(indexOids, metric, oidToPdu, module)
+	if !labelsMatch(labels, metric) {
+		return nil
+	}
+	maxLength := valueLengthLimit(metric, module)
+	if maxLength > 0 {
+		truncateLookups(labels, metric.Lookups, maxLength)
+	}
+	// Labels from the indexes take precedence over static labels, and the
+	// static labels of the metric over those of the module.
+	for _, static := range []map[string]string{metric.StaticLabels, module.StaticLabels} {
+		for k, v := range static {
+			if _, ok := labels[k]; !ok {
+				labels[k] = v
+			}
+		}
+	}
+	for _, k := range metric.DropLabels {
+		delete(labels, k)
+	}
+	if len(metric.RenameLabels) > 0 {
+		renamed := make(map[string]string, len(labels))
+		for k, v := range labels {
+			if to, ok := metric.RenameLabels[k]; ok {
+				k = to
+			} else if _, ok := renamed[k]; ok {
+				// A renamed label takes precedence over one it replaces.
+				continue
+			}
+			renamed[k] = v
+		}
+		labels = renamed
+	}
+	if len(module.RelabelConfigs) > 0 {
+		labels[model.MetricNameLabel] = metric.Name
+		if !relabel(labels, module.RelabelConfigs) {
+			return nil
+		}
+		if name := labels[model.MetricNameLabel]; name != metric.Name {
+			renamed := *metric
+			renamed.Name = name
+			metric = &renamed
+		}
+		// As in Prometheus, labels starting with __ are only for use
+		// while relabeling.
+		for k := range labels {
+			if strings.HasPrefix(k, model.ReservedLabelPrefix) {
+				delete(labels, k)
+			}
+		}
+	}
 
 	value := getPduValue(pdu)
+	if ignoreValue(metric, value) {
+		return nil
+	}
 	t := prometheus.UntypedValue
+	if pdu.Type == gosnmp.Counter64 && metric.Counter64 == "wrap" {
+		value = float64(gosnmp.ToBigInt(pdu.Value).Uint64() % maxExactFloat)
+	}
 
 	labelnames := make([]string, 0, len(labels)+1)
 	labelvalues := make([]string, 0, len(labels)+1)
-	for k, v := range labels {
+	for k := range labels {
 		labelnames = append(labelnames, k)
-		labelvalues = append(labelvalues, v)
+	}
+	// Sorted, so that the labels of a metric are always in the same order.
+	sort.Strings(labelnames)
+	for _, k := range labelnames {
+		labelvalues = append(labelvalues, sanitizeUTF8(labels[k], module.InvalidUTF8))
+	}
+
+	if pdu.Type == gosnmp.Counter64 && (metric.Counter64 == "split" || metric.Counter64 == "info") {
+		return counter64(metric, gosnmp.ToBigInt(pdu.Value).Uint64(), labelnames, labelvalues)
 	}
 
 	switch metric.Type {
 	case "counter":
 		t = prometheus.CounterValue
-	case "gauge":
+		value = clampValue(scaleValue(value, metric.Scale)+metric.Offset, metric)
+	case "gauge", "Float", "Double":
 		t = prometheus.GaugeValue
+		value = clampValue(scaleValue(value, metric.Scale)+metric.Offset, metric)
+	case "Bool":
+		// A TruthValue, where true is 1 and false is 2.
+		t = prometheus.GaugeValue
+		switch value {
+		case 1:
+		case 2:
+			value = 0
+		default:
+			log.Debugf("Invalid TruthValue %v of %s", value, pdu.Name)
+			value = math.NaN()
+		}
+	case "UptimeTimestamp":
+		// TimeTicks since boot, as the Unix time of the boot. This is
+		// rounded to the second, so that it doesn't change from scrape to
+		// scrape with the latency of requests.
+		t = prometheus.GaugeValue
+		value = math.Floor(float64(time.Now().UnixNano())/1e9 - value/100 + 0.5)
+	case "EnumAsInfo":
+		return enumAsInfo(metric, int(value), labelnames, labelvalues)
+	case "EnumAsStateSet":
+		return enumAsStateSet(metric, int(value), labelnames, labelvalues)
+	case "Bits":
+		b, _ := pdu.Value.([]byte)
+		return bits(metric, b, labelnames, labelvalues)
+	case "DateAndTime":
+		t = prometheus.GaugeValue
+		value = math.NaN()
+		b, _ := pdu.Value.([]byte)
+		if dt, err := parseDateAndTime(b); err == nil {
+			value = float64(dt.UnixNano()) / 1e9
+		} else {
+			log.Debugf("Error parsing DateAndTime value of %s: %s", pdu.Name, err)
+		}
 	default:
 		// It's some form of string.
 		t = prometheus.GaugeValue
@@ -207,7 +944,8 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 		// If the name is already an index, we do not need to set it again.
 		if _, ok := labels[metric.Name]; !ok {
 			labelnames = append(labelnames, metric.Name)
-			labelvalues = append(labelvalues, pduValueAsString(pdu, metric.Type))
+			str := sanitizeUTF8(pduValueAsLabel(pdu, metric.Type, metric.Encoding, module), module.InvalidUTF8)
+			labelvalues = append(labelvalues, truncateValue(str, maxLength))
 		}
 	}
 
@@ -215,6 +953,145 @@ func pduToSamples(indexOids []int, pdu *gosnmp.SnmpPDU, metric *config.Metric, o
 		t, value, labelvalues...)}
 }
 
+// The max_value_length of the metric, or failing that of the module, or
+// failing that --snmp.max-value-length. 0 for no limit.
+func valueLengthLimit(metric *config.Metric, module *config.Module) int {
+	if metric.MaxValueLength != nil {
+		return int(*metric.MaxValueLength)
+	}
+	if module.MaxValueLength != nil {
+		return int(*module.MaxValueLength)
+	}
+	return *maxValueLength
+}
+
+// Cut a string value to at most maxLength characters, and mark that it
+// was cut. 0 is no limit.
+func truncateValue(value string, maxLength int) string {
+	if maxLength <= 0 || len(value) <= maxLength {
+		return value
+	}
+	if r := []rune(value); len(r) > maxLength {
+		return string(r[:maxLength]) + "..."
+	}
+	return value
+}
+
+// Truncate the labels set by lookups. Indexes aren't truncated, as that
+// could make samples clash.
+func truncateLookups(labels map[string]string, lookups []*config.Lookup, maxLength int) {
+	for _, lookup := range lookups {
+		if lookup.Oid != "" {
+			if v, ok := labels[lookup.Labelname]; ok {
+				labels[lookup.Labelname] = truncateValue(v, maxLength)
+			}
+		}
+		truncateLookups(labels, lookup.Lookups, maxLength)
+	}
+}
+
+// Integers up to 2^53 fit exactly in a float64.
+const maxExactFloat = 1 << 53
+
+// Export a Counter64 without losing precision, either split into metrics of
+// its high and low 32 bits, or as the label of an info metric. The halves
+// are gauges even for counters, as the low half goes down whenever it
+// carries into the high half, which rate() would take for a reset.
+func counter64(metric *config.Metric, value uint64, labelnames, labelvalues []string) []prometheus.Metric {
+	if metric.Counter64 == "info" {
+		labelnames = append(labelnames, metric.Name)
+		labelvalues = append(labelvalues, strconv.FormatUint(value, 10))
+		return []prometheus.Metric{prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+"_info", metric.Help+" (Counter64 as info)", labelnames, nil),
+			prometheus.GaugeValue, 1.0, labelvalues...)}
+	}
+	return []prometheus.Metric{
+		prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+"_high", metric.Help+" (high 32 bits)", labelnames, nil),
+			prometheus.GaugeValue, float64(value>>32), labelvalues...),
+		prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+"_low", metric.Help+" (low 32 bits)", labelnames, nil),
+			prometheus.GaugeValue, float64(value&0xffffffff), labelvalues...),
+	}
+}
+
+// Apply relabel configs to the labels of a sample, with the metric name in
+// __name__. Returns false if the sample is to be dropped.
+func relabel(labels map[string]string, configs []*config.RelabelConfig) bool {
+	for _, rc := range configs {
+		values := make([]string, 0, len(rc.SourceLabels))
+		for _, name := range rc.SourceLabels {
+			values = append(values, labels[name])
+		}
+		value := strings.Join(values, rc.Separator)
+		switch rc.Action {
+		case "keep":
+			if !rc.Regex.MatchString(value) {
+				return false
+			}
+		case "drop":
+			if rc.Regex.MatchString(value) {
+				return false
+			}
+		case "replace":
+			indexes := rc.Regex.FindStringSubmatchIndex(value)
+			if indexes == nil {
+				continue
+			}
+			res := string(rc.Regex.ExpandString([]byte{}, rc.Replacement, value, indexes))
+			if rc.TargetLabel == model.MetricNameLabel {
+				if !model.IsValidMetricName(model.LabelValue(res)) {
+					log.Debugf("Not renaming metric %s to invalid name %q", labels[model.MetricNameLabel], res)
+					continue
+				}
+				labels[rc.TargetLabel] = res
+			} else if res == "" {
+				delete(labels, rc.TargetLabel)
+			} else {
+				labels[rc.TargetLabel] = res
+			}
+		}
+	}
+	return true
+}
+
+// Whether the raw value of a numeric metric is one of its ignore_values,
+// such as a sentinel for not available.
+func ignoreValue(metric *config.Metric, value float64) bool {
+	switch metric.Type {
+	case "counter", "gauge", "Float", "Double":
+	default:
+		return false
+	}
+	for _, v := range metric.IgnoreValues {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Clamp a value to the min and max of its metric, if any.
+func clampValue(value float64, metric *config.Metric) float64 {
+	if metric.Min != nil && value < *metric.Min {
+		return *metric.Min
+	}
+	if metric.Max != nil && value > *metric.Max {
+		return *metric.Max
+	}
+	return value
+}
+
+// Multiply a value by the scale of its metric, if any. Scales such as 0.01
+// divide by their inverse instead, so that 1234 becomes 12.34 rather than
+// 12.340000000000002.
+func scaleValue(value, scale float64) float64 {
+	if scale == 0 || scale == 1 {
+		return value
+	}
+	if inverse := 1 / scale; inverse == math.Trunc(inverse) {
+		return value / inverse
+	}
+	return value * scale
+}
+
 func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labelvalues []string) []prometheus.Metric {
 	results := []prometheus.Metric{}
 	for name, strMetricSlice := range metric.RegexpExtracts {
@@ -239,6 +1116,92 @@ func applyRegexExtracts(metric *config.Metric, pduValue string, labelnames, labe
 	return results
 }
 
+func enumAsInfo(metric *config.Metric, value int, labelnames, labelvalues []string) []prometheus.Metric {
+	// Lookup the enum name, falling back to the value.
+	state, ok := metric.EnumValues[value]
+	if !ok {
+		state = strconv.Itoa(value)
+	}
+	labelnames = append(labelnames, metric.Name)
+	labelvalues = append(labelvalues, state)
+
+	return []prometheus.Metric{prometheus.MustNewConstMetric(prometheus.NewDesc(metric.Name+"_info", metric.Help+" (EnumAsInfo)", labelnames, nil),
+		prometheus.GaugeValue, 1.0, labelvalues...)}
+}
+
+func enumAsStateSet(metric *config.Metric, value int, labelnames, labelvalues []string) []prometheus.Metric {
+	labelnames = append(labelnames, metric.Name)
+	desc := prometheus.NewDesc(metric.Name, metric.Help+" (EnumAsStateSet)", labelnames, nil)
+	results := []prometheus.Metric{}
+
+	state, ok := metric.EnumValues[value]
+	if !ok {
+		// Unknown value, so use the number as the state.
+		state = strconv.Itoa(value)
+	}
+	results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1.0, append(labelvalues, state)...))
+
+	for k, v := range metric.EnumValues {
+		if k == value {
+			continue
+		}
+		results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 0.0, append(labelvalues, v)...))
+	}
+	return results
+}
+
+// Parse a DateAndTime per RFC 2579. Without a timezone, UTC is assumed.
+func parseDateAndTime(b []byte) (time.Time, error) {
+	if len(b) != 8 && len(b) != 11 {
+		return time.Time{}, fmt.Errorf("invalid DateAndTime length %d", len(b))
+	}
+	loc := time.UTC
+	if len(b) == 11 {
+		offset := (int(b[9])*60 + int(b[10])) * 60
+		switch b[8] {
+		case '+':
+		case '-':
+			offset = -offset
+		default:
+			return time.Time{}, fmt.Errorf("invalid DateAndTime direction from UTC %q", b[8])
+		}
+		loc = time.FixedZone("", offset)
+	}
+	year := int(b[0])<<8 | int(b[1])
+	return time.Date(year, time.Month(b[2]), int(b[3]), int(b[4]), int(b[5]), int(b[6]), int(b[7])*1e8, loc), nil
+}
+
+func bits(metric *config.Metric, value []byte, labelnames, labelvalues []string) []prometheus.Metric {
+	labelnames = append(labelnames, metric.Name)
+	desc := prometheus.NewDesc(metric.Name, metric.Help, labelnames, nil)
+	results := []prometheus.Metric{}
+
+	// Bit 0 is the most significant bit of the first octet.
+	for i := 0; i < len(value)*8; i++ {
+		set := value[i/8]&(128>>uint(i%8)) != 0
+		name, ok := metric.EnumValues[i]
+		if !ok {
+			if !set {
+				continue
+			}
+			// Unknown bit, so use the number as the name.
+			name = strconv.Itoa(i)
+		}
+		v := 0.0
+		if set {
+			v = 1.0
+		}
+		results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append(labelvalues, name)...))
+	}
+	// Named bits beyond the end of the value are not set.
+	for k, v := range metric.EnumValues {
+		if k >= len(value)*8 {
+			results = append(results, prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 0.0, append(labelvalues, v)...))
+		}
+	}
+	return results
+}
+
 // Right pad oid with zeros, and split at the given point.
 // Some routers exclude trailing 0s in responses.
 func splitOid(oid []int, count int) ([]int, []int) {
@@ -256,13 +1219,17 @@ func splitOid(oid []int, count int) ([]int, []int) {
 
 // This mirrors decodeValue in gosnmp's helper.go.
 func pduValueAsString(pdu *gosnmp.SnmpPDU, typ string) string {
-	switch pdu.Value.(type) {
+	switch v := pdu.Value.(type) {
 	case int:
 		return strconv.Itoa(pdu.Value.(int))
 	case uint:
 		return strconv.FormatUint(uint64(pdu.Value.(uint)), 10)
 	case uint64:
 		return strconv.FormatUint(pdu.Value.(uint64), 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
 	case string:
 		if pdu.Type == gosnmp.ObjectIdentifier {
 			// Trim leading period.
@@ -274,6 +1241,18 @@ func pduValueAsString(pdu *gosnmp.SnmpPDU, typ string) string {
 		if typ == "" {
 			typ = "OctetString"
 		}
+		switch typ {
+		case "InetAddress":
+			return inetAddressAsString(-1, pdu.Value.([]byte))
+		case "InetAddressIPv6":
+			return inetAddressAsString(2, pdu.Value.([]byte))
+		}
+		if typ == "DateAndTime" {
+			if dt, err := parseDateAndTime(pdu.Value.([]byte)); err == nil {
+				return dt.Format(time.RFC3339Nano)
+			}
+			typ = "OctetString"
+		}
 		// Reuse the OID index parsing code.
 		parts := make([]int, len(pdu.Value.([]byte)))
 		for i, o := range pdu.Value.([]byte) {
@@ -283,7 +1262,7 @@ func pduValueAsString(pdu *gosnmp.SnmpPDU, typ string) string {
 			// Prepend the length, as it is explicit in an index.
 			parts = append([]int{len(pdu.Value.([]byte))}, parts...)
 		}
-		str, _, _ := indexOidsAsString(parts, typ)
+		str, _, _ := indexOidsAsString(parts, typ, 0, false)
 		return str
 	case nil:
 		return ""
@@ -295,10 +1274,177 @@ func pduValueAsString(pdu *gosnmp.SnmpPDU, typ string) string {
 	}
 }
 
-// Convert oids to a string index value.
+// Convert oids to a string index value. Fixed size and implied indexes
+// have no length, and implied indexes take the rest of the oids.
 //
 // Returns the string, the oids that were used and the oids left over.
-func indexOidsAsString(indexOids []int, typ string) (string, []int, []int) {
+// As pduValueAsString, but with the given encoding of the bytes of strings.
+func pduValueAsEncodedString(pdu *gosnmp.SnmpPDU, typ, encoding string) string {
+	if b, ok := pdu.Value.([]byte); ok && encoding != "" && (typ == "OctetString" || typ == "DisplayString") {
+		return encodeOctets(b, encoding)
+	}
+	return pduValueAsString(pdu, typ)
+}
+
+// As pduValueAsEncodedString, with MAC addresses in the module's format.
+func pduValueAsLabel(pdu *gosnmp.SnmpPDU, typ, encoding string, module *config.Module) string {
+	if b, ok := pdu.Value.([]byte); ok && typ == "PhysAddress48" && macFormatted(module) {
+		return formatMAC(b, module)
+	}
+	return pduValueAsEncodedString(pdu, typ, encoding)
+}
+
+// Whether the module renders MAC addresses other than as AA:BB:CC:DD:EE:FF.
+func macFormatted(module *config.Module) bool {
+	return module.MACAddressFormat != "" || module.MACAddressLowercase
+}
+
+// Render a MAC address in the mac_address_format of the module, so that
+// labels match the notation of an inventory.
+func formatMAC(b []byte, module *config.Module) string {
+	digits := fmt.Sprintf("%X", b)
+	if module.MACAddressLowercase {
+		digits = strings.ToLower(digits)
+	}
+	size, separator := 2, ":"
+	switch module.MACAddressFormat {
+	case "raw":
+		return digits
+	case "dot":
+		size, separator = 4, "."
+	case "hyphen":
+		separator = "-"
+	}
+	groups := make([]string, 0, len(digits)/size+1)
+	for len(digits) > size {
+		groups = append(groups, digits[:size])
+		digits = digits[size:]
+	}
+	groups = append(groups, digits)
+	return strings.Join(groups, separator)
+}
+
+// Render bytes as a label value, so that binary strings don't produce
+// invalid UTF-8.
+func encodeOctets(b []byte, encoding string) string {
+	switch encoding {
+	case "hex":
+		if len(b) == 0 {
+			return ""
+		}
+		return fmt.Sprintf("0x%X", b)
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b)
+	case "utf8":
+		// Invalid bytes become U+FFFD.
+		return string([]rune(string(b)))
+	case "ascii":
+		// Printable ASCII is kept, and everything else escaped as \xNN.
+		s := &bytes.Buffer{}
+		for _, c := range b {
+			switch {
+			case c == '\\':
+				s.WriteString(`\\`)
+			case c >= 0x20 && c < 0x7f:
+				s.WriteByte(c)
+			default:
+				fmt.Fprintf(s, "\\x%02x", c)
+			}
+		}
+		return s.String()
+	default:
+		return string(b)
+	}
+}
+
+// Make a label value valid UTF-8 as the module's invalid_utf8 says, by
+// replacing invalid bytes with U+FFFD or hex encoding the whole value.
+func sanitizeUTF8(value, mode string) string {
+	if mode == "" || utf8.ValidString(value) {
+		return value
+	}
+	if mode == "hex" {
+		return encodeOctets([]byte(value), "hex")
+	}
+	return encodeOctets([]byte(value), "utf8")
+}
+
+// Render octets per an RFC 2579 DISPLAY-HINT, such as 1x: or 1d.1d.1d.1d.
+// Hex is rendered as in PhysAddress48, with two digits per octet.
+func displayHintOctets(hint string, b []byte) (string, error) {
+	out := &bytes.Buffer{}
+	h, last := 0, 0
+	for len(b) > 0 {
+		// The last specification is repeated until the octets run out.
+		if h == len(hint) {
+			h = last
+		}
+		last = h
+		star := hint[h] == '*'
+		if star {
+			h++
+		}
+		length := 0
+		start := h
+		for h < len(hint) && hint[h] >= '0' && hint[h] <= '9' {
+			length = length*10 + int(hint[h]-'0')
+			h++
+		}
+		if h == start || h == len(hint) || length == 0 {
+			return "", fmt.Errorf("missing octet length or format")
+		}
+		format := hint[h]
+		h++
+		if !strings.ContainsRune("xdoat", rune(format)) {
+			return "", fmt.Errorf("unknown format %q", format)
+		}
+		isChar := func() bool { return h < len(hint) && hint[h] != '*' && (hint[h] < '0' || hint[h] > '9') }
+		var separator, terminator string
+		if isChar() {
+			separator = hint[h : h+1]
+			h++
+		}
+		if star && isChar() {
+			terminator = hint[h : h+1]
+			h++
+		}
+
+		repeat := 1
+		if star {
+			repeat = int(b[0])
+			b = b[1:]
+		}
+		for i := 0; i < repeat && len(b) > 0; i++ {
+			n := length
+			if n > len(b) {
+				n = len(b)
+			}
+			octets := b[:n]
+			b = b[n:]
+			switch format {
+			case 'x':
+				fmt.Fprintf(out, "%X", octets)
+			case 'd':
+				out.WriteString(new(big.Int).SetBytes(octets).Text(10))
+			case 'o':
+				out.WriteString(new(big.Int).SetBytes(octets).Text(8))
+			case 'a', 't':
+				out.Write(octets)
+			}
+			if len(b) == 0 {
+				break
+			}
+			if star && i == repeat-1 && terminator != "" {
+				out.WriteString(terminator)
+			} else {
+				out.WriteString(separator)
+			}
+		}
+	}
+	return out.String(), nil
+}
+
+func indexOidsAsString(indexOids []int, typ string, fixedSize int, implied bool) (string, []int, []int) {
 	switch typ {
 	case "Integer32", "Integer", "gauge", "counter":
 		// Extract the oid for this index, and keep the remainder for the next index.
@@ -312,11 +1458,8 @@ func indexOidsAsString(indexOids []int, typ string) (string, []int, []int) {
 		}
 		return strings.Join(parts, ":"), subOid, indexOids
 	case "OctetString":
-		subOid, indexOids := splitOid(indexOids, 1)
-		length := subOid[0]
-		content, indexOids := splitOid(indexOids, length)
-		subOid = append(subOid, content...)
-		parts := make([]byte, length)
+		subOid, content, indexOids := splitLengthOid(indexOids, fixedSize, implied)
+		parts := make([]byte, len(content))
 		for i, o := range content {
 			parts[i] = byte(o)
 		}
@@ -326,41 +1469,25 @@ func indexOidsAsString(indexOids []int, typ string) (string, []int, []int) {
 			return fmt.Sprintf("0x%X", string(parts)), subOid, indexOids
 		}
 	case "DisplayString":
-		subOid, indexOids := splitOid(indexOids, 1)
-		length := subOid[0]
-		content, indexOids := splitOid(indexOids, length)
-		subOid = append(subOid, content...)
-		parts := make([]byte, length)
+		subOid, content, indexOids := splitLengthOid(indexOids, fixedSize, implied)
+		parts := make([]byte, len(content))
 		for i, o := range content {
 			parts[i] = byte(o)
 		}
 		// ASCII, so can convert staight to utf-8.
 		return string(parts), subOid, indexOids
 	case "InetAddress":
+		// The address type, followed by the address.
 		addressType, indexOids := splitOid(indexOids, 1)
-		octets, indexOids := splitOid(indexOids, 1)
-		address, indexOids := splitOid(indexOids, octets[0])
-		subOid := append(addressType, octets...)
-		subOid = append(subOid, address...)
-		if addressType[0] == 1 { // IPv4.
-			parts := make([]string, 4)
-			for i, o := range address {
-				parts[i] = strconv.Itoa(o)
-			}
-			return strings.Join(parts, "."), subOid, indexOids
-		} else if addressType[0] == 2 { // IPv6.
-			parts := make([]string, 8)
-			for i := 0; i < 8; i++ {
-				parts[i] = fmt.Sprintf("%02X%02X", address[i*2], address[i*2+1])
-			}
-			return strings.Join(parts, ":"), subOid, indexOids
-		} else { // Unknown, treat as OctetString.
-			parts := make([]byte, octets[0])
-			for i, o := range address {
-				parts[i] = byte(o)
-			}
-			return fmt.Sprintf("0x%X", string(parts)), subOid, indexOids
+		str, address, indexOids := inetAddressIndexAsString(indexOids, addressType[0], false)
+		return str, append(addressType, address...), indexOids
+	case "InetAddressIPv6":
+		subOid, indexOids := splitOid(indexOids, 16)
+		parts := make([]byte, 16)
+		for i, o := range subOid {
+			parts[i] = byte(o)
 		}
+		return inetAddressAsString(2, parts), subOid, indexOids
 	case "IpAddr":
 		subOid, indexOids := splitOid(indexOids, 4)
 		parts := make([]string, 4)
@@ -392,35 +1519,227 @@ func indexOidsAsString(indexOids []int, typ string) (string, []int, []int) {
 	}
 }
 
-func indexesToLabels(indexOids []int, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU) map[string]string {
+// Convert a length prefixed InetAddress index of the given InetAddressType.
+//
+// Returns the string, the oids that were used and the oids left over.
+func inetAddressIndexAsString(indexOids []int, addressType int, implied bool) (string, []int, []int) {
+	subOid, address, indexOids := splitLengthOid(indexOids, 0, implied)
+	parts := make([]byte, len(address))
+	for i, o := range address {
+		parts[i] = byte(o)
+	}
+	return inetAddressAsString(addressType, parts), subOid, indexOids
+}
+
+// Split off a string index, which is prefixed by its length
+// unless it is fixed size or implied.
+//
+// Returns the oids that were used, the content and the oids left over.
+func splitLengthOid(indexOids []int, fixedSize int, implied bool) ([]int, []int, []int) {
+	if fixedSize > 0 {
+		content, indexOids := splitOid(indexOids, fixedSize)
+		return content, content, indexOids
+	}
+	if implied {
+		return indexOids, indexOids, []int{}
+	}
+	length, indexOids := splitOid(indexOids, 1)
+	content, indexOids := splitOid(indexOids, length[0])
+	return append(length, content...), content, indexOids
+}
+
+// Render an InetAddress per RFC 4001, with IPv6 addresses per RFC 5952.
+// If the InetAddressType is not known (-1), it is guessed from the length.
+func inetAddressAsString(addressType int, address []byte) string {
+	if addressType < 0 {
+		switch len(address) {
+		case 4:
+			addressType = 1
+		case 16:
+			addressType = 2
+		case 8:
+			addressType = 3
+		case 20:
+			addressType = 4
+		}
+	}
+	zone := ""
+	switch {
+	case addressType == 3 && len(address) == 8, addressType == 4 && len(address) == 20:
+		zone = fmt.Sprintf("%%%d", uint32(address[len(address)-4])<<24|uint32(address[len(address)-3])<<16|uint32(address[len(address)-2])<<8|uint32(address[len(address)-1]))
+		address = address[:len(address)-4]
+		addressType -= 2
+	}
+	switch {
+	case addressType == 1 && len(address) == 4:
+		return net.IP(address).String() + zone
+	case addressType == 2 && len(address) == 16:
+		ip := net.IP(address)
+		if ip.To4() != nil {
+			// IPv4-mapped addresses, which Go would render as plain IPv4.
+			return "::ffff:" + ip.To4().String() + zone
+		}
+		return ip.String() + zone
+	case addressType == 16:
+		return string(address)
+	case len(address) == 0:
+		return ""
+	default: // Unknown, treat as OctetString.
+		return fmt.Sprintf("0x%X", string(address))
+	}
+}
+
+func indexesToLabels(indexOids []int, metric *config.Metric, oidToPdu map[string]gosnmp.SnmpPDU, module *config.Module) map[string]string {
 	labels := map[string]string{}
 	labelOids := map[string][]int{}
 
 	// Covert indexes to useful strings.
-	for _, index := range metric.Indexes {
-		str, subOid, remainingOids := indexOidsAsString(indexOids, index.Type)
+	for i, index := range metric.Indexes {
+		var str string
+		var subOid, remainingOids []int
+		if index.Type == "InetAddress" && i > 0 && metric.Indexes[i-1].Type == "InetAddressType" {
+			// Per RFC 4001 the address type is the preceding index.
+			str, subOid, remainingOids = inetAddressIndexAsString(indexOids, labelOids[metric.Indexes[i-1].Labelname][0], index.Implied)
+		} else if index.Encoding != "" && (index.Type == "OctetString" || index.Type == "DisplayString") {
+			var content []int
+			subOid, content, remainingOids = splitLengthOid(indexOids, index.FixedSize, index.Implied)
+			b := make([]byte, len(content))
+			for i, o := range content {
+				b[i] = byte(o)
+			}
+			str = encodeOctets(b, index.Encoding)
+		} else if index.DisplayHint != "" && index.Type == "OctetString" {
+			var content []int
+			subOid, content, remainingOids = splitLengthOid(indexOids, index.FixedSize, index.Implied)
+			b := make([]byte, len(content))
+			for i, o := range content {
+				b[i] = byte(o)
+			}
+			var err error
+			if str, err = displayHintOctets(index.DisplayHint, b); err != nil {
+				log.Debugf("Error applying DISPLAY-HINT %q to index %s: %s", index.DisplayHint, index.Labelname, err)
+				str = encodeOctets(b, "hex")
+			}
+		} else if index.Type == "PhysAddress48" && macFormatted(module) {
+			subOid, remainingOids = splitOid(indexOids, 6)
+			b := make([]byte, len(subOid))
+			for i, o := range subOid {
+				b[i] = byte(o)
+			}
+			str = formatMAC(b, module)
+		} else {
+			str, subOid, remainingOids = indexOidsAsString(indexOids, index.Type, index.FixedSize, index.Implied)
+		}
 		// The labelvalue is the text form of the index oids.
-		labels[index.Labelname] = str
+		labels[index.Labelname] = replaceLabelValue(str, index.RegexpReplacements)
 		// Save its oid in case we need it for lookups.
 		labelOids[index.Labelname] = subOid
 		// For the next iteration.
 		indexOids = remainingOids
 	}
 
+	// Join indexes into one label, such as an interface and address.
+	for _, join := range metric.JoinIndexes {
+		values := make([]string, 0, len(join.Labels))
+		for _, label := range join.Labels {
+			values = append(values, labels[label])
+			delete(labels, label)
+		}
+		labels[join.Labelname] = strings.Join(values, join.Separator)
+	}
+
 	// Perform lookups.
 	for _, lookup := range metric.Lookups {
-		oid := lookup.Oid
+		var subOids []int
 		for _, label := range lookup.Labels {
-			for _, o := range labelOids[label] {
-				oid = fmt.Sprintf("%s.%d", oid, o)
-			}
-		}
-		if pdu, ok := oidToPdu[oid]; ok {
-			labels[lookup.Labelname] = pduValueAsString(&pdu, lookup.Type)
-		} else {
-			labels[lookup.Labelname] = ""
+			subOids = append(subOids, labelOids[label]...)
 		}
+		lookupLabel(lookup, lookupOid(lookup, subOids), labels, oidToPdu, module)
 	}
 
 	return labels
 }
+
+// Set the label for a lookup, and follow any lookups chained from it.
+func lookupLabel(lookup *config.Lookup, oid string, labels map[string]string, oidToPdu map[string]gosnmp.SnmpPDU, module *config.Module) {
+	pdu, ok := oidToPdu[oid]
+	if ok {
+		labels[lookup.Labelname] = replaceLabelValue(pduValueAsLabel(&pdu, lookup.Type, lookup.Encoding, module), lookup.RegexpReplacements)
+	} else {
+		labels[lookup.Labelname] = ""
+	}
+	for _, chained := range lookup.Lookups {
+		chainedOid := ""
+		if ok {
+			// The value of this lookup is the index of the chained one.
+			chainedOid = lookupOid(chained, pduValueAsOids(&pdu, lookup.Type))
+		}
+		lookupLabel(chained, chainedOid, labels, oidToPdu, module)
+	}
+}
+
+// The OID to look up for an index, after stripping its prefix and applying
+// the offset. Empty if there is no such index.
+func lookupOid(lookup *config.Lookup, subOids []int) string {
+	if lookup.StripPrefix > len(subOids) {
+		return ""
+	}
+	subOids = subOids[lookup.StripPrefix:]
+	oid := lookup.Oid
+	for i, o := range subOids {
+		if i == len(subOids)-1 {
+			o += lookup.IndexOffset
+			if o < 0 {
+				return ""
+			}
+		}
+		oid = fmt.Sprintf("%s.%d", oid, o)
+	}
+	return oid
+}
+
+// Apply regexp replacements to a label value, in order.
+func replaceLabelValue(value string, replacements []config.RegexpReplacement) string {
+	for _, r := range replacements {
+		value = r.Regex.ReplaceAllString(value, r.Replacement)
+	}
+	return value
+}
+
+// Convert a PDU value to the oids it would have as an index.
+func pduValueAsOids(pdu *gosnmp.SnmpPDU, typ string) []int {
+	switch v := pdu.Value.(type) {
+	case int:
+		return []int{v}
+	case uint:
+		return []int{int(v)}
+	case uint64:
+		return []int{int(v)}
+	case string:
+		if pdu.Type == gosnmp.ObjectIdentifier {
+			// Trim leading period.
+			return oidToList(v[1:])
+		}
+		if pdu.Type == gosnmp.IPAddress {
+			return oidToList(v)
+		}
+		// Treat as a DisplayString, with an explicit length.
+		oids := []int{len(v)}
+		for _, b := range []byte(v) {
+			oids = append(oids, int(b))
+		}
+		return oids
+	case []byte:
+		oids := []int{}
+		if typ == "" || typ == "OctetString" || typ == "DisplayString" {
+			// Variable length, so the length is explicit in an index.
+			oids = append(oids, len(v))
+		}
+		for _, b := range v {
+			oids = append(oids, int(b))
+		}
+		return oids
+	default:
+		return []int{}
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_model/go"
 	"github.com/soniah/gosnmp"
 
@@ -12,12 +17,15 @@ import (
 )
 
 func TestPduToSample(t *testing.T) {
+	min, max := 0.0, 100.0
+	maxLength, noMaxLength := config.Limit(5), config.Limit(0)
 
 	cases := []struct {
 		pdu             *gosnmp.SnmpPDU
 		indexOids       []int
 		metric          *config.Metric
 		oidToPdu        map[string]gosnmp.SnmpPDU
+		module          *config.Module
 		expectedMetrics map[string]string
 	}{
 		{
@@ -228,26 +236,992 @@ func TestPduToSample(t *testing.T) {
 			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
 			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"-2" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`},
 		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsInfo",
+				Help:       "Help string",
+				EnumValues: map[int]string{0: "foo", 1: "bar", 2: "baz"},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"baz" > gauge:<value:1 > `: `Desc{fqName: "test_metric_info", help: "Help string (EnumAsInfo)", constLabels: {}, variableLabels: [test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 3,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsInfo",
+				Help:       "Help string",
+				EnumValues: map[int]string{0: "foo", 1: "bar", 2: "baz"},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"3" > gauge:<value:1 > `: `Desc{fqName: "test_metric_info", help: "Help string (EnumAsInfo)", constLabels: {}, variableLabels: [test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "EnumAsStateSet",
+				Help:       "Help string",
+				EnumValues: map[int]string{0: "foo", 1: "bar", 2: "baz"},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"test_metric" value:"foo" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string (EnumAsStateSet)", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"bar" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string (EnumAsStateSet)", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"baz" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string (EnumAsStateSet)", constLabels: {}, variableLabels: [test_metric]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.OctetString,
+				Value: []byte{0x50},
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:       "test_metric",
+				Oid:        "1.1.1.1.1",
+				Type:       "Bits",
+				Help:       "Help string",
+				EnumValues: map[int]string{0: "foo", 1: "bar", 2: "baz", 8: "qux"},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`label:<name:"test_metric" value:"foo" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"bar" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"baz" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"3" > gauge:<value:1 > `:   `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+				`label:<name:"test_metric" value:"qux" > gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [test_metric]}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.OctetString,
+				Value: []byte{7, 226, 1, 2, 3, 4, 5, 6, '+', 1, 0},
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "DateAndTime",
+				Help: "Help string",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:1.5148586456e+09 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Gauge32,
+				Value: uint(4294967295),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:         "test_metric",
+				Oid:          "1.1.1.1.1",
+				Type:         "gauge",
+				Help:         "Help string",
+				Scale:        0.1,
+				IgnoreValues: []float64{4294967295},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Gauge32,
+				Value: uint(2000),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:         "test_metric",
+				Oid:          "1.1.1.1.1",
+				Type:         "gauge",
+				Help:         "Help string",
+				Scale:        0.1,
+				IgnoreValues: []float64{4294967295},
+				Min:          &min,
+				Max:          &max,
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:100 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Counter64,
+				Value: uint64(9007199254740993),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:      "test_metric",
+				Oid:       "1.1.1.1.1",
+				Type:      "counter",
+				Help:      "Help string",
+				Counter64: "wrap",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`counter:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Counter64,
+				Value: uint64(9007199254740993),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:      "test_metric",
+				Oid:       "1.1.1.1.1",
+				Type:      "counter",
+				Help:      "Help string",
+				Counter64: "split",
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{
+				`gauge:<value:2.097152e+06 > `: `Desc{fqName: "test_metric_high", help: "Help string (high 32 bits)", constLabels: {}, variableLabels: []}`,
+				`gauge:<value:1 > `:            `Desc{fqName: "test_metric_low", help: "Help string (low 32 bits)", constLabels: {}, variableLabels: []}`,
+			},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Counter64,
+				Value: uint64(9007199254740993),
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:      "test_metric",
+				Oid:       "1.1.1.1.1",
+				Type:      "counter",
+				Help:      "Help string",
+				Counter64: "info",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`label:<name:"test_metric" value:"9007199254740993" > gauge:<value:1 > `: `Desc{fqName: "test_metric_info", help: "Help string (Counter64 as info)", constLabels: {}, variableLabels: [test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Bool",
+				Help: "Help string",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:0 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 1,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name: "test_metric",
+				Oid:  "1.1.1.1.1",
+				Type: "Bool",
+				Help: "Help string",
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 2345,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:  "test_metric",
+				Oid:   "1.1.1.1.1",
+				Type:  "gauge",
+				Help:  "Help string",
+				Scale: 0.01,
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:23.45 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1",
+				Type:  gosnmp.Integer,
+				Value: 55,
+			},
+			indexOids: []int{},
+			metric: &config.Metric{
+				Name:   "test_metric",
+				Oid:    "1.1.1.1.1",
+				Type:   "gauge",
+				Help:   "Help string",
+				Scale:  0.5,
+				Offset: -100,
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			expectedMetrics: map[string]string{`gauge:<value:-72.5 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: []}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{3},
+			metric: &config.Metric{
+				Name:         "test_metric",
+				Oid:          "1.1.1.1.1",
+				Type:         "gauge",
+				Help:         "Help string",
+				Indexes:      []*config.Index{{Labelname: "index", Type: "gauge"}},
+				StaticLabels: map[string]string{"tier": "core", "index": "ignored"},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			module:          &config.Module{StaticLabels: map[string]string{"vendor": "cisco", "tier": "access"}},
+			expectedMetrics: map[string]string{`label:<name:"index" value:"3" > label:<name:"tier" value:"core" > label:<name:"vendor" value:"cisco" > gauge:<value:2 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [index tier vendor]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{3},
+			metric: &config.Metric{
+				Name:         "test_metric",
+				Oid:          "1.1.1.1.1",
+				Type:         "gauge",
+				Help:         "Help string",
+				Indexes:      []*config.Index{{Labelname: "index", Type: "gauge"}},
+				StaticLabels: map[string]string{"tier": "core", "port": "static", "vendor": "cisco"},
+				DropLabels:   []string{"tier"},
+				RenameLabels: map[string]string{"index": "port"},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			module:          &config.Module{},
+			expectedMetrics: map[string]string{`label:<name:"port" value:"3" > label:<name:"vendor" value:"cisco" > gauge:<value:2 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [port vendor]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{3},
+			metric: &config.Metric{
+				Name:               "test_metric",
+				Oid:                "1.1.1.1.1",
+				Type:               "gauge",
+				Help:               "Help string",
+				Indexes:            []*config.Index{{Labelname: "index", Type: "gauge"}},
+				Lookups:            []*config.Lookup{{Labels: []string{"index"}, Labelname: "ifAlias", Oid: "1.1.2", Type: "DisplayString"}},
+				KeepIfLabelMatches: map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:uplink.*)$")}},
+				DropIfLabelMatches: map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:.*spare.*)$")}},
+			},
+			oidToPdu:        map[string]gosnmp.SnmpPDU{"1.1.2.3": {Value: "uplink to core"}, "1.1.2.4": {Value: "access port"}, "1.1.2.5": {Value: "uplink spare"}},
+			module:          &config.Module{},
+			expectedMetrics: map[string]string{`label:<name:"ifAlias" value:"uplink to core" > label:<name:"index" value:"3" > gauge:<value:2 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [ifAlias index]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.4",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{4},
+			metric: &config.Metric{
+				Name:               "test_metric",
+				Oid:                "1.1.1.1.1",
+				Type:               "gauge",
+				Help:               "Help string",
+				Indexes:            []*config.Index{{Labelname: "index", Type: "gauge"}},
+				Lookups:            []*config.Lookup{{Labels: []string{"index"}, Labelname: "ifAlias", Oid: "1.1.2", Type: "DisplayString"}},
+				KeepIfLabelMatches: map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:uplink.*)$")}},
+				DropIfLabelMatches: map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:.*spare.*)$")}},
+			},
+			oidToPdu:        map[string]gosnmp.SnmpPDU{"1.1.2.3": {Value: "uplink to core"}, "1.1.2.4": {Value: "access port"}, "1.1.2.5": {Value: "uplink spare"}},
+			module:          &config.Module{},
+			expectedMetrics: map[string]string{},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.5",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{5},
+			metric: &config.Metric{
+				Name:               "test_metric",
+				Oid:                "1.1.1.1.1",
+				Type:               "gauge",
+				Help:               "Help string",
+				Indexes:            []*config.Index{{Labelname: "index", Type: "gauge"}},
+				Lookups:            []*config.Lookup{{Labels: []string{"index"}, Labelname: "ifAlias", Oid: "1.1.2", Type: "DisplayString"}},
+				KeepIfLabelMatches: map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:uplink.*)$")}},
+				DropIfLabelMatches: map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:.*spare.*)$")}},
+			},
+			oidToPdu:        map[string]gosnmp.SnmpPDU{"1.1.2.3": {Value: "uplink to core"}, "1.1.2.4": {Value: "access port"}, "1.1.2.5": {Value: "uplink spare"}},
+			module:          &config.Module{},
+			expectedMetrics: map[string]string{},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3.97.98.99.100.101.102",
+				Type:  gosnmp.OctetString,
+				Value: []byte("Cisco IOS Software, Version 15"),
+			},
+			indexOids: []int{6, 97, 98, 99, 100, 101, 102},
+			metric: &config.Metric{
+				Name:    "test_metric",
+				Oid:     "1.1.1.1.1",
+				Type:    "DisplayString",
+				Help:    "Help string",
+				Indexes: []*config.Index{{Labelname: "index", Type: "DisplayString"}},
+				Lookups: []*config.Lookup{{Labels: []string{"index"}, Labelname: "descr", Oid: "1.1.2", Type: "DisplayString"}},
+			},
+			oidToPdu:        map[string]gosnmp.SnmpPDU{"1.1.2.6.97.98.99.100.101.102": {Value: "Gigabit Ethernet"}},
+			module:          &config.Module{MaxValueLength: &maxLength},
+			expectedMetrics: map[string]string{`label:<name:"descr" value:"Gigab..." > label:<name:"index" value:"abcdef" > label:<name:"test_metric" value:"Cisco..." > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [descr index test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3.97.98.99.100.101.102",
+				Type:  gosnmp.OctetString,
+				Value: []byte("Cisco IOS Software, Version 15"),
+			},
+			indexOids: []int{6, 97, 98, 99, 100, 101, 102},
+			metric: &config.Metric{
+				Name:           "test_metric",
+				Oid:            "1.1.1.1.1",
+				Type:           "DisplayString",
+				Help:           "Help string",
+				Indexes:        []*config.Index{{Labelname: "index", Type: "DisplayString"}},
+				Lookups:        []*config.Lookup{{Labels: []string{"index"}, Labelname: "descr", Oid: "1.1.2", Type: "DisplayString"}},
+				MaxValueLength: &noMaxLength,
+			},
+			oidToPdu:        map[string]gosnmp.SnmpPDU{"1.1.2.6.97.98.99.100.101.102": {Value: "Gigabit Ethernet"}},
+			module:          &config.Module{MaxValueLength: &maxLength},
+			expectedMetrics: map[string]string{`label:<name:"descr" value:"Gigabit Ethernet" > label:<name:"index" value:"abcdef" > label:<name:"test_metric" value:"Cisco IOS Software, Version 15" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [descr index test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.4.67.97.102.233",
+				Type:  gosnmp.OctetString,
+				Value: []byte("Caf\xe9"),
+			},
+			indexOids: []int{4, 67, 97, 102, 233},
+			metric: &config.Metric{
+				Name:    "test_metric",
+				Oid:     "1.1.1.1.1",
+				Type:    "DisplayString",
+				Help:    "Help string",
+				Indexes: []*config.Index{{Labelname: "index", Type: "DisplayString"}},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			module:          &config.Module{InvalidUTF8: "replace"},
+			expectedMetrics: map[string]string{`label:<name:"index" value:"Caf\357\277\275" > label:<name:"test_metric" value:"Caf\357\277\275" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [index test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.4.67.97.102.233",
+				Type:  gosnmp.OctetString,
+				Value: []byte("Caf\xe9"),
+			},
+			indexOids: []int{4, 67, 97, 102, 233},
+			metric: &config.Metric{
+				Name:    "test_metric",
+				Oid:     "1.1.1.1.1",
+				Type:    "DisplayString",
+				Help:    "Help string",
+				Indexes: []*config.Index{{Labelname: "index", Type: "DisplayString"}},
+			},
+			oidToPdu:        make(map[string]gosnmp.SnmpPDU),
+			module:          &config.Module{InvalidUTF8: "hex"},
+			expectedMetrics: map[string]string{`label:<name:"index" value:"0x436166E9" > label:<name:"test_metric" value:"0x436166E9" > gauge:<value:1 > `: `Desc{fqName: "test_metric", help: "Help string", constLabels: {}, variableLabels: [index test_metric]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{3},
+			metric: &config.Metric{
+				Name:    "test_metric",
+				Oid:     "1.1.1.1.1",
+				Type:    "gauge",
+				Help:    "Help string",
+				Indexes: []*config.Index{{Labelname: "index", Type: "gauge"}},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			module: &config.Module{RelabelConfigs: []*config.RelabelConfig{
+				{SourceLabels: []string{"__name__"}, Regex: config.Regexp{Regexp: regexp.MustCompile("^test_(.*)$")}, TargetLabel: "__name__", Replacement: "renamed_$1", Action: "replace"},
+				{SourceLabels: []string{"index"}, Separator: ";", Regex: config.Regexp{Regexp: regexp.MustCompile("^(.*)$")}, TargetLabel: "__tmp", Replacement: "x", Action: "replace"},
+				{SourceLabels: []string{"index"}, Regex: config.Regexp{Regexp: regexp.MustCompile("^3$")}, TargetLabel: "port", Replacement: "uplink", Action: "replace"},
+			}},
+			expectedMetrics: map[string]string{`label:<name:"index" value:"3" > label:<name:"port" value:"uplink" > gauge:<value:2 > `: `Desc{fqName: "renamed_metric", help: "Help string", constLabels: {}, variableLabels: [index port]}`},
+		},
+		{
+			pdu: &gosnmp.SnmpPDU{
+				Name:  "1.1.1.1.1.3",
+				Type:  gosnmp.Integer,
+				Value: 2,
+			},
+			indexOids: []int{3},
+			metric: &config.Metric{
+				Name:    "test_metric",
+				Oid:     "1.1.1.1.1",
+				Type:    "gauge",
+				Help:    "Help string",
+				Indexes: []*config.Index{{Labelname: "index", Type: "gauge"}},
+			},
+			oidToPdu: make(map[string]gosnmp.SnmpPDU),
+			module: &config.Module{RelabelConfigs: []*config.RelabelConfig{
+				{SourceLabels: []string{"__name__", "index"}, Separator: ";", Regex: config.Regexp{Regexp: regexp.MustCompile("^test_metric;[0-9]$")}, Action: "drop"},
+			}},
+			expectedMetrics: map[string]string{},
+		},
+	}
+
+	for i, c := range cases {
+		module := c.module
+		if module == nil {
+			module = &config.Module{}
+		}
+		metrics := pduToSamples(c.indexOids, c.pdu, c.metric, c.oidToPdu, module)
+		if len(metrics) != len(c.expectedMetrics) {
+			t.Fatalf("Unexpected number of metrics returned for case %v: want %v, got %v", i, len(c.expectedMetrics), len(metrics))
+		}
+		metric := &io_prometheus_client.Metric{}
+		for _, m := range metrics {
+			err := m.Write(metric)
+			if err != nil {
+				t.Fatalf("Error writing metric: %v", err)
+			}
+			if _, ok := c.expectedMetrics[metric.String()]; !ok {
+				t.Fatalf("Unexpected metric: got %v", metric.String())
+			}
+			if c.expectedMetrics[metric.String()] != m.Desc().String() {
+				t.Fatalf("Unexpected metric: got %v , want %v", m.Desc().String(), c.expectedMetrics[metric.String()])
+			}
+		}
+	}
+}
+
+func TestValueLengthLimit(t *testing.T) {
+	defer func(l int) { *maxValueLength = l }(*maxValueLength)
+	*maxValueLength = 100
+	limit := func(l config.Limit) *config.Limit { return &l }
+
+	cases := []struct {
+		metric *config.Limit
+		module *config.Limit
+		want   int
+	}{
+		{want: 100},
+		{module: limit(50), want: 50},
+		{module: limit(0), want: 0},
+		{metric: limit(10), module: limit(50), want: 10},
+		{metric: limit(0), module: limit(50), want: 0},
+		{metric: limit(10), want: 10},
+	}
+	for i, c := range cases {
+		got := valueLengthLimit(&config.Metric{MaxValueLength: c.metric}, &config.Module{MaxValueLength: c.module})
+		if got != c.want {
+			t.Errorf("Case %d: want %d, got %d", i, c.want, got)
+		}
+	}
+}
+
+func TestPduToSampleUptimeTimestamp(t *testing.T) {
+	pdu := &gosnmp.SnmpPDU{Name: "1.1.1.1.1", Type: gosnmp.TimeTicks, Value: uint32(360000)}
+	metric := &config.Metric{Name: "test_metric", Oid: "1.1.1.1.1", Type: "UptimeTimestamp", Help: "Help string"}
+	before := time.Now().Unix()
+	metrics := pduToSamples([]int{}, pdu, metric, map[string]gosnmp.SnmpPDU{}, &config.Module{})
+	after := time.Now().Unix()
+	if len(metrics) != 1 {
+		t.Fatalf("Unexpected number of metrics: %d", len(metrics))
+	}
+	m := &io_prometheus_client.Metric{}
+	if err := metrics[0].Write(m); err != nil {
+		t.Fatal(err)
+	}
+	got := m.GetGauge().GetValue()
+	if got < float64(before-3600) || got > float64(after-3600+1) || got != float64(int64(got)) {
+		t.Errorf("Wrong boot time: got %v, want a second from %v to %v", got, before-3600, after-3600)
+	}
+}
+
+func TestMissingObjects(t *testing.T) {
+	pdus := []gosnmp.SnmpPDU{
+		{Name: ".1.1.1.0", Type: gosnmp.Integer, Value: 1},
+		{Name: ".1.1.2.0", Type: gosnmp.NoSuchObject},
+		{Name: ".1.1.3.0", Type: gosnmp.NoSuchInstance},
+		{Name: ".1.2.1", Type: gosnmp.NoSuchInstance},
+	}
+	metrics := []*config.Metric{
+		{Name: "a", Oid: "1.1.1"},
+		{Name: "b", Oid: "1.1.2"},
+		{Name: "c", Oid: "1.1.3", MissingObjects: "skip"},
+	}
+	cases := []struct {
+		module  string
+		metric  string
+		missing []string
+		err     bool
+	}{
+		{missing: []string{}},
+		{module: "metric", missing: []string{"1.1.2.0", "1.2.1"}},
+		{module: "metric", metric: "metric", missing: []string{"1.1.2.0", "1.1.3.0", "1.2.1"}},
+		{module: "fail", err: true},
+		{metric: "fail", err: true},
+	}
+	for i, c := range cases {
+		metrics[2].MissingObjects = "skip"
+		if c.metric != "" {
+			metrics[2].MissingObjects = c.metric
+		}
+		module := &config.Module{Metrics: metrics, MissingObjects: c.module}
+		got, missing, err := missingObjects(pdus, buildMetricTree(metrics), module)
+		if c.err {
+			if err == nil {
+				t.Errorf("Expected an error for case %d", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error for case %d: %s", i, err)
+		}
+		if len(got) != 1 || got[0].Name != ".1.1.1.0" {
+			t.Errorf("Wrong PDUs for case %d: %v", i, got)
+		}
+		if !reflect.DeepEqual(missing, c.missing) {
+			t.Errorf("Wrong missing objects for case %d: want %v, got %v", i, c.missing, missing)
+		}
+	}
+}
+
+func TestLimitSeries(t *testing.T) {
+	metrics := []*config.Metric{
+		{Name: "a", Oid: "1.1.1"},
+		{Name: "b", Oid: "1.1.2", MaxSeries: 3},
+		{Name: "c", Oid: "1.1.3"},
+	}
+	oidToPdu := map[string]gosnmp.SnmpPDU{}
+	for _, oid := range []string{"1.1.1.1", "1.1.1.2", "1.1.1.10", "1.1.2.2", "1.1.2.9", "1.1.2.10", "1.1.2.10.1", "1.1.3.1", "1.2.1"} {
+		oidToPdu[oid] = gosnmp.SnmpPDU{Name: "." + oid}
+	}
+	module := &config.Module{Metrics: metrics, MaxSeries: 2}
+	dropped, exceeded := limitSeries(oidToPdu, buildMetricTree(metrics), module)
+	want := map[string]struct{}{"1.1.1.10": {}, "1.1.2.10.1": {}}
+	if !reflect.DeepEqual(dropped, want) {
+		t.Errorf("Wrong rows dropped: want %v, got %v", want, dropped)
+	}
+	if len(exceeded) != 2 || exceeded[0].Name != "a" || exceeded[1].Name != "b" {
+		t.Errorf("Wrong metrics exceeded: %v", exceeded)
+	}
+
+	module.MaxSeries = 0
+	dropped, exceeded = limitSeries(oidToPdu, buildMetricTree(metrics), module)
+	if len(dropped) != 1 || len(exceeded) != 1 {
+		t.Errorf("Wrong rows dropped without a module limit: %v", dropped)
+	}
+}
+
+func TestBulkWalk(t *testing.T) {
+	values := map[string]int{"1.3.6.1.2.1.1.3.0": 100, "1.3.6.1.2.1.20.1": 20}
+	for i := 1; i <= 10; i++ {
+		values[fmt.Sprintf("1.3.6.1.2.1.2.2.1.1.%d", i)] = i
+	}
+	a := newFakeAgent(t, values)
+	defer a.close()
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+	module.WalkParams.MaxRepetitions = 8
+	module.WalkParams.Retries = 0
+	module.WalkParams.Timeout = 100 * time.Millisecond
+
+	for _, overMax := range []string{"", "truncate"} {
+		a.mtx.Lock()
+		a.maxRepetitions, a.overMax = 3, overMax
+		a.mtx.Unlock()
+		snmp, err := newSession(a.addr(), module, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdus, err := bulkWalk(snmp, "1.3.6.1.2.1.2", time.Time{}, false)
+		snmp.Conn.Close()
+		if err != nil {
+			t.Fatalf("Error walking with %q: %s", overMax, err)
+		}
+		// The walk stops at the end of the subtree, not at 1.3.6.1.2.1.20.
+		if len(pdus) != 10 || pdus[0].Name != ".1.3.6.1.2.1.2.2.1.1.1" || pdus[9].Name != ".1.3.6.1.2.1.2.2.1.1.10" {
+			t.Errorf("Wrong PDUs with %q: %v", overMax, pdus)
+		}
+		if snmp.MaxRepetitions != 2 {
+			t.Errorf("Wrong max_repetitions with %q: %d", overMax, snmp.MaxRepetitions)
+		}
+	}
+
+	// A lost request fails the walk once out of retries, rather than
+	// reducing max_repetitions.
+	a.mtx.Lock()
+	a.overMax, a.requests = "drop", 0
+	a.mtx.Unlock()
+	snmp, err := newSession(a.addr(), module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bulkWalk(snmp, "1.3.6.1.2.1.2", time.Time{}, false); err == nil {
+		t.Errorf("Expected an error walking with lost requests")
+	}
+	snmp.Conn.Close()
+	a.mtx.Lock()
+	requests := a.requests
+	a.maxRepetitions = 0
+	a.mtx.Unlock()
+	if snmp.MaxRepetitions != 8 || requests != 1 {
+		t.Errorf("Wrong max_repetitions after a lost request: %d after %d requests", snmp.MaxRepetitions, requests)
+	}
+
+	// A walk of a single object gets it.
+	snmp, err = newSession(a.addr(), module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snmp.Conn.Close()
+	pdus, err := bulkWalk(snmp, "1.3.6.1.2.1.1.3.0", time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdus) != 1 || pdus[0].Name != ".1.3.6.1.2.1.1.3.0" || pdus[0].Value != 100 {
+		t.Errorf("Wrong PDUs for a single object: %v", pdus)
+	}
+}
+
+func TestLookupCache(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.2.2.1.10.1": 100, "1.3.6.1.2.1.31.1.1.1.1.1": 7})
+	defer a.close()
+	module := &config.Module{
+		Walk:       []string{"1.3.6.1.2.1.2.2.1.10", "1.3.6.1.2.1.31.1.1.1.1"},
+		WalkParams: config.DefaultWalkParams,
+		Metrics: []*config.Metric{{
+			Name:    "ifInOctets",
+			Oid:     "1.3.6.1.2.1.2.2.1.10",
+			Type:    "counter",
+			Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}},
+			Lookups: []*config.Lookup{{Labels: []string{"ifIndex"}, Labelname: "ifName", Oid: "1.3.6.1.2.1.31.1.1.1.1", Type: "gauge", CacheTTL: time.Hour}},
+		}},
+	}
+	scrape := func() []gosnmp.SnmpPDU {
+		pdus, err := ScrapeTarget(a.addr(), module)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pdus
+	}
+
+	scrape()
+	// The lookup isn't walked again, yet is still in the results.
+	a.mtx.Lock()
+	a.values["1.3.6.1.2.1.31.1.1.1.1.1"] = 8
+	a.mtx.Unlock()
+	pdus := scrape()
+	if len(pdus) != 2 || pdus[1].Name != ".1.3.6.1.2.1.31.1.1.1.1.1" || pdus[1].Value != 7 {
+		t.Errorf("Cached lookup not used: %v", pdus)
+	}
+	// Other walks aren't cached.
+	a.mtx.Lock()
+	a.values["1.3.6.1.2.1.2.2.1.10.1"] = 200
+	a.mtx.Unlock()
+	pdus = scrape()
+	if len(pdus) != 2 || pdus[0].Value != 200 {
+		t.Errorf("Walk without a cache_ttl cached: %v", pdus)
+	}
+}
+
+func TestNonIncreasingOids(t *testing.T) {
+	values := map[string]int{}
+	for i := 1; i <= 5; i++ {
+		values[fmt.Sprintf("1.3.6.1.2.1.2.2.1.1.%d", i)] = i
 	}
+	a := newFakeAgent(t, values)
+	defer a.close()
+	a.mtx.Lock()
+	a.extra = map[string]string{"1.3.6.1.2.1.2.2.1.1.3": "1.3.6.1.2.1.2.2.1.1.1"}
+	a.mtx.Unlock()
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+	snmp, err := newSession(a.addr(), module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snmp.Conn.Close()
 
-	for i, c := range cases {
-		metrics := pduToSamples(c.indexOids, c.pdu, c.metric, c.oidToPdu)
-		if len(metrics) != len(c.expectedMetrics) {
-			t.Fatalf("Unexpected number of metrics returned for case %v: want %v, got %v", i, len(c.expectedMetrics), len(metrics))
+	if _, err := bulkWalk(snmp, "1.3.6.1.2.1.2", time.Time{}, false); err == nil || err.Error() != "OID not increasing: .1.3.6.1.2.1.2.2.1.1.1" {
+		t.Errorf("Expected the walk to fail, got: %v", err)
+	}
+	pdus, err := bulkWalk(snmp, "1.3.6.1.2.1.2", time.Time{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdus) != 5 || pdus[3].Name != ".1.3.6.1.2.1.2.2.1.1.4" {
+		t.Errorf("Wrong PDUs: %v", pdus)
+	}
+}
+
+func TestWalkBudget(t *testing.T) {
+	values := map[string]int{"1.3.6.1.2.1.1.3.0": 100}
+	for i := 1; i <= 10; i++ {
+		values[fmt.Sprintf("1.3.6.1.2.1.2.2.1.1.%d", i)] = i
+	}
+	a := newFakeAgent(t, values)
+	defer a.close()
+	a.mtx.Lock()
+	a.delay = 20 * time.Millisecond
+	a.mtx.Unlock()
+	module := &config.Module{
+		Walk:       []string{"1.3.6.1.2.1.2", "1.3.6.1.2.1.1"},
+		WalkParams: config.DefaultWalkParams,
+		WalkBudget: 50 * time.Millisecond,
+	}
+	module.WalkParams.MaxRepetitions = 1
+
+	pdus, err := ScrapeTarget(a.addr(), module)
+	if e, ok := err.(walkBudgetError); !ok || len(e.subtrees) != 1 || e.subtrees[0] != "1.3.6.1.2.1.2" {
+		t.Fatalf("Expected the walk of 1.3.6.1.2.1.2 to be cut off, got: %v", err)
+	}
+	if len(pdus) < 2 || len(pdus) > 10 {
+		t.Fatalf("Wrong number of PDUs: %v", pdus)
+	}
+	// What the cut off walk fetched is kept, and the rest of the module walked.
+	if pdus[0].Name != ".1.3.6.1.2.1.2.2.1.1.1" || pdus[len(pdus)-1].Name != ".1.3.6.1.2.1.1.3.0" {
+		t.Errorf("Wrong PDUs: %v", pdus)
+	}
+}
+
+func TestPartialResults(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100, "1.3.6.1.2.1.2.2.1.1.1": 1})
+	defer a.close()
+	a.mtx.Lock()
+	a.unanswered = "1.3.6.1.2.1.2"
+	a.mtx.Unlock()
+	module := &config.Module{
+		Walk:       []string{"1.3.6.1.2.1.1", "1.3.6.1.2.1.2"},
+		WalkParams: config.DefaultWalkParams,
+		Metrics: []*config.Metric{
+			{Name: "sysUpTime", Oid: "1.3.6.1.2.1.1.3", Type: "gauge"},
+			{Name: "ifIndex", Oid: "1.3.6.1.2.1.2.2.1.1", Type: "gauge"},
+		},
+	}
+	module.WalkParams.Retries = 0
+	module.WalkParams.Timeout = 50 * time.Millisecond
+
+	collect := func() map[string]string {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			collector{target: a.addr(), module: module}.Collect(ch)
+			close(ch)
+		}()
+		got := map[string]string{}
+		for m := range ch {
+			name := regexp.MustCompile(`fqName: "([^"]*)"`).FindStringSubmatch(m.Desc().String())[1]
+			pb := &io_prometheus_client.Metric{}
+			m.Write(pb)
+			got[name] = pb.String()
 		}
-		metric := &io_prometheus_client.Metric{}
-		for _, m := range metrics {
-			err := m.Write(metric)
-			if err != nil {
-				t.Fatalf("Error writing metric: %v", err)
-			}
-			if _, ok := c.expectedMetrics[metric.String()]; !ok {
-				t.Fatalf("Unexpected metric: got %v", metric.String())
-			}
-			if c.expectedMetrics[metric.String()] != m.Desc().String() {
-				t.Fatalf("Unexpected metric: got %v , want %v", m.Desc().String(), c.expectedMetrics[metric.String()])
+		return got
+	}
+
+	got := collect()
+	if _, ok := got["snmp_error"]; !ok || len(got) != 1 {
+		t.Errorf("Expected the scrape to fail: %v", got)
+	}
+
+	module.PartialResults = true
+	got = collect()
+	if _, ok := got["sysUpTime"]; !ok {
+		t.Errorf("Metrics fetched before the timeout not exported: %v", got)
+	}
+	if _, ok := got["ifIndex"]; ok {
+		t.Errorf("Unexpected metric fetched after the timeout: %v", got)
+	}
+	if partial := got["snmp_scrape_partial"]; !strings.Contains(partial, `value:"timeout"`) {
+		t.Errorf("Wrong snmp_scrape_partial: %s", partial)
+	}
+}
+
+func TestGetOidsInChunks(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100, "1.3.6.1.2.1.1.7.0": 72})
+	defer a.close()
+	oids := []string{"1.3.6.1.2.1.1.3.0", "1.3.6.1.2.1.1.5.0", "1.3.6.1.2.1.1.7.0"}
+
+	cases := []struct {
+		name        string
+		version     int
+		errorStatus int
+		want        []string
+		err         string
+	}{
+		{
+			name:    "Missing objects are kept as noSuchObject",
+			version: 2,
+			want:    []string{".1.3.6.1.2.1.1.3.0=Integer", ".1.3.6.1.2.1.1.5.0=NoSuchObject", ".1.3.6.1.2.1.1.7.0=Integer"},
+		},
+		{
+			name:    "SNMPv1 noSuchName gets the OIDs one at a time",
+			version: 1,
+			want:    []string{".1.3.6.1.2.1.1.3.0=Integer", ".1.3.6.1.2.1.1.5.0=NoSuchObject", ".1.3.6.1.2.1.1.7.0=Integer"},
+		},
+		{
+			name:        "Other error statuses fail",
+			version:     2,
+			errorStatus: 5,
+			err:         "genErr",
+		},
+	}
+	for _, c := range cases {
+		a.mtx.Lock()
+		a.errorStatus = c.errorStatus
+		a.mtx.Unlock()
+		module := &config.Module{WalkParams: config.DefaultWalkParams}
+		module.WalkParams.Version = c.version
+		snmp, err := newSession(a.addr(), module, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdus, err := getOidsInChunks(snmp, oids)
+		snmp.Conn.Close()
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: want error %q, got %v", c.name, c.err, err)
 			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		got := []string{}
+		for _, pdu := range pdus {
+			got = append(got, fmt.Sprintf("%s=%s", pdu.Name, pdu.Type))
 		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestDynamicFilterWalkReused(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{
+		"1.3.6.1.2.1.2.2.1.1.1": 1, "1.3.6.1.2.1.2.2.1.1.2": 2, "1.3.6.1.2.1.2.2.1.1.3": 3,
+		"1.3.6.1.2.1.2.2.1.7.1": 1, "1.3.6.1.2.1.2.2.1.7.2": 2, "1.3.6.1.2.1.2.2.1.7.3": 1,
+	})
+	defer a.close()
+	module := &config.Module{
+		Walk:       []string{"1.3.6.1.2.1.2.2"},
+		WalkParams: config.DefaultWalkParams,
+		Metrics: []*config.Metric{
+			{Name: "ifIndex", Oid: "1.3.6.1.2.1.2.2.1.1", Type: "gauge"},
+			{Name: "ifAdminStatus", Oid: "1.3.6.1.2.1.2.2.1.7", Type: "gauge"},
+		},
+		Filters: config.Filters{
+			Dynamic: []config.DynamicFilter{
+				{Oid: "1.3.6.1.2.1.2.2.1.7", Targets: []string{"1.3.6.1.2.1.2.2"}, Values: []string{"1"}},
+			},
+		},
+	}
+	stats := newScrapeStats()
+	pdus, err := scrapeTarget(a.addr(), module, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, pdu := range pdus {
+		got = append(got, pdu.Name)
+	}
+	want := []string{".1.3.6.1.2.1.2.2.1.7.1", ".1.3.6.1.2.1.2.2.1.7.3", ".1.3.6.1.2.1.2.2.1.1.1", ".1.3.6.1.2.1.2.2.1.1.3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong PDUs: want %v, got %v", want, got)
+	}
+	// The walk of the filter column, three rows and endOfMibView, and the
+	// GET of ifIndex for the allowed rows. ifAdminStatus isn't got again.
+	if stats.varbinds != 6 {
+		t.Errorf("Wrong varbinds: %d", stats.varbinds)
+	}
+}
+
+func TestFilterPdus(t *testing.T) {
+	pdus := []gosnmp.SnmpPDU{
+		{Name: ".1.1.1.1"},
+		{Name: ".1.1.1.2"},
+		{Name: ".1.1.2.1"},
+		{Name: ".1.1.2.2"},
+		{Name: ".1.2.1"},
+	}
+	allowedOids := map[string][]string{"1.1": []string{"1.1.1.2", "1.1.2.2"}}
+	got := []string{}
+	for _, pdu := range filterPdus(pdus, allowedOids) {
+		got = append(got, pdu.Name)
+	}
+	want := []string{".1.1.1.2", ".1.1.2.2", ".1.2.1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterPdus: got %v, want %v", got, want)
+	}
+}
+
+func TestFilterAllowedOidsStatic(t *testing.T) {
+	module := &config.Module{
+		Metrics: []*config.Metric{
+			{Name: "ifIndex", Oid: "1.1.1.1"},
+			{Name: "ifDescr", Oid: "1.1.1.2"},
+			{Name: "vlanName", Oid: "1.2.1.1"},
+		},
+		Filters: config.Filters{
+			Static: []config.StaticFilter{
+				{Targets: []string{"1.1"}, Indices: []string{"1", "5", "10"}},
+				{Targets: []string{"1.1"}, Indices: []string{"5", "10", "20"}},
+				{Targets: []string{"1.2.1.1"}, Indices: []string{"100"}},
+			},
+		},
+	}
+	// Static filters don't walk, so need no connection.
+	got, _, err := filterAllowedOids(nil, module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{
+		"1.1":     []string{"1.1.1.1.10", "1.1.1.1.5", "1.1.1.2.10", "1.1.1.2.5"},
+		"1.2.1.1": []string{"1.2.1.1.100"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterAllowedOids: got %v, want %v", got, want)
 	}
 }
 
@@ -262,6 +1236,64 @@ func TestGetPduValue(t *testing.T) {
 	}
 }
 
+func TestGetPduValueOctetString(t *testing.T) {
+	pdu := &gosnmp.SnmpPDU{
+		Value: []byte(" 12.5 "),
+		Type:  gosnmp.OctetString,
+	}
+	if value := getPduValue(pdu); value != 12.5 {
+		t.Fatalf("Got wrong value for numeric OctetString PDU: %v", value)
+	}
+	pdu.Value = []byte("abc")
+	if value := getPduValue(pdu); !math.IsNaN(value) {
+		t.Fatalf("Got non-NaN value for non-numeric OctetString PDU: %v", value)
+	}
+}
+
+func TestGetPduValueOpaque(t *testing.T) {
+	pdu := &gosnmp.SnmpPDU{
+		Value: float32(1.5),
+		Type:  gosnmp.OpaqueFloat,
+	}
+	if value := getPduValue(pdu); value != 1.5 {
+		t.Fatalf("Got wrong value for OpaqueFloat PDU: %v", value)
+	}
+	pdu = &gosnmp.SnmpPDU{
+		Value: float64(-2.25),
+		Type:  gosnmp.OpaqueDouble,
+	}
+	if value := getPduValue(pdu); value != -2.25 {
+		t.Fatalf("Got wrong value for OpaqueDouble PDU: %v", value)
+	}
+	// Malformed values.
+	for _, pdu := range []*gosnmp.SnmpPDU{{Type: gosnmp.OpaqueFloat}, {Type: gosnmp.OpaqueDouble, Value: []byte{1}}} {
+		if value := getPduValue(pdu); !math.IsNaN(value) {
+			t.Errorf("Got non-NaN value for malformed %s PDU: %v", pdu.Type, value)
+		}
+	}
+}
+
+func TestDecodeOpaqueFloat(t *testing.T) {
+	// A response with 1.3.6.1 as an Opaque wrapped float of 1.5, as sent by
+	// NetSNMP.
+	response := []byte{
+		0x30, 0x28, 0x02, 0x01, 0x01, 0x04, 0x06, 'p', 'u', 'b', 'l', 'i', 'c',
+		0xa2, 0x1b, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+		0x30, 0x10, 0x30, 0x0e, 0x06, 0x03, 0x2b, 0x06, 0x01,
+		0x44, 0x07, 0x9f, 0x78, 0x04, 0x3f, 0xc0, 0x00, 0x00,
+	}
+	packet, err := gosnmp.Default.SnmpDecodePacket(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet.Variables) != 1 || packet.Variables[0].Type != gosnmp.OpaqueFloat {
+		t.Fatalf("Opaque float not decoded: %+v", packet.Variables)
+	}
+	if value := getPduValue(&packet.Variables[0]); value != 1.5 {
+		t.Errorf("Got wrong value for OpaqueFloat PDU: %v", value)
+	}
+}
+
 func TestOidToList(t *testing.T) {
 	cases := []struct {
 		oid    string
@@ -367,6 +1399,44 @@ func TestPduValueAsString(t *testing.T) {
 			typ:    "IpAddr",
 			result: "1.2.3.4",
 		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{7, 226, 1, 2, 3, 4, 5, 6, '+', 1, 0}},
+			typ:    "DateAndTime",
+			result: "2018-01-02T03:04:05.6+01:00",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{7, 226, 1, 2, 3, 4, 5, 0}},
+			typ:    "DateAndTime",
+			result: "2018-01-02T03:04:05Z",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{7, 226, 1}},
+			typ:    "DateAndTime",
+			result: "0x07E201",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{10, 0, 0, 1}},
+			typ:    "InetAddress",
+			result: "10.0.0.1",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255, 255, 10, 0, 0, 1}},
+			typ:    "InetAddress",
+			result: "::ffff:10.0.0.1",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: []byte{32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+			typ:    "InetAddressIPv6",
+			result: "2001:db8::1",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: float32(1.1)},
+			result: "1.1",
+		},
+		{
+			pdu:    &gosnmp.SnmpPDU{Value: float64(-2.5)},
+			result: "-2.5",
+		},
 		{
 			pdu:    &gosnmp.SnmpPDU{Value: nil},
 			result: "",
@@ -385,6 +1455,7 @@ func TestIndexesToLabels(t *testing.T) {
 		oid      []int
 		metric   config.Metric
 		oidToPdu map[string]gosnmp.SnmpPDU
+		module   config.Module
 		result   map[string]string
 	}{
 		{
@@ -399,6 +1470,48 @@ func TestIndexesToLabels(t *testing.T) {
 			oidToPdu: map[string]gosnmp.SnmpPDU{},
 			result:   map[string]string{"l": "4"},
 		},
+		{
+			oid: []int{4},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge",
+					RegexpReplacements: []config.RegexpReplacement{{Regex: config.Regexp{regexp.MustCompile("^4$")}, Replacement: "four"}}}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "ifDescr", Oid: "1.1.1", Type: "DisplayString",
+					RegexpReplacements: []config.RegexpReplacement{
+						{Regex: config.Regexp{regexp.MustCompile("GigabitEthernet")}, Replacement: "Gi"},
+						{Regex: config.Regexp{regexp.MustCompile(`\s+$`)}, Replacement: ""},
+					}}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.1.1.4": gosnmp.SnmpPDU{Value: "GigabitEthernet0/1  "}},
+			result:   map[string]string{"l": "four", "ifDescr": "Gi0/1"},
+		},
+		{
+			oid: []int{2, 255, 97},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "OctetString", Encoding: "base64"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "name", Oid: "1.1.1", Type: "DisplayString", Encoding: "ascii"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.1.1.2.255.97": gosnmp.SnmpPDU{Value: []byte{'a', 0}}},
+			result:   map[string]string{"l": "/2E=", "name": `a\x00`},
+		},
+		{
+			oid:      []int{4, 10, 0, 0, 1, 7},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "a", Type: "OctetString", DisplayHint: "1d.1d.1d.1d"}, {Labelname: "b", Type: "gauge"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"a": "10.0.0.1", "b": "7"},
+		},
+		{
+			oid: []int{3, 1000005},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "slot", Type: "gauge"}, {Labelname: "port", Type: "gauge"}},
+				Lookups: []*config.Lookup{
+					{Labels: []string{"slot", "port"}, Labelname: "ifDescr", Oid: "1.1.1", Type: "DisplayString", StripPrefix: 1, IndexOffset: -1000000},
+					{Labels: []string{"slot", "port"}, Labelname: "ifAlias", Oid: "1.1.2", Type: "DisplayString", IndexOffset: -2000000},
+					{Labels: []string{"slot"}, Labelname: "ifName", Oid: "1.1.3", Type: "DisplayString", StripPrefix: 2},
+				},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.1.1.5": gosnmp.SnmpPDU{Value: "eth5"}},
+			result:   map[string]string{"slot": "3", "port": "1000005", "ifDescr": "eth5", "ifAlias": "", "ifName": ""},
+		},
 		{
 			oid: []int{3, 4},
 			metric: config.Metric{
@@ -456,6 +1569,33 @@ func TestIndexesToLabels(t *testing.T) {
 			oidToPdu: map[string]gosnmp.SnmpPDU{},
 			result:   map[string]string{"l": "01:FF:00:00:00:10"},
 		},
+		{
+			oid:      []int{1, 255, 0, 0, 0, 16},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "PhysAddress48"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			module:   config.Module{MACAddressFormat: "dot", MACAddressLowercase: true},
+			result:   map[string]string{"l": "01ff.0000.0010"},
+		},
+		{
+			oid: []int{1},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "mac", Oid: "1.1.1", Type: "PhysAddress48"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.1.1.1": {Value: []byte{1, 255, 0, 0, 0, 16}}},
+			module:   config.Module{MACAddressFormat: "hyphen"},
+			result:   map[string]string{"l": "1", "mac": "01-FF-00-00-00-10"},
+		},
+		{
+			oid: []int{1},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "mac", Oid: "1.1.1", Type: "PhysAddress48"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.1.1.1": {Value: []byte{1, 255, 0, 0, 0, 16}}},
+			module:   config.Module{MACAddressFormat: "raw", MACAddressLowercase: true},
+			result:   map[string]string{"l": "1", "mac": "01ff00000010"},
+		},
 		{
 			oid:      []int{3, 65, 32, 255},
 			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "OctetString"}}},
@@ -471,6 +1611,44 @@ func TestIndexesToLabels(t *testing.T) {
 			oidToPdu: map[string]gosnmp.SnmpPDU{"1.3.65.32.255": gosnmp.SnmpPDU{Value: "octet"}},
 			result:   map[string]string{"l": "octet"},
 		},
+		{
+			oid: []int{65, 32, 255, 7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "OctetString", FixedSize: 3}, {Labelname: "a", Type: "gauge"}},
+				Lookups: []*config.Lookup{{Labels: []string{"l"}, Labelname: "d", Oid: "1"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.65.32.255": gosnmp.SnmpPDU{Value: "desc"}},
+			result:   map[string]string{"l": "0x4120FF", "a": "7", "d": "desc"},
+		},
+		{
+			oid:      []int{101, 116, 104, 48},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "DisplayString", FixedSize: 4}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "eth0"},
+		},
+		{
+			oid:      []int{7, 65, 32, 255},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "a", Type: "gauge"}, {Labelname: "l", Type: "OctetString", Implied: true}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"a": "7", "l": "0x4120FF"},
+		},
+		{
+			oid: []int{7, 101, 116, 104, 48},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "a", Type: "gauge"}, {Labelname: "l", Type: "DisplayString", Implied: true}},
+				Lookups: []*config.Lookup{{Labels: []string{"a", "l"}, Labelname: "d", Oid: "1"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.7.101.116.104.48": gosnmp.SnmpPDU{Value: "desc"}},
+			result:   map[string]string{"a": "7", "l": "eth0", "d": "desc"},
+		},
+		{
+			oid: []int{1, 10, 0, 0, 1},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "l", Type: "InetAddress", Implied: true}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"t": "ipv4", "l": "10.0.0.1"},
+		},
 		{
 			oid:      []int{1, 4, 192, 168, 1, 2},
 			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "InetAddress"}}},
@@ -490,7 +1668,7 @@ func TestIndexesToLabels(t *testing.T) {
 			oid:      []int{2, 16, 42, 6, 29, 128, 0, 1, 0, 3, 0, 0, 0, 0, 0, 1, 1, 52},
 			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "InetAddress"}}},
 			oidToPdu: map[string]gosnmp.SnmpPDU{},
-			result:   map[string]string{"l": "2A06:1D80:0001:0003:0000:0000:0001:0134"},
+			result:   map[string]string{"l": "2a06:1d80:1:3::1:134"},
 		},
 		{
 			oid:      []int{3, 1, 9},
@@ -507,6 +1685,37 @@ func TestIndexesToLabels(t *testing.T) {
 			oidToPdu: map[string]gosnmp.SnmpPDU{"3.2.16.42.6.29.128.0.1.0.3.0.0.0.0.0.1.1.52": gosnmp.SnmpPDU{Value: "ipv6"}},
 			result:   map[string]string{"l": "ipv6", "b": "7"},
 		},
+		{
+			oid: []int{2, 16, 32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 7},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "l", Type: "InetAddress"}, {Labelname: "b", Type: "gauge"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"t": "ipv6", "l": "2001:db8::1", "b": "7"},
+		},
+		{
+			oid: []int{1, 4, 10, 0, 0, 1},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "l", Type: "InetAddress"}},
+				Lookups: []*config.Lookup{{Labels: []string{"t", "l"}, Labelname: "n", Oid: "3"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"3.1.4.10.0.0.1": gosnmp.SnmpPDU{Value: "name"}},
+			result:   map[string]string{"t": "ipv4", "l": "10.0.0.1", "n": "name"},
+		},
+		{
+			oid: []int{4, 20, 254, 128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 3},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "t", Type: "InetAddressType"}, {Labelname: "l", Type: "InetAddress"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"t": "ipv6z", "l": "fe80::1%3"},
+		},
+		{
+			oid:      []int{32, 1, 13, 184, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 7},
+			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "InetAddressIPv6"}, {Labelname: "b", Type: "gauge"}}},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "2001:db8::1", "b": "7"},
+		},
 		{
 			oid:      []int{192, 168, 1, 2},
 			metric:   config.Metric{Indexes: []*config.Index{{Labelname: "l", Type: "IpAddr"}}},
@@ -537,11 +1746,181 @@ func TestIndexesToLabels(t *testing.T) {
 				"g": "42",
 			},
 		},
+		{
+			oid: []int{4},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{
+					{
+						Labels: []string{"l"}, Labelname: "parent", Oid: "1.1", Type: "gauge",
+						Lookups: []*config.Lookup{{Labelname: "parentName", Oid: "1.2", Type: "DisplayString"}},
+					},
+				},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{
+				"1.1.4": gosnmp.SnmpPDU{Value: 2},
+				"1.2.2": gosnmp.SnmpPDU{Value: []byte("chassis")},
+			},
+			result: map[string]string{"l": "4", "parent": "2", "parentName": "chassis"},
+		},
+		{
+			oid: []int{4},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{
+					{
+						Labels: []string{"l"}, Labelname: "parent", Oid: "1.1", Type: "gauge",
+						Lookups: []*config.Lookup{{Labelname: "parentName", Oid: "1.2", Type: "DisplayString"}},
+					},
+				},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{},
+			result:   map[string]string{"l": "4", "parent": "", "parentName": ""},
+		},
+		{
+			oid: []int{4},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "l", Type: "gauge"}},
+				Lookups: []*config.Lookup{
+					{
+						Labels: []string{"l"}, Labelname: "name", Oid: "1.1", Type: "DisplayString",
+						Lookups: []*config.Lookup{{Labelname: "nameDesc", Oid: "1.2", Type: "DisplayString"}},
+					},
+				},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{
+				"1.1.4":       gosnmp.SnmpPDU{Value: []byte("ab")},
+				"1.2.2.97.98": gosnmp.SnmpPDU{Value: []byte("desc")},
+			},
+			result: map[string]string{"l": "4", "name": "ab", "nameDesc": "desc"},
+		},
+		{
+			oid: []int{2, 10, 0, 0, 1},
+			metric: config.Metric{
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}, {Labelname: "addr", Type: "IpAddr"}},
+				Lookups: []*config.Lookup{
+					{Labels: []string{"ifIndex"}, Labelname: "ifName", Oid: "1.2", Type: "DisplayString"},
+				},
+				JoinIndexes: []*config.JoinIndexes{{Labels: []string{"ifIndex", "addr"}, Labelname: "address", Separator: "/"}},
+			},
+			oidToPdu: map[string]gosnmp.SnmpPDU{"1.2.2": gosnmp.SnmpPDU{Value: []byte("eth0")}},
+			result:   map[string]string{"address": "2/10.0.0.1", "ifName": "eth0"},
+		},
 	}
 	for _, c := range cases {
-		got := indexesToLabels(c.oid, &c.metric, c.oidToPdu)
+		got := indexesToLabels(c.oid, &c.metric, c.oidToPdu, &c.module)
 		if !reflect.DeepEqual(got, c.result) {
 			t.Errorf("oidToList(%v, %v, %v): got %v, want %v", c.oid, c.metric, c.oidToPdu, got, c.result)
 		}
 	}
 }
+
+func TestEncodeOctets(t *testing.T) {
+	cases := []struct {
+		in       []byte
+		encoding string
+		out      string
+	}{
+		{in: []byte{0xca, 0xfe}, encoding: "hex", out: "0xCAFE"},
+		{in: []byte{}, encoding: "hex", out: ""},
+		{in: []byte("Port 1"), encoding: "base64", out: "UG9ydCAx"},
+		{in: []byte{'a', 0xff, 0xc3, 0xa9}, encoding: "utf8", out: "a\ufffd\u00e9"},
+		{in: []byte{'a', '\\', 0, 0xc3}, encoding: "ascii", out: `a\\\x00\xc3`},
+	}
+	for _, c := range cases {
+		if got := encodeOctets(c.in, c.encoding); got != c.out {
+			t.Errorf("encodeOctets(%v, %s): got %q, want %q", c.in, c.encoding, got, c.out)
+		}
+	}
+}
+
+func TestDisplayHintOctets(t *testing.T) {
+	cases := []struct {
+		hint string
+		in   []byte
+		out  string
+		err  bool
+	}{
+		{hint: "1x:", in: []byte{0x00, 0x1a, 0x2b}, out: "00:1A:2B"},
+		{hint: "1d.1d.1d.1d", in: []byte{10, 0, 0, 1}, out: "10.0.0.1"},
+		{hint: "255a", in: []byte("Gi0/1"), out: "Gi0/1"},
+		{hint: "2d-1d-1d,1d:1d:1d.1d", in: []byte{0x07, 0xe8, 3, 5, 10, 2, 3, 0}, out: "2024-3-5,10:2:3.0"},
+		{hint: "1d.", in: []byte{1, 2, 3}, out: "1.2.3"},
+		{hint: "2o", in: []byte{0x01, 0x00}, out: "400"},
+		{hint: "*1d./1a", in: []byte{2, 1, 2, 'x', 'y'}, out: "1.2/xy"},
+		{hint: "1x:", in: []byte{}, out: ""},
+		{hint: "x", in: []byte{1}, err: true},
+		{hint: "0x", in: []byte{1}, err: true},
+		{hint: "1q", in: []byte{1}, err: true},
+	}
+	for _, c := range cases {
+		got, err := displayHintOctets(c.hint, c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("displayHintOctets(%q, %v): expected error, got %q", c.hint, c.in, got)
+			}
+			continue
+		}
+		if err != nil || got != c.out {
+			t.Errorf("displayHintOctets(%q, %v): got %q, %v, want %q", c.hint, c.in, got, err, c.out)
+		}
+	}
+}
+
+func TestTruncateValue(t *testing.T) {
+	cases := []struct {
+		in        string
+		maxLength int
+		out       string
+	}{
+		{in: "abcdef", maxLength: 0, out: "abcdef"},
+		{in: "abcdef", maxLength: 6, out: "abcdef"},
+		{in: "abcdef", maxLength: 3, out: "abc..."},
+		{in: "ééé", maxLength: 3, out: "ééé"},
+		{in: "éééé", maxLength: 3, out: "ééé..."},
+	}
+	for _, c := range cases {
+		if got := truncateValue(c.in, c.maxLength); got != c.out {
+			t.Errorf("truncateValue(%q, %d): got %q, want %q", c.in, c.maxLength, got, c.out)
+		}
+	}
+}
+
+func TestScrapeStats(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100, "1.3.6.1.2.1.2.2.1.1.1": 1, "1.3.6.1.2.1.2.2.1.1.2": 2, "1.3.6.1.2.1.2.2.1.1.3": 3})
+	defer a.close()
+	a.mtx.Lock()
+	a.lose = 1
+	a.mtx.Unlock()
+	module := &config.Module{
+		Walk:       []string{"1.3.6.1.2.1.2"},
+		Get:        []string{"1.3.6.1.2.1.1.3.0"},
+		WalkParams: config.DefaultWalkParams,
+	}
+	module.WalkParams.Retries = 1
+	module.WalkParams.Timeout = 50 * time.Millisecond
+
+	stats := newScrapeStats()
+	if _, err := scrapeTarget(a.addr(), module, stats); err != nil {
+		t.Fatal(err)
+	}
+	// The lost GETBULK, its retry and the GET.
+	if stats.packetsSent != 3 || stats.packetsReceived != 2 || stats.retries != 1 {
+		t.Errorf("Wrong packets counted: %+v", stats)
+	}
+	// Three rows and endOfMibView, then sysUpTime.
+	if stats.varbinds != 5 {
+		t.Errorf("Wrong varbinds counted: %d", stats.varbinds)
+	}
+	if _, ok := stats.walkDurations["1.3.6.1.2.1.2"]; !ok || len(stats.walkDurations) != 1 {
+		t.Errorf("Wrong walk durations: %v", stats.walkDurations)
+	}
+
+	stats.response(&gosnmp.SnmpPacket{Error: gosnmp.NoSuchName, Variables: []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.Null}}})
+	if stats.errorStatuses[gosnmp.NoSuchName] != 1 || len(stats.errorStatuses) != 1 {
+		t.Errorf("Wrong error statuses: %v", stats.errorStatuses)
+	}
+	if name := errorStatusName(gosnmp.NoSuchName); name != "noSuchName" {
+		t.Errorf("Wrong name of noSuchName: %s", name)
+	}
+}
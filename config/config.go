@@ -1,27 +1,264 @@
 package config
 
 import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
 	"github.com/soniah/gosnmp"
 	"gopkg.in/yaml.v2"
 )
 
+// Load a config file. The filename may be a glob, such as conf.d/*.yml, in
+// which case the modules and auths of all the matching files are merged.
+// Files may be YAML, or compiled by Compile.
 func LoadFile(filename string) (*Config, error) {
-	content, err := ioutil.ReadFile(filename)
+	return loadFiles(filename, true, 0)
+}
+
+// LoadFileLazy loads a config file as LoadFile does, except that modules are
+// only decoded when first used by Module, keeping up to size of them decoded.
+// This saves memory when only a few of many modules are used. Errors in a
+// module aren't found until it is used.
+func LoadFileLazy(filename string, size int) (*Config, error) {
+	return loadFiles(filename, true, size)
+}
+
+// CurrentVersion is the version of the config schema. When the config
+// changes incompatibly, bump it and add a migration from the previous
+// version. Files without a version are version 1.
+const CurrentVersion = 1
+
+// Migrations of the YAML of a config from each version to the next, by the
+// version they migrate from.
+var migrations = map[int]func(yaml.MapSlice) (yaml.MapSlice, error){}
+
+func checkVersion(version int) error {
+	switch {
+	case version == 0:
+		version = 1
+	case version < 0:
+		return fmt.Errorf("invalid config version %d", version)
+	case version > CurrentVersion:
+		return fmt.Errorf("config version %d is newer than this exporter supports, which is %d", version, CurrentVersion)
+	}
+	if version < CurrentVersion {
+		return fmt.Errorf("config version %d is older than %d, update it with snmp_exporter migrate-config", version, CurrentVersion)
+	}
+	return nil
+}
+
+// Migrate the YAML of a config file to the current version. Comments are
+// not kept.
+func Migrate(content []byte) ([]byte, error) {
+	var items yaml.MapSlice
+	if err := yaml.Unmarshal(content, &items); err != nil {
+		return nil, err
+	}
+	version := 1
+	for i, item := range items {
+		if item.Key == "version" {
+			v, ok := item.Value.(int)
+			if !ok {
+				return nil, fmt.Errorf("invalid config version %v", item.Value)
+			}
+			if v > 0 {
+				version = v
+			}
+			items = append(items[:i:i], items[i+1:]...)
+			break
+		}
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("config version %d is newer than this exporter supports, which is %d", version, CurrentVersion)
+	}
+	for ; version < CurrentVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("no migration from config version %d", version)
+		}
+		var err error
+		if items, err = migrate(items); err != nil {
+			return nil, fmt.Errorf("migrating from config version %d: %s", version, err)
+		}
+	}
+	items = append(yaml.MapSlice{{Key: "version", Value: CurrentVersion}}, items...)
+	out, err := yaml.Marshal(items)
 	if err != nil {
 		return nil, err
 	}
-	cfg := &Config{}
-	err = yaml.Unmarshal(content, cfg)
+	// Check the result is valid.
+	if _, _, err := parseFile(out, false); err != nil {
+		return nil, fmt.Errorf("migrated config is invalid: %s", err)
+	}
+	return out, nil
+}
+
+// Compiled configs start with this, followed by the gob encoded
+// compiledConfig. Change it when the Config changes incompatibly.
+const compiledHeader = "snmp_exporter compiled config 2\n"
+
+type compiledConfig struct {
+	Auths map[string]*NamedAuth
+	// Each module is encoded separately, so they can be decoded lazily.
+	Modules map[string][]byte
+}
+
+// Compile the config file, or files if a glob, into a form that is much
+// quicker to load than YAML. Credentials from the environment or files
+// aren't included, they're loaded along with the compiled config.
+func Compile(filename string, w io.Writer) error {
+	cfg, err := loadFiles(filename, false, 0)
+	if err != nil {
+		return err
+	}
+	compiled := compiledConfig{Auths: cfg.Auths, Modules: map[string][]byte{}}
+	for name, module := range cfg.Modules {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(module); err != nil {
+			return fmt.Errorf("module %s: %s", name, err)
+		}
+		compiled.Modules[name] = buf.Bytes()
+	}
+	if _, err := io.WriteString(w, compiledHeader); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(compiled)
+}
+
+// Parse a file, which may be YAML or compiled. If lazy, the modules are
+// returned undecoded.
+func parseFile(content []byte, lazy bool) (*Config, map[string]*rawModule, error) {
+	cfg := &Config{Modules: map[string]*Module{}}
+	raw := map[string]*rawModule{}
+	if bytes.HasPrefix(content, []byte(compiledHeader)) {
+		compiled := compiledConfig{}
+		if err := gob.NewDecoder(bytes.NewReader(content[len(compiledHeader):])).Decode(&compiled); err != nil {
+			return nil, nil, err
+		}
+		cfg.Auths = compiled.Auths
+		for name, b := range compiled.Modules {
+			raw[name] = &rawModule{content: b, compiled: true}
+		}
+	} else if lazy {
+		// Split the file into the YAML of each module, without decoding them.
+		var items yaml.MapSlice
+		if err := yaml.Unmarshal(content, &items); err != nil {
+			return nil, nil, err
+		}
+		for _, item := range items {
+			b, err := yaml.Marshal(item.Value)
+			if err != nil {
+				return nil, nil, err
+			}
+			name := fmt.Sprint(item.Key)
+			if name == "version" {
+				if err := yaml.Unmarshal(b, &cfg.Version); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			if name == "auths" {
+				if err := yaml.Unmarshal(b, &cfg.Auths); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			raw[name] = &rawModule{content: b}
+		}
+	} else if err := yaml.Unmarshal(content, cfg); err != nil {
+		return nil, nil, err
+	}
+	if lazy {
+		return cfg, raw, nil
+	}
+	for name, r := range raw {
+		module, err := r.decode()
+		if err != nil {
+			return nil, nil, fmt.Errorf("module %s: %s", name, err)
+		}
+		cfg.Modules[name] = module
+	}
+	return cfg, nil, nil
+}
+
+// Load the files, and if secrets is false make the paths of credential
+// files absolute rather than reading them. If lazy is above 0, modules are
+// decoded when used with an LRU of that size.
+func loadFiles(filename string, secrets bool, lazy int) (*Config, error) {
+	filenames, err := filepath.Glob(filename)
 	if err != nil {
 		return nil, err
 	}
-	return cfg, nil
+	if len(filenames) == 0 {
+		if strings.ContainsAny(filename, "*?[") {
+			return nil, fmt.Errorf("no config files match %s", filename)
+		}
+		// Not a glob, so report the file as missing.
+		filenames = []string{filename}
+	}
+
+	// All the files are checked to be the current version.
+	cfg := Config{Version: CurrentVersion, Modules: map[string]*Module{}, Auths: map[string]*NamedAuth{}}
+	if lazy > 0 {
+		cfg.lazy = newLazyModules(lazy)
+	}
+	sources := map[string]string{}
+	authSources := map[string]string{}
+	for _, f := range filenames {
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		fileCfg, raw, err := parseFile(content, lazy > 0)
+		if err == nil {
+			err = checkVersion(fileCfg.Version)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f, err)
+		}
+		for name, module := range fileCfg.Modules {
+			if err := module.WalkParams.Auth.load(filepath.Dir(f), secrets); err != nil {
+				return nil, fmt.Errorf("%s: module %s: %s", f, name, err)
+			}
+			if source, ok := sources[name]; ok {
+				return nil, fmt.Errorf("module %s is defined in both %s and %s", name, source, f)
+			}
+			cfg.Modules[name] = module
+			sources[name] = f
+		}
+		for name, module := range raw {
+			if source, ok := sources[name]; ok {
+				return nil, fmt.Errorf("module %s is defined in both %s and %s", name, source, f)
+			}
+			module.dir = filepath.Dir(f)
+			cfg.lazy.raw[name] = module
+			sources[name] = f
+		}
+		for name, auth := range fileCfg.Auths {
+			if err := auth.Auth.load(filepath.Dir(f), secrets); err != nil {
+				return nil, fmt.Errorf("%s: auth %s: %s", f, name, err)
+			}
+			if source, ok := authSources[name]; ok {
+				return nil, fmt.Errorf("auth %s is defined in both %s and %s", name, source, f)
+			}
+			cfg.Auths[name] = auth
+			authSources[name] = f
+		}
+	}
+	return &cfg, nil
 }
 
 var (
@@ -44,28 +281,165 @@ var (
 	DefaultRegexpExtract = RegexpExtract{
 		Value: "$1",
 	}
+	DefaultJoinIndexes = JoinIndexes{
+		Separator: ".",
+	}
+	DefaultRelabelConfig = RelabelConfig{
+		Separator:   ";",
+		Regex:       Regexp{regexp.MustCompile("^(?:(.*))$")},
+		Replacement: "$1",
+		Action:      "replace",
+	}
 )
 
-// Config for the snmp_exporter.
-type Config map[string]*Module
+// Config for the snmp_exporter. The modules are at the top level of the
+// file, so auths and version can't be used as module names.
+type Config struct {
+	// The version of the schema, see CurrentVersion.
+	Version int `yaml:"version,omitempty"`
+	// Credentials that a scrape can use instead of those of the module,
+	// chosen with the auth URL parameter.
+	Auths   map[string]*NamedAuth `yaml:"auths,omitempty"`
+	Modules map[string]*Module    `yaml:",inline"`
+
+	// With LoadFileLazy, the modules that are decoded when first used.
+	lazy *lazyModules
+}
+
+// CheckModuleName returns an error if the name can't be that of a module,
+// as modules are inline in the config alongside its other keys.
+func CheckModuleName(name string) error {
+	if name == "auths" || name == "version" {
+		return fmt.Errorf("module name %q is reserved, as it's a key of the config", name)
+	}
+	return nil
+}
+
+// Module returns the named module, or nil if there's no such module.
+func (c *Config) Module(name string) (*Module, error) {
+	if c.lazy != nil {
+		return c.lazy.get(name)
+	}
+	return c.Modules[name], nil
+}
+
+// ModuleNames returns the sorted names of all the modules.
+func (c *Config) ModuleNames() []string {
+	names := []string{}
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	if c.lazy != nil {
+		for name := range c.lazy.raw {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MarshalJSON implements the json.Marshaler interface, putting the modules
+// at the top level as is done for YAML.
+func (c Config) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{}
+	for name, module := range c.Modules {
+		out[name] = module
+	}
+	if len(c.Auths) > 0 {
+		out["auths"] = c.Auths
+	}
+	if c.Version != 0 {
+		out["version"] = c.Version
+	}
+	return json.Marshal(out)
+}
+
+// NamedAuth is the SNMP version and credentials to scrape with, as set for
+// a module.
+type NamedAuth struct {
+	Version int  `yaml:"version,omitempty" json:"version,omitempty"`
+	Auth    Auth `yaml:"auth,omitempty" json:"auth,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *NamedAuth) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.Version = DefaultWalkParams.Version
+	c.Auth = DefaultAuth
+	type plain NamedAuth
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "auth"); err != nil {
+		return err
+	}
+	return c.Auth.validate(c.Version)
+}
 
 type WalkParams struct {
-	Version        int           `yaml:"version,omitempty"`
-	MaxRepetitions uint8         `yaml:"max_repetitions,omitempty"`
-	Retries        int           `yaml:"retries,omitempty"`
-	Timeout        time.Duration `yaml:"timeout,omitempty"`
-	Auth           Auth          `yaml:"auth,omitempty"`
+	Version        int           `yaml:"version,omitempty" json:"version,omitempty"`
+	MaxRepetitions uint8         `yaml:"max_repetitions,omitempty" json:"max_repetitions,omitempty"`
+	Retries        int           `yaml:"retries,omitempty" json:"retries,omitempty"`
+	Timeout        time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Auth           Auth          `yaml:"auth,omitempty" json:"auth,omitempty"`
+	// udp or tcp, udp by default. A target of tcp://host:port or
+	// udp://host:port takes precedence.
+	Transport string `yaml:"transport,omitempty" json:"transport,omitempty"`
+	// How to wait before retrying a request over UDP, rather than retrying
+	// at once. fixed waits the retry_backoff_delay, which defaults to the
+	// timeout, and exponential doubles it for each retry, with jitter.
+	RetryBackoff      string        `yaml:"retry_backoff,omitempty" json:"retry_backoff,omitempty"`
+	RetryBackoffDelay time.Duration `yaml:"retry_backoff_delay,omitempty" json:"retry_backoff_delay,omitempty"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 type Module struct {
 	// A list of OIDs.
-	Walk       []string   `yaml:"walk"`
-	Metrics    []*Metric  `yaml:"metrics"`
-	WalkParams WalkParams `yaml:",inline"`
+	Walk       []string   `yaml:"walk" json:"walk"`
+	Get        []string   `yaml:"get,omitempty" json:"get,omitempty"`
+	Metrics    []*Metric  `yaml:"metrics" json:"metrics"`
+	WalkParams WalkParams `yaml:",inline" json:"-"`
+	Filters    Filters    `yaml:"filters,omitempty" json:"filters,omitempty"`
+	// Not used by the exporter, these are for decoding traps.
+	Notifications []*Notification `yaml:"notifications,omitempty" json:"notifications,omitempty"`
+	// Labels added to every sample of the module.
+	StaticLabels map[string]string `yaml:"static_labels,omitempty" json:"static_labels,omitempty"`
+	// Applied by the collector to the samples of the module.
+	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs,omitempty" json:"relabel_configs,omitempty"`
+	// Longer string values and lookups are truncated, unless a metric sets
+	// its own limit. 0 for no limit, and if unset the exporter's default.
+	MaxValueLength *Limit `yaml:"max_value_length,omitempty" json:"max_value_length,omitempty"`
+	// What to do when the agent has no such object for an OID that's got,
+	// unless a metric sets its own policy. One of skip, metric or fail.
+	MissingObjects string `yaml:"missing_objects,omitempty" json:"missing_objects,omitempty"`
+	// Rows of a metric beyond this many are dropped, unless the metric sets
+	// its own limit. 0 for no limit.
+	MaxSeries int `yaml:"max_series,omitempty" json:"max_series,omitempty"`
+	// When the target stops answering partway through a scrape, export
+	// what was fetched before it did rather than failing the scrape.
+	PartialResults bool `yaml:"partial_results,omitempty" json:"partial_results,omitempty"`
+	// Each walk of the module is cut off after this long, keeping what it
+	// fetched, so that the rest of the module is scraped. 0 for no limit.
+	WalkBudget time.Duration `yaml:"walk_budget,omitempty" json:"walk_budget,omitempty"`
+	// Skip varbinds of a GETBULK walk whose OID isn't after the previous,
+	// rather than failing the walk, for agents which return them out of order.
+	AllowNonIncreasingOids bool `yaml:"allow_non_increasing_oids,omitempty" json:"allow_non_increasing_oids,omitempty"`
+	// Scrapes of a target are reused by those within this long after, so
+	// that the device is polled less often. 0 for no cache.
+	ScrapeCacheTTL time.Duration `yaml:"scrape_cache_ttl,omitempty" json:"scrape_cache_ttl,omitempty"`
+	// Also get sysUpTime.0, to export the uptime of the device and whether
+	// it rebooted since the last scrape.
+	DetectReboots bool `yaml:"detect_reboots,omitempty" json:"detect_reboots,omitempty"`
+	// How label values that aren't valid UTF-8, such as Latin-1 strings,
+	// are sanitised: replace or hex. They're left as they are by default.
+	InvalidUTF8 string `yaml:"invalid_utf8,omitempty" json:"invalid_utf8,omitempty"`
+	// How MAC addresses are rendered: colon, as AA:BB:CC:DD:EE:FF, which is
+	// the default, hyphen, dot, as AABB.CCDD.EEFF, or raw, as AABBCCDDEEFF.
+	MACAddressFormat    string `yaml:"mac_address_format,omitempty" json:"mac_address_format,omitempty"`
+	MACAddressLowercase bool   `yaml:"mac_address_lowercase,omitempty" json:"mac_address_lowercase,omitempty"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 func (c *WalkParams) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -77,28 +451,65 @@ func (c *WalkParams) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	return c.validate()
+}
 
+// Check the walk parameters. This is also needed for modules, as
+// UnmarshalYAML isn't called for inline structs.
+func (c WalkParams) validate() error {
 	if c.Version < 1 || c.Version > 3 {
 		return fmt.Errorf("SNMP version must be 1, 2 or 3. Got: %d", c.Version)
 	}
-	if c.Version == 3 {
-		if c.Auth.Username == "" {
+	if c.Retries < 0 {
+		return fmt.Errorf("retries must not be negative. Got: %d", c.Retries)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive. Got: %s", c.Timeout)
+	}
+	if c.MaxRepetitions == 0 {
+		return fmt.Errorf("max_repetitions must be positive")
+	}
+	switch c.Transport {
+	case "", "udp", "tcp":
+	default:
+		return fmt.Errorf("transport must be udp or tcp. Got: %s", c.Transport)
+	}
+	switch c.RetryBackoff {
+	case "", "fixed", "exponential":
+	default:
+		return fmt.Errorf("retry_backoff must be fixed or exponential. Got: %s", c.RetryBackoff)
+	}
+	if c.RetryBackoffDelay < 0 {
+		return fmt.Errorf("retry_backoff_delay must not be negative. Got: %s", c.RetryBackoffDelay)
+	}
+	return c.Auth.validate(c.Version)
+}
+
+// Check the auth has what the SNMP version needs.
+func (c Auth) validate(version int) error {
+	if version < 1 || version > 3 {
+		return fmt.Errorf("SNMP version must be 1, 2 or 3. Got: %d", version)
+	}
+	if version == 3 {
+		if c.Username == "" {
 			return fmt.Errorf("Auth username is missing, required for SNMPv3")
 		}
-		if c.Auth.SecurityLevel != "authPriv" &&
-			c.Auth.SecurityLevel != "authNoPriv" && c.Auth.SecurityLevel != "noAuthNoPriv" {
+		if c.SecurityLevel != "authPriv" &&
+			c.SecurityLevel != "authNoPriv" && c.SecurityLevel != "noAuthNoPriv" {
 			return fmt.Errorf("Security level must be one of authPriv, authNoPriv or noAuthNoPriv")
 		}
-		if c.Auth.Password == "" && c.Auth.SecurityLevel != "noAuthNoPriv" {
+		if c.Password == "" && c.PasswordFile == "" && c.SecurityLevel != "noAuthNoPriv" {
 			return fmt.Errorf("Auth password is missing, required for SNMPv3 with auth.")
 		}
-		if c.Auth.AuthProtocol != "MD5" && c.Auth.AuthProtocol != "SHA" {
+		if c.AuthProtocol != "MD5" && c.AuthProtocol != "SHA" {
 			return fmt.Errorf("Auth protocol must be SHA or MD5.")
 		}
-		if c.Auth.PrivProtocol != "DES" && c.Auth.PrivProtocol != "AES" {
-			return fmt.Errorf("Priv protocol must be DES or AES.")
+		switch c.PrivProtocol {
+		case "DES", "AES", "AES192", "AES256", "AES192C", "AES256C":
+		default:
+			return fmt.Errorf("Priv protocol must be DES, AES, AES192, AES256, AES192C or AES256C.")
 		}
-		if c.Auth.PrivPassword == "" && c.Auth.SecurityLevel == "authPriv" {
+		if c.PrivPassword == "" && c.PrivPasswordFile == "" && c.SecurityLevel == "authPriv" {
 			return fmt.Errorf("Priv password is missing, required for SNMPv3 with priv.")
 		}
 	}
@@ -114,9 +525,61 @@ func (c *Module) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	if err := checkStaticLabels(c.StaticLabels); err != nil {
+		return err
+	}
+	for _, r := range c.RelabelConfigs {
+		if r == nil {
+			return fmt.Errorf("empty relabel_configs entry in module")
+		}
+	}
+	if c.MaxValueLength != nil && *c.MaxValueLength < 0 {
+		return fmt.Errorf("max_value_length must not be negative")
+	}
+	if err := checkMissingObjects(c.MissingObjects); err != nil {
+		return err
+	}
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("max_series must not be negative")
+	}
+	if c.WalkBudget < 0 {
+		return fmt.Errorf("walk_budget must not be negative")
+	}
+	if c.ScrapeCacheTTL < 0 {
+		return fmt.Errorf("scrape_cache_ttl must not be negative")
+	}
+	switch c.InvalidUTF8 {
+	case "", "replace", "hex":
+	default:
+		return fmt.Errorf("invalid_utf8 must be replace or hex, got %q", c.InvalidUTF8)
+	}
+	switch c.MACAddressFormat {
+	case "", "colon", "hyphen", "dot", "raw":
+	default:
+		return fmt.Errorf("mac_address_format must be colon, hyphen, dot or raw, got %q", c.MACAddressFormat)
+	}
+	return c.WalkParams.validate()
+}
+
+func checkStaticLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid static label name %q", name)
+		}
+	}
 	return nil
 }
 
+// MarshalJSON implements the json.Marshaler interface, putting the walk
+// parameters inline as is done for YAML.
+func (c Module) MarshalJSON() ([]byte, error) {
+	type plain Module
+	return json.Marshal(struct {
+		plain
+		WalkParams
+	}{plain(c), c.WalkParams})
+}
+
 // configureSNMP sets the various version and auth settings.
 func (c WalkParams) ConfigureSNMP(g *gosnmp.GoSNMP) {
 	switch c.Version {
@@ -140,33 +603,81 @@ func (c WalkParams) ConfigureSNMP(g *gosnmp.GoSNMP) {
 		g.MsgFlags = gosnmp.AuthPriv
 	}
 	usm := &gosnmp.UsmSecurityParameters{
-		UserName:                 c.Auth.Username,
-		AuthenticationPassphrase: string(c.Auth.Password),
-		PrivacyPassphrase:        string(c.Auth.PrivPassword),
-	}
-	switch c.Auth.AuthProtocol {
-	case "SHA":
-		usm.AuthenticationProtocol = gosnmp.SHA
-	case "MD5":
-		usm.AuthenticationProtocol = gosnmp.MD5
-	}
-	switch c.Auth.PrivProtocol {
-	case "DES":
-		usm.PrivacyProtocol = gosnmp.DES
-	case "AES":
-		usm.PrivacyProtocol = gosnmp.AES
+		UserName:               c.Auth.Username,
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+	// The protocols have defaults, so are only used by the levels that need them.
+	if c.Auth.SecurityLevel == "authNoPriv" || c.Auth.SecurityLevel == "authPriv" {
+		usm.AuthenticationPassphrase = string(c.Auth.Password)
+		switch c.Auth.AuthProtocol {
+		case "SHA":
+			usm.AuthenticationProtocol = gosnmp.SHA
+		case "MD5":
+			usm.AuthenticationProtocol = gosnmp.MD5
+		}
+	}
+	if c.Auth.SecurityLevel == "authPriv" {
+		usm.PrivacyPassphrase = string(c.Auth.PrivPassword)
+		switch c.Auth.PrivProtocol {
+		case "DES":
+			usm.PrivacyProtocol = gosnmp.DES
+		case "AES":
+			usm.PrivacyProtocol = gosnmp.AES
+		case "AES192":
+			usm.PrivacyProtocol = gosnmp.AES192
+		case "AES256":
+			usm.PrivacyProtocol = gosnmp.AES256
+		case "AES192C":
+			usm.PrivacyProtocol = gosnmp.AES192C
+		case "AES256C":
+			usm.PrivacyProtocol = gosnmp.AES256C
+		}
 	}
 	g.SecurityParameters = usm
+	g.ContextName = c.Auth.ContextName
 }
 
 type Metric struct {
-	Name           string                     `yaml:"name"`
-	Oid            string                     `yaml:"oid"`
-	Type           string                     `yaml:"type"`
-	Help           string                     `yaml:"help"`
-	Indexes        []*Index                   `yaml:"indexes,omitempty"`
-	Lookups        []*Lookup                  `yaml:"lookups,omitempty"`
-	RegexpExtracts map[string][]RegexpExtract `yaml:"regex_extracts,omitempty"`
+	Name           string                     `yaml:"name" json:"name"`
+	Oid            string                     `yaml:"oid" json:"oid"`
+	Type           string                     `yaml:"type" json:"type"`
+	Help           string                     `yaml:"help" json:"help"`
+	Indexes        []*Index                   `yaml:"indexes,omitempty" json:"indexes,omitempty"`
+	Lookups        []*Lookup                  `yaml:"lookups,omitempty" json:"lookups,omitempty"`
+	RegexpExtracts map[string][]RegexpExtract `yaml:"regex_extracts,omitempty" json:"regex_extracts,omitempty"`
+	EnumValues     map[int]string             `yaml:"enum_values,omitempty" json:"enum_values,omitempty"`
+	JoinIndexes    []*JoinIndexes             `yaml:"join_indexes,omitempty" json:"join_indexes,omitempty"`
+	Scale          float64                    `yaml:"scale,omitempty" json:"scale,omitempty"`
+	Offset         float64                    `yaml:"offset,omitempty" json:"offset,omitempty"`
+	StaticLabels   map[string]string          `yaml:"static_labels,omitempty" json:"static_labels,omitempty"`
+	// How to export Counter64 values too large for a float64 to hold
+	// exactly, one of wrap, split or info. By default they lose precision.
+	Counter64 string `yaml:"counter64,omitempty" json:"counter64,omitempty"`
+	// How to render the bytes of OctetString and DisplayString values.
+	Encoding string `yaml:"encoding,omitempty" json:"encoding,omitempty"`
+	// Labels removed from the samples, and then labels renamed from the key
+	// to the value. Applied after the static labels.
+	DropLabels   []string          `yaml:"drop_labels,omitempty" json:"drop_labels,omitempty"`
+	RenameLabels map[string]string `yaml:"rename_labels,omitempty" json:"rename_labels,omitempty"`
+	// Samples are only kept if the value of each label, including those
+	// from lookups, matches its regex, and dropped if any label's value
+	// matches its regex.
+	KeepIfLabelMatches map[string]Regexp `yaml:"keep_if_label_matches,omitempty" json:"keep_if_label_matches,omitempty"`
+	DropIfLabelMatches map[string]Regexp `yaml:"drop_if_label_matches,omitempty" json:"drop_if_label_matches,omitempty"`
+	// Longer string values and lookups are truncated, overriding the limit
+	// of the module. 0 lifts the module's limit.
+	MaxValueLength *Limit `yaml:"max_value_length,omitempty" json:"max_value_length,omitempty"`
+	// Samples of numeric metrics with one of these values, before any
+	// scale, are dropped. Such as 4294967295 for not available.
+	IgnoreValues []float64 `yaml:"ignore_values,omitempty" json:"ignore_values,omitempty"`
+	// Values are clamped to these, after any scale and offset.
+	Min *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	// Overrides the missing_objects policy of the module.
+	MissingObjects string `yaml:"missing_objects,omitempty" json:"missing_objects,omitempty"`
+	// Overrides the max_series of the module.
+	MaxSeries int `yaml:"max_series,omitempty" json:"max_series,omitempty"`
 }
 
 func (c *Metric) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -174,14 +685,65 @@ func (c *Metric) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal((*plain)(c)); err != nil {
 		return err
 	}
-	return nil
+	switch c.Counter64 {
+	case "", "wrap", "split", "info":
+	default:
+		return fmt.Errorf("counter64 of %s must be one of wrap, split or info, got %q", c.Name, c.Counter64)
+	}
+	if err := checkEncoding(c.Encoding); err != nil {
+		return err
+	}
+	if c.MaxValueLength != nil && *c.MaxValueLength < 0 {
+		return fmt.Errorf("max_value_length of %s must not be negative", c.Name)
+	}
+	if err := checkMissingObjects(c.MissingObjects); err != nil {
+		return err
+	}
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("max_series of %s must not be negative", c.Name)
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return fmt.Errorf("min of %s is greater than its max", c.Name)
+	}
+	targets := map[string]string{}
+	for from, to := range c.RenameLabels {
+		if !model.LabelName(to).IsValid() {
+			return fmt.Errorf("invalid label name %q to rename %s to in %s", to, from, c.Name)
+		}
+		if other, ok := targets[to]; ok {
+			return fmt.Errorf("labels %s and %s of %s are both renamed to %s", other, from, c.Name, to)
+		}
+		targets[to] = from
+	}
+	for _, matches := range []map[string]Regexp{c.KeepIfLabelMatches, c.DropIfLabelMatches} {
+		for name, re := range matches {
+			if !model.LabelName(name).IsValid() {
+				return fmt.Errorf("invalid label name %q to match in %s", name, c.Name)
+			}
+			if re.Regexp == nil {
+				return fmt.Errorf("missing regex for label %s to match in %s", name, c.Name)
+			}
+		}
+	}
+	return checkStaticLabels(c.StaticLabels)
 }
 
 type Index struct {
-	Labelname string `yaml:"labelname"`
-	Type      string `yaml:"type"`
+	Labelname string `yaml:"labelname" json:"labelname"`
+	Type      string `yaml:"type" json:"type"`
+	// The index is IMPLIED, so has no length in the OID.
+	Implied bool `yaml:"implied,omitempty" json:"implied,omitempty"`
+	// The index is a fixed length string, so has no length in the OID.
+	FixedSize int `yaml:"fixed_size,omitempty" json:"fixed_size,omitempty"`
+	// Applied in order to the label value.
+	RegexpReplacements []RegexpReplacement `yaml:"regexp_replacements,omitempty" json:"regexp_replacements,omitempty"`
+	// How to render the bytes of OctetString and DisplayString indexes.
+	Encoding string `yaml:"encoding,omitempty" json:"encoding,omitempty"`
+	// An RFC 2579 DISPLAY-HINT to render OctetString indexes with, such
+	// as 1d.1d.1d.1d. An encoding takes precedence.
+	DisplayHint string `yaml:"display_hint,omitempty" json:"display_hint,omitempty"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 func (c *Index) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -192,16 +754,30 @@ func (c *Index) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
-	return nil
+	return checkEncoding(c.Encoding)
 }
 
 type Lookup struct {
-	Labels    []string `yaml:"labels"`
-	Labelname string   `yaml:"labelname"`
-	Oid       string   `yaml:"oid"`
-	Type      string   `yaml:"type"`
+	Labels    []string `yaml:"labels" json:"labels"`
+	Labelname string   `yaml:"labelname" json:"labelname"`
+	Oid       string   `yaml:"oid" json:"oid"`
+	Type      string   `yaml:"type" json:"type"`
+	// Chained lookups, indexed by the value of this lookup.
+	Lookups []*Lookup `yaml:"lookups,omitempty" json:"lookups,omitempty"`
+	// Applied in order to the label value.
+	RegexpReplacements []RegexpReplacement `yaml:"regexp_replacements,omitempty" json:"regexp_replacements,omitempty"`
+	// How to render the bytes of OctetString and DisplayString values.
+	Encoding string `yaml:"encoding,omitempty" json:"encoding,omitempty"`
+	// For tables indexed differently to the one looked up in, how many
+	// sub-identifiers to remove from the start of the index, such as a slot
+	// number, and then what to add to the last sub-identifier.
+	StripPrefix int `yaml:"strip_prefix,omitempty" json:"strip_prefix,omitempty"`
+	IndexOffset int `yaml:"index_offset,omitempty" json:"index_offset,omitempty"`
+	// How long to reuse a walk of the lookup's OID for, rather than walking
+	// it every scrape. Only used where it's walked on its own.
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty" json:"cache_ttl,omitempty"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 func (c *Lookup) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -212,6 +788,192 @@ func (c *Lookup) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	if c.StripPrefix < 0 {
+		return fmt.Errorf("strip_prefix of lookup %s must not be negative", c.Labelname)
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl of lookup %s must not be negative", c.Labelname)
+	}
+	return checkEncoding(c.Encoding)
+}
+
+// Encodings of the bytes of strings as label values. By default
+// OctetStrings are hex, and DisplayStrings are used as is.
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case "", "hex", "base64", "utf8", "ascii":
+		return nil
+	default:
+		return fmt.Errorf("encoding must be one of hex, base64, utf8 or ascii, got %q", encoding)
+	}
+}
+
+// Policies for objects the agent has no such object or instance for. By
+// default they're skipped, as if they weren't asked for.
+func checkMissingObjects(policy string) error {
+	switch policy {
+	case "", "skip", "metric", "fail":
+		return nil
+	default:
+		return fmt.Errorf("missing_objects must be one of skip, metric or fail, got %q", policy)
+	}
+}
+
+// JoinIndexes replaces the labels of several indexes with one label,
+// holding their values joined by the separator.
+type JoinIndexes struct {
+	Labels    []string `yaml:"labels" json:"labels"`
+	Labelname string   `yaml:"labelname" json:"labelname"`
+	Separator string   `yaml:"separator" json:"separator"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *JoinIndexes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultJoinIndexes
+	type plain JoinIndexes
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "join_indexes"); err != nil {
+		return err
+	}
+	if c.Labelname == "" {
+		return fmt.Errorf("join_indexes labelname is missing")
+	}
+	if len(c.Labels) == 0 {
+		return fmt.Errorf("join_indexes for %s has no labels", c.Labelname)
+	}
+	return nil
+}
+
+type Filters struct {
+	Static  []StaticFilter  `yaml:"static,omitempty" json:"static,omitempty"`
+	Dynamic []DynamicFilter `yaml:"dynamic,omitempty" json:"dynamic,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *Filters) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Filters
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "filters"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StaticFilter restricts the rows of the target tables to those with one
+// of the given indexes, such as an ifIndex of 1 or a VLAN of 100.
+type StaticFilter struct {
+	Targets []string `yaml:"targets" json:"targets"`
+	Indices []string `yaml:"indices" json:"indices"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *StaticFilter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain StaticFilter
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "static filter"); err != nil {
+		return err
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("Static filter has no targets")
+	}
+	if len(c.Indices) == 0 {
+		return fmt.Errorf("Static filter on %s has no indices", strings.Join(c.Targets, ", "))
+	}
+	return nil
+}
+
+// DynamicFilter restricts the rows of the target tables to those
+// whose index has one of the given values in the filter OID.
+type DynamicFilter struct {
+	Oid     string   `yaml:"oid" json:"oid"`
+	Targets []string `yaml:"targets" json:"targets"`
+	Values  []string `yaml:"values" json:"values"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *DynamicFilter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DynamicFilter
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "dynamic filter"); err != nil {
+		return err
+	}
+	if c.Oid == "" {
+		return fmt.Errorf("Dynamic filter oid is missing")
+	}
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("Dynamic filter on %s has no targets", c.Oid)
+	}
+	return nil
+}
+
+// Notification describes a NOTIFICATION-TYPE or TRAP-TYPE, so that
+// received traps can be decoded.
+type Notification struct {
+	Name     string     `yaml:"name" json:"name"`
+	Oid      string     `yaml:"oid" json:"oid"`
+	Help     string     `yaml:"help" json:"help"`
+	Varbinds []*Varbind `yaml:"varbinds,omitempty" json:"varbinds,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *Notification) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Notification
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "notification"); err != nil {
+		return err
+	}
+	if !model.IsValidMetricName(model.LabelValue(c.Name)) {
+		return fmt.Errorf("invalid notification name %q", c.Name)
+	}
+	names := map[string]bool{}
+	for _, varbind := range c.Varbinds {
+		if names[varbind.Name] {
+			return fmt.Errorf("duplicate varbind %s in notification %s", varbind.Name, c.Name)
+		}
+		names[varbind.Name] = true
+	}
+	return nil
+}
+
+// Varbind is an object sent with a notification. The OID is that of
+// the object, without the instance. Traps are counted by the values of
+// enums and of varbinds that are labels.
+type Varbind struct {
+	Name       string         `yaml:"name" json:"name"`
+	Oid        string         `yaml:"oid" json:"oid"`
+	Type       string         `yaml:"type" json:"type"`
+	EnumValues map[int]string `yaml:"enum_values,omitempty" json:"enum_values,omitempty"`
+	Label      bool           `yaml:"label,omitempty" json:"label,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *Varbind) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Varbind
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "varbind"); err != nil {
+		return err
+	}
+	if !model.LabelName(c.Name).IsValid() {
+		return fmt.Errorf("invalid varbind name %q", c.Name)
+	}
 	return nil
 }
 
@@ -234,6 +996,14 @@ func (s Secret) MarshalYAML() (interface{}, error) {
 	return nil, nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if DoNotHideSecrets || s == "" {
+		return json.Marshal(string(s))
+	}
+	return json.Marshal("<secret>")
+}
+
 //UnmarshalYAML implements the yaml.Unmarshaler interface for Secrets.
 func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type plain Secret
@@ -241,19 +1011,25 @@ func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
 }
 
 type Auth struct {
-	Community     Secret `yaml:"community,omitempty"`
-	SecurityLevel string `yaml:"security_level,omitempty"`
-	Username      string `yaml:"username,omitempty"`
-	Password      Secret `yaml:"password,omitempty"`
-	AuthProtocol  string `yaml:"auth_protocol,omitempty"`
-	PrivProtocol  string `yaml:"priv_protocol,omitempty"`
-	PrivPassword  Secret `yaml:"priv_password,omitempty"`
+	Community        Secret `yaml:"community,omitempty" json:"community,omitempty"`
+	CommunityFile    string `yaml:"community_file,omitempty" json:"community_file,omitempty"`
+	SecurityLevel    string `yaml:"security_level,omitempty" json:"security_level,omitempty"`
+	Username         string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password         Secret `yaml:"password,omitempty" json:"password,omitempty"`
+	PasswordFile     string `yaml:"password_file,omitempty" json:"password_file,omitempty"`
+	AuthProtocol     string `yaml:"auth_protocol,omitempty" json:"auth_protocol,omitempty"`
+	PrivProtocol     string `yaml:"priv_protocol,omitempty" json:"priv_protocol,omitempty"`
+	PrivPassword     Secret `yaml:"priv_password,omitempty" json:"priv_password,omitempty"`
+	PrivPasswordFile string `yaml:"priv_password_file,omitempty" json:"priv_password_file,omitempty"`
+	ContextName      string `yaml:"context_name,omitempty" json:"context_name,omitempty"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultAuth
+	// The default community doesn't apply if it's read from a file.
+	c.Community = ""
 	type plain Auth
 	if err := unmarshal((*plain)(c)); err != nil {
 		return err
@@ -261,14 +1037,94 @@ func (c *Auth) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	if c.Community == "" && c.CommunityFile == "" {
+		c.Community = DefaultAuth.Community
+	}
+	if c.Community != "" && c.CommunityFile != "" {
+		return fmt.Errorf("at most one of community and community_file must be set")
+	}
+	if c.Password != "" && c.PasswordFile != "" {
+		return fmt.Errorf("at most one of password and password_file must be set")
+	}
+	if c.PrivPassword != "" && c.PrivPasswordFile != "" {
+		return fmt.Errorf("at most one of priv_password and priv_password_file must be set")
+	}
+	return nil
+}
+
+func (c *Auth) load(dir string, secrets bool) error {
+	if secrets {
+		return c.loadSecrets(dir)
+	}
+	for _, path := range []*string{&c.CommunityFile, &c.PasswordFile, &c.PrivPasswordFile} {
+		if *path == "" || filepath.IsAbs(*path) {
+			continue
+		}
+		abs, err := filepath.Abs(filepath.Join(dir, *path))
+		if err != nil {
+			return err
+		}
+		*path = abs
+	}
+	return nil
+}
+
+// Only ${VAR} is expanded, so that a $ can otherwise be used as is.
+var envVarRE = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// Expand ${VAR} in the credentials, and read those that are in files.
+// Relative files are relative to dir, the directory of the config file.
+// This is done when the exporter loads its config, rather than when
+// unmarshalling, so that the generator writes the variables and files out
+// as they are.
+func (c *Auth) loadSecrets(dir string) error {
+	var err error
+	expand := func(s string) string {
+		return envVarRE.ReplaceAllStringFunc(s, func(v string) string {
+			value, ok := os.LookupEnv(v[2 : len(v)-1])
+			if !ok && err == nil {
+				err = fmt.Errorf("environment variable %s not set", v[2:len(v)-1])
+			}
+			return value
+		})
+	}
+	c.Community = Secret(expand(string(c.Community)))
+	c.Username = expand(c.Username)
+	c.Password = Secret(expand(string(c.Password)))
+	c.PrivPassword = Secret(expand(string(c.PrivPassword)))
+	if err != nil {
+		return err
+	}
+
+	for _, f := range []struct {
+		path   string
+		secret *Secret
+	}{
+		{c.CommunityFile, &c.Community},
+		{c.PasswordFile, &c.Password},
+		{c.PrivPasswordFile, &c.PrivPassword},
+	} {
+		if f.path == "" {
+			continue
+		}
+		path := f.path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		*f.secret = Secret(strings.TrimRight(string(content), "\r\n"))
+	}
 	return nil
 }
 
 type RegexpExtract struct {
-	Value string `yaml:"value"`
-	Regex Regexp `yaml:"regex"`
+	Value string `yaml:"value" json:"value"`
+	Regex Regexp `yaml:"regex" json:"regex"`
 
-	XXX map[string]interface{} `yaml:",inline"`
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
 }
 
 func (c *RegexpExtract) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -283,6 +1139,75 @@ func (c *RegexpExtract) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// RegexpReplacement replaces the matches of a regex in a label value, such
+// as to shorten GigabitEthernet to Gi.
+type RegexpReplacement struct {
+	Regex       Regexp `yaml:"regex" json:"regex"`
+	Replacement string `yaml:"replacement" json:"replacement"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *RegexpReplacement) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RegexpReplacement
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "regexp_replacement"); err != nil {
+		return err
+	}
+	if c.Regex.Regexp == nil {
+		return fmt.Errorf("regex is required in regexp_replacements")
+	}
+	return nil
+}
+
+// RelabelConfig rewrites the samples of a module, much as the
+// metric_relabel_configs of Prometheus. The metric name is the __name__ label.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty" json:"source_labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty" json:"separator,omitempty"`
+	Regex        Regexp   `yaml:"regex,omitempty" json:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty" json:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty" json:"replacement,omitempty"`
+	// One of replace, keep or drop.
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+
+	XXX map[string]interface{} `yaml:",inline" json:"-"`
+}
+
+func (c *RelabelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRelabelConfig
+	type plain RelabelConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := CheckOverflow(c.XXX, "relabel_config"); err != nil {
+		return err
+	}
+	if c.Regex.Regexp == nil {
+		c.Regex = DefaultRelabelConfig.Regex
+	}
+	for _, l := range c.SourceLabels {
+		if !model.LabelName(l).IsValid() {
+			return fmt.Errorf("invalid source label name %q in relabel_configs", l)
+		}
+	}
+	switch c.Action {
+	case "replace":
+		if !model.LabelName(c.TargetLabel).IsValid() {
+			return fmt.Errorf("relabel_configs with action replace need a valid target_label, got %q", c.TargetLabel)
+		}
+	case "keep", "drop":
+		if len(c.SourceLabels) == 0 {
+			return fmt.Errorf("relabel_configs with action %s need source_labels", c.Action)
+		}
+	default:
+		return fmt.Errorf("relabel_configs action must be one of replace, keep or drop, got %q", c.Action)
+	}
+	return nil
+}
+
 // Regexp encapsulates a regexp.Regexp and makes it YAML marshalable.
 type Regexp struct {
 	*regexp.Regexp
@@ -296,6 +1221,53 @@ func (re Regexp) MarshalYAML() (interface{}, error) {
 	return nil, nil
 }
 
+// MarshalJSON implements the json.Marshaler interface.
+func (re Regexp) MarshalJSON() ([]byte, error) {
+	if re.Regexp != nil {
+		return json.Marshal(re.String())
+	}
+	return []byte("null"), nil
+}
+
+// GobEncode implements the gob.GobEncoder interface.
+func (re Regexp) GobEncode() ([]byte, error) {
+	if re.Regexp != nil {
+		return []byte(re.String()), nil
+	}
+	return nil, nil
+}
+
+// GobDecode implements the gob.GobDecoder interface. The regexp is already
+// anchored.
+func (re *Regexp) GobDecode(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	regex, err := regexp.Compile(string(b))
+	if err != nil {
+		return err
+	}
+	re.Regexp = regex
+	return nil
+}
+
+// A Limit such as a maximum length, where 0 is no limit. Options of this
+// type are pointers, so that an explicit 0 can lift a limit set elsewhere.
+type Limit int
+
+// GobEncode implements the gob.GobEncoder interface. gob would otherwise
+// drop a pointer to 0, leaving the limit unset in compiled configs.
+func (l *Limit) GobEncode() ([]byte, error) {
+	return []byte(strconv.Itoa(int(*l))), nil
+}
+
+// GobDecode implements the gob.GobDecoder interface.
+func (l *Limit) GobDecode(b []byte) error {
+	i, err := strconv.Atoi(string(b))
+	*l = Limit(i)
+	return err
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var s string
@@ -310,12 +1282,22 @@ func (re *Regexp) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// Whether unknown fields are an error. Otherwise they're logged and
+// ignored, so that a config can be shared by different versions of the
+// exporter, such as during a rolling upgrade.
+var Strict = true
+
 func CheckOverflow(m map[string]interface{}, ctx string) error {
 	if len(m) > 0 {
 		var keys []string
 		for k := range m {
 			keys = append(keys, k)
 		}
+		sort.Strings(keys)
+		if !Strict {
+			log.Warnf("Ignoring unknown fields in %s: %s", ctx, strings.Join(keys, ", "))
+			return nil
+		}
 		return fmt.Errorf("unknown fields in %s: %s", ctx, strings.Join(keys, ", "))
 	}
 	return nil
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"fmt"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// A module that hasn't been decoded, as YAML or gob if compiled.
+type rawModule struct {
+	content  []byte
+	compiled bool
+	// The directory of the file it's from, for credential files.
+	dir string
+}
+
+func (r *rawModule) decode() (*Module, error) {
+	module := &Module{}
+	if r.compiled {
+		if err := gob.NewDecoder(bytes.NewReader(r.content)).Decode(module); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(r.content, module); err != nil {
+		return nil, err
+	}
+	return module, nil
+}
+
+// The modules of a config, decoded when first used. The most recently used
+// are kept decoded.
+type lazyModules struct {
+	raw  map[string]*rawModule
+	size int
+
+	mtx sync.Mutex
+	// Of *lazyModule, the most recently used at the front.
+	lru     *list.List
+	decoded map[string]*list.Element
+}
+
+type lazyModule struct {
+	name   string
+	module *Module
+}
+
+func newLazyModules(size int) *lazyModules {
+	return &lazyModules{
+		raw:     map[string]*rawModule{},
+		size:    size,
+		lru:     list.New(),
+		decoded: map[string]*list.Element{},
+	}
+}
+
+func (l *lazyModules) get(name string) (*Module, error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if e, ok := l.decoded[name]; ok {
+		l.lru.MoveToFront(e)
+		return e.Value.(*lazyModule).module, nil
+	}
+	raw, ok := l.raw[name]
+	if !ok {
+		return nil, nil
+	}
+	module, err := raw.decode()
+	if err != nil {
+		return nil, fmt.Errorf("module %s: %s", name, err)
+	}
+	if err := module.WalkParams.Auth.loadSecrets(raw.dir); err != nil {
+		return nil, fmt.Errorf("module %s: %s", name, err)
+	}
+	l.decoded[name] = l.lru.PushFront(&lazyModule{name: name, module: module})
+	if l.lru.Len() > l.size {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.decoded, oldest.Value.(*lazyModule).name)
+	}
+	return module, nil
+}
@@ -1,10 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_model/go"
+	"github.com/soniah/gosnmp"
 	yaml "gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
 )
 
 func TestHideConfigSecrets(t *testing.T) {
@@ -26,6 +42,68 @@ func TestHideConfigSecrets(t *testing.T) {
 	}
 }
 
+func TestHideConfigSecretsJSON(t *testing.T) {
+	sc := &SafeConfig{}
+	err := sc.ReloadConfig("testdata/snmp-auth.yml")
+	if err != nil {
+		t.Errorf("Error loading config %v: %v", "testdata/snmp-auth.yml", err)
+	}
+
+	sc.RLock()
+	c, err := json.Marshal(sc.C)
+	sc.RUnlock()
+	if err != nil {
+		t.Errorf("Error marshalling config: %v", err)
+	}
+	if strings.Contains(string(c), "mysecret") {
+		t.Fatal("config's JSON reveals authentication credentials.")
+	}
+}
+
+func TestMarshalModuleJSON(t *testing.T) {
+	module := &config.Module{
+		Walk: []string{"1.3.6.1.2.1.2"},
+		Metrics: []*config.Metric{
+			{
+				Name:    "ifOperStatus",
+				Oid:     "1.3.6.1.2.1.2.2.1.8",
+				Type:    "EnumAsStateSet",
+				Help:    "The current operational state of the interface.",
+				Indexes: []*config.Index{{Labelname: "ifIndex", Type: "gauge"}},
+				Lookups: []*config.Lookup{
+					{Labels: []string{"ifIndex"}, Labelname: "ifDescr", Oid: "1.3.6.1.2.1.2.2.1.2", Type: "DisplayString"},
+				},
+				EnumValues: map[int]string{1: "up", 2: "down"},
+			},
+		},
+		WalkParams: config.WalkParams{
+			Version:        3,
+			MaxRepetitions: 25,
+			Retries:        3,
+			Timeout:        time.Second,
+			Auth: config.Auth{
+				Username:      "user",
+				SecurityLevel: "authNoPriv",
+				Password:      "mysecret",
+				AuthProtocol:  "SHA",
+			},
+		},
+	}
+	want := `{"walk":["1.3.6.1.2.1.2"],"metrics":[{"name":"ifOperStatus","oid":"1.3.6.1.2.1.2.2.1.8","type":"EnumAsStateSet",` +
+		`"help":"The current operational state of the interface.","indexes":[{"labelname":"ifIndex","type":"gauge"}],` +
+		`"lookups":[{"labels":["ifIndex"],"labelname":"ifDescr","oid":"1.3.6.1.2.1.2.2.1.2","type":"DisplayString"}],` +
+		`"enum_values":{"1":"up","2":"down"}}],"filters":{},"version":3,"max_repetitions":25,"retries":3,"timeout":1000000000,` +
+		`"auth":{"security_level":"authNoPriv","username":"user","password":"\u003csecret\u003e","auth_protocol":"SHA"}}`
+	got, err := json.Marshal(module)
+	if err != nil {
+		t.Fatalf("Error marshalling module: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Wrong JSON: want\n%s\ngot\n%s", want, got)
+	}
+
+}
+
 func TestLoadConfigWithOverrides(t *testing.T) {
 	sc := &SafeConfig{}
 	err := sc.ReloadConfig("testdata/snmp-with-overrides.yml")
@@ -39,3 +117,769 @@ func TestLoadConfigWithOverrides(t *testing.T) {
 		t.Errorf("Error marshalling config: %v", err)
 	}
 }
+
+func TestLoadConfigLenient(t *testing.T) {
+	in := "m:\n  walk: [1]\n  future_option: true\n  metrics:\n  - name: a\n    oid: 1.1\n    type: gauge\n    help: A.\n"
+	err := yaml.Unmarshal([]byte(in), &config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "unknown fields in module: future_option") {
+		t.Errorf("Expected an error for unknown fields, got %v", err)
+	}
+
+	config.Strict = false
+	defer func() { config.Strict = true }()
+	cfg := config.Config{}
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatalf("Unexpected error with unknown fields when lenient: %s", err)
+	}
+	if m := cfg.Modules["m"]; len(m.Metrics) != 1 || m.Metrics[0].Name != "a" {
+		t.Errorf("Wrong module: %+v", m)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	sc := &SafeConfig{}
+	if err := sc.ReloadConfig("testdata/snmp-auth.yml"); err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	loaded := sc.C
+	gauge := func(g prometheus.Gauge) float64 {
+		m := &io_prometheus_client.Metric{}
+		if err := g.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	if gauge(configReloadSuccess) != 1 || gauge(configReloadSeconds) == 0 {
+		t.Errorf("Reload not recorded as successful")
+	}
+
+	// A failed reload keeps the current config.
+	if err := sc.ReloadConfig("testdata/missing.yml"); err == nil {
+		t.Fatal("Expected error loading missing config")
+	}
+	if sc.C != loaded {
+		t.Errorf("Config changed by a failed reload")
+	}
+	if gauge(configReloadSuccess) != 0 {
+		t.Errorf("Reload not recorded as failed")
+	}
+}
+
+func TestLoadConfigGlob(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("cisco.yml", "cisco:\n  walk: [1.3.6.1.4.1.9]\n")
+	write("arista.yml", "arista:\n  walk: [1.3.6.1.4.1.30065]\n")
+
+	c, err := config.LoadFile(filepath.Join(dir, "*.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Modules) != 2 || c.Modules["cisco"] == nil || c.Modules["arista"] == nil {
+		t.Errorf("Wrong modules loaded: %v", c.Modules)
+	}
+
+	write("more.yml", "cisco:\n  walk: [1.3.6.1.4.1.9.9]\n")
+	_, err = config.LoadFile(filepath.Join(dir, "*.yml"))
+	want := "module cisco is defined in both " + filepath.Join(dir, "cisco.yml") + " and " + filepath.Join(dir, "more.yml")
+	if err == nil || err.Error() != want {
+		t.Errorf("Wrong error: want %q, got %v", want, err)
+	}
+
+	_, err = config.LoadFile(filepath.Join(dir, "*.yaml"))
+	if want := "no config files match " + filepath.Join(dir, "*.yaml"); err == nil || err.Error() != want {
+		t.Errorf("Wrong error: want %q, got %v", want, err)
+	}
+}
+
+func TestLoadConfigSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("community", "s3cret\n")
+	write("snmp.yml", `v2:
+  walk: [1.3.6.1.2.1.1]
+  auth:
+    community_file: community
+v3:
+  walk: [1.3.6.1.2.1.1]
+  version: 3
+  auth:
+    username: ${TEST_SNMP_USER}
+    security_level: authNoPriv
+    password_file: `+filepath.Join(dir, "community")+`
+default:
+  walk: [1.3.6.1.2.1.1]
+`)
+	os.Setenv("TEST_SNMP_USER", "monitor")
+	defer os.Unsetenv("TEST_SNMP_USER")
+
+	c, err := config.LoadFile(filepath.Join(dir, "snmp.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Modules["v2"].WalkParams.Auth.Community; got != "s3cret" {
+		t.Errorf("Wrong community from file: %q", got)
+	}
+	if auth := c.Modules["v3"].WalkParams.Auth; auth.Username != "monitor" || auth.Password != "s3cret" {
+		t.Errorf("Wrong v3 auth: %q %q", auth.Username, auth.Password)
+	}
+	if got := c.Modules["default"].WalkParams.Auth.Community; got != "public" {
+		t.Errorf("Wrong default community: %q", got)
+	}
+
+	os.Unsetenv("TEST_SNMP_USER")
+	_, err = config.LoadFile(filepath.Join(dir, "snmp.yml"))
+	if err == nil || !strings.Contains(err.Error(), "environment variable TEST_SNMP_USER not set") {
+		t.Errorf("Expected unset variable error, got %v", err)
+	}
+
+	write("both.yml", "v2:\n  walk: [1]\n  auth:\n    community: a\n    community_file: community\n")
+	_, err = config.LoadFile(filepath.Join(dir, "both.yml"))
+	if err == nil || !strings.Contains(err.Error(), "at most one of community and community_file must be set") {
+		t.Errorf("Expected error for community and community_file, got %v", err)
+	}
+}
+
+func TestCompileConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "community"), []byte("s3cret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	yml := filepath.Join(dir, "snmp.yml")
+	content := `auths:
+  v3:
+    version: 3
+    auth:
+      username: ${TEST_SNMP_USER}
+if_mib:
+  walk: [1.3.6.1.2.1.2]
+  auth:
+    community_file: community
+  max_value_length: 64
+  relabel_configs:
+    - source_labels: [ifIndex]
+      regex: '1|2'
+      action: drop
+  metrics:
+    - name: ifDescr
+      oid: 1.3.6.1.2.1.2.2.1.2
+      type: DisplayString
+      max_value_length: 0
+      regex_extracts:
+        Up:
+          - regex: 'up.*'
+            value: '1'
+`
+	if err := ioutil.WriteFile(yml, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compiled := filepath.Join(dir, "snmp.compiled")
+	if err := compileConfig(yml, compiled); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_SNMP_USER", "monitor")
+	defer os.Unsetenv("TEST_SNMP_USER")
+
+	// The compiled config must not depend on where it's loaded from.
+	moved := filepath.Join(dir, "moved", "snmp.compiled")
+	os.Mkdir(filepath.Dir(moved), 0755)
+	if err := os.Rename(compiled, moved); err != nil {
+		t.Fatal(err)
+	}
+	want, err := config.LoadFile(yml)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := config.LoadFile(moved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Modules["if_mib"].WalkParams.Auth.Community != "s3cret" || got.Auths["v3"].Auth.Username != "monitor" {
+		t.Errorf("Credentials not loaded with the compiled config: %+v", got)
+	}
+	// Credential files are compiled as absolute paths.
+	got.Modules["if_mib"].WalkParams.Auth.CommunityFile = "community"
+	config.DoNotHideSecrets = true
+	defer func() { config.DoNotHideSecrets = false }()
+	wantYAML, err := yaml.Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotYAML, err := yaml.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(gotYAML) != string(wantYAML) {
+		t.Errorf("Compiled config differs: want\n%s\ngot\n%s", wantYAML, gotYAML)
+	}
+	if !got.Modules["if_mib"].Metrics[0].RegexpExtracts["Up"][0].Regex.MatchString("up 3 days") {
+		t.Errorf("Compiled regexp doesn't match")
+	}
+	// A limit of 0 isn't lost as unset.
+	if l := got.Modules["if_mib"].Metrics[0].MaxValueLength; l == nil || *l != 0 {
+		t.Errorf("Compiled max_value_length of 0 lost: %v", l)
+	}
+}
+
+func TestLoadConfigLazy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "community"), []byte("s3cret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	yml := filepath.Join(dir, "snmp.yml")
+	content := `auths:
+  v2:
+    auth:
+      community: other
+a:
+  walk: [1.3.6.1.2.1.1]
+  auth:
+    community_file: community
+b:
+  walk: [1.3.6.1.2.1.2]
+  max_repetitions: 5
+bad:
+  walk: [1.3.6.1.2.1.2]
+  unknown_field: 1
+`
+	if err := ioutil.WriteFile(yml, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	compiled := filepath.Join(dir, "snmp.compiled")
+	if err := compileConfig(filepath.Join(dir, "snmp.yml"), compiled); err == nil {
+		t.Fatal("Expected an error compiling an invalid module")
+	}
+	if _, err := config.LoadFile(yml); err == nil {
+		t.Fatal("Expected an error loading an invalid module")
+	}
+
+	c, err := config.LoadFileLazy(yml, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ModuleNames(); strings.Join(got, ",") != "a,b,bad" {
+		t.Errorf("Wrong module names: %v", got)
+	}
+	if c.Auths["v2"] == nil || c.Auths["v2"].Auth.Community != "other" {
+		t.Errorf("Wrong auths: %+v", c.Auths)
+	}
+	a, err := c.Module("a")
+	if err != nil || a.WalkParams.Auth.Community != "s3cret" {
+		t.Fatalf("Wrong module a: %+v, %v", a, err)
+	}
+	if again, _ := c.Module("a"); again != a {
+		t.Errorf("Module a not kept decoded")
+	}
+	b, err := c.Module("b")
+	if err != nil || b.WalkParams.MaxRepetitions != 5 || b.WalkParams.Retries != 3 {
+		t.Fatalf("Wrong module b: %+v, %v", b, err)
+	}
+	if again, _ := c.Module("a"); again == a {
+		t.Errorf("Module a not evicted")
+	}
+	if _, err := c.Module("bad"); err == nil || !strings.Contains(err.Error(), "unknown_field") {
+		t.Errorf("Expected error for module bad, got %v", err)
+	}
+	if m, err := c.Module("missing"); m != nil || err != nil {
+		t.Errorf("Expected no module, got %+v, %v", m, err)
+	}
+
+	// Compiled configs load lazily too.
+	content = content[:strings.Index(content, "bad:")]
+	if err := ioutil.WriteFile(yml, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := compileConfig(yml, compiled); err != nil {
+		t.Fatal(err)
+	}
+	c, err = config.LoadFileLazy(compiled, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := c.Module("b"); err != nil || b.WalkParams.MaxRepetitions != 5 {
+		t.Errorf("Wrong compiled module b: %+v, %v", b, err)
+	}
+	if a, err := c.Module("a"); err != nil || a.WalkParams.Auth.Community != "s3cret" {
+		t.Errorf("Wrong compiled module a: %+v, %v", a, err)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snmp.yml")
+	content := "if_mib:\n  walk: [1.3.6.1.2.1.2]\n  auth:\n    community: s3cret\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := migrateConfig(path, false, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "version: 1\n" + strings.Replace(content, " [1.3.6.1.2.1.2]", "\n  - 1.3.6.1.2.1.2", 1)
+	if out.String() != want {
+		t.Errorf("Wrong migrated config: want\n%s\ngot\n%s", want, out.String())
+	}
+	if err := migrateConfig(path, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Version != 1 || c.Modules["if_mib"].WalkParams.Auth.Community != "s3cret" {
+		t.Errorf("Wrong migrated config: %+v", c)
+	}
+
+	// Configs newer than the exporter are rejected.
+	if err := ioutil.WriteFile(path, []byte("version: 99\n"+content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.LoadFile(path); err == nil || !strings.Contains(err.Error(), "config version 99 is newer") {
+		t.Errorf("Expected error for a newer config, got %v", err)
+	}
+	if err := migrateConfig(path, false, &out); err == nil {
+		t.Errorf("Expected error migrating a newer config")
+	}
+}
+
+func TestConfigVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snmp.yml")
+	content := "if_mib:\n  walk: [1.3.6.1.2.1.2]\n"
+	for version, want := range map[string]string{
+		"":             "",
+		"version: 1\n": "",
+		// Configs newer than the exporter are rejected.
+		"version: 99\n": "config version 99 is newer than this exporter supports, which is 1",
+		"version: -1\n": "invalid config version -1",
+	} {
+		if err := ioutil.WriteFile(path, []byte(version+content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := config.LoadFile(path)
+		if want == "" && err != nil {
+			t.Errorf("Unexpected error for %q: %s", version, err)
+		}
+		if want != "" && (err == nil || !strings.HasSuffix(err.Error(), want)) {
+			t.Errorf("Wrong error for %q: want %q, got %v", version, want, err)
+		}
+	}
+}
+
+func TestModuleWalkParams(t *testing.T) {
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{in: "m:\n  walk: [1]\n  timeout: 30s\n  retries: 0\n  max_repetitions: 1\n  transport: tcp\n  retry_backoff: exponential\n  retry_backoff_delay: 2s"},
+		{in: "m:\n  walk: [1]\n  version: 4", err: "SNMP version must be 1, 2 or 3. Got: 4"},
+		{in: "m:\n  walk: [1]\n  retries: -1", err: "retries must not be negative. Got: -1"},
+		{in: "m:\n  walk: [1]\n  timeout: 0s", err: "timeout must be positive. Got: 0s"},
+		{in: "m:\n  walk: [1]\n  max_repetitions: 0", err: "max_repetitions must be positive"},
+		{in: "m:\n  walk: [1]\n  version: 3", err: "Auth username is missing, required for SNMPv3"},
+		{in: "m:\n  walk: [1]\n  transport: sctp", err: "transport must be udp or tcp. Got: sctp"},
+		{in: "m:\n  walk: [1]\n  retry_backoff: linear", err: "retry_backoff must be fixed or exponential. Got: linear"},
+		{in: "m:\n  walk: [1]\n  retry_backoff_delay: -1s", err: "retry_backoff_delay must not be negative. Got: -1s"},
+	}
+	for _, c := range cases {
+		cfg := config.Config{}
+		err := yaml.Unmarshal([]byte(c.in), &cfg)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("Unexpected error parsing %q: %s", c.in, err)
+				continue
+			}
+			if p := cfg.Modules["m"].WalkParams; p.Timeout != 30*time.Second || p.Retries != 0 || p.MaxRepetitions != 1 || p.Version != 2 || p.Transport != "tcp" || p.RetryBackoff != "exponential" || p.RetryBackoffDelay != 2*time.Second {
+				t.Errorf("Wrong walk params: %+v", p)
+			}
+			continue
+		}
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Wrong error parsing %q: want %q, got %v", c.in, c.err, err)
+		}
+	}
+}
+
+func TestStaticLabels(t *testing.T) {
+	cfg := config.Config{}
+	in := "m:\n  walk: [1]\n  static_labels:\n    vendor: cisco\n  metrics:\n  - name: a\n    oid: 1.1\n    type: gauge\n    help: A.\n    static_labels:\n      tier: access\n"
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Modules["m"].StaticLabels["vendor"] != "cisco" || cfg.Modules["m"].Metrics[0].StaticLabels["tier"] != "access" {
+		t.Errorf("Wrong static labels: %v %v", cfg.Modules["m"].StaticLabels, cfg.Modules["m"].Metrics[0].StaticLabels)
+	}
+
+	err := yaml.Unmarshal([]byte("m:\n  walk: [1]\n  static_labels:\n    1tier: access\n"), &config.Config{})
+	if want := `invalid static label name "1tier"`; err == nil || err.Error() != want {
+		t.Errorf("Wrong error: want %q, got %v", want, err)
+	}
+}
+
+func TestRenameLabels(t *testing.T) {
+	metric := "m:\n  walk: [1]\n  metrics:\n  - name: a\n    oid: 1.1\n    type: gauge\n    help: A.\n    rename_labels:\n"
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{in: "      ifDescr: interface\n"},
+		{in: "      ifDescr: 1interface\n", err: `invalid label name "1interface" to rename ifDescr to in a`},
+		{in: "      ifDescr: interface\n      ifName: interface\n", err: "are both renamed to interface"},
+	}
+	for _, c := range cases {
+		err := yaml.Unmarshal([]byte(metric+c.in), &config.Config{})
+		if c.err == "" && err != nil {
+			t.Errorf("Unexpected error for %q: %s", c.in, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("Wrong error for %q: want %q, got %v", c.in, c.err, err)
+		}
+	}
+}
+
+func TestNotificationNames(t *testing.T) {
+	notification := "m:\n  notifications:\n  - oid: 1.3.6.1.6.3.1.1.5.3\n    help: A link went down.\n"
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{in: "    name: linkDown\n    varbinds:\n    - name: ifIndex\n      oid: 1.3.6.1.2.1.2.2.1.1\n      type: gauge\n"},
+		{in: "    name: link-down\n", err: `invalid notification name "link-down"`},
+		{in: "    name: linkDown\n    varbinds:\n    - name: if.Index\n      oid: 1.3.6.1.2.1.2.2.1.1\n      type: gauge\n", err: `invalid varbind name "if.Index"`},
+		{in: "    name: linkDown\n    varbinds:\n    - name: ifIndex\n      oid: 1.3.6.1.2.1.2.2.1.1\n      type: gauge\n    - name: ifIndex\n      oid: 1.3.6.1.2.1.2.2.1.1\n      type: gauge\n", err: "duplicate varbind ifIndex in notification linkDown"},
+	}
+	for _, c := range cases {
+		err := yaml.Unmarshal([]byte(notification+c.in), &config.Config{})
+		if c.err == "" && err != nil {
+			t.Errorf("Unexpected error for %q: %s", c.in, err)
+		}
+		if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("Wrong error for %q: want %q, got %v", c.in, c.err, err)
+		}
+	}
+}
+
+func TestLabelMatches(t *testing.T) {
+	metric := "m:\n  walk: [1]\n  metrics:\n  - name: a\n    oid: 1.1\n    type: gauge\n    help: A.\n"
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{in: "    keep_if_label_matches:\n      ifAlias: uplink.*\n    drop_if_label_matches:\n      ifAlias: .*spare.*\n"},
+		{in: "    keep_if_label_matches:\n      1ifAlias: uplink.*\n", err: `invalid label name "1ifAlias" to match in a`},
+		{in: "    drop_if_label_matches:\n      ifAlias:\n", err: "missing regex for label ifAlias to match in a"},
+		{in: "    drop_if_label_matches:\n      ifAlias: (\n", err: "missing closing )"},
+	}
+	for _, c := range cases {
+		cfg := config.Config{}
+		err := yaml.Unmarshal([]byte(metric+c.in), &cfg)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("Unexpected error for %q: %s", c.in, err)
+			} else if m := cfg.Modules["m"].Metrics[0]; !m.KeepIfLabelMatches["ifAlias"].MatchString("uplink to core") || m.KeepIfLabelMatches["ifAlias"].MatchString("an uplink") {
+				t.Errorf("Regex not anchored: %+v", m.KeepIfLabelMatches)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Wrong error for %q: want %q, got %v", c.in, c.err, err)
+		}
+	}
+}
+
+func TestMinMax(t *testing.T) {
+	metric := "m:\n  walk: [1]\n  metrics:\n  - name: a\n    oid: 1.1\n    type: gauge\n    help: A.\n    ignore_values: [0xFFFFFFFF, -1]\n"
+	cfg := config.Config{}
+	if err := yaml.Unmarshal([]byte(metric+"    min: 0\n    max: 100\n"), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	m := cfg.Modules["m"].Metrics[0]
+	if len(m.IgnoreValues) != 2 || m.IgnoreValues[0] != 4294967295 || m.IgnoreValues[1] != -1 || *m.Min != 0 || *m.Max != 100 {
+		t.Errorf("Wrong metric: %+v", m)
+	}
+	err := yaml.Unmarshal([]byte(metric+"    min: 10\n    max: 1\n"), &config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "min of a is greater than its max") {
+		t.Errorf("Wrong error for min above max: %v", err)
+	}
+}
+
+func TestMissingObjectsConfig(t *testing.T) {
+	cfg := config.Config{}
+	in := "m:\n  walk: [1]\n  missing_objects: metric\n  metrics:\n  - name: a\n    oid: 1.1\n    type: gauge\n    help: A.\n    missing_objects: fail\n"
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if m := cfg.Modules["m"]; m.MissingObjects != "metric" || m.Metrics[0].MissingObjects != "fail" {
+		t.Errorf("Wrong missing_objects: %+v", m)
+	}
+	err := yaml.Unmarshal([]byte("m:\n  walk: [1]\n  missing_objects: ignore\n"), &config.Config{})
+	if err == nil || !strings.Contains(err.Error(), "missing_objects must be one of skip, metric or fail") {
+		t.Errorf("Wrong error for an invalid policy: %v", err)
+	}
+}
+
+func TestRelabelConfigs(t *testing.T) {
+	cfg := config.Config{}
+	in := "m:\n  walk: [1]\n  relabel_configs:\n  - source_labels: [__name__]\n    regex: 'if(.*)'\n    target_label: __name__\n    replacement: 'interface_$1'\n  - source_labels: [ifAlias]\n    regex: ''\n    action: drop\n"
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	r := cfg.Modules["m"].RelabelConfigs
+	if len(r) != 2 || r[0].Action != "replace" || r[0].Separator != ";" || !r[0].Regex.MatchString("ifSpeed") || r[0].Regex.MatchString("xifSpeed") {
+		t.Errorf("Wrong relabel configs: %+v", r)
+	}
+	if r[1].Action != "drop" || r[1].Replacement != "$1" || !r[1].Regex.MatchString("") {
+		t.Errorf("Wrong relabel config: %+v", r[1])
+	}
+
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{in: "- regex: a", err: `relabel_configs with action replace need a valid target_label, got ""`},
+		{in: "- action: keep", err: "relabel_configs with action keep need source_labels"},
+		{in: "- source_labels: [a]\n    action: labelmap", err: `relabel_configs action must be one of replace, keep or drop, got "labelmap"`},
+		{in: "- source_labels: [a-b]\n    action: drop", err: `invalid source label name "a-b" in relabel_configs`},
+	}
+	for _, c := range cases {
+		err := yaml.Unmarshal([]byte("m:\n  walk: [1]\n  relabel_configs:\n  "+c.in+"\n"), &config.Config{})
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Wrong error parsing %q: want %q, got %v", c.in, c.err, err)
+		}
+	}
+}
+
+func TestNamedAuths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snmp.yml")
+	content := `auths:
+  public_v2:
+    auth:
+      community: public
+  secure_v3:
+    version: 3
+    auth:
+      username: monitor
+      security_level: authNoPriv
+      password: ${TEST_SNMP_PASSWORD}
+if_mib:
+  walk: [1.3.6.1.2.1.2]
+`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_SNMP_PASSWORD", "s3cret")
+	defer os.Unsetenv("TEST_SNMP_PASSWORD")
+
+	c, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Modules) != 1 || c.Modules["if_mib"] == nil {
+		t.Errorf("Wrong modules loaded: %v", c.Modules)
+	}
+	if a := c.Auths["public_v2"]; a == nil || a.Version != 2 || a.Auth.Community != "public" {
+		t.Errorf("Wrong public_v2 auth: %+v", a)
+	}
+	if a := c.Auths["secure_v3"]; a == nil || a.Version != 3 || a.Auth.Username != "monitor" || a.Auth.Password != "s3cret" {
+		t.Errorf("Wrong secure_v3 auth: %+v", a)
+	}
+
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "auths:") || !strings.Contains(string(out), "if_mib:") || strings.Contains(string(out), "s3cret") {
+		t.Errorf("Wrong marshalled config:\n%s", out)
+	}
+
+	err = yaml.Unmarshal([]byte("auths:\n  v3:\n    version: 3\n"), &config.Config{})
+	if want := "Auth username is missing, required for SNMPv3"; err == nil || err.Error() != want {
+		t.Errorf("Wrong error: want %q, got %v", want, err)
+	}
+}
+
+func TestHandlerUnknownAuth(t *testing.T) {
+	sc.Lock()
+	sc.C = &config.Config{Modules: map[string]*config.Module{"default": &config.Module{}}}
+	sc.Unlock()
+	defer func() {
+		sc.Lock()
+		sc.C = &config.Config{}
+		sc.Unlock()
+	}()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/snmp?target=localhost&auth=missing", nil))
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "Unknown auth 'missing'") {
+		t.Errorf("Wrong response for unknown auth: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestContextName(t *testing.T) {
+	cfg := config.Config{}
+	in := "m:\n  walk: [1]\n  version: 3\n  auth:\n    username: monitor\n    security_level: noAuthNoPriv\n    context_name: vlan-1\n"
+	if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	module := cfg.Modules["m"]
+	g := &gosnmp.GoSNMP{}
+	module.WalkParams.ConfigureSNMP(g)
+	if g.ContextName != "vlan-1" {
+		t.Errorf("Wrong context name: %q", g.ContextName)
+	}
+
+	// The context parameter of a scrape overrides that of the module.
+	withContext := moduleWithContext(module, "vlan-100")
+	withContext.WalkParams.ConfigureSNMP(g)
+	if g.ContextName != "vlan-100" || module.WalkParams.Auth.ContextName != "vlan-1" {
+		t.Errorf("Wrong context names: %q, module %q", g.ContextName, module.WalkParams.Auth.ContextName)
+	}
+}
+
+func TestPrivProtocols(t *testing.T) {
+	cases := map[string]gosnmp.SnmpV3PrivProtocol{
+		"DES":     gosnmp.DES,
+		"AES":     gosnmp.AES,
+		"AES192":  gosnmp.AES192,
+		"AES256":  gosnmp.AES256,
+		"AES192C": gosnmp.AES192C,
+		"AES256C": gosnmp.AES256C,
+	}
+	for protocol, want := range cases {
+		cfg := config.Config{}
+		in := "m:\n  walk: [1]\n  version: 3\n  auth:\n    username: monitor\n    security_level: authPriv\n    password: pass\n    priv_protocol: " + protocol + "\n    priv_password: priv\n"
+		if err := yaml.Unmarshal([]byte(in), &cfg); err != nil {
+			t.Errorf("%s: %s", protocol, err)
+			continue
+		}
+		g := &gosnmp.GoSNMP{}
+		cfg.Modules["m"].WalkParams.ConfigureSNMP(g)
+		usm := g.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+		if usm.PrivacyProtocol != want {
+			t.Errorf("%s: wrong privacy protocol %v, want %v", protocol, usm.PrivacyProtocol, want)
+		}
+	}
+
+	cfg := config.Config{}
+	in := "m:\n  walk: [1]\n  version: 3\n  auth:\n    username: monitor\n    security_level: authPriv\n    password: pass\n    priv_protocol: AES512\n    priv_password: priv\n"
+	if err := yaml.Unmarshal([]byte(in), &cfg); err == nil {
+		t.Error("Expected an error for an unknown privacy protocol")
+	}
+}
+
+func TestRequestAuth(t *testing.T) {
+	key := []byte("key")
+	expires := strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10)
+	sign := func(target, module, expires, body string) string {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(target + "\n" + module + "\n" + expires + "\n" + body))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	body := "version: 3\nauth:\n  username: monitor\n  security_level: authNoPriv\n  password: s3cret\n"
+	credentialProviders["vault"] = newVaultProvider("http://localhost", "", time.Minute)
+	defer delete(credentialProviders, "vault")
+	cases := []struct {
+		url       string
+		body      string
+		expires   string
+		signature string
+		key       []byte
+		err       string
+	}{
+		{url: "/snmp?target=localhost&module=if_mib", body: body, expires: expires, signature: sign("localhost", "if_mib", expires, body), key: key},
+		{url: "/snmp?target=localhost", body: body, expires: expires, signature: sign("localhost", "", expires, body),
+			err: "credentials can't be sent with a scrape without --web.credentials-key-file"},
+		{url: "/snmp?target=localhost", body: body, expires: expires, signature: sign("localhost", "", expires, "other"), key: key, err: "bad signature"},
+		{url: "/snmp?target=localhost", body: body, expires: expires, signature: "zz", key: key, err: "bad signature"},
+		// The target and module are signed, so the credentials can't be sent elsewhere.
+		{url: "/snmp?target=attacker", body: body, expires: expires, signature: sign("localhost", "", expires, body), key: key, err: "bad signature"},
+		{url: "/snmp?target=localhost&module=other", body: body, expires: expires, signature: sign("localhost", "", expires, body), key: key, err: "bad signature"},
+		// As is the expiry.
+		{url: "/snmp?target=localhost", body: body, expires: "1", signature: sign("localhost", "", expires, body), key: key, err: "bad signature"},
+		{url: "/snmp?target=localhost", body: body, expires: "1", signature: sign("localhost", "", "1", body), key: key, err: "credentials expired"},
+		{url: "/snmp?target=localhost", body: body, expires: "", signature: sign("localhost", "", "", body), key: key, err: "bad X-SNMP-Auth-Expires"},
+		{url: "/snmp?target=localhost", body: body, expires: "99999999999", signature: sign("localhost", "", "99999999999", body), key: key,
+			err: "credentials must expire within 5m0s"},
+		{url: "/snmp?target=localhost", body: "version: 3\n", expires: expires, signature: sign("localhost", "", expires, "version: 3\n"), key: key,
+			err: "Auth username is missing, required for SNMPv3"},
+		{url: "/snmp?target=localhost", body: "auth:\n  community_file: /etc/passwd\n", expires: expires,
+			signature: sign("localhost", "", expires, "auth:\n  community_file: /etc/passwd\n"), key: key,
+			err: "credentials from files can't be sent with a scrape"},
+		{url: "/snmp?target=localhost", body: "auth:\n  community: vault:kv/secret#community\n", expires: expires,
+			signature: sign("localhost", "", expires, "auth:\n  community: vault:kv/secret#community\n"), key: key,
+			err: "references to secrets can't be sent with a scrape"},
+		// The first case again.
+		{url: "/snmp?target=localhost&module=if_mib", body: body, expires: expires, signature: sign("localhost", "if_mib", expires, body), key: key,
+			err: "credentials already used"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", c.url, strings.NewReader(c.body))
+		r.Header.Set("X-SNMP-Auth-Signature", c.signature)
+		r.Header.Set("X-SNMP-Auth-Expires", c.expires)
+		auth, err := requestAuth(r, c.key)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("Wrong error for %s %q: want %q, got %v", c.url, c.body, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s %q: %s", c.url, c.body, err)
+			continue
+		}
+		if auth.Version != 3 || auth.Auth.Username != "monitor" || auth.Auth.Password != "s3cret" {
+			t.Errorf("Wrong auth: %+v", auth)
+		}
+	}
+}
+
+func TestSignatureCache(t *testing.T) {
+	c := newSignatureCache()
+	now := time.Unix(0, 0)
+	if !c.use("a", now.Add(time.Minute), now) || c.use("a", now.Add(time.Minute), now) {
+		t.Errorf("Signature not used once")
+	}
+	// Forgotten once expired, when it can't be used anyway.
+	c.use("b", now.Add(3*time.Minute), now.Add(2*time.Minute))
+	if len(c.expires) != 1 {
+		t.Errorf("Expired signatures not forgotten: %v", c.expires)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Add a comment above each metric of a generated YAML config naming the MIB
+// object it came from and giving its DESCRIPTION, for people reviewing the
+// config. go-yaml can't write comments, so they're added to its output.
+func annotateConfig(out []byte, nameToNode map[string]*Node) []byte {
+	lines := strings.Split(string(out), "\n")
+	result := make([]string, 0, len(lines))
+	section := ""
+	for i, line := range lines {
+		if strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "  -") && !strings.HasPrefix(line, "   ") {
+			section = strings.TrimSuffix(strings.TrimSpace(line), ":")
+		}
+		// The oid of a metric comes right after its name.
+		if section == "metrics" && strings.HasPrefix(line, "  - name: ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "    oid: ") {
+			oid := strings.Trim(strings.TrimPrefix(lines[i+1], "    oid: "), `"'`)
+			if n, ok := nameToNode[oid]; ok {
+				result = append(result, metricComment(n, "  ")...)
+			}
+		}
+		result = append(result, line)
+	}
+	return []byte(strings.Join(result, "\n"))
+}
+
+// The comment lines describing a node, wrapped at 80 columns.
+func metricComment(n *Node, indent string) []string {
+	name := n.Label
+	if n.Module != "" {
+		name = n.Module + "::" + n.Label
+	}
+	lines := []string{fmt.Sprintf("%s# %s (%s)", indent, name, n.Oid)}
+	line := ""
+	for _, word := range strings.Fields(n.Description) {
+		if line != "" && len(indent)+2+len(line)+1+len(word) > 80 {
+			lines = append(lines, indent+"# "+line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, indent+"# "+line)
+	}
+	return lines
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestAnnotateConfig(t *testing.T) {
+	root := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifDescr", Module: "IF-MIB", Access: "ACCESS_READONLY", Type: "OCTETSTR",
+				Description: "A textual string containing information about the interface. This string should include the name of the manufacturer."},
+		}}
+	nameToNode := prepareTree(root)
+	c := config.Config{Modules: map[string]*config.Module{"if_mib": &config.Module{
+		Walk: []string{"1.1"},
+		Metrics: []*config.Metric{
+			{Name: "ifDescr", Oid: "1.1", Type: "DisplayString", Help: "A textual string."},
+			{Name: "other", Oid: "1.2", Type: "gauge", Help: "Not in the MIBs."},
+		},
+	}}}
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(annotateConfig(out, nameToNode))
+
+	want := "  metrics:\n" +
+		"  # IF-MIB::ifDescr (1.1)\n" +
+		"  # A textual string containing information about the interface. This string\n" +
+		"  # should include the name of the manufacturer.\n" +
+		"  - name: ifDescr\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("Missing comment, want %q in:\n%s", want, got)
+	}
+	if strings.Count(got, "#") != 3 {
+		t.Errorf("Wrong number of comment lines in:\n%s", got)
+	}
+	if err := yaml.Unmarshal([]byte(got), &config.Config{}); err != nil {
+		t.Errorf("Annotated config doesn't parse: %s", err)
+	}
+}
@@ -1,10 +1,24 @@
 package main
 
-import "github.com/prometheus/snmp_exporter/config"
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
 
 // The generator config.
 type Config struct {
 	Modules map[string]*ModuleConfig `yaml:"modules"`
+	// Named auths, copied to the output for scrapes to choose with the
+	// auth URL parameter.
+	Auths map[string]*config.NamedAuth `yaml:"auths,omitempty"`
+	// Defaults for the help of all modules.
+	HelpDescription string `yaml:"help_description,omitempty"`
+	HelpMaxLength   int    `yaml:"help_max_length,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
@@ -17,11 +31,62 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := config.CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	for name := range c.Modules {
+		if err := config.CheckModuleName(name); err != nil {
+			return err
+		}
+	}
+	return checkHelpOptions(c.HelpDescription, c.HelpMaxLength)
+}
+
+func checkHelpOptions(description string, maxLength int) error {
+	if description != "" && description != "first_sentence" && description != "full" {
+		return fmt.Errorf("help_description must be first_sentence or full. Got: %s", description)
+	}
+	if maxLength < 0 {
+		return fmt.Errorf("help_max_length must not be negative. Got: %d", maxLength)
+	}
 	return nil
 }
 
 type MetricOverrides struct {
 	RegexpExtracts map[string][]config.RegexpExtract `yaml:"regex_extracts,omitempty"`
+	Type           string                            `yaml:"type,omitempty"`
+	Ignore         bool                              `yaml:"ignore,omitempty"`
+	// Generate regex_extracts from the enum values, as states or values.
+	EnumRegexExtracts string `yaml:"enum_regex_extracts,omitempty"`
+	// Labels added to every sample of the metric.
+	StaticLabels map[string]string `yaml:"static_labels,omitempty"`
+	// Applied to the value where the object is used as an index or lookup.
+	RegexpReplacements []config.RegexpReplacement `yaml:"regexp_replacements,omitempty"`
+	// What to multiply the value by, and then add to it. A scale replaces
+	// that from a DISPLAY-HINT such as d-2.
+	Scale  float64 `yaml:"scale,omitempty"`
+	Offset float64 `yaml:"offset,omitempty"`
+	// How to export large Counter64 values, wrap, split or info.
+	Counter64 string `yaml:"counter64,omitempty"`
+	// How to render the bytes of strings, also where the object is used as
+	// an index or lookup.
+	Encoding string `yaml:"encoding,omitempty"`
+	// Replaces the help from the MIB.
+	Help string `yaml:"help,omitempty"`
+	// Labels removed from the samples, and then labels renamed.
+	DropLabels   []string          `yaml:"drop_labels,omitempty"`
+	RenameLabels map[string]string `yaml:"rename_labels,omitempty"`
+	// Only keep samples whose labels match, and drop those whose match.
+	KeepIfLabelMatches map[string]config.Regexp `yaml:"keep_if_label_matches,omitempty"`
+	DropIfLabelMatches map[string]config.Regexp `yaml:"drop_if_label_matches,omitempty"`
+	// Truncate longer strings, overriding the limit of the module. 0 lifts
+	// the module's limit.
+	MaxValueLength *config.Limit `yaml:"max_value_length,omitempty"`
+	// Drop samples with these raw values, and clamp values to min and max.
+	IgnoreValues []float64 `yaml:"ignore_values,omitempty"`
+	Min          *float64  `yaml:"min,omitempty"`
+	Max          *float64  `yaml:"max,omitempty"`
+	// Overrides the missing_objects policy of the module.
+	MissingObjects string `yaml:"missing_objects,omitempty"`
+	// Overrides the max_series of the module.
+	MaxSeries int `yaml:"max_series,omitempty"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
@@ -31,22 +96,103 @@ func (c *MetricOverrides) UnmarshalYAML(unmarshal func(interface{}) error) error
 	if err := unmarshal((*plain)(c)); err != nil {
 		return err
 	}
+	switch c.EnumRegexExtracts {
+	case "", "states", "values":
+	default:
+		return fmt.Errorf("enum_regex_extracts must be states or values. Got: %s", c.EnumRegexExtracts)
+	}
+	switch c.Counter64 {
+	case "", "wrap", "split", "info":
+	default:
+		return fmt.Errorf("counter64 must be wrap, split or info. Got: %s", c.Counter64)
+	}
+	switch c.Encoding {
+	case "", "hex", "base64", "utf8", "ascii":
+	default:
+		return fmt.Errorf("encoding must be hex, base64, utf8 or ascii. Got: %s", c.Encoding)
+	}
+	if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+		return fmt.Errorf("min must not be greater than max")
+	}
+	if err := checkMissingObjects(c.MissingObjects); err != nil {
+		return err
+	}
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("max_series must not be negative. Got: %d", c.MaxSeries)
+	}
 	if err := config.CheckOverflow(c.XXX, "overrides"); err != nil {
 		return err
 	}
 	return nil
 }
 
+var metricPrefixRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
 type ModuleConfig struct {
 	Walk       []string                   `yaml:"walk"`
+	Get        []string                   `yaml:"get,omitempty"`
+	Exclude    []string                   `yaml:"exclude,omitempty"`
 	Lookups    []*Lookup                  `yaml:"lookups"`
 	WalkParams config.WalkParams          `yaml:",inline"`
 	Overrides  map[string]MetricOverrides `yaml:"overrides"`
+	Filters    config.Filters             `yaml:"filters,omitempty"`
+	// Prepended to the name of every metric.
+	Prefix string `yaml:"prefix,omitempty"`
+	// Prepend the name of the MIB module of each metric, such as cisco_envmon_.
+	NamespaceByMIB bool `yaml:"namespace_by_mib,omitempty"`
+	// Indexes to join into one label.
+	JoinIndexes []*JoinIndexes `yaml:"join_indexes,omitempty"`
+	// Leave out objects with a STATUS of deprecated or obsolete.
+	// Defaults to the --skip-deprecated flag.
+	SkipDeprecated *bool `yaml:"skip_deprecated,omitempty"`
+	// Which objects to include by their MAX-ACCESS, readable_only,
+	// include_not_accessible or all. Defaults to include_not_accessible.
+	Access string `yaml:"access,omitempty"`
+	// Labels added to every sample of the module.
+	StaticLabels map[string]string `yaml:"static_labels,omitempty"`
+	// Copied to the module, for the exporter to relabel its samples.
+	RelabelConfigs []*config.RelabelConfig `yaml:"relabel_configs,omitempty"`
+	// Copied to the module, for the exporter to truncate longer strings.
+	MaxValueLength *config.Limit `yaml:"max_value_length,omitempty"`
+	// Copied to the module, what the exporter does with missing objects.
+	MissingObjects string `yaml:"missing_objects,omitempty"`
+	// Copied to the module, the most rows of a metric the exporter keeps.
+	MaxSeries int `yaml:"max_series,omitempty"`
+	// Copied to the module, whether the exporter keeps partial scrapes.
+	PartialResults bool `yaml:"partial_results,omitempty"`
+	// Copied to the module, how long the exporter lets each walk take.
+	WalkBudget time.Duration `yaml:"walk_budget,omitempty"`
+	// Copied to the module, whether the exporter skips out of order OIDs.
+	AllowNonIncreasingOids bool `yaml:"allow_non_increasing_oids,omitempty"`
+	// Copied to the module, how long the exporter reuses a scrape for.
+	ScrapeCacheTTL time.Duration `yaml:"scrape_cache_ttl,omitempty"`
+	// Copied to the module, whether the exporter gets sysUpTime to detect reboots.
+	DetectReboots bool `yaml:"detect_reboots,omitempty"`
+	// Copied to the module, how the exporter sanitises invalid UTF-8 in labels.
+	InvalidUTF8 string `yaml:"invalid_utf8,omitempty"`
+	// Copied to the module, how the exporter renders MAC addresses.
+	MACAddressFormat    string `yaml:"mac_address_format,omitempty"`
+	MACAddressLowercase bool   `yaml:"mac_address_lowercase,omitempty"`
+	// Names, OIDs or MIB modules of notifications to describe for decoding traps.
+	Notifications []string `yaml:"notifications,omitempty"`
+	// Varbinds of the notifications to count traps by, as well as enums.
+	NotificationLabels []string `yaml:"notification_labels,omitempty"`
+	// Append units from the MIB to metric names, defaults to true.
+	UnitSuffixes bool `yaml:"unit_suffixes"`
+	// How much of the MIB description to use in help, first_sentence or full.
+	HelpDescription string `yaml:"help_description,omitempty"`
+	// Truncate the description in help to this many characters, 0 for no limit.
+	HelpMaxLength int `yaml:"help_max_length,omitempty"`
+	// Export TimeTicks in seconds rather than hundredths of a second, with
+	// a _seconds suffix. Defaults to true.
+	TimeTicksSeconds bool `yaml:"timeticks_seconds"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
 func (c *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.UnitSuffixes = true
+	c.TimeTicksSeconds = true
 	type plain ModuleConfig
 	if err := unmarshal((*plain)(c)); err != nil {
 		return err
@@ -54,23 +200,197 @@ func (c *ModuleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := config.CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	if err := checkWalkParams(c.WalkParams); err != nil {
+		return err
+	}
+	if c.Prefix != "" && !metricPrefixRE.MatchString(c.Prefix) {
+		return fmt.Errorf("prefix must only contain letters, digits, underscores and colons, and not start with a digit. Got: %s", c.Prefix)
+	}
+	switch c.Access {
+	case "", "readable_only", "include_not_accessible", "all":
+	default:
+		return fmt.Errorf("access must be readable_only, include_not_accessible or all. Got: %s", c.Access)
+	}
+	if err := checkMissingObjects(c.MissingObjects); err != nil {
+		return err
+	}
+	if c.MaxSeries < 0 {
+		return fmt.Errorf("max_series must not be negative. Got: %d", c.MaxSeries)
+	}
+	if c.WalkBudget < 0 {
+		return fmt.Errorf("walk_budget must not be negative. Got: %s", c.WalkBudget)
+	}
+	if c.ScrapeCacheTTL < 0 {
+		return fmt.Errorf("scrape_cache_ttl must not be negative. Got: %s", c.ScrapeCacheTTL)
+	}
+	switch c.InvalidUTF8 {
+	case "", "replace", "hex":
+	default:
+		return fmt.Errorf("invalid_utf8 must be replace or hex. Got: %s", c.InvalidUTF8)
+	}
+	switch c.MACAddressFormat {
+	case "", "colon", "hyphen", "dot", "raw":
+	default:
+		return fmt.Errorf("mac_address_format must be colon, hyphen, dot or raw. Got: %s", c.MACAddressFormat)
+	}
+	lookups, err := expandLookupProfiles(c.Lookups)
+	if err != nil {
+		return err
+	}
+	c.Lookups = lookups
+	return checkHelpOptions(c.HelpDescription, c.HelpMaxLength)
+}
+
+func checkMissingObjects(policy string) error {
+	switch policy {
+	case "", "skip", "metric", "fail":
+		return nil
+	default:
+		return fmt.Errorf("missing_objects must be skip, metric or fail. Got: %s", policy)
+	}
+}
+
+// Unset walk parameters are left to the snmp_exporter's defaults,
+// so only check those that are set.
+func checkWalkParams(p config.WalkParams) error {
+	if p.Version != 0 && (p.Version < 1 || p.Version > 3) {
+		return fmt.Errorf("SNMP version must be 1, 2 or 3. Got: %d", p.Version)
+	}
+	if p.Retries < 0 {
+		return fmt.Errorf("retries must not be negative. Got: %d", p.Retries)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative. Got: %s", p.Timeout)
+	}
+	switch p.Transport {
+	case "", "udp", "tcp":
+	default:
+		return fmt.Errorf("transport must be udp or tcp. Got: %s", p.Transport)
+	}
+	switch p.RetryBackoff {
+	case "", "fixed", "exponential":
+	default:
+		return fmt.Errorf("retry_backoff must be fixed or exponential. Got: %s", p.RetryBackoff)
+	}
+	if p.RetryBackoffDelay < 0 {
+		return fmt.Errorf("retry_backoff_delay must not be negative. Got: %s", p.RetryBackoffDelay)
+	}
 	return nil
 }
 
 type Lookup struct {
 	OldIndex string `yaml:"old_index"`
 	NewIndex string `yaml:"new_index"`
+	// Keep the label of the old index alongside the new one, rather than
+	// replacing it. Also set by drop_source_indexes: false.
+	KeepSourceIndexes bool `yaml:"keep_source_indexes,omitempty"`
+	// For tables indexed differently to that of the new index, how many
+	// sub-identifiers to remove from the start of the old index, and then
+	// what to add to its last sub-identifier.
+	StripPrefix int `yaml:"strip_prefix,omitempty"`
+	IndexOffset int `yaml:"index_offset,omitempty"`
+	// Copied to the lookup, how long the exporter reuses a walk of it.
+	CacheTTL time.Duration `yaml:"cache_ttl,omitempty"`
+	// Set when the lookup is the name of a profile, such as if-mib-standard.
+	Profile string `yaml:"-"`
 
 	XXX map[string]interface{} `yaml:",inline"`
 }
 
+// Sets of lookups commonly used together, which can be given by name
+// in place of a lookup.
+var lookupProfiles = map[string][]Lookup{
+	// The interface name, alias and description on everything indexed by ifIndex.
+	"if-mib-standard": {
+		{OldIndex: "ifIndex", NewIndex: "ifName", KeepSourceIndexes: true},
+		{OldIndex: "ifIndex", NewIndex: "ifAlias", KeepSourceIndexes: true},
+		{OldIndex: "ifIndex", NewIndex: "ifDescr"},
+	},
+}
+
+// Replace profile names with the lookups of the profile.
+func expandLookupProfiles(lookups []*Lookup) ([]*Lookup, error) {
+	expanded := make([]*Lookup, 0, len(lookups))
+	for _, l := range lookups {
+		if l.Profile == "" {
+			expanded = append(expanded, l)
+			continue
+		}
+		profile, ok := lookupProfiles[l.Profile]
+		if !ok {
+			names := []string{}
+			for name := range lookupProfiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown lookup profile %q, must be one of: %s", l.Profile, strings.Join(names, ", "))
+		}
+		for _, p := range profile {
+			p := p
+			expanded = append(expanded, &p)
+		}
+	}
+	return expanded, nil
+}
+
 func (c *Lookup) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var profile string
+	if err := unmarshal(&profile); err == nil {
+		c.Profile = profile
+		return nil
+	}
 	type plain Lookup
 	if err := unmarshal((*plain)(c)); err != nil {
 		return err
 	}
+	// The inverse of keep_source_indexes, as the option was first asked for.
+	var drop struct {
+		DropSourceIndexes *bool `yaml:"drop_source_indexes"`
+	}
+	if err := unmarshal(&drop); err != nil {
+		return err
+	}
+	delete(c.XXX, "drop_source_indexes")
 	if err := config.CheckOverflow(c.XXX, "module"); err != nil {
 		return err
 	}
+	if drop.DropSourceIndexes != nil {
+		if *drop.DropSourceIndexes && c.KeepSourceIndexes {
+			return fmt.Errorf("lookup %s can't set both keep_source_indexes and drop_source_indexes", c.NewIndex)
+		}
+		c.KeepSourceIndexes = !*drop.DropSourceIndexes
+	}
+	if c.StripPrefix < 0 {
+		return fmt.Errorf("strip_prefix of lookup %s must not be negative", c.NewIndex)
+	}
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("cache_ttl of lookup %s must not be negative", c.NewIndex)
+	}
+	return nil
+}
+
+type JoinIndexes struct {
+	Indexes   []string `yaml:"indexes"`
+	Labelname string   `yaml:"labelname"`
+	Separator string   `yaml:"separator"`
+
+	XXX map[string]interface{} `yaml:",inline"`
+}
+
+func (c *JoinIndexes) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	c.Separator = config.DefaultJoinIndexes.Separator
+	type plain JoinIndexes
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if err := config.CheckOverflow(c.XXX, "join_indexes"); err != nil {
+		return err
+	}
+	if c.Labelname == "" {
+		return fmt.Errorf("join_indexes labelname is missing")
+	}
+	if len(c.Indexes) < 2 {
+		return fmt.Errorf("join_indexes for %s must have at least two indexes", c.Labelname)
+	}
 	return nil
 }
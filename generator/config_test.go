@@ -0,0 +1,150 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestModuleConfigWalkParams(t *testing.T) {
+	cases := []struct {
+		in  string
+		err string
+	}{
+		{in: "walk: [1]"},
+		{in: "walk: [1]\nversion: 1\nmax_repetitions: 10\nretries: 0\ntimeout: 1m"},
+		{in: "walk: [1]\nversion: 3\nauth:\n  security_level: authPriv\n  priv_protocol: AES256C"},
+		{in: "walk: [1]\nversion: 4", err: "SNMP version must be 1, 2 or 3. Got: 4"},
+		{in: "walk: [1]\nretries: -1", err: "retries must not be negative. Got: -1"},
+		{in: "walk: [1]\ntimeout: -5s", err: "timeout must not be negative. Got: -5s"},
+		{in: "walk: [1]\nprefix: cisco_env_"},
+		{in: "walk: [1]\naccess: readable_only"},
+		{in: "walk: [1]\naccess: writable", err: "access must be readable_only, include_not_accessible or all. Got: writable"},
+		{in: "walk: [1]\noverrides:\n  ifOperStatus:\n    enum_regex_extracts: states"},
+		{in: "walk: [1]\noverrides:\n  ifOperStatus:\n    enum_regex_extracts: names", err: "enum_regex_extracts must be states or values. Got: names"},
+		{in: "walk: [1]\ninvalid_utf8: hex"},
+		{in: "walk: [1]\ninvalid_utf8: drop", err: "invalid_utf8 must be replace or hex. Got: drop"},
+		{in: "walk: [1]\nmac_address_format: dot\nmac_address_lowercase: true"},
+		{in: "walk: [1]\nmac_address_format: cisco", err: "mac_address_format must be colon, hyphen, dot or raw. Got: cisco"},
+		{in: "walk: [1]\nprefix: 1cisco", err: "prefix must only contain letters, digits, underscores and colons, and not start with a digit. Got: 1cisco"},
+	}
+	for _, c := range cases {
+		m := &ModuleConfig{}
+		err := yaml.Unmarshal([]byte(c.in), m)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("Unexpected error parsing %q: %s", c.in, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Wrong error parsing %q: want %q, got %v", c.in, c.err, err)
+		}
+	}
+
+	m := &ModuleConfig{}
+	if err := yaml.Unmarshal([]byte("walk: [1]\nmax_repetitions: 10\nretries: 1\ntimeout: 1m"), m); err != nil {
+		t.Fatal(err)
+	}
+	if m.WalkParams.MaxRepetitions != 10 || m.WalkParams.Retries != 1 || m.WalkParams.Timeout != time.Minute {
+		t.Errorf("Wrong walk params: %+v", m.WalkParams)
+	}
+	// Units are added to names, and TimeTicks are in seconds, unless turned off.
+	if !m.UnitSuffixes || !m.TimeTicksSeconds {
+		t.Errorf("Wrong unit defaults: %+v", m)
+	}
+	if err := yaml.Unmarshal([]byte("walk: [1]\nunit_suffixes: false\ntimeticks_seconds: false"), m); err != nil {
+		t.Fatal(err)
+	}
+	if m.UnitSuffixes || m.TimeTicksSeconds {
+		t.Errorf("Units used when turned off: %+v", m)
+	}
+}
+
+func TestConfigModuleNames(t *testing.T) {
+	for _, name := range []string{"auths", "version"} {
+		err := yaml.Unmarshal([]byte("modules:\n  "+name+":\n    walk: [1]"), &Config{})
+		if want := `module name "` + name + `" is reserved, as it's a key of the config`; err == nil || err.Error() != want {
+			t.Errorf("Wrong error for module %s: want %q, got %v", name, want, err)
+		}
+	}
+	if err := yaml.Unmarshal([]byte("modules:\n  if_mib:\n    walk: [1]"), &Config{}); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestLookupProfiles(t *testing.T) {
+	m := &ModuleConfig{}
+	in := `
+walk: [1]
+lookups:
+  - old_index: bsnDot11EssIndex
+    new_index: bsnDot11EssSsid
+  - if-mib-standard
+`
+	if err := yaml.Unmarshal([]byte(in), m); err != nil {
+		t.Fatal(err)
+	}
+	want := []*Lookup{
+		{OldIndex: "bsnDot11EssIndex", NewIndex: "bsnDot11EssSsid"},
+		{OldIndex: "ifIndex", NewIndex: "ifName", KeepSourceIndexes: true},
+		{OldIndex: "ifIndex", NewIndex: "ifAlias", KeepSourceIndexes: true},
+		{OldIndex: "ifIndex", NewIndex: "ifDescr"},
+	}
+	if !reflect.DeepEqual(m.Lookups, want) {
+		t.Errorf("Wrong lookups: want %+v, got %+v", want, m.Lookups)
+	}
+
+	err := yaml.Unmarshal([]byte("walk: [1]\nlookups: [missing]"), &ModuleConfig{})
+	if want := `unknown lookup profile "missing", must be one of: if-mib-standard`; err == nil || err.Error() != want {
+		t.Errorf("Wrong error: want %q, got %v", want, err)
+	}
+}
+
+func TestLookupDropSourceIndexes(t *testing.T) {
+	cases := []struct {
+		in   string
+		keep bool
+		err  string
+	}{
+		{in: ""},
+		{in: "keep_source_indexes: true", keep: true},
+		{in: "drop_source_indexes: true"},
+		{in: "drop_source_indexes: false", keep: true},
+		{in: "keep_source_indexes: true\n  drop_source_indexes: true", err: "lookup ifName can't set both keep_source_indexes and drop_source_indexes"},
+	}
+	for _, c := range cases {
+		m := &ModuleConfig{}
+		err := yaml.Unmarshal([]byte("walk: [1]\nlookups:\n- old_index: ifIndex\n  new_index: ifName\n  "+c.in), m)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Errorf("Wrong error for %q: want %q, got %v", c.in, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %s", c.in, err)
+			continue
+		}
+		if m.Lookups[0].KeepSourceIndexes != c.keep {
+			t.Errorf("Wrong keep_source_indexes for %q: %v", c.in, m.Lookups[0].KeepSourceIndexes)
+		}
+	}
+}
+
+func TestJoinIndexes(t *testing.T) {
+	m := &ModuleConfig{}
+	if err := yaml.Unmarshal([]byte("walk: [1]\njoin_indexes:\n- indexes: [a, b]\n  labelname: ab"), m); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m.JoinIndexes[0].Separator, "."; got != want {
+		t.Errorf("Wrong default separator: want %q, got %q", want, got)
+	}
+
+	err := yaml.Unmarshal([]byte("walk: [1]\njoin_indexes:\n- indexes: [a]\n  labelname: ab"), &ModuleConfig{})
+	if want := "join_indexes for ab must have at least two indexes"; err == nil || err.Error() != want {
+		t.Errorf("Wrong error: want %q, got %v", want, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// Write Markdown documentation of the metrics of each module, so that what
+// is collected can be reviewed without reading snmp.yml.
+func writeDocs(w io.Writer, c config.Config, nameToNode map[string]*Node) {
+	names := make([]string, 0, len(c.Modules))
+	for name := range c.Modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		module := c.Modules[name]
+		fmt.Fprintf(w, "## %s\n\n", name)
+		if len(module.Walk) > 0 {
+			fmt.Fprintf(w, "Walks %s.\n", markdownList(module.Walk))
+		}
+		if len(module.Get) > 0 {
+			fmt.Fprintf(w, "Gets %s.\n", markdownList(module.Get))
+		}
+		if len(module.Walk) > 0 || len(module.Get) > 0 {
+			fmt.Fprintln(w)
+		}
+
+		fmt.Fprintln(w, "| Metric | OID | Type | Labels | MIB | Description |")
+		fmt.Fprintln(w, "| --- | --- | --- | --- | --- | --- |")
+		for _, metric := range module.Metrics {
+			description, mib := "", ""
+			if n, ok := nameToNode[metric.Oid]; ok {
+				description, mib = n.Description, n.Module
+			}
+			labels := markdownList(metricLabels(metric))
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n",
+				metric.Name, metric.Oid, metric.Type, labels, mib, markdownEscape(description))
+			extracts := make([]string, 0, len(metric.RegexpExtracts))
+			for suffix := range metric.RegexpExtracts {
+				extracts = append(extracts, suffix)
+			}
+			sort.Strings(extracts)
+			for _, suffix := range extracts {
+				fmt.Fprintf(w, "| %s%s | %s | gauge | %s | %s | Extracted from %s. |\n",
+					metric.Name, suffix, metric.Oid, labels, mib, metric.Name)
+			}
+		}
+	}
+}
+
+// The labels of a metric after lookups and joins, in the order they are added.
+func metricLabels(metric *config.Metric) []string {
+	labels := []string{}
+	add := func(label string) {
+		for _, l := range labels {
+			if l == label {
+				return
+			}
+		}
+		labels = append(labels, label)
+	}
+	remove := func(label string) {
+		for i, l := range labels {
+			if l == label {
+				labels = append(labels[:i], labels[i+1:]...)
+				return
+			}
+		}
+	}
+
+	for _, index := range metric.Indexes {
+		add(index.Labelname)
+	}
+	for _, join := range metric.JoinIndexes {
+		for _, label := range join.Labels {
+			remove(label)
+		}
+		add(join.Labelname)
+	}
+	var addLookups func(lookups []*config.Lookup)
+	addLookups = func(lookups []*config.Lookup) {
+		for _, lookup := range lookups {
+			add(lookup.Labelname)
+			addLookups(lookup.Lookups)
+		}
+	}
+	addLookups(metric.Lookups)
+	return labels
+}
+
+func markdownList(items []string) string {
+	quoted := make([]string, 0, len(items))
+	for _, item := range items {
+		quoted = append(quoted, "`"+item+"`")
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// Descriptions can contain anything, so keep them to one cell of a table.
+func markdownEscape(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.NewReplacer(`|`, `\|`, `<`, `&lt;`, `>`, `&gt;`).Replace(s)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestWriteDocs(t *testing.T) {
+	var regexpAll config.Regexp
+	regexpAll.Regexp, _ = regexp.Compile(".*")
+	c := config.Config{Modules: map[string]*config.Module{
+		"if_mib": &config.Module{
+			Walk: []string{"1.3.6.1.2.1.2.2.1.10"},
+			Get:  []string{"1.3.6.1.2.1.1.3.0"},
+			Metrics: []*config.Metric{
+				{
+					Name: "ifInOctets",
+					Oid:  "1.3.6.1.2.1.2.2.1.10",
+					Type: "counter",
+					Indexes: []*config.Index{
+						{Labelname: "ifName", Type: "gauge"},
+					},
+					Lookups: []*config.Lookup{
+						{Labels: []string{"ifName"}, Labelname: "ifName", Oid: "1.3.6.1.2.1.31.1.1.1.1", Type: "DisplayString"},
+					},
+				},
+				{
+					Name: "sysUpTime",
+					Oid:  "1.3.6.1.2.1.1.3",
+					Type: "gauge",
+					RegexpExtracts: map[string][]config.RegexpExtract{
+						"Days": {{Regex: regexpAll, Value: "$1"}},
+					},
+				},
+			},
+		},
+		"a_module": &config.Module{},
+	}}
+	nameToNode := map[string]*Node{
+		"1.3.6.1.2.1.2.2.1.10": {Module: "IF-MIB", Description: "The total number of octets\n   received | in."},
+	}
+	expected := "## a_module\n\n" +
+		"| Metric | OID | Type | Labels | MIB | Description |\n" +
+		"| --- | --- | --- | --- | --- | --- |\n" +
+		"\n" +
+		"## if_mib\n\n" +
+		"Walks `1.3.6.1.2.1.2.2.1.10`.\n" +
+		"Gets `1.3.6.1.2.1.1.3.0`.\n\n" +
+		"| Metric | OID | Type | Labels | MIB | Description |\n" +
+		"| --- | --- | --- | --- | --- | --- |\n" +
+		"| ifInOctets | 1.3.6.1.2.1.2.2.1.10 | counter | `ifName` | IF-MIB | The total number of octets received \\| in. |\n" +
+		"| sysUpTime | 1.3.6.1.2.1.1.3 | gauge |  |  |  |\n" +
+		"| sysUpTimeDays | 1.3.6.1.2.1.1.3 | gauge |  |  | Extracted from sysUpTime. |\n"
+
+	out := &bytes.Buffer{}
+	writeDocs(out, c, nameToNode)
+	if got := out.String(); got != expected {
+		t.Errorf("Wrong docs: want\n%s\ngot\n%s", expected, got)
+	}
+}
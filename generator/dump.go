@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+)
+
+// A node as output by the dump command.
+type dumpNode struct {
+	Oid         string   `json:"oid"`
+	Label       string   `json:"label"`
+	Module      string   `json:"module,omitempty"`
+	Type        string   `json:"type"`
+	Access      string   `json:"access,omitempty"`
+	Indexes     []string `json:"indexes,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// Write out every node of the tree whose OID, label or MIB module matches
+// the filter, as a table or as JSON.
+func writeDump(w io.Writer, root *Node, format string, filter *regexp.Regexp) error {
+	nodes := []dumpNode{}
+	walkNode(root, func(n *Node) {
+		if n.Oid == "" {
+			return
+		}
+		if filter != nil && !filter.MatchString(n.Oid) && !filter.MatchString(n.Label) && !filter.MatchString(n.Module) {
+			return
+		}
+		nodes = append(nodes, dumpNode{
+			Oid:         n.Oid,
+			Label:       n.Label,
+			Module:      n.Module,
+			Type:        n.Type,
+			Access:      strings.TrimPrefix(n.Access, "ACCESS_"),
+			Indexes:     n.Indexes,
+			Description: n.Description,
+		})
+	})
+
+	if format == "json" {
+		out, err := json.MarshalIndent(nodes, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", out)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "OID\tLABEL\tMODULE\tTYPE\tACCESS\tINDEXES")
+	for _, n := range nodes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", n.Oid, n.Label, n.Module, n.Type, n.Access, strings.Join(n.Indexes, ","))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestWriteDump(t *testing.T) {
+	root := &Node{Oid: "1", Label: "root", Module: "TEST-MIB",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifTable", Module: "IF-MIB",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifEntry", Module: "IF-MIB", Indexes: []string{"ifIndex"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Module: "IF-MIB", Type: "INTEGER", Indexes: []string{"ifIndex"}},
+							{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Module: "IF-MIB", Type: "OCTETSTR", Indexes: []string{"ifIndex"}, Description: "A description."},
+						}},
+				}},
+		}}
+
+	out := &bytes.Buffer{}
+	if err := writeDump(out, root, "table", regexp.MustCompile("^ifDescr$")); err != nil {
+		t.Fatal(err)
+	}
+	want := "OID      LABEL    MODULE  TYPE      ACCESS    INDEXES\n" +
+		"1.1.1.2  ifDescr  IF-MIB  OCTETSTR  READONLY  ifIndex\n"
+	if out.String() != want {
+		t.Errorf("Wrong table: want\n%s\ngot\n%s", want, out)
+	}
+
+	out.Reset()
+	if err := writeDump(out, root, "json", regexp.MustCompile("^IF-MIB$")); err != nil {
+		t.Fatal(err)
+	}
+	nodes := []dumpNode{}
+	if err := json.Unmarshal(out.Bytes(), &nodes); err != nil {
+		t.Fatal(err)
+	}
+	labels := []string{}
+	for _, n := range nodes {
+		labels = append(labels, n.Label)
+	}
+	if want := []string{"ifTable", "ifEntry", "ifIndex", "ifDescr"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("Wrong nodes: want %v, got %v", want, labels)
+	}
+	if nodes[3].Description != "A description." || nodes[3].Access != "READONLY" {
+		t.Errorf("Wrong node: %+v", nodes[3])
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	// Only ${VAR} is expanded, as $1 is used in regex_extracts.
+	// $${VAR} is left as ${VAR}, for the snmp_exporter to expand.
+	envVarRE = regexp.MustCompile(`\$?\$\{(\w+)\}`)
+	// An include is the only value on its line, such as "walk: !include walk.yml",
+	// "- !include module.yml" or "!include modules.yml".
+	includeRE = regexp.MustCompile(`^(\s*)((?:- |[^#'"]*?:\s+)?)!include\s+(\S+)\s*$`)
+)
+
+// Read a generator config, replacing !include lines with the content of
+// the included file and ${VAR} with the environment variable VAR.
+func readGeneratorConfig(path string) ([]byte, error) {
+	content, err := includeFiles(path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return expandEnv(content)
+}
+
+// Included files are relative to the file including them, and may
+// themselves include files. Their paths are added to included, if not nil.
+func includeFiles(path string, stack []string, included *[]string) ([]byte, error) {
+	for _, p := range stack {
+		if p == path {
+			return nil, fmt.Errorf("%s includes itself via %s", path, strings.Join(stack, " -> "))
+		}
+	}
+	stack = append(stack, path)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		m := includeRE.FindStringSubmatch(line)
+		if m == nil {
+			out = append(out, line)
+			continue
+		}
+		indent, prefix, file := m[1], m[2], m[3]
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		if included != nil {
+			*included = append(*included, file)
+		}
+		content, err := includeFiles(file, stack, included)
+		if err != nil {
+			return nil, err
+		}
+		// The included content goes on the following lines, indented
+		// under the key or list item.
+		if prefix != "" {
+			out = append(out, indent+strings.TrimRight(prefix, " \t"))
+			indent += "  "
+		}
+		for _, l := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+			if l == "" {
+				out = append(out, l)
+				continue
+			}
+			out = append(out, indent+l)
+		}
+	}
+	return []byte(strings.Join(out, "\n")), nil
+}
+
+// Variables are expanded in the parsed scalars, rather than the text, so
+// that values such as passwords with a "#" or ": " in them stay as they
+// are. Unset variables are an error, rather than silently becoming empty.
+func expandEnv(content []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	expanded := expandEnvValue(doc, &missing)
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("environment variables not set: %s", strings.Join(missing, ", "))
+	}
+	return yaml.Marshal(expanded)
+}
+
+func expandEnvValue(v interface{}, missing *[]string) interface{} {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i := range v {
+			v[i].Key = expandEnvValue(v[i].Key, missing)
+			v[i].Value = expandEnvValue(v[i].Value, missing)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = expandEnvValue(v[i], missing)
+		}
+	case string:
+		if !envVarRE.MatchString(v) {
+			return v
+		}
+		expanded := envVarRE.ReplaceAllStringFunc(v, func(m string) string {
+			if m[1] == '$' {
+				return m[1:]
+			}
+			name := m[2 : len(m)-1]
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				*missing = append(*missing, name)
+			}
+			return value
+		})
+		return scalarOf(expanded)
+	}
+	return v
+}
+
+// A number or boolean, such as for "max_repetitions: ${REPETITIONS}", where
+// it's written the same way as YAML writes it, and otherwise the string.
+func scalarOf(s string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	switch v.(type) {
+	case int, int64, uint64, float64, bool:
+		if out, err := yaml.Marshal(v); err == nil && string(out) == s+"\n" {
+			return v
+		}
+	}
+	return s
+}
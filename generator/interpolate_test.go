@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestReadGeneratorConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "interpolate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"generator.yml": `modules:
+  if_mib:
+    walk: !include walk.yml
+    auth:
+      community: ${TEST_COMMUNITY}
+      password: $${SNMP_PASSWORD}
+    overrides:
+      ifAlias:
+        regex_extracts:
+          Info:
+            - regex: '(.*)'
+              value: '$1'
+  !include modules/other.yml
+`,
+		"walk.yml": "- interfaces\n- ifXTable\n",
+		"modules/other.yml": `other:
+  walk:
+    - sysUpTime
+    - !include extra.yml
+`,
+		"modules/extra.yml": "${TEST_EXTRA}",
+		"loop.yml":          "modules: !include loop.yml\n",
+		"unset.yml":         "modules: ${TEST_UNSET1}${TEST_UNSET2}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Setenv("TEST_COMMUNITY", "secret")
+	os.Setenv("TEST_EXTRA", "1.3.6.1.2.1.1.5")
+	defer os.Unsetenv("TEST_COMMUNITY")
+	defer os.Unsetenv("TEST_EXTRA")
+
+	got, err := readGeneratorConfig(filepath.Join(dir, "generator.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `modules:
+  if_mib:
+    walk:
+    - interfaces
+    - ifXTable
+    auth:
+      community: secret
+      password: ${SNMP_PASSWORD}
+    overrides:
+      ifAlias:
+        regex_extracts:
+          Info:
+          - regex: (.*)
+            value: $1
+  other:
+    walk:
+    - sysUpTime
+    - 1.3.6.1.2.1.1.5
+`
+	if string(got) != want {
+		t.Errorf("Wrong config: want\n%s\ngot\n%s", want, got)
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(got, cfg); err != nil {
+		t.Fatalf("Error parsing config: %s", err)
+	}
+	if walk := cfg.Modules["other"].Walk; !reflect.DeepEqual(walk, []string{"sysUpTime", "1.3.6.1.2.1.1.5"}) {
+		t.Errorf("Wrong walk: %v", walk)
+	}
+
+	_, err = readGeneratorConfig(filepath.Join(dir, "loop.yml"))
+	if err == nil || !strings.Contains(err.Error(), "includes itself") {
+		t.Errorf("Expected include loop error, got %v", err)
+	}
+	_, err = readGeneratorConfig(filepath.Join(dir, "unset.yml"))
+	if err == nil || err.Error() != "environment variables not set: TEST_UNSET1, TEST_UNSET2" {
+		t.Errorf("Expected unset variables error, got %v", err)
+	}
+	_, err = readGeneratorConfig(filepath.Join(dir, "missing.yml"))
+	if !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	in := []byte(`modules:
+  if_mib:
+    walk: [interfaces]
+    max_repetitions: ${TEST_VALUE}
+    auth:
+      community: ${TEST_VALUE}
+`)
+	defer os.Unsetenv("TEST_VALUE")
+	// Values are taken as they are, not as YAML.
+	for _, value := range []string{"pass#word", "pass: word", "*password", "%password", "&password", "0123", "'password'", "[password]", "true"} {
+		os.Setenv("TEST_VALUE", value)
+		out, err := expandEnv(in)
+		if err != nil {
+			t.Errorf("Error expanding %q: %s", value, err)
+			continue
+		}
+		var got struct {
+			Modules map[string]struct {
+				Auth struct {
+					Community string
+				}
+			}
+		}
+		if err := yaml.Unmarshal(out, &got); err != nil {
+			t.Errorf("Error parsing config with %q: %s\n%s", value, err, out)
+			continue
+		}
+		if community := got.Modules["if_mib"].Auth.Community; community != value {
+			t.Errorf("Wrong community: want %q, got %q", value, community)
+		}
+	}
+
+	// Numbers are still numbers.
+	os.Setenv("TEST_VALUE", "25")
+	out, err := expandEnv(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(out, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if m := cfg.Modules["if_mib"]; m.WalkParams.MaxRepetitions != 25 || m.WalkParams.Auth.Community != "25" {
+		t.Errorf("Wrong module: %+v", m.WalkParams)
+	}
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -13,29 +21,167 @@ import (
 	"github.com/prometheus/snmp_exporter/config"
 )
 
-// Generate a snmp_exporter config and write it out.
-func generateConfig(nodes *Node, nameToNode map[string]*Node) {
-	content, err := ioutil.ReadFile("generator.yml")
-	if err != nil {
-		log.Fatalf("Error reading yml config: %s", err)
+// Read the generator configs, and merge their modules and auths.
+// The defaults in each file only apply to the modules in that file.
+func loadGeneratorConfigs(paths []string) (map[string]*ModuleConfig, map[string]*config.NamedAuth, error) {
+	modules := map[string]*ModuleConfig{}
+	auths := map[string]*config.NamedAuth{}
+	sources := map[string]string{}
+	authSources := map[string]string{}
+	for _, path := range paths {
+		content, err := readGeneratorConfig(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error reading yml config: %s", err)
+		}
+		cfg := &Config{}
+		err = yaml.Unmarshal(content, cfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error parsing yml config %s: %s", path, err)
+		}
+		for name, m := range cfg.Modules {
+			if source, ok := sources[name]; ok {
+				return nil, nil, fmt.Errorf("Module %s is defined in both %s and %s", name, source, path)
+			}
+			if m.HelpDescription == "" {
+				m.HelpDescription = cfg.HelpDescription
+			}
+			if m.HelpMaxLength == 0 {
+				m.HelpMaxLength = cfg.HelpMaxLength
+			}
+			modules[name] = m
+			sources[name] = path
+		}
+		for name, a := range cfg.Auths {
+			if source, ok := authSources[name]; ok {
+				return nil, nil, fmt.Errorf("Auth %s is defined in both %s and %s", name, source, path)
+			}
+			auths[name] = a
+			authSources[name] = path
+		}
 	}
-	cfg := &Config{}
-	err = yaml.Unmarshal(content, cfg)
+	return modules, auths, nil
+}
+
+// Generate the snmp_exporter modules of the generator configs.
+// Up to concurrency modules are generated at once, which is safe as the tree
+// isn't changed after prepareTree. Modules are started and reported in name
+// order, so the output doesn't depend on scheduling.
+func generateModules(nodes *Node, nameToNode map[string]*Node, inputPaths []string, skipDeprecated, strict bool, concurrency int) config.Config {
+	modules, auths, err := loadGeneratorConfigs(inputPaths)
 	if err != nil {
-		log.Fatalf("Error parsing yml config: %s", err)
+		log.Fatal(err)
+	}
+	names := make([]string, 0, len(modules))
+	for name, m := range modules {
+		if m.SkipDeprecated == nil {
+			m.SkipDeprecated = &skipDeprecated
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if concurrency < 1 {
+		concurrency = 1
 	}
 
-	outputConfig := config.Config{}
-	for name, m := range cfg.Modules {
-		log.Infof("Generating config for module %s", name)
-		outputConfig[name] = generateConfigModule(m, nodes, nameToNode)
-		outputConfig[name].WalkParams = m.WalkParams
-		log.Infof("Generated %d metrics for module %s", len(outputConfig[name].Metrics), name)
+	results := make([]*config.Module, len(names))
+	errs := make([]error, len(names))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, name string) {
+			defer func() { <-sem; wg.Done() }()
+			log.Infof("Generating config for module %s", name)
+			results[i], errs[i] = generateConfigModule(modules[name], nodes, nameToNode)
+		}(i, name)
+	}
+	wg.Wait()
+
+	outputConfig := config.Config{Version: config.CurrentVersion, Modules: map[string]*config.Module{}, Auths: auths}
+	for i, name := range names {
+		if errs[i] != nil {
+			log.Fatalf("Error generating module %s: %s", name, errs[i])
+		}
+		module := results[i]
+		outputConfig.Modules[name] = module
+		log.Infof("Generated %d metrics for module %s", len(module.Metrics), name)
+		if len(module.Metrics) == 0 && len(module.Notifications) == 0 {
+			if strict {
+				log.Fatalf("Module %s has no metrics", name)
+			}
+			log.Warnf("Module %s has no metrics", name)
+		}
+	}
+	return outputConfig
+}
+
+// Generate a snmp_exporter config and write it out.
+func generateConfig(nodes *Node, nameToNode map[string]*Node, inputPaths []string, outputPath, format string, splitByModule bool, statsPath string, skipDeprecated, strict bool, concurrency int, comments bool) {
+	outputConfig := generateModules(nodes, nameToNode, inputPaths, skipDeprecated, strict, concurrency)
+	commentNodes := nameToNode
+	if !comments {
+		commentNodes = nil
+	}
+
+	stats := configStats(outputConfig)
+	printStats(os.Stdout, stats)
+	if statsPath != "" {
+		out, err := marshalStats(stats)
+		if err != nil {
+			log.Fatalf("Error marshalling stats: %s", err)
+		}
+		writeFile(statsPath, out)
+		log.Infof("Stats written to %s", statsPath)
+	}
+
+	if !splitByModule {
+		writeConfig(outputPath, format, outputConfig, commentNodes)
+		return
+	}
+
+	// Write each module to its own file, in a directory named after the index.
+	dir := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	if dir == outputPath {
+		dir += ".d"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Error creating output directory: %s", err)
+	}
+	index := map[string]string{}
+	if len(outputConfig.Auths) > 0 {
+		// Auths can't be a module name, so this can't clash with a module.
+		file := "auths.yml"
+		if format == "json" {
+			file = "auths.json"
+		}
+		writeConfig(filepath.Join(dir, file), format, config.Config{Version: outputConfig.Version, Auths: outputConfig.Auths}, nil)
+	}
+	for name, module := range outputConfig.Modules {
+		if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			log.Fatalf("Module name %q can't be used as a file name", name)
+		}
+		file := name + ".yml"
+		if format == "json" {
+			file = name + ".json"
+		}
+		writeConfig(filepath.Join(dir, file), format, config.Config{Version: outputConfig.Version, Modules: map[string]*config.Module{name: module}}, commentNodes)
+		index[name] = filepath.Join(filepath.Base(dir), file)
 	}
+	out, err := marshal(format, index)
+	if err != nil {
+		log.Fatalf("Error marshalling %s: %s", format, err)
+	}
+	writeFile(outputPath, out)
+	log.Infof("Index of modules written to %s", outputPath)
+}
 
+// Write out a snmp_exporter config, as YAML or JSON.
+// With nameToNode, the metrics of a YAML config are annotated with comments.
+func writeConfig(path, format string, c config.Config, nameToNode map[string]*Node) {
 	config.DoNotHideSecrets = true
-	out, err := yaml.Marshal(outputConfig)
-	config.DoNotHideSecrets = false
+	defer func() { config.DoNotHideSecrets = false }()
+	out, err := yaml.Marshal(c)
 	if err != nil {
 		log.Fatalf("Error marshalling yml: %s", err)
 	}
@@ -46,21 +192,117 @@ func generateConfig(nodes *Node, nameToNode map[string]*Node) {
 		log.Fatalf("Error parsing generated config: %s", err)
 	}
 
-	f, err := os.Create("snmp.yml")
+	if format == "json" {
+		out, err = marshal(format, c)
+		if err != nil {
+			log.Fatalf("Error marshalling json: %s", err)
+		}
+	} else if nameToNode != nil {
+		out = annotateConfig(out, nameToNode)
+	}
+
+	writeFile(path, out)
+	log.Infof("Config written to %s", path)
+}
+
+func marshal(format string, v interface{}) ([]byte, error) {
+	if format == "json" {
+		out, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(out, '\n'), nil
+	}
+	return yaml.Marshal(v)
+}
+
+func writeFile(path string, content []byte) {
+	f, err := os.Create(path)
 	if err != nil {
 		log.Fatalf("Error opening output file: %s", err)
 	}
-	_, err = f.Write(out)
+	defer f.Close()
+	_, err = f.Write(content)
 	if err != nil {
 		log.Fatalf("Error writing to output file: %s", err)
 	}
-	log.Infof("Config written to snmp.yml")
+}
+
+// Print the NetSNMP parse errors, grouped by the kind of problem.
+func printParseErrors(w io.Writer, parseErrors string) {
+	kinds := []struct {
+		title  string
+		prefix string
+		lines  []string
+	}{
+		{title: "Missing MIB modules", prefix: "Cannot find module"},
+		{title: "Missing imports", prefix: "Did not find"},
+		{title: "Objects dropped due to unknown parents", prefix: "Unlinked OID"},
+		{title: "Broken objects kept with only their OID", prefix: "Salvaged OID"},
+		{title: "Other errors"},
+	}
+	seen := map[string]struct{}{}
+	for _, line := range strings.Split(parseErrors, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, ok := seen[line]; ok {
+			continue
+		}
+		seen[line] = struct{}{}
+		for i := range kinds {
+			// The last kind has no prefix, and catches everything else.
+			if strings.HasPrefix(line, kinds[i].prefix) {
+				kinds[i].lines = append(kinds[i].lines, line)
+				break
+			}
+		}
+	}
+	for _, k := range kinds {
+		fmt.Fprintf(w, "%s (%d):\n", k.title, len(k.lines))
+		for _, line := range k.lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
 }
 
 var (
+	lenient            = kingpin.Flag("lenient", "Recover from common errors in MIBs, such as keeping the OIDs of broken objects so their children aren't lost.").Bool()
+	mibCacheDir        = kingpin.Flag("mibs.cache-dir", "Directory to cache the parsed MIBs in, so unchanged MIBs aren't parsed again.").String()
 	generateCommand    = kingpin.Command("generate", "Generate snmp.yml from generator.yml")
-	parseErrorsCommand = kingpin.Command("parse_errors", "Debug: Print the parse errors output by NetSNMP")
-	dumpCommand        = kingpin.Command("dump", "Debug: Dump the parsed and prepared MIBs")
+	inputPaths         = generateCommand.Flag("input", "Path to a generator config. May be repeated, in which case the modules of all of them are generated.").Short('i').Default("generator.yml").Strings()
+	outputPath         = generateCommand.Flag("output-path", "Path to write the snmp_exporter config to, or the index of modules with --split-by-module.").Short('o').Default("snmp.yml").String()
+	splitByModule      = generateCommand.Flag("split-by-module", "Write each module to its own file, in a directory named after the output path.").Bool()
+	format             = generateCommand.Flag("format", "Format to write the snmp_exporter config in, yaml or json.").Default("yaml").Enum("yaml", "json")
+	statsPath          = generateCommand.Flag("stats-path", "Path to also write the stats of each generated module to, as JSON.").String()
+	strict             = generateCommand.Flag("strict", "Fail if a module has no metrics, rather than warning.").Bool()
+	skipDeprecated     = generateCommand.Flag("skip-deprecated", "Leave out objects with a STATUS of deprecated or obsolete, unless a module sets skip_deprecated.").Bool()
+	concurrency        = generateCommand.Flag("concurrency", "How many modules to generate at once.").Default(strconv.Itoa(runtime.NumCPU())).Int()
+	comments           = generateCommand.Flag("comments", "Add a comment above each metric in the YAML config with the MIB object and description it came from.").Bool()
+	watchInputs        = generateCommand.Flag("watch", "Keep running, and regenerate whenever the generator configs or MIBs change.").Bool()
+	watchInterval      = generateCommand.Flag("watch.interval", "How often to check for changes with --watch.").Default("1s").Duration()
+	watchReloadURL     = generateCommand.Flag("watch.reload-url", "URL to POST to after each successful regeneration with --watch, such as http://localhost:9116/-/reload.").String()
+	docsCommand        = kingpin.Command("docs", "Generate Markdown documentation of the metrics of each module")
+	docsInputPaths     = docsCommand.Flag("input", "Path to a generator config. May be repeated.").Short('i').Default("generator.yml").Strings()
+	docsOutputPath     = docsCommand.Flag("output-path", "Path to write the documentation to, rather than stdout.").Short('o').String()
+	docsSkipDeprecated = docsCommand.Flag("skip-deprecated", "Leave out objects with a STATUS of deprecated or obsolete, unless a module sets skip_deprecated.").Bool()
+	serveCommand       = kingpin.Command("serve", "Serve a web UI for building generator.yml by browsing the MIBs")
+	serveListenAddress = serveCommand.Flag("web.listen-address", "Address to listen on for the web UI.").Default("localhost:9117").String()
+	scrapeCommand      = kingpin.Command("scrape-config", "Print a Prometheus scrape config for scraping targets with a module")
+	scrapeModule       = scrapeCommand.Flag("module", "The snmp_exporter module to scrape the targets with.").Required().String()
+	scrapeTargets      = scrapeCommand.Flag("targets", "Path to a file of targets, one per line.").Required().String()
+	scrapeJobName      = scrapeCommand.Flag("job-name", "Name of the Prometheus job.").Default("snmp").String()
+	scrapeExporterAddr = scrapeCommand.Flag("exporter-address", "Address of the snmp_exporter, as seen by Prometheus.").Default("localhost:9116").String()
+	parseErrorsCommand = kingpin.Command("parse_errors", "Debug: Print the parse errors output by NetSNMP, grouped by kind")
+	dumpCommand        = kingpin.Command("dump", "List every object in the parsed and prepared MIBs")
+	dumpFormat         = dumpCommand.Flag("format", "Format to list the objects in, table or json.").Default("table").Enum("table", "json")
+	dumpFilter         = dumpCommand.Flag("filter", "Only list objects whose OID, name or MIB module matches this regular expression.").Regexp()
+	mibsCommand        = kingpin.Command("mibs", "Manage MIB files")
+	mibsFetchCommand   = mibsCommand.Command("fetch", "Download and unpack well known collections of MIBs")
+	mibsFetchDir       = mibsFetchCommand.Flag("mibs-dir", "Directory to unpack the MIBs into, with a subdirectory per source.").Default("mibs").String()
+	mibsFetchSources   = mibsFetchCommand.Arg("source", "MIB sources to fetch, see the list command. Defaults to the IETF and IANA MIBs.").Strings()
+	mibsListCommand    = mibsCommand.Command("list", "List the MIB sources that can be fetched")
 )
 
 func main() {
@@ -68,20 +310,64 @@ func main() {
 	kingpin.HelpFlag.Short('h')
 	command := kingpin.Parse()
 
-	parseErrors := initSNMP()
-	log.Warnf("NetSNMP reported %d parse errors", len(strings.Split(parseErrors, "\n")))
+	// These don't need the MIBs to be loaded.
+	switch command {
+	case mibsFetchCommand.FullCommand():
+		if err := fetchMIBs(*mibsFetchDir, *mibsFetchSources); err != nil {
+			log.Fatalf("Error fetching MIBs: %s", err)
+		}
+		return
+	case mibsListCommand.FullCommand():
+		printMIBSources(os.Stdout)
+		return
+	case scrapeCommand.FullCommand():
+		targets, err := readTargets(*scrapeTargets)
+		if err != nil {
+			log.Fatalf("Error reading targets: %s", err)
+		}
+		if err := writeScrapeConfig(os.Stdout, *scrapeModule, *scrapeJobName, *scrapeExporterAddr, targets); err != nil {
+			log.Fatalf("Error writing scrape config: %s", err)
+		}
+		return
+	case generateCommand.FullCommand():
+		if *watchInputs {
+			watch(*inputPaths, *watchInterval, *watchReloadURL)
+			return
+		}
+	}
+
+	nodes, parseErrors := loadMIBTree(*lenient, *mibCacheDir)
+	parseErrors = strings.TrimSpace(parseErrors)
+	if parseErrors != "" {
+		log.Warnf("NetSNMP reported %d parse errors", len(strings.Split(parseErrors, "\n")))
+	}
+	if salvaged := strings.Count(parseErrors, "Salvaged OID"); salvaged > 0 {
+		log.Warnf("Kept %d broken objects with only their OID, see parse_errors for details", salvaged)
+	}
 
-	nodes := getMIBTree()
 	nameToNode := prepareTree(nodes)
 
 	switch command {
 	case generateCommand.FullCommand():
-		generateConfig(nodes, nameToNode)
+		generateConfig(nodes, nameToNode, *inputPaths, *outputPath, *format, *splitByModule, *statsPath, *skipDeprecated, *strict, *concurrency, *comments)
+	case docsCommand.FullCommand():
+		outputConfig := generateModules(nodes, nameToNode, *docsInputPaths, *docsSkipDeprecated, false, 1)
+		out := &bytes.Buffer{}
+		writeDocs(out, outputConfig, nameToNode)
+		if *docsOutputPath == "" {
+			os.Stdout.Write(out.Bytes())
+			return
+		}
+		writeFile(*docsOutputPath, out.Bytes())
+		log.Infof("Documentation written to %s", *docsOutputPath)
+	case serveCommand.FullCommand():
+		log.Infof("Listening on %s", *serveListenAddress)
+		log.Fatal(http.ListenAndServe(*serveListenAddress, newServer(nodes, nameToNode).handler()))
 	case parseErrorsCommand.FullCommand():
-		fmt.Println(parseErrors)
+		printParseErrors(os.Stdout, parseErrors)
 	case dumpCommand.FullCommand():
-		walkNode(nodes, func(n *Node) {
-			fmt.Printf("%s %s %s %s %s\n", n.Oid, n.Label, n.Type, n.Indexes, n.Description)
-		})
+		if err := writeDump(os.Stdout, nodes, *dumpFormat, *dumpFilter); err != nil {
+			log.Fatalf("Error writing dump: %s", err)
+		}
 	}
 }
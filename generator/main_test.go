@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateModulesConcurrently(t *testing.T) {
+	root := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifTable",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+							{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR", Hint: "255a"},
+							{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "ifInOctets", Type: "COUNTER"},
+						}},
+				}},
+		}}
+	nameToNode := prepareTree(root)
+
+	dir, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	modules := []string{"modules:"}
+	for i := 0; i < 20; i++ {
+		modules = append(modules, fmt.Sprintf("  m%d:\n    walk: [ifTable]\n    lookups:\n    - old_index: ifIndex\n      new_index: ifDescr", i))
+	}
+	path := filepath.Join(dir, "generator.yml")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(modules, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := generateModules(root, nameToNode, []string{path}, false, false, 1)
+	got := generateModules(root, nameToNode, []string{path}, false, false, 8)
+	if len(got.Modules) != 20 {
+		t.Fatalf("Wrong number of modules: %d", len(got.Modules))
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Concurrent generation differs: want %+v, got %+v", want, got)
+	}
+}
+
+func TestGenerateModulesAuths(t *testing.T) {
+	root := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS"},
+		}}
+	nameToNode := prepareTree(root)
+
+	dir, err := ioutil.TempDir("", "generate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "generator.yml")
+	content := "auths:\n  secure_v3:\n    version: 3\n    auth:\n      username: monitor\n      security_level: noAuthNoPriv\nmodules:\n  system:\n    walk: [sysUpTime]\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := generateModules(root, nameToNode, []string{path}, false, false, 1)
+	if a := got.Auths["secure_v3"]; a == nil || a.Version != 3 || a.Auth.Username != "monitor" {
+		t.Errorf("Wrong auths: %+v", got.Auths)
+	}
+	if len(got.Modules) != 1 || got.Modules["system"] == nil {
+		t.Errorf("Wrong modules: %+v", got.Modules)
+	}
+}
+
+func TestPrintParseErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		errors string
+		want   string
+	}{
+		{
+			name:   "No errors",
+			errors: "",
+			want: `Missing MIB modules (0):
+Missing imports (0):
+Objects dropped due to unknown parents (0):
+Broken objects kept with only their OID (0):
+Other errors (0):
+`,
+		},
+		{
+			name: "Each kind",
+			errors: `Cannot find module (FOO-MIB): At line 1 in (none)
+Did not find 'fooName' in module FOO-MIB (BAR-MIB)
+Unlinked OID in BAR-MIB: barTable ::= { foo 1 }
+Salvaged OID in BAR-MIB: barEntry ::= { barTable 1 }
+Bad operator (INTEGER): At line 73 in /usr/share/snmp/mibs/BAZ-MIB.txt
+`,
+			want: `Missing MIB modules (1):
+  Cannot find module (FOO-MIB): At line 1 in (none)
+Missing imports (1):
+  Did not find 'fooName' in module FOO-MIB (BAR-MIB)
+Objects dropped due to unknown parents (1):
+  Unlinked OID in BAR-MIB: barTable ::= { foo 1 }
+Broken objects kept with only their OID (1):
+  Salvaged OID in BAR-MIB: barEntry ::= { barTable 1 }
+Other errors (1):
+  Bad operator (INTEGER): At line 73 in /usr/share/snmp/mibs/BAZ-MIB.txt
+`,
+		},
+		{
+			name: "Duplicates, blank lines and whitespace",
+			errors: `  Cannot find module (FOO-MIB): At line 1 in (none)
+
+Cannot find module (FOO-MIB): At line 1 in (none)
+Cannot find module (BAR-MIB): At line 1 in (none)
+`,
+			want: `Missing MIB modules (2):
+  Cannot find module (FOO-MIB): At line 1 in (none)
+  Cannot find module (BAR-MIB): At line 1 in (none)
+Missing imports (0):
+Objects dropped due to unknown parents (0):
+Broken objects kept with only their OID (0):
+Other errors (0):
+`,
+		},
+	}
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		printParseErrors(buf, c.errors)
+		if buf.String() != c.want {
+			t.Errorf("%s: want\n%s\ngot\n%s", c.name, c.want, buf.String())
+		}
+	}
+}
+
+func TestGenerateConfigSplitByModule(t *testing.T) {
+	root := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS"},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "sysServices", Type: "INTEGER"},
+		}}
+	nameToNode := prepareTree(root)
+
+	cases := []struct {
+		name      string
+		generator string
+		format    string
+		output    string
+		files     map[string][]string
+	}{
+		{
+			name:      "YAML",
+			generator: "modules:\n  uptime:\n    walk: [sysUpTime]\n  services:\n    walk: [sysServices]\n",
+			format:    "yaml",
+			output:    "snmp.yml",
+			files: map[string][]string{
+				"snmp.yml":          {"services: snmp/services.yml", "uptime: snmp/uptime.yml"},
+				"snmp/uptime.yml":   {"uptime:", "name: sysUpTime_seconds"},
+				"snmp/services.yml": {"services:", "name: sysServices"},
+			},
+		},
+		{
+			name:      "JSON",
+			generator: "modules:\n  uptime:\n    walk: [sysUpTime]\n",
+			format:    "json",
+			output:    "snmp.json",
+			files: map[string][]string{
+				"snmp.json":        {`"uptime": "snmp/uptime.json"`},
+				"snmp/uptime.json": {`"uptime": {`, `"name": "sysUpTime_seconds"`},
+			},
+		},
+		{
+			name:      "Auths in their own file",
+			generator: "auths:\n  public_v2:\n    version: 2\nmodules:\n  uptime:\n    walk: [sysUpTime]\n",
+			format:    "yaml",
+			output:    "snmp.yml",
+			files: map[string][]string{
+				"snmp.yml":        {"uptime: snmp/uptime.yml"},
+				"snmp/auths.yml":  {"auths:", "public_v2:"},
+				"snmp/uptime.yml": {"uptime:"},
+			},
+		},
+		{
+			name:      "Output path without an extension",
+			generator: "modules:\n  uptime:\n    walk: [sysUpTime]\n",
+			format:    "yaml",
+			output:    "snmp",
+			files: map[string][]string{
+				"snmp":              {"uptime: snmp.d/uptime.yml"},
+				"snmp.d/uptime.yml": {"uptime:"},
+			},
+		},
+	}
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "generate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		input := filepath.Join(dir, "generator.yml")
+		if err := ioutil.WriteFile(input, []byte(c.generator), 0644); err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(dir, "out")
+		if err := os.Mkdir(output, 0755); err != nil {
+			t.Fatal(err)
+		}
+		generateConfig(root, nameToNode, []string{input}, filepath.Join(output, c.output), c.format, true, "", false, false, 1, false)
+
+		var got []string
+		filepath.Walk(output, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				rel, _ := filepath.Rel(output, path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		if len(got) != len(c.files) {
+			t.Errorf("%s: wrong files written: %v", c.name, got)
+		}
+		for file, want := range c.files {
+			content, err := ioutil.ReadFile(filepath.Join(output, file))
+			if err != nil {
+				t.Errorf("%s: %s", c.name, err)
+				continue
+			}
+			for _, w := range want {
+				if !strings.Contains(string(content), w) {
+					t.Errorf("%s: %s doesn't contain %q:\n%s", c.name, file, w, content)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadGeneratorConfigs(t *testing.T) {
+	cases := []struct {
+		name    string
+		files   []string
+		modules map[string]string // Module name to its help description.
+		auths   []string
+		err     string
+	}{
+		{
+			name:    "One file",
+			files:   []string{"modules:\n  a:\n    walk: [sysUpTime]\n"},
+			modules: map[string]string{"a": ""},
+		},
+		{
+			name: "Modules and auths are unioned",
+			files: []string{
+				"auths:\n  public_v2:\n    version: 2\nmodules:\n  a:\n    walk: [sysUpTime]\n",
+				"auths:\n  public_v1:\n    version: 1\nmodules:\n  b:\n    walk: [sysUpTime]\n  c:\n    walk: [sysUpTime]\n",
+			},
+			modules: map[string]string{"a": "", "b": "", "c": ""},
+			auths:   []string{"public_v2", "public_v1"},
+		},
+		{
+			name: "Defaults only apply to their own file",
+			files: []string{
+				"help_description: full\nmodules:\n  a:\n    walk: [sysUpTime]\n  b:\n    walk: [sysUpTime]\n    help_description: first_sentence\n",
+				"modules:\n  c:\n    walk: [sysUpTime]\n",
+			},
+			modules: map[string]string{"a": "full", "b": "first_sentence", "c": ""},
+		},
+		{
+			name: "Duplicate module",
+			files: []string{
+				"modules:\n  a:\n    walk: [sysUpTime]\n",
+				"modules:\n  a:\n    walk: [sysName]\n",
+			},
+			err: "Module a is defined in both 0.yml and 1.yml",
+		},
+		{
+			name: "Duplicate auth",
+			files: []string{
+				"auths:\n  public:\n    version: 2\n",
+				"auths:\n  public:\n    version: 1\n",
+			},
+			err: "Auth public is defined in both 0.yml and 1.yml",
+		},
+		{
+			name:  "Invalid file",
+			files: []string{"modules:\n  a:\n    walk: [sysUpTime]\n", "modules: [a]\n"},
+			err:   "Error parsing yml config 1.yml",
+		},
+	}
+	for _, c := range cases {
+		dir, err := ioutil.TempDir("", "generate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		var paths []string
+		for i, content := range c.files {
+			path := filepath.Join(dir, fmt.Sprintf("%d.yml", i))
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, path)
+		}
+		modules, auths, err := loadGeneratorConfigs(paths)
+		if c.err != "" {
+			if err == nil || !strings.Contains(strings.Replace(err.Error(), dir+string(filepath.Separator), "", -1), c.err) {
+				t.Errorf("%s: want error %q, got %v", c.name, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		got := map[string]string{}
+		for name, m := range modules {
+			got[name] = m.HelpDescription
+		}
+		if !reflect.DeepEqual(got, c.modules) {
+			t.Errorf("%s: wrong modules: want %v, got %v", c.name, c.modules, got)
+		}
+		if len(auths) != len(c.auths) {
+			t.Errorf("%s: wrong auths: want %v, got %v", c.name, c.auths, auths)
+		}
+		for _, name := range c.auths {
+			if auths[name] == nil {
+				t.Errorf("%s: auth %s missing: %v", c.name, name, auths)
+			}
+		}
+	}
+}
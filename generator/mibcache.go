@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/common/log"
+)
+
+// Bump when the Node struct or the parsers change, to invalidate old caches.
+const mibCacheVersion = 1
+
+// What's cached of parsing the MIBs.
+type mibCacheEntry struct {
+	Tree        *Node
+	ParseErrors string
+}
+
+// Parse the MIBs and get the tree, and the parse errors. With a cache
+// directory, a tree previously parsed from the same MIB files and options is
+// reused rather than parsing the MIBs again.
+func loadMIBTree(lenient bool, cacheDir string) (*Node, string) {
+	if cacheDir == "" {
+		parseErrors := initSNMP(lenient)
+		return getMIBTree(), parseErrors
+	}
+
+	key, err := mibCacheKey(mibDirs(), lenient)
+	if err != nil {
+		log.Warnf("Not caching MIBs, error hashing them: %s", err)
+		parseErrors := initSNMP(lenient)
+		return getMIBTree(), parseErrors
+	}
+	path := filepath.Join(cacheDir, key+".gob")
+	entry, err := readMIBCache(path)
+	if err == nil {
+		log.Infof("Loaded MIBs from cache %s", path)
+		return entry.Tree, entry.ParseErrors
+	}
+	if !os.IsNotExist(err) {
+		log.Warnf("Ignoring MIB cache %s: %s", path, err)
+	}
+
+	entry = &mibCacheEntry{ParseErrors: initSNMP(lenient)}
+	entry.Tree = getMIBTree()
+	if err := writeMIBCache(path, entry); err != nil {
+		log.Warnf("Error writing MIB cache: %s", err)
+	} else {
+		log.Infof("MIBs cached in %s", path)
+	}
+	return entry.Tree, entry.ParseErrors
+}
+
+// Hash the contents of the files in the MIB directories, along with what
+// else affects parsing.
+func mibCacheKey(dirs []string, lenient bool) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%d %s %t\n", mibCacheVersion, mibParser, lenient)
+	for _, dir := range dirs {
+		fmt.Fprintf(h, "dir %s\n", dir)
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+		for _, fi := range files {
+			if fi.IsDir() {
+				continue
+			}
+			fmt.Fprintf(h, "file %s %d\n", fi.Name(), fi.Size())
+			f, err := os.Open(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				return "", err
+			}
+			_, err = io.Copy(h, f)
+			f.Close()
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func readMIBCache(path string) (*mibCacheEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entry := &mibCacheEntry{}
+	if err := gob.NewDecoder(f).Decode(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Write the cache to a temporary file and rename it into place, so that
+// concurrent runs never see a partial cache.
+func writeMIBCache(path string, entry *mibCacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".mibcache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := gob.NewEncoder(f).Encode(entry); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
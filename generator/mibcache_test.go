@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMIBCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mibcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mibs := filepath.Join(dir, "mibs")
+	if err := os.Mkdir(mibs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mib := filepath.Join(mibs, "TEST-MIB")
+	if err := ioutil.WriteFile(mib, []byte("TEST-MIB DEFINITIONS ::= BEGIN END"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := []string{mibs, filepath.Join(dir, "missing")}
+	key, err := mibCacheKey(dirs, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := mibCacheKey(dirs, true); other == key {
+		t.Errorf("Key didn't change with lenient")
+	}
+	if err := ioutil.WriteFile(mib, []byte("TEST-MIB DEFINITIONS ::= BEGIN END "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if other, _ := mibCacheKey(dirs, false); other == key {
+		t.Errorf("Key didn't change when a MIB changed")
+	}
+
+	entry := &mibCacheEntry{
+		Tree: &Node{Oid: "1", Label: "iso", Children: []*Node{
+			{Oid: "1.1", Label: "ifIndex", Type: "INTEGER", EnumValues: map[int]string{1: "up"}, Indexes: []string{"ifIndex"}},
+		}},
+		ParseErrors: "Cannot find module (OTHER-MIB)",
+	}
+	path := filepath.Join(dir, "cache", key+".gob")
+	if err := writeMIBCache(path, entry); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readMIBCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, entry) {
+		t.Errorf("Wrong cache entry: want %+v, got %+v", entry, got)
+	}
+}
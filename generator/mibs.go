@@ -0,0 +1,266 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/yaml.v2"
+)
+
+// A well known collection of MIBs that can be fetched.
+type mibSource struct {
+	Name        string
+	Description string
+	URL         string
+	// Only files under this path in an archive are unpacked.
+	Path string
+	// Fetched when no sources are given.
+	Default bool
+}
+
+var mibSources = []mibSource{
+	{
+		Name:        "net-snmp",
+		Description: "The IETF and Net-SNMP MIBs shipped with Net-SNMP, including SNMPv2-MIB and IF-MIB",
+		URL:         "https://github.com/net-snmp/net-snmp/archive/refs/tags/v5.9.4.tar.gz",
+		Path:        "net-snmp-5.9.4/mibs/",
+		Default:     true,
+	},
+	{
+		Name:        "iana-iftype",
+		Description: "IANAifType-MIB, the current list of interface types",
+		URL:         "https://www.iana.org/assignments/ianaiftype-mib/ianaiftype-mib",
+		Default:     true,
+	},
+	{
+		Name:        "iana-address-family",
+		Description: "IANA-ADDRESS-FAMILY-NUMBERS-MIB",
+		URL:         "https://www.iana.org/assignments/ianaaddressfamilynumbers-mib/ianaaddressfamilynumbers-mib",
+		Default:     true,
+	},
+	{
+		Name:        "arista",
+		Description: "Arista Networks sensor MIBs",
+		URL:         "https://www.arista.com/assets/data/docs/MIBS/ARISTA-ENTITY-SENSOR-MIB.txt",
+	},
+	{
+		Name:        "paloalto",
+		Description: "Palo Alto PanOS 7.0 enterprise MIBs",
+		URL:         "https://www.paloaltonetworks.com/content/dam/pan/en_US/assets/zip/technical-documentation/snmp-mib-modules/PAN-MIB-MODULES-7.0.zip",
+	},
+	{
+		Name:        "synology",
+		Description: "Synology NAS MIBs",
+		URL:         "http://dedl.synology.com/download/Document/MIBGuide/Synology_MIB_File.zip",
+	},
+}
+
+// The lockfile records the checksum of each download, so that later
+// fetches get exactly the same MIBs.
+type mibLock map[string]mibLockEntry
+
+type mibLockEntry struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+}
+
+const mibLockFile = "mibs.lock"
+
+func mibSourceNames() []string {
+	names := make([]string, 0, len(mibSources))
+	for _, s := range mibSources {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// Fetch the given MIB sources, or the default ones if none are given,
+// into a directory per source under dir.
+func fetchMIBs(dir string, names []string) error {
+	sources := []mibSource{}
+	for _, s := range mibSources {
+		if len(names) == 0 && s.Default {
+			sources = append(sources, s)
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, s := range mibSources {
+			if s.Name == name {
+				sources = append(sources, s)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown MIB source %q, must be one of: %s", name, strings.Join(mibSourceNames(), ", "))
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	lockPath := filepath.Join(dir, mibLockFile)
+	lock, err := readMIBLock(lockPath)
+	if err != nil {
+		return err
+	}
+
+	dirs := []string{}
+	for _, s := range sources {
+		log.Infof("Fetching %s from %s", s.Name, s.URL)
+		data, err := download(s.URL)
+		if err != nil {
+			return fmt.Errorf("error fetching %s: %s", s.Name, err)
+		}
+		sum := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sum[:])
+		if locked, ok := lock[s.Name]; ok && locked.URL == s.URL && locked.SHA256 != checksum {
+			return fmt.Errorf("checksum mismatch for %s: %s is locked, but got %s. Remove it from %s if the new download is expected", s.Name, locked.SHA256, checksum, lockPath)
+		}
+		lock[s.Name] = mibLockEntry{URL: s.URL, SHA256: checksum}
+
+		sourceDir := filepath.Join(dir, s.Name)
+		if err := os.MkdirAll(sourceDir, 0755); err != nil {
+			return err
+		}
+		count, err := unpackMIBs(data, s, sourceDir)
+		if err != nil {
+			return fmt.Errorf("error unpacking %s: %s", s.Name, err)
+		}
+		log.Infof("Unpacked %d files from %s to %s", count, s.Name, sourceDir)
+		dirs = append(dirs, sourceDir)
+	}
+
+	if err := writeMIBLock(lockPath, lock); err != nil {
+		return err
+	}
+	log.Infof("To use these MIBs, set MIBDIRS=+%s", strings.Join(dirs, string(filepath.ListSeparator)))
+	return nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Unpack a tarball, zip file or single MIB into dir.
+// Archives are flattened, as NetSNMP doesn't look in subdirectories.
+func unpackMIBs(data []byte, s mibSource, dir string) (int, error) {
+	count := 0
+	write := func(name string, r io.Reader) error {
+		if !strings.HasPrefix(name, s.Path) || strings.HasSuffix(name, "/") {
+			return nil
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		count++
+		return ioutil.WriteFile(filepath.Join(dir, path.Base(name)), content, 0644)
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := write(hdr.Name, tr); err != nil {
+				return 0, err
+			}
+		}
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return 0, err
+		}
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return 0, err
+			}
+			err = write(f.Name, r)
+			r.Close()
+			if err != nil {
+				return 0, err
+			}
+		}
+	default:
+		if err := write(path.Base(s.URL), bytes.NewReader(data)); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func readMIBLock(path string) (mibLock, error) {
+	lock := mibLock{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", path, err)
+	}
+	return lock, nil
+}
+
+func writeMIBLock(path string, lock mibLock) error {
+	out, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0644)
+}
+
+// Print the MIB sources that can be fetched.
+func printMIBSources(w io.Writer) {
+	sources := append([]mibSource{}, mibSources...)
+	sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+	for _, s := range sources {
+		def := ""
+		if s.Default {
+			def = " (default)"
+		}
+		fmt.Fprintf(w, "%s%s: %s\n  %s\n", s.Name, def, s.Description, s.URL)
+	}
+}
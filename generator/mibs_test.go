@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func testTarball(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testZip(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func listDir(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestFetchMIBs(t *testing.T) {
+	content := map[string][]byte{
+		"/mibs.tar.gz": testTarball(t, map[string]string{
+			"pkg-1.0/mibs/IF-MIB.txt":      "IF-MIB DEFINITIONS ::= BEGIN END",
+			"pkg-1.0/mibs/sub/SNMPv2-MIB":  "SNMPv2-MIB DEFINITIONS ::= BEGIN END",
+			"pkg-1.0/README":               "Not a MIB.",
+			"pkg-1.0/mibs/../../../escape": "Not a MIB.",
+		}),
+		"/vendor.zip": testZip(t, map[string]string{
+			"VENDOR-MIB.my": "VENDOR-MIB DEFINITIONS ::= BEGIN END",
+		}),
+		"/single-mib": []byte("SINGLE-MIB DEFINITIONS ::= BEGIN END"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := content[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	savedSources := mibSources
+	defer func() { mibSources = savedSources }()
+	mibSources = []mibSource{
+		{Name: "pkg", URL: server.URL + "/mibs.tar.gz", Path: "pkg-1.0/mibs/", Default: true},
+		{Name: "single", URL: server.URL + "/single-mib", Default: true},
+		{Name: "vendor", URL: server.URL + "/vendor.zip"},
+		{Name: "missing", URL: server.URL + "/missing.zip"},
+	}
+
+	dir, err := ioutil.TempDir("", "mibs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The defaults.
+	if err := fetchMIBs(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listDir(t, dir), []string{"mibs.lock", "pkg", "single"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong files: want %v, got %v", want, got)
+	}
+	if got, want := listDir(t, filepath.Join(dir, "pkg")), []string{"IF-MIB.txt", "SNMPv2-MIB", "escape"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong files: want %v, got %v", want, got)
+	}
+	if got, want := listDir(t, filepath.Join(dir, "single")), []string{"single-mib"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong files: want %v, got %v", want, got)
+	}
+
+	// Named sources.
+	if err := fetchMIBs(dir, []string{"vendor"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := listDir(t, filepath.Join(dir, "vendor")), []string{"VENDOR-MIB.my"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong files: want %v, got %v", want, got)
+	}
+	lock, err := readMIBLock(filepath.Join(dir, mibLockFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(lock), 3; got != want {
+		t.Errorf("Wrong number of locked sources: want %d, got %d", want, got)
+	}
+	if got, want := lock["single"].SHA256, "579d04b2e31e2cf7358d7e194c0fe65503e01165c91125fa7f5fc4b80aa72c1f"; got != want {
+		t.Errorf("Wrong checksum: want %s, got %s", want, got)
+	}
+
+	// A changed download doesn't match the lockfile.
+	content["/single-mib"] = []byte("CHANGED-MIB DEFINITIONS ::= BEGIN END")
+	if err := fetchMIBs(dir, []string{"single"}); err == nil || !strings.Contains(err.Error(), "checksum mismatch for single") {
+		t.Errorf("Expected checksum mismatch, got %v", err)
+	}
+
+	if err := fetchMIBs(dir, []string{"missing"}); err == nil || !strings.Contains(err.Error(), "404 Not Found") {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+	if err := fetchMIBs(dir, []string{"unknown"}); err == nil || !strings.Contains(err.Error(), `unknown MIB source "unknown"`) {
+		t.Errorf("Expected unknown source error, got %v", err)
+	}
+}
@@ -1,3 +1,6 @@
+//go:build cgo
+// +build cgo
+
 package main
 
 /*
@@ -5,6 +8,7 @@ package main
 #cgo CFLAGS: -I/usr/local/include
 #include <net-snmp/net-snmp-config.h>
 #include <net-snmp/mib_api.h>
+#include <net-snmp/library/default_store.h>
 #include <unistd.h>
 */
 import "C"
@@ -13,24 +17,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/prometheus/common/log"
 )
 
-// One entry in the tree of the MIB.
-type Node struct {
-	Oid         string
-	Label       string
-	Augments    string
-	Children    []*Node
-	Description string
-	Type        string
-	Hint        string
-	Units       string
-	Access      string
-
-	Indexes []string
-}
+// The MIBs are parsed by NetSNMP.
+const mibParser = "netsnmp"
 
 // Adapted from parse.h.
 var (
@@ -69,12 +62,30 @@ var (
 		67: "ACCESS_NOTIFY",
 		48: "ACCESS_CREATE",
 	}
+	netSnmpStatusMap = map[int]string{
+		23: "mandatory",
+		24: "optional",
+		25: "obsolete",
+		39: "deprecated",
+		57: "current",
+	}
 )
 
+// Get the directories NetSNMP loads MIBs from.
+func mibDirs() []string {
+	dirs := filepath.SplitList(C.GoString(C.netsnmp_get_mib_directory()))
+	for i, d := range dirs {
+		dirs[i] = os.ExpandEnv(d)
+	}
+	return dirs
+}
+
 // Initilise NetSNMP. Returns MIB parse errors.
+// When lenient, NetSNMP allows underscores in names and replaces
+// duplicate definitions with the latest one.
 //
 // Warning: This function plays with the stderr file descriptor.
-func initSNMP() string {
+func initSNMP(lenient bool) string {
 	// Load all the MIBs.
 	// RFC1213-MIB is lacking type hints and has many common tables,
 	// so prefer MIBs with hints.
@@ -83,6 +94,10 @@ func initSNMP() string {
 	log.Infof("Loading MIBs from %s", C.GoString(C.netsnmp_get_mib_directory()))
 	// We want the descriptions.
 	C.snmp_set_save_descriptions(1)
+	if lenient {
+		C.netsnmp_ds_set_boolean(C.NETSNMP_DS_LIBRARY_ID, C.NETSNMP_DS_LIB_MIB_PARSE_LABEL, 1)
+		C.netsnmp_ds_set_boolean(C.NETSNMP_DS_LIBRARY_ID, C.NETSNMP_DS_LIB_MIB_REPLACE, 1)
+	}
 
 	// Make stderr go to a pipe, as netsnmp tends to spew a
 	// lot of errors on startup that there's no apparent
@@ -124,6 +139,8 @@ func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
 		n.Oid = fmt.Sprintf("%d", t.subid)
 	}
 	n.Label = C.GoString(t.label)
+	var module [256]C.char
+	n.Module = C.GoString(C.module_name(t.modid, &module[0]))
 	if typ, ok := netSnmptypeMap[int(t._type)]; ok {
 		n.Type = typ
 	} else {
@@ -136,10 +153,37 @@ func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
 		n.Access = "unknown"
 	}
 
+	n.Status = netSnmpStatusMap[int(t.status)]
 	n.Augments = C.GoString(t.augments)
 	n.Description = C.GoString(t.description)
 	n.Hint = C.GoString(t.hint)
+	n.TextualConvention = C.GoString(C.get_tc_descriptor(t.tc_index))
 	n.Units = C.GoString(t.units)
+	if t.ranges != nil && t.ranges.next == nil && t.ranges.low == t.ranges.high {
+		n.FixedSize = int(t.ranges.low)
+	}
+
+	enums := map[int]string{}
+	enum := t.enums
+	for enum != nil {
+		enums[int(enum.value)] = C.GoString(enum.label)
+		enum = enum.next
+	}
+	n.EnumValues = enums
+
+	varbinds := []string{}
+	for varbind := t.varbinds; varbind != nil; varbind = varbind.next {
+		varbinds = append(varbinds, C.GoString(varbind.vblabel))
+	}
+	if len(varbinds) > 0 {
+		// NetSNMP keeps the objects of an OBJECT-GROUP as varbinds too.
+		// The groups of a MODULE-COMPLIANCE aren't kept.
+		if n.Type == "OBJGROUP" {
+			n.Members = varbinds
+		} else {
+			n.Varbinds = varbinds
+		}
+	}
 
 	if t.child_list == nil {
 		return
@@ -161,6 +205,9 @@ func buildMIBTree(t *C.struct_tree, n *Node, oid string) {
 	indexes := []string{}
 	for index != nil {
 		indexes = append(indexes, C.GoString(index.ilabel))
+		if index.isimplied != 0 {
+			n.ImpliedIndex = true
+		}
 		index = index.next
 	}
 	n.Indexes = indexes
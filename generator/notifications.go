@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// Describe the NOTIFICATION-TYPEs and TRAP-TYPEs at or under each of the
+// configured names, OIDs or MIB modules, so that traps can be decoded.
+func generateNotifications(cfg *ModuleConfig, root *Node, nameToNode map[string]*Node) ([]*config.Notification, error) {
+	var notifications []*config.Notification
+	seen := map[string]bool{}
+	labels, labelled := map[string]bool{}, map[string]bool{}
+	for _, name := range cfg.NotificationLabels {
+		labels[name] = true
+	}
+	add := func(n *Node) bool {
+		if n.Type != "NOTIFTYPE" && n.Type != "TRAPTYPE" {
+			return false
+		}
+		if seen[n.Oid] {
+			return true
+		}
+		seen[n.Oid] = true
+		notification := &config.Notification{
+			Name: sanitizeLabelName(n.Label),
+			Oid:  n.Oid,
+			Help: metricHelp(n, cfg),
+		}
+		for _, v := range n.Varbinds {
+			varbindNode, ok := nameToNode[v]
+			if !ok {
+				log.Warnf("Can't find varbind %s of notification %s", v, n.Label)
+				continue
+			}
+			typ, ok := metricType(varbindNode.Type)
+			if !ok {
+				log.Warnf("Can't handle varbind type %s of %s in notification %s", varbindNode.Type, v, n.Label)
+				continue
+			}
+			varbind := &config.Varbind{
+				Name: sanitizeLabelName(varbindNode.Label),
+				Oid:  varbindNode.Oid,
+				Type: typ,
+			}
+			if len(varbindNode.EnumValues) > 0 {
+				varbind.EnumValues = varbindNode.EnumValues
+			}
+			if labels[varbindNode.Label] || labels[varbindNode.Oid] {
+				varbind.Label = true
+				labelled[varbindNode.Label] = true
+				labelled[varbindNode.Oid] = true
+			}
+			notification.Varbinds = append(notification.Varbinds, varbind)
+		}
+		notifications = append(notifications, notification)
+		return true
+	}
+
+	for _, name := range cfg.Notifications {
+		found := false
+		if n, ok := nameToNode[name]; ok {
+			walkNode(n, func(n *Node) {
+				found = add(n) || found
+			})
+		} else {
+			// Not an object, so try it as a MIB module.
+			walkNode(root, func(n *Node) {
+				if n.Module == name {
+					found = add(n) || found
+				}
+			})
+		}
+		if !found {
+			return nil, fmt.Errorf("Cannot find notifications in '%s'%s", name, didYouMean(name, nameToNode))
+		}
+	}
+	for _, name := range cfg.NotificationLabels {
+		if !labelled[name] {
+			return nil, fmt.Errorf("Cannot find varbind '%s' of the notifications to label traps by", name)
+		}
+	}
+	return notifications, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// A Prometheus scrape config for the snmp_exporter, with only the fields
+// needed to scrape a module.
+type scrapeConfig struct {
+	JobName        string              `yaml:"job_name"`
+	StaticConfigs  []staticConfig      `yaml:"static_configs"`
+	MetricsPath    string              `yaml:"metrics_path"`
+	Params         map[string][]string `yaml:"params"`
+	RelabelConfigs []relabelConfig     `yaml:"relabel_configs"`
+}
+
+type staticConfig struct {
+	Targets []string `yaml:"targets"`
+}
+
+type relabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow,omitempty"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+}
+
+// Write a Prometheus scrape_configs stanza that scrapes the targets with the
+// module through the exporter.
+func writeScrapeConfig(w io.Writer, module, jobName, exporterAddress string, targets []string) error {
+	cfg := struct {
+		ScrapeConfigs []scrapeConfig `yaml:"scrape_configs"`
+	}{
+		ScrapeConfigs: []scrapeConfig{{
+			JobName:       jobName,
+			StaticConfigs: []staticConfig{{Targets: targets}},
+			MetricsPath:   "/snmp",
+			Params:        map[string][]string{"module": {module}},
+			RelabelConfigs: []relabelConfig{
+				{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+				{SourceLabels: []string{"__param_target"}, TargetLabel: "instance"},
+				{TargetLabel: "__address__", Replacement: exporterAddress},
+			},
+		}},
+	}
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// Read a list of targets, one per line. Blank lines and comments starting
+// with # are ignored.
+func readTargets(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	targets := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			targets = append(targets, line)
+		}
+	}
+	return targets, scanner.Err()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWriteScrapeConfig(t *testing.T) {
+	out := &bytes.Buffer{}
+	if err := writeScrapeConfig(out, "if_mib", "snmp", "localhost:9116", []string{"192.168.1.2", "switch1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `scrape_configs:
+- job_name: snmp
+  static_configs:
+  - targets:
+    - 192.168.1.2
+    - switch1
+  metrics_path: /snmp
+  params:
+    module:
+    - if_mib
+  relabel_configs:
+  - source_labels: [__address__]
+    target_label: __param_target
+  - source_labels: [__param_target]
+    target_label: instance
+  - target_label: __address__
+    replacement: localhost:9116
+`
+	if out.String() != want {
+		t.Errorf("Wrong scrape config: want\n%s\ngot\n%s", want, out)
+	}
+}
+
+func TestReadTargets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.txt")
+	if err := ioutil.WriteFile(path, []byte("# Core switches.\n192.168.1.2\n\n  switch1  # Rack 4.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets, err := readTargets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.168.1.2", "switch1"}; !reflect.DeepEqual(targets, want) {
+		t.Errorf("Wrong targets: want %v, got %v", want, targets)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/common/log"
+	"gopkg.in/yaml.v2"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// A web UI for building generator.yml by browsing the MIB tree, which also
+// shows the snmp.yml that would be generated from it.
+type server struct {
+	root       *Node
+	nameToNode map[string]*Node
+	// The generator isn't written to be used concurrently.
+	mtx sync.Mutex
+}
+
+// A node of the MIB tree, as shown in the UI.
+type serveNode struct {
+	Oid         string   `json:"oid"`
+	Label       string   `json:"label"`
+	Type        string   `json:"type"`
+	Access      string   `json:"access"`
+	Module      string   `json:"module"`
+	Description string   `json:"description"`
+	Indexes     []string `json:"indexes,omitempty"`
+	Children    int      `json:"children"`
+}
+
+// A module to generate, as built in the UI.
+type serveModule struct {
+	Walk    []string      `json:"walk" yaml:"walk"`
+	Lookups []serveLookup `json:"lookups" yaml:"lookups,omitempty"`
+}
+
+type serveLookup struct {
+	OldIndex          string `json:"old_index" yaml:"old_index"`
+	NewIndex          string `json:"new_index" yaml:"new_index"`
+	KeepSourceIndexes bool   `json:"keep_source_indexes" yaml:"keep_source_indexes,omitempty"`
+}
+
+type serveRequest struct {
+	Name string `json:"name"`
+	serveModule
+}
+
+type serveResponse struct {
+	GeneratorYAML string `json:"generator_yml"`
+	SnmpYAML      string `json:"snmp_yml"`
+	Metrics       int    `json:"metrics"`
+}
+
+func newServer(root *Node, nameToNode map[string]*Node) *server {
+	return &server{root: root, nameToNode: nameToNode}
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(serveIndexHTML))
+	})
+	mux.HandleFunc("/api/children", s.children)
+	mux.HandleFunc("/api/search", s.search)
+	mux.HandleFunc("/api/generate", s.generate)
+	return mux
+}
+
+func toServeNode(n *Node) serveNode {
+	return serveNode{
+		Oid:         n.Oid,
+		Label:       n.Label,
+		Type:        n.Type,
+		Access:      n.Access,
+		Module:      n.Module,
+		Description: n.Description,
+		Indexes:     n.Indexes,
+		Children:    len(n.Children),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("Error writing response: %s", err)
+	}
+}
+
+// The children of the node with the given OID, or the root without one.
+func (s *server) children(w http.ResponseWriter, r *http.Request) {
+	oid := r.URL.Query().Get("oid")
+	if oid == "" {
+		writeJSON(w, []serveNode{toServeNode(s.root)})
+		return
+	}
+	n, ok := s.nameToNode[oid]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Cannot find oid '%s'%s", oid, didYouMean(oid, s.nameToNode)), http.StatusNotFound)
+		return
+	}
+	children := []serveNode{}
+	for _, c := range n.Children {
+		children = append(children, toServeNode(c))
+	}
+	writeJSON(w, children)
+}
+
+// Objects whose names contain the query, ignoring case.
+func (s *server) search(w http.ResponseWriter, r *http.Request) {
+	query := strings.ToLower(r.URL.Query().Get("q"))
+	results := []serveNode{}
+	if query != "" {
+		walkNode(s.root, func(n *Node) {
+			if strings.Contains(strings.ToLower(n.Label), query) {
+				results = append(results, toServeNode(n))
+			}
+		})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Label < results[j].Label })
+	if len(results) > 50 {
+		results = results[:50]
+	}
+	writeJSON(w, results)
+}
+
+// Generate both generator.yml and snmp.yml for a module.
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	req := serveRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing request: %s", err), http.StatusBadRequest)
+		return
+	}
+	// The generator exits on errors, so check everything it would.
+	if err := s.checkModule(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	generatorYAML, err := yaml.Marshal(map[string]map[string]serveModule{"modules": {req.Name: req.serveModule}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Parse it back, so that the defaults are as when it's used.
+	cfg := &Config{}
+	if err := yaml.Unmarshal(generatorYAML, cfg); err != nil {
+		http.Error(w, fmt.Sprintf("Error parsing generated generator.yml: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	s.mtx.Lock()
+	module, err := generateConfigModule(cfg.Modules[req.Name], s.root, s.nameToNode)
+	s.mtx.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	snmpYAML, err := yaml.Marshal(config.Config{Modules: map[string]*config.Module{req.Name: module}})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, serveResponse{
+		GeneratorYAML: string(generatorYAML),
+		SnmpYAML:      string(snmpYAML),
+		Metrics:       len(module.Metrics),
+	})
+}
+
+// Check what generateConfigModule doesn't, before the module is generated.
+func (s *server) checkModule(req serveRequest) error {
+	if req.Name == "" {
+		return fmt.Errorf("The module needs a name")
+	}
+	if len(req.Walk) == 0 {
+		return fmt.Errorf("Nothing to walk, select some objects")
+	}
+	for _, lookup := range req.Lookups {
+		if lookup.OldIndex == "" {
+			return fmt.Errorf("Lookup of %s has no old index", lookup.NewIndex)
+		}
+	}
+	return nil
+}
+
+const serveIndexHTML = `<html>
+<head>
+<title>SNMP Exporter Config Generator</title>
+<style>
+body { font-family: sans-serif; display: flex; }
+#tree { width: 50%; padding-right: 1em; }
+#module { width: 50%; }
+ul { list-style: none; padding-left: 1.2em; }
+.toggle { cursor: pointer; display: inline-block; width: 1em; }
+.meta { color: #777; font-size: smaller; }
+textarea { width: 100%; height: 15em; }
+#error { color: #c00; }
+</style>
+</head>
+<body>
+<div id="tree">
+<h1>MIB tree</h1>
+<input id="search" placeholder="Search object names"> <button onclick="search()">Search</button>
+<ul id="results"></ul>
+<ul id="root"></ul>
+</div>
+<div id="module">
+<h1>Module</h1>
+<p><label>Name: <input id="name" value="my_module"></label></p>
+<h2>Walk</h2>
+<p>Tick objects in the tree to walk them, or add an OID, object or MIB module name.</p>
+<ul id="walk"></ul>
+<input id="walkName" placeholder="ifTable or IF-MIB"> <button onclick="addWalk(document.getElementById('walkName').value)">Add</button>
+<h2>Lookups</h2>
+<ul id="lookups"></ul>
+<input id="oldIndex" placeholder="old_index, such as ifIndex">
+<input id="newIndex" placeholder="new_index, such as ifName">
+<label><input type="checkbox" id="keepSource"> keep_source_indexes</label>
+<button onclick="addLookup()">Add</button>
+<p><button onclick="generate()">Generate</button> <span id="error"></span></p>
+<h2>generator.yml <a id="generatorLink" download="generator.yml">download</a></h2>
+<textarea id="generatorYml" readonly></textarea>
+<h2>snmp.yml <a id="snmpLink" download="snmp.yml">download</a></h2>
+<textarea id="snmpYml" readonly></textarea>
+</div>
+<script>
+var walk = [];
+var lookups = [];
+
+function nodeItem(n) {
+  var li = document.createElement('li');
+  var toggle = document.createElement('span');
+  toggle.className = 'toggle';
+  toggle.textContent = n.children > 0 ? '+' : '';
+  var box = document.createElement('input');
+  box.type = 'checkbox';
+  box.checked = walk.indexOf(n.label) >= 0;
+  box.onchange = function() { box.checked ? addWalk(n.label) : removeWalk(n.label); };
+  var text = document.createElement('span');
+  text.textContent = ' ' + n.label + ' ';
+  text.title = n.description;
+  var meta = document.createElement('span');
+  meta.className = 'meta';
+  meta.textContent = n.oid + ' ' + n.type + ' ' + n.module + (n.indexes ? ' indexes: ' + n.indexes.join(', ') : '');
+  li.append(toggle, box, text, meta);
+  var children = null;
+  toggle.onclick = function() {
+    if (children) {
+      children.remove();
+      children = null;
+      toggle.textContent = '+';
+      return;
+    }
+    children = document.createElement('ul');
+    li.append(children);
+    toggle.textContent = '-';
+    load(n.oid, children);
+  };
+  return li;
+}
+
+function load(oid, ul) {
+  fetch('api/children?oid=' + encodeURIComponent(oid)).then(function(r) { return r.json(); }).then(function(nodes) {
+    nodes.forEach(function(n) { ul.append(nodeItem(n)); });
+  });
+}
+
+function search() {
+  var ul = document.getElementById('results');
+  ul.innerHTML = '';
+  var q = document.getElementById('search').value;
+  fetch('api/search?q=' + encodeURIComponent(q)).then(function(r) { return r.json(); }).then(function(nodes) {
+    nodes.forEach(function(n) { ul.append(nodeItem(n)); });
+  });
+}
+
+function addWalk(name) {
+  if (name && walk.indexOf(name) < 0) {
+    walk.push(name);
+  }
+  render();
+}
+
+function removeWalk(name) {
+  walk = walk.filter(function(w) { return w != name; });
+  render();
+}
+
+function addLookup() {
+  lookups.push({
+    old_index: document.getElementById('oldIndex').value,
+    new_index: document.getElementById('newIndex').value,
+    keep_source_indexes: document.getElementById('keepSource').checked
+  });
+  render();
+}
+
+function render() {
+  var ul = document.getElementById('walk');
+  ul.innerHTML = '';
+  walk.forEach(function(w) {
+    var li = document.createElement('li');
+    li.textContent = w + ' ';
+    var remove = document.createElement('button');
+    remove.textContent = 'Remove';
+    remove.onclick = function() { removeWalk(w); };
+    li.append(remove);
+    ul.append(li);
+  });
+  ul = document.getElementById('lookups');
+  ul.innerHTML = '';
+  lookups.forEach(function(l, i) {
+    var li = document.createElement('li');
+    li.textContent = l.old_index + ' -> ' + l.new_index + (l.keep_source_indexes ? ' (keep source indexes) ' : ' ');
+    var remove = document.createElement('button');
+    remove.textContent = 'Remove';
+    remove.onclick = function() { lookups.splice(i, 1); render(); };
+    li.append(remove);
+    ul.append(li);
+  });
+}
+
+function setOutput(id, linkId, text) {
+  document.getElementById(id).value = text;
+  document.getElementById(linkId).href = URL.createObjectURL(new Blob([text], {type: 'text/yaml'}));
+}
+
+function generate() {
+  var error = document.getElementById('error');
+  error.textContent = '';
+  fetch('api/generate', {
+    method: 'POST',
+    body: JSON.stringify({name: document.getElementById('name').value, walk: walk, lookups: lookups})
+  }).then(function(r) {
+    if (!r.ok) {
+      return r.text().then(function(t) { throw new Error(t); });
+    }
+    return r.json();
+  }).then(function(result) {
+    setOutput('generatorYml', 'generatorLink', result.generator_yml);
+    setOutput('snmpYml', 'snmpLink', result.snmp_yml);
+    error.textContent = 'Generated ' + result.metrics + ' metrics.';
+  }).catch(function(e) {
+    error.textContent = e.message;
+  });
+}
+
+load('', document.getElementById('root'));
+</script>
+</body>
+</html>
+`
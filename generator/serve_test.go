@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServe(t *testing.T) {
+	root := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifTable",
+				Children: []*Node{
+					{Oid: "1.1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+						Children: []*Node{
+							{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+							{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR", Hint: "255a"},
+						}},
+				}},
+		}}
+	nameToNode := prepareTree(root)
+	server := httptest.NewServer(newServer(root, nameToNode).handler())
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/"); code != http.StatusOK || !strings.Contains(body, "<title>SNMP Exporter Config Generator</title>") {
+		t.Errorf("Wrong index page: %d %s", code, body)
+	}
+
+	_, body := get("/api/children?oid=1.1")
+	children := []serveNode{}
+	if err := json.Unmarshal([]byte(body), &children); err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 || children[0].Label != "ifEntry" || children[0].Children != 2 {
+		t.Errorf("Wrong children: %+v", children)
+	}
+	if code, body := get("/api/children?oid=ifTabel"); code != http.StatusNotFound || !strings.Contains(body, "did you mean ifTable?") {
+		t.Errorf("Expected not found, got %d %s", code, body)
+	}
+
+	_, body = get("/api/search?q=IFD")
+	results := []serveNode{}
+	if err := json.Unmarshal([]byte(body), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Oid != "1.1.1.2" {
+		t.Errorf("Wrong search results: %+v", results)
+	}
+
+	post := func(request string) (int, string) {
+		resp, err := http.Post(server.URL+"/api/generate", "application/json", strings.NewReader(request))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	code, body := post(`{"name": "if", "walk": ["ifTable"], "lookups": [{"old_index": "ifIndex", "new_index": "ifDescr"}]}`)
+	if code != http.StatusOK {
+		t.Fatalf("Error generating: %d %s", code, body)
+	}
+	result := serveResponse{}
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	expectedGenerator := "modules:\n  if:\n    walk:\n    - ifTable\n    lookups:\n    - old_index: ifIndex\n      new_index: ifDescr\n"
+	if result.GeneratorYAML != expectedGenerator {
+		t.Errorf("Wrong generator.yml: want %q, got %q", expectedGenerator, result.GeneratorYAML)
+	}
+	if result.Metrics != 2 || !strings.Contains(result.SnmpYAML, "labelname: ifDescr") {
+		t.Errorf("Wrong snmp.yml: %s", result.SnmpYAML)
+	}
+
+	if code, body := post(`{"name": "if", "walk": ["ifTabel"]}`); code != http.StatusBadRequest || !strings.Contains(body, "Cannot find oid 'ifTabel' to walk, did you mean ifTable?") {
+		t.Errorf("Expected bad request, got %d %s", code, body)
+	}
+	if code, body := post(`{"name": "if", "walk": ["ifTable"], "lookups": [{"old_index": "ifIndex", "new_index": "ifDesc"}]}`); code != http.StatusBadRequest || !strings.Contains(body, "Unknown index 'ifDesc'") {
+		t.Errorf("Expected bad request, got %d %s", code, body)
+	}
+}
@@ -0,0 +1,1146 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A parser for SMIv1 and SMIv2 MIB modules, producing the same tree of
+// Nodes as NetSNMP does. This is used when the generator is built without
+// cgo, so that it can be a static binary.
+
+// Modules that are loaded before all others, so that their definitions win.
+// RFC1213-MIB is lacking type hints and has many common tables,
+// so prefer MIBs with hints.
+var smiPreferredModules = []string{"SNMPv2-MIB", "IF-MIB", "IP-MIB"}
+
+// The macros that define OIDs.
+var smiMacroTypes = map[string]string{
+	"OBJECT-TYPE":        "",
+	"MODULE-IDENTITY":    "MODID",
+	"OBJECT-IDENTITY":    "OBJIDENTITY",
+	"NOTIFICATION-TYPE":  "NOTIFTYPE",
+	"TRAP-TYPE":          "TRAPTYPE",
+	"OBJECT-GROUP":       "OBJGROUP",
+	"NOTIFICATION-GROUP": "NOTIFGROUP",
+	"MODULE-COMPLIANCE":  "MODCOMP",
+	"AGENT-CAPABILITIES": "AGENTCAP",
+}
+
+// The base SMI types, named as NetSNMP does.
+var smiBaseTypes = map[string]string{
+	"INTEGER":           "INTEGER",
+	"OCTET STRING":      "OCTETSTR",
+	"OBJECT IDENTIFIER": "OBJID",
+	"BITS":              "BITSTRING",
+	"Integer32":         "INTEGER32",
+	"Unsigned32":        "UNSIGNED32",
+	"UInteger32":        "UINTEGER",
+	"Counter":           "COUNTER",
+	"Counter32":         "COUNTER",
+	"Counter64":         "COUNTER64",
+	"Gauge":             "GAUGE",
+	"Gauge32":           "GAUGE",
+	"TimeTicks":         "TIMETICKS",
+	"IpAddress":         "IPADDR",
+	"NetworkAddress":    "NETADDR",
+	"NsapAddress":       "NSAPADDRESS",
+	"Opaque":            "OPAQUE",
+	"SEQUENCE":          "OTHER",
+	"SEQUENCE OF":       "OTHER",
+	"CHOICE":            "OTHER",
+}
+
+var smiAccessMap = map[string]string{
+	"read-only":             "ACCESS_READONLY",
+	"read-write":            "ACCESS_READWRITE",
+	"write-only":            "ACCESS_WRITEONLY",
+	"not-accessible":        "ACCESS_NOACCESS",
+	"accessible-for-notify": "ACCESS_NOTIFY",
+	"read-create":           "ACCESS_CREATE",
+}
+
+// The SMI modules that only define macros, types and the top of the tree.
+// These are built in, so that they need not be present on disk.
+const smiBuiltinModules = `
+SNMPv2-SMI DEFINITIONS ::= BEGIN
+org            OBJECT IDENTIFIER ::= { iso 3 }
+dod            OBJECT IDENTIFIER ::= { org 6 }
+internet       OBJECT IDENTIFIER ::= { dod 1 }
+directory      OBJECT IDENTIFIER ::= { internet 1 }
+mgmt           OBJECT IDENTIFIER ::= { internet 2 }
+mib-2          OBJECT IDENTIFIER ::= { mgmt 1 }
+transmission   OBJECT IDENTIFIER ::= { mib-2 10 }
+experimental   OBJECT IDENTIFIER ::= { internet 3 }
+private        OBJECT IDENTIFIER ::= { internet 4 }
+enterprises    OBJECT IDENTIFIER ::= { private 1 }
+security       OBJECT IDENTIFIER ::= { internet 5 }
+snmpV2         OBJECT IDENTIFIER ::= { internet 6 }
+snmpDomains    OBJECT IDENTIFIER ::= { snmpV2 1 }
+snmpProxys     OBJECT IDENTIFIER ::= { snmpV2 2 }
+snmpModules    OBJECT IDENTIFIER ::= { snmpV2 3 }
+zeroDotZero    OBJECT IDENTIFIER ::= { 0 0 }
+END
+
+RFC1155-SMI DEFINITIONS ::= BEGIN
+org            OBJECT IDENTIFIER ::= { iso 3 }
+dod            OBJECT IDENTIFIER ::= { org 6 }
+internet       OBJECT IDENTIFIER ::= { dod 1 }
+directory      OBJECT IDENTIFIER ::= { internet 1 }
+mgmt           OBJECT IDENTIFIER ::= { internet 2 }
+experimental   OBJECT IDENTIFIER ::= { internet 3 }
+private        OBJECT IDENTIFIER ::= { internet 4 }
+enterprises    OBJECT IDENTIFIER ::= { private 1 }
+END
+
+RFC-1212 DEFINITIONS ::= BEGIN
+END
+
+RFC-1215 DEFINITIONS ::= BEGIN
+END
+
+SNMPv2-CONF DEFINITIONS ::= BEGIN
+END
+`
+
+type smiToken struct {
+	text   string
+	line   int
+	quoted bool
+}
+
+type smiOidComponent struct {
+	name   string
+	num    int
+	hasNum bool
+}
+
+type smiRange struct {
+	low, high int64
+}
+
+type smiSyntax struct {
+	base   string // INTEGER, OCTET STRING, BITS, SEQUENCE OF etc. or a type name.
+	of     string
+	enums  map[int]string
+	sizes  []smiRange
+	ranges []smiRange
+}
+
+type smiType struct {
+	name   string
+	hint   string
+	status string
+	syntax *smiSyntax
+}
+
+type smiObject struct {
+	label       string
+	macro       string
+	line        int
+	syntax      *smiSyntax
+	access      string
+	status      string
+	units       string
+	description string
+	indexes     []string
+	augments    string
+	objects     []string
+	enterprise  string
+	oid         []smiOidComponent
+}
+
+type smiModule struct {
+	name        string
+	file        string
+	imports     map[string]string
+	importLines map[string]int
+	macros      map[string]bool
+	types       map[string]*smiType
+	objects     []*smiObject
+	objectNames map[string]*smiObject
+}
+
+func isSMIWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_'
+}
+
+// Split a MIB file into tokens, dropping comments.
+// When lenient, comments always run to the end of the line as with NetSNMP,
+// as many MIBs have lines like "-- foo -- bar".
+func lexSMI(data string, lenient bool) []smiToken {
+	tokens := []smiToken{}
+	line := 1
+	i := 0
+	for i < len(data) {
+		c := data[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case strings.HasPrefix(data[i:], "--"):
+			// Comments run to the end of the line, or the next "--".
+			i += 2
+			for i < len(data) && data[i] != '\n' {
+				if !lenient && strings.HasPrefix(data[i:], "--") {
+					i += 2
+					break
+				}
+				i++
+			}
+		case c == '"':
+			start := line
+			j := i + 1
+			for j < len(data) {
+				if data[j] == '"' {
+					if j+1 < len(data) && data[j+1] == '"' {
+						j += 2
+						continue
+					}
+					break
+				}
+				if data[j] == '\n' {
+					line++
+				}
+				j++
+			}
+			tokens = append(tokens, smiToken{text: strings.Replace(data[i+1:j], `""`, `"`, -1), line: start, quoted: true})
+			i = j + 1
+		case c == '\'':
+			// Hex and binary strings, such as '00'H.
+			j := strings.IndexByte(data[i+1:], '\'')
+			if j < 0 {
+				j = len(data) - i - 1
+			}
+			j += i + 2
+			if j < len(data) && isSMIWordChar(data[j]) {
+				j++
+			}
+			if j > len(data) {
+				j = len(data)
+			}
+			tokens = append(tokens, smiToken{text: data[i:j], line: line})
+			i = j
+		case strings.HasPrefix(data[i:], "::="):
+			tokens = append(tokens, smiToken{text: "::=", line: line})
+			i += 3
+		case strings.HasPrefix(data[i:], ".."):
+			tokens = append(tokens, smiToken{text: "..", line: line})
+			i += 2
+		case strings.IndexByte("{}()[],;|.<>", c) >= 0:
+			tokens = append(tokens, smiToken{text: string(c), line: line})
+			i++
+		case isSMIWordChar(c):
+			j := i + 1
+			for j < len(data) && isSMIWordChar(data[j]) && !strings.HasPrefix(data[j:], "--") {
+				j++
+			}
+			tokens = append(tokens, smiToken{text: data[i:j], line: line})
+			i = j
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+type smiParser struct {
+	tokens []smiToken
+	pos    int
+	file   string
+	errors []string
+	// Keep the OIDs of broken definitions, so their children aren't lost.
+	lenient bool
+}
+
+func (p *smiParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// Is the token n ahead the given unquoted text?
+func (p *smiParser) is(n int, text string) bool {
+	if p.pos+n >= len(p.tokens) {
+		return false
+	}
+	t := p.tokens[p.pos+n]
+	return !t.quoted && t.text == text
+}
+
+func (p *smiParser) peek(n int) string {
+	if p.pos+n >= len(p.tokens) || p.tokens[p.pos+n].quoted {
+		return ""
+	}
+	return p.tokens[p.pos+n].text
+}
+
+func (p *smiParser) line() int {
+	if p.done() {
+		if len(p.tokens) == 0 {
+			return 0
+		}
+		return p.tokens[len(p.tokens)-1].line
+	}
+	return p.tokens[p.pos].line
+}
+
+func (p *smiParser) next() smiToken {
+	if p.done() {
+		return smiToken{line: p.line()}
+	}
+	p.pos++
+	return p.tokens[p.pos-1]
+}
+
+func (p *smiParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s (%s): At line %d", fmt.Sprintf(format, args...), p.file, p.line())
+}
+
+func (p *smiParser) expect(text string) error {
+	if !p.is(0, text) {
+		got := p.next()
+		return p.errorf("Expected %q, got %q", text, got.text)
+	}
+	p.pos++
+	return nil
+}
+
+// Skip over a bracketed expression, if there is one.
+func (p *smiParser) skipBalanced() {
+	depth := 0
+	for !p.done() {
+		switch p.peek(0) {
+		case "{", "(", "[":
+			depth++
+		case "}", ")", "]":
+			depth--
+		}
+		p.pos++
+		if depth <= 0 {
+			return
+		}
+	}
+}
+
+// Parse the comma separated words within braces, such as an INDEX.
+func (p *smiParser) parseList() ([]string, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	list := []string{}
+	item := []string{}
+	for !p.done() && !p.is(0, "}") {
+		tok := p.next()
+		if tok.text == "," {
+			list = append(list, strings.Join(item, " "))
+			item = []string{}
+			continue
+		}
+		item = append(item, tok.text)
+	}
+	if len(item) > 0 {
+		list = append(list, strings.Join(item, " "))
+	}
+	return list, p.expect("}")
+}
+
+func parseSMIValue(text string) int64 {
+	switch {
+	case text == "MIN":
+		return math.MinInt64
+	case text == "MAX":
+		return math.MaxInt64
+	case strings.HasPrefix(text, "'"):
+		end := strings.LastIndexByte(text, '\'')
+		if end <= 0 {
+			return 0 // Unterminated.
+		}
+		base := 16
+		if strings.HasSuffix(strings.ToUpper(text), "B") {
+			base = 2
+		}
+		if v, err := strconv.ParseUint(text[1:end], base, 64); err == nil && v <= math.MaxInt64 {
+			return int64(v)
+		}
+		return math.MaxInt64
+	}
+	if v, err := strconv.ParseInt(text, 10, 64); err == nil {
+		return v
+	}
+	if strings.HasPrefix(text, "-") {
+		return math.MinInt64
+	}
+	return math.MaxInt64
+}
+
+// Parse a size or range constraint, such as (SIZE (0..255)).
+func (p *smiParser) parseConstraint(s *smiSyntax) {
+	depth := 0
+	ranges := &s.ranges
+	for !p.done() {
+		tok := p.next()
+		switch tok.text {
+		case "(":
+			depth++
+		case ")":
+			depth--
+			if depth <= 0 {
+				return
+			}
+		case "SIZE":
+			ranges = &s.sizes
+		case "|", "..":
+		default:
+			low := parseSMIValue(tok.text)
+			high := low
+			if p.is(0, "..") {
+				p.pos++
+				high = parseSMIValue(p.next().text)
+			}
+			*ranges = append(*ranges, smiRange{low: low, high: high})
+		}
+	}
+}
+
+// Parse named numbers, such as { up(1), down(2) }.
+func (p *smiParser) parseEnums() (map[int]string, error) {
+	enums := map[int]string{}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for !p.done() && !p.is(0, "}") {
+		if p.is(0, ",") {
+			p.pos++
+			continue
+		}
+		name := p.next().text
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		value, err := strconv.Atoi(p.next().text)
+		if err != nil {
+			return nil, p.errorf("Bad value for enum %s", name)
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		enums[value] = name
+	}
+	return enums, p.expect("}")
+}
+
+func (p *smiParser) parseSyntax() (*smiSyntax, error) {
+	if p.is(0, "[") {
+		p.skipBalanced()
+	}
+	if p.is(0, "IMPLICIT") || p.is(0, "EXPLICIT") {
+		p.pos++
+	}
+	s := &smiSyntax{}
+	tok := p.next()
+	switch tok.text {
+	case "OCTET", "BIT":
+		if err := p.expect("STRING"); err != nil {
+			return nil, err
+		}
+		s.base = "OCTET STRING"
+		if tok.text == "BIT" {
+			s.base = "BITS"
+		}
+	case "OBJECT":
+		if err := p.expect("IDENTIFIER"); err != nil {
+			return nil, err
+		}
+		s.base = "OBJECT IDENTIFIER"
+	case "SEQUENCE":
+		if p.is(0, "OF") {
+			p.pos++
+			s.base = "SEQUENCE OF"
+			s.of = p.next().text
+			return s, nil
+		}
+		s.base = "SEQUENCE"
+		p.skipBalanced()
+		return s, nil
+	case "CHOICE":
+		s.base = "CHOICE"
+		p.skipBalanced()
+		return s, nil
+	case "", "{", "}", "(", ")", ",", ";", "::=":
+		return nil, p.errorf("Bad syntax %q", tok.text)
+	default:
+		s.base = tok.text
+	}
+	if p.is(0, "{") {
+		enums, err := p.parseEnums()
+		if err != nil {
+			return nil, err
+		}
+		s.enums = enums
+	}
+	if p.is(0, "(") {
+		p.parseConstraint(s)
+	}
+	return s, nil
+}
+
+// Parse an OID value, such as { iso org(3) 6 }.
+func (p *smiParser) parseOid() ([]smiOidComponent, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	oid := []smiOidComponent{}
+	for !p.done() && !p.is(0, "}") {
+		tok := p.next()
+		c := smiOidComponent{}
+		if n, err := strconv.Atoi(tok.text); err == nil {
+			c.num = n
+			c.hasNum = true
+		} else {
+			c.name = tok.text
+			if p.is(0, "(") {
+				p.pos++
+				n, err := strconv.Atoi(p.next().text)
+				if err != nil {
+					return nil, p.errorf("Bad OID component for %s", tok.text)
+				}
+				c.num = n
+				c.hasNum = true
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+			}
+		}
+		oid = append(oid, c)
+	}
+	return oid, p.expect("}")
+}
+
+func (p *smiParser) parseClauses(obj *smiObject) error {
+	for !p.done() && !p.is(0, "::=") {
+		if p.is(0, "END") {
+			return p.errorf("Missing ::= for %s", obj.label)
+		}
+		tok := p.next()
+		if tok.quoted {
+			continue
+		}
+		var err error
+		switch tok.text {
+		case "SYNTAX":
+			var s *smiSyntax
+			s, err = p.parseSyntax()
+			if obj.syntax == nil {
+				obj.syntax = s
+			}
+		case "UNITS":
+			if obj.units == "" {
+				obj.units = p.next().text
+			}
+		case "ACCESS", "MAX-ACCESS":
+			if obj.access == "" {
+				obj.access = p.next().text
+			}
+		case "STATUS":
+			if obj.status == "" {
+				obj.status = p.next().text
+			}
+		case "DESCRIPTION":
+			if obj.description == "" {
+				obj.description = p.next().text
+			}
+		case "INDEX":
+			obj.indexes, err = p.parseList()
+		case "AUGMENTS":
+			var augments []string
+			augments, err = p.parseList()
+			if len(augments) > 0 {
+				obj.augments = augments[0]
+			}
+		case "OBJECTS", "VARIABLES", "NOTIFICATIONS":
+			var objects []string
+			objects, err = p.parseList()
+			obj.objects = append(obj.objects, objects...)
+		case "MANDATORY-GROUPS":
+			var groups []string
+			groups, err = p.parseList()
+			obj.objects = append(obj.objects, groups...)
+		case "GROUP":
+			obj.objects = append(obj.objects, p.next().text)
+		case "ENTERPRISE":
+			obj.enterprise = p.next().text
+		case "{", "(", "[":
+			p.pos--
+			p.skipBalanced()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return p.expect("::=")
+}
+
+func (p *smiParser) parseTextualConvention(t *smiType) error {
+	for !p.done() {
+		tok := p.next()
+		if tok.quoted {
+			continue
+		}
+		switch tok.text {
+		case "DISPLAY-HINT":
+			t.hint = p.next().text
+		case "STATUS":
+			t.status = p.next().text
+		case "SYNTAX":
+			s, err := p.parseSyntax()
+			t.syntax = s
+			return err
+		case "::=", "END":
+			return p.errorf("Missing SYNTAX for %s", t.name)
+		}
+	}
+	return p.errorf("Missing SYNTAX for %s", t.name)
+}
+
+func (p *smiParser) parseAssignment(m *smiModule) error {
+	tok := p.next()
+	name := tok.text
+	switch {
+	case p.is(0, "MACRO"):
+		for !p.done() && !p.is(0, "END") {
+			p.pos++
+		}
+		p.pos++
+		m.macros[name] = true
+	case p.is(0, "::="):
+		p.pos++
+		t := &smiType{name: name}
+		if p.is(0, "TEXTUAL-CONVENTION") {
+			p.pos++
+			if err := p.parseTextualConvention(t); err != nil {
+				return err
+			}
+		} else {
+			s, err := p.parseSyntax()
+			if err != nil {
+				return err
+			}
+			t.syntax = s
+		}
+		m.types[name] = t
+	case p.is(0, "OBJECT") && p.is(1, "IDENTIFIER"):
+		p.pos += 2
+		if err := p.expect("::="); err != nil {
+			return err
+		}
+		oid, err := p.parseOid()
+		if err != nil {
+			return err
+		}
+		m.addObject(&smiObject{label: name, macro: "OBJECT IDENTIFIER", line: tok.line, oid: oid})
+	case smiMacroTypes[p.peek(0)] != "" || p.is(0, "OBJECT-TYPE"):
+		obj := &smiObject{label: name, macro: p.next().text, line: tok.line}
+		if err := p.parseClauses(obj); err != nil {
+			return err
+		}
+		if obj.macro == "TRAP-TYPE" {
+			n, err := strconv.Atoi(p.next().text)
+			if err != nil {
+				return p.errorf("Bad trap number for %s", name)
+			}
+			obj.oid = []smiOidComponent{{name: obj.enterprise}, {num: 0, hasNum: true}, {num: n, hasNum: true}}
+		} else {
+			oid, err := p.parseOid()
+			if err != nil {
+				return err
+			}
+			obj.oid = oid
+		}
+		m.addObject(obj)
+	default:
+		return p.errorf("Unexpected %q after %s", p.peek(0), name)
+	}
+	return nil
+}
+
+// Skip to what looks like the start of the next assignment.
+func (p *smiParser) recover() {
+	p.pos++
+	for !p.done() && !p.is(0, "END") {
+		if !p.tokens[p.pos].quoted && (p.is(1, "::=") || p.is(1, "MACRO") || p.is(1, "OBJECT") && p.is(2, "IDENTIFIER") || smiMacroTypes[p.peek(1)] != "" || p.is(1, "OBJECT-TYPE")) {
+			return
+		}
+		p.pos++
+	}
+}
+
+func (p *smiParser) parseImports(m *smiModule) error {
+	p.pos++
+	symbols := []string{}
+	for !p.done() && !p.is(0, ";") {
+		tok := p.next()
+		switch tok.text {
+		case ",":
+		case "FROM":
+			module := p.next()
+			for _, s := range symbols {
+				m.imports[s] = module.text
+			}
+			if _, ok := m.importLines[module.text]; !ok {
+				m.importLines[module.text] = module.line
+			}
+			symbols = []string{}
+			if p.is(0, "{") {
+				p.skipBalanced()
+			}
+		default:
+			symbols = append(symbols, tok.text)
+		}
+	}
+	return p.expect(";")
+}
+
+func (m *smiModule) addObject(obj *smiObject) {
+	m.objects = append(m.objects, obj)
+	if _, ok := m.objectNames[obj.label]; !ok {
+		m.objectNames[obj.label] = obj
+	}
+}
+
+func (p *smiParser) parseModule() (*smiModule, error) {
+	m := &smiModule{
+		name:        p.next().text,
+		file:        p.file,
+		imports:     map[string]string{},
+		importLines: map[string]int{},
+		macros:      map[string]bool{},
+		types:       map[string]*smiType{},
+		objectNames: map[string]*smiObject{},
+	}
+	if p.is(0, "{") {
+		p.skipBalanced()
+	}
+	if err := p.expect("DEFINITIONS"); err != nil {
+		return nil, err
+	}
+	for !p.done() && !p.is(0, "::=") {
+		p.pos++
+	}
+	if err := p.expect("::="); err != nil {
+		return nil, err
+	}
+	if err := p.expect("BEGIN"); err != nil {
+		return nil, err
+	}
+	for !p.done() && !p.is(0, "END") {
+		var err error
+		start := p.pos
+		switch {
+		case p.is(0, "IMPORTS"):
+			err = p.parseImports(m)
+		case p.is(0, "EXPORTS"):
+			for !p.done() && !p.is(0, ";") {
+				p.pos++
+			}
+			p.pos++
+		default:
+			err = p.parseAssignment(m)
+		}
+		if err != nil {
+			p.errors = append(p.errors, fmt.Sprintf("Error in %s: %s", m.name, err))
+			p.recover()
+			if p.lenient {
+				p.salvage(m, start)
+			}
+		}
+	}
+	return m, p.expect("END")
+}
+
+// Keep the label and OID of a broken definition between start and
+// the current position, dropping the rest of it.
+func (p *smiParser) salvage(m *smiModule, start int) {
+	end := p.pos
+	defer func() { p.pos = end }()
+	// A definition cut off by the end of the file recovers past its tokens.
+	if end > len(p.tokens) {
+		end = len(p.tokens)
+	}
+	if end-start < 2 {
+		return
+	}
+	label := p.tokens[start]
+	if label.quoted || label.text == "" || label.text[0] < 'a' || label.text[0] > 'z' {
+		return // Not an object.
+	}
+	macro := p.tokens[start+1].text
+	if macro == "OBJECT" {
+		macro = "OBJECT IDENTIFIER"
+	} else if _, ok := smiMacroTypes[macro]; !ok {
+		return
+	}
+	for i := end - 2; i > start; i-- {
+		if p.tokens[i].text == "::=" && i+1 < len(p.tokens) && p.tokens[i+1].text == "{" {
+			p.pos = i + 1
+			oid, err := p.parseOid()
+			if err != nil || p.pos > end {
+				return
+			}
+			m.addObject(&smiObject{label: label.text, macro: macro, line: label.line, oid: oid})
+			p.errors = append(p.errors, fmt.Sprintf("Salvaged OID of %s in %s (%s): At line %d", label.text, m.name, p.file, label.line))
+			return
+		}
+	}
+}
+
+// Parse all the modules in a MIB file.
+func parseSMI(data, file string, lenient bool) ([]*smiModule, []string) {
+	p := &smiParser{tokens: lexSMI(data, lenient), file: file, lenient: lenient}
+	modules := []*smiModule{}
+	for !p.done() {
+		m, err := p.parseModule()
+		if err != nil {
+			p.errors = append(p.errors, err.Error())
+			// Skip past the broken module.
+			for !p.done() && !p.is(0, "END") {
+				p.pos++
+			}
+			p.pos++
+		}
+		if m != nil {
+			modules = append(modules, m)
+		}
+	}
+	return modules, p.errors
+}
+
+// Does the file look like it contains a MIB module?
+func isSMIFile(data string) bool {
+	tokens := lexSMI(data, false)
+	for i, t := range tokens {
+		if i > 50 {
+			break
+		}
+		if t.text == "DEFINITIONS" && !t.quoted {
+			return true
+		}
+	}
+	return false
+}
+
+type smiTreeBuilder struct {
+	modules     []*smiModule
+	moduleNames map[string]*smiModule
+	oids        map[*smiObject][]int
+	resolving   map[*smiObject]bool
+	errors      []string
+}
+
+// Find the definition of a name as seen from a module.
+func (b *smiTreeBuilder) lookupObject(m *smiModule, name string) (*smiModule, *smiObject) {
+	if obj, ok := m.objectNames[name]; ok {
+		return m, obj
+	}
+	if from, ok := m.imports[name]; ok {
+		if im, ok := b.moduleNames[from]; ok {
+			if obj, ok := im.objectNames[name]; ok {
+				return im, obj
+			}
+		}
+	}
+	for _, om := range b.modules {
+		if obj, ok := om.objectNames[name]; ok {
+			return om, obj
+		}
+	}
+	return nil, nil
+}
+
+func (b *smiTreeBuilder) lookupType(m *smiModule, name string) (*smiModule, *smiType) {
+	if t, ok := m.types[name]; ok {
+		return m, t
+	}
+	if from, ok := m.imports[name]; ok {
+		if im, ok := b.moduleNames[from]; ok {
+			if t, ok := im.types[name]; ok {
+				return im, t
+			}
+		}
+	}
+	for _, om := range b.modules {
+		if t, ok := om.types[name]; ok {
+			return om, t
+		}
+	}
+	return nil, nil
+}
+
+func (b *smiTreeBuilder) resolveOid(m *smiModule, obj *smiObject) []int {
+	if oid, ok := b.oids[obj]; ok {
+		return oid
+	}
+	if b.resolving[obj] || len(obj.oid) == 0 {
+		return nil
+	}
+	b.resolving[obj] = true
+	defer delete(b.resolving, obj)
+
+	var oid []int
+	first := obj.oid[0]
+	switch {
+	case first.hasNum:
+		oid = []int{first.num}
+	case first.name == "ccitt":
+		oid = []int{0}
+	case first.name == "iso":
+		oid = []int{1}
+	case first.name == "joint-iso-ccitt":
+		oid = []int{2}
+	default:
+		pm, parent := b.lookupObject(m, first.name)
+		if parent != nil {
+			oid = b.resolveOid(pm, parent)
+		}
+		if oid == nil {
+			parts := []string{}
+			for _, c := range obj.oid {
+				if c.hasNum {
+					parts = append(parts, strconv.Itoa(c.num))
+				} else {
+					parts = append(parts, c.name)
+				}
+			}
+			b.errors = append(b.errors, fmt.Sprintf("Unlinked OID in %s: %s ::= { %s }", m.name, obj.label, strings.Join(parts, " ")))
+			b.oids[obj] = nil
+			return nil
+		}
+	}
+	result := append([]int{}, oid...)
+	for _, c := range obj.oid[1:] {
+		if !c.hasNum {
+			b.errors = append(b.errors, fmt.Sprintf("Bad OID component %s for %s in %s (%s)", c.name, obj.label, m.name, m.file))
+			b.oids[obj] = nil
+			return nil
+		}
+		result = append(result, c.num)
+	}
+	b.oids[obj] = result
+	return result
+}
+
+// Resolve a syntax through any textual conventions to a base type.
+// Returns the type, the display hint, enums and the textual convention.
+func (b *smiTreeBuilder) resolveSyntax(m *smiModule, s *smiSyntax) (typ, hint string, enums map[int]string, tcName string, sizes []smiRange) {
+	enums = s.enums
+	sizes = s.sizes
+	base := s.base
+	for i := 0; i < 20; i++ {
+		if t, ok := smiBaseTypes[base]; ok {
+			return t, hint, enums, tcName, sizes
+		}
+		tm, tc := b.lookupType(m, base)
+		if tc == nil || tc.syntax == nil {
+			break
+		}
+		if tcName == "" && tc.syntax.base != "SEQUENCE" && tc.syntax.base != "CHOICE" {
+			tcName = tc.name
+		}
+		if hint == "" {
+			hint = tc.hint
+		}
+		if enums == nil {
+			enums = tc.syntax.enums
+		}
+		if sizes == nil {
+			sizes = tc.syntax.sizes
+		}
+		m = tm
+		base = tc.syntax.base
+	}
+	return "unknown", hint, enums, tcName, sizes
+}
+
+func (b *smiTreeBuilder) checkImports() {
+	errors := []string{}
+	for _, m := range b.modules {
+		for symbol, from := range m.imports {
+			im, ok := b.moduleNames[from]
+			if !ok {
+				continue
+			}
+			if _, ok := smiMacroTypes[symbol]; ok {
+				continue
+			}
+			if _, ok := smiBaseTypes[symbol]; ok {
+				continue
+			}
+			if symbol == "TEXTUAL-CONVENTION" || im.macros[symbol] || im.types[symbol] != nil || im.objectNames[symbol] != nil {
+				continue
+			}
+			errors = append(errors, fmt.Sprintf("Did not find '%s' in module %s (%s)", symbol, from, m.file))
+		}
+		for from, line := range m.importLines {
+			if _, ok := b.moduleNames[from]; !ok {
+				errors = append(errors, fmt.Sprintf("Cannot find module (%s): At line %d in %s", from, line, m.file))
+			}
+		}
+	}
+	sort.Strings(errors)
+	b.errors = append(b.errors, errors...)
+}
+
+// Build the tree of Nodes from parsed modules.
+// Earlier modules take precedence when an OID is defined more than once.
+func buildSMITree(modules []*smiModule) (*Node, []string) {
+	b := &smiTreeBuilder{
+		moduleNames: map[string]*smiModule{},
+		oids:        map[*smiObject][]int{},
+		resolving:   map[*smiObject]bool{},
+	}
+	for _, m := range modules {
+		if _, ok := b.moduleNames[m.name]; ok {
+			continue
+		}
+		b.moduleNames[m.name] = m
+		b.modules = append(b.modules, m)
+	}
+	b.checkImports()
+
+	root := &Node{Oid: "1", Label: "iso", Type: "OTHER", Access: "unknown", EnumValues: map[int]string{}}
+	nodes := map[string]*Node{"1": root}
+	subids := map[*Node]int{}
+	defined := map[*Node]bool{}
+	for _, m := range b.modules {
+		for _, obj := range m.objects {
+			oid := b.resolveOid(m, obj)
+			if len(oid) < 2 || oid[0] != 1 {
+				continue
+			}
+			// Create any missing parents.
+			parent := root
+			for i := 2; i <= len(oid); i++ {
+				parts := make([]string, i)
+				for j, o := range oid[:i] {
+					parts[j] = strconv.Itoa(o)
+				}
+				key := strings.Join(parts, ".")
+				n, ok := nodes[key]
+				if !ok {
+					n = &Node{Oid: key, Type: "OTHER", Access: "unknown", EnumValues: map[int]string{}}
+					nodes[key] = n
+					subids[n] = oid[i-1]
+					parent.Children = append(parent.Children, n)
+				}
+				parent = n
+			}
+			n := parent
+			if defined[n] {
+				continue
+			}
+			defined[n] = true
+			n.Label = obj.label
+			n.Module = m.name
+			n.Status = obj.status
+			n.Description = obj.description
+			n.Units = obj.units
+			n.Augments = obj.augments
+			if obj.indexes != nil {
+				n.Indexes = []string{}
+				for _, index := range obj.indexes {
+					if strings.HasPrefix(index, "IMPLIED ") {
+						n.ImpliedIndex = true
+					}
+					n.Indexes = append(n.Indexes, strings.TrimPrefix(index, "IMPLIED "))
+				}
+			}
+			if access, ok := smiAccessMap[obj.access]; ok {
+				n.Access = access
+			}
+			switch {
+			case obj.macro == "OBJECT-TYPE" && obj.syntax != nil:
+				typ, hint, enums, tcName, sizes := b.resolveSyntax(m, obj.syntax)
+				n.Type = typ
+				n.Hint = hint
+				n.TextualConvention = tcName
+				if len(sizes) == 1 && sizes[0].low == sizes[0].high {
+					n.FixedSize = int(sizes[0].low)
+				}
+				for k, v := range enums {
+					n.EnumValues[k] = v
+				}
+			case smiMacroTypes[obj.macro] != "":
+				n.Type = smiMacroTypes[obj.macro]
+			}
+			switch obj.macro {
+			case "NOTIFICATION-TYPE", "TRAP-TYPE":
+				n.Varbinds = obj.objects
+			case "OBJECT-GROUP", "MODULE-COMPLIANCE":
+				n.Members = obj.objects
+			}
+		}
+	}
+
+	for _, n := range nodes {
+		children := n.Children
+		sort.Slice(children, func(i, j int) bool {
+			return subids[children[i]] < subids[children[j]]
+		})
+	}
+	return root, b.errors
+}
+
+// Parse all the MIB files in the given directories.
+// Returns the tree and the parse errors.
+func loadMIBs(dirs []string, lenient bool) (*Node, []string) {
+	modules := []*smiModule{}
+	errors := []string{}
+	for _, dir := range dirs {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				errors = append(errors, fmt.Sprintf("Cannot read MIB directory %s: %s", dir, err))
+			}
+			continue
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			path := filepath.Join(dir, f.Name())
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("Cannot read MIB file %s: %s", path, err))
+				continue
+			}
+			if !isSMIFile(string(data)) {
+				continue
+			}
+			m, errs := parseSMI(string(data), path, lenient)
+			modules = append(modules, m...)
+			errors = append(errors, errs...)
+		}
+	}
+	builtin, _ := parseSMI(smiBuiltinModules, "builtin", false)
+	modules = append(modules, builtin...)
+
+	// Order modules so that the preferred ones are first, and the rest
+	// are in a stable order. Only the first instance of a module is used.
+	preference := map[string]int{}
+	for i, name := range smiPreferredModules {
+		preference[name] = i - len(smiPreferredModules)
+	}
+	sort.SliceStable(modules, func(i, j int) bool {
+		if preference[modules[i].name] != preference[modules[j].name] {
+			return preference[modules[i].name] < preference[modules[j].name]
+		}
+		return modules[i].name < modules[j].name
+	})
+
+	tree, errs := buildSMITree(modules)
+	return tree, append(errors, errs...)
+}
@@ -0,0 +1,68 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/log"
+)
+
+// Without cgo the MIBs are parsed in Go, rather than by NetSNMP.
+const mibParser = "smi"
+
+// The directories NetSNMP searches by default.
+var defaultMIBDirs = []string{
+	"$HOME/.snmp/mibs",
+	"/usr/share/snmp/mibs",
+	"/usr/share/snmp/mibs/iana",
+	"/usr/share/snmp/mibs/ietf",
+	"/usr/share/mibs/site",
+	"/usr/share/mibs/iana",
+	"/usr/share/mibs/ietf",
+	"/usr/share/mibs/netsnmp",
+	"/usr/local/share/snmp/mibs",
+}
+
+var mibTree *Node
+
+// Get the MIB directories. As with NetSNMP, MIBDIRS overrides the default
+// directories, or adds to them if it starts with a "+".
+func mibDirs() []string {
+	dirs := defaultMIBDirs
+	if env := os.Getenv("MIBDIRS"); env != "" {
+		if strings.HasPrefix(env, "+") {
+			dirs = append(filepath.SplitList(env[1:]), dirs...)
+		} else {
+			dirs = filepath.SplitList(env)
+		}
+	}
+	result := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		result = append(result, os.ExpandEnv(d))
+	}
+	return result
+}
+
+// Parse all the MIBs. Returns MIB parse errors.
+// When lenient, broken definitions keep their OID, so that the objects
+// under them aren't lost.
+func initSNMP(lenient bool) string {
+	dirs := mibDirs()
+	// Help the user find their MIB directories.
+	log.Infof("Loading MIBs from %s", strings.Join(dirs, string(filepath.ListSeparator)))
+	tree, errors := loadMIBs(dirs, lenient)
+	mibTree = tree
+	if len(errors) == 0 {
+		return ""
+	}
+	return strings.Join(errors, "\n") + "\n"
+}
+
+// Get the MIB tree parsed by initSNMP.
+func getMIBTree() *Node {
+	return mibTree
+}
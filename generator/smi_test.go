@@ -0,0 +1,348 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSMIModules = `
+SNMPv2-TC DEFINITIONS ::= BEGIN
+IMPORTS
+    TimeTicks FROM SNMPv2-SMI;
+
+TEXTUAL-CONVENTION MACRO ::=
+BEGIN
+    TYPE NOTATION ::= "DISPLAY-HINT" Text
+    VALUE NOTATION ::= value(VALUE Syntax)
+END
+
+DisplayString ::= TEXTUAL-CONVENTION
+    DISPLAY-HINT "255a"
+    STATUS       current
+    DESCRIPTION  "Text -- not a comment."
+    SYNTAX       OCTET STRING (SIZE (0..255))
+
+TruthValue ::= TEXTUAL-CONVENTION
+    STATUS       current
+    DESCRIPTION  "A boolean."
+    SYNTAX       INTEGER { true(1), false(2) }
+END
+
+TEST-MIB DEFINITIONS ::= BEGIN
+IMPORTS
+    MODULE-IDENTITY, OBJECT-TYPE, NOTIFICATION-TYPE, Counter64, Integer32, enterprises
+        FROM SNMPv2-SMI  -- A comment.
+    DisplayString, TruthValue, Missing
+        FROM SNMPv2-TC
+    OBJECT-GROUP, MODULE-COMPLIANCE FROM SNMPv2-CONF  Other FROM OTHER-MIB;
+
+testMIB MODULE-IDENTITY
+    LAST-UPDATED "201801010000Z"
+    ORGANIZATION "Test"
+    CONTACT-INFO "Test"
+    DESCRIPTION  "The test MIB."
+    REVISION     "201801010000Z"
+    DESCRIPTION  "Initial revision."
+    ::= { enterprises 12345 }
+
+testTable OBJECT-TYPE
+    SYNTAX      SEQUENCE OF TestEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A table."
+    ::= { testMIB 1 }
+
+testEntry OBJECT-TYPE
+    SYNTAX      TestEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A ""row""."
+    INDEX       { testIndex, IMPLIED testName }
+    ::= { testTable 1 }
+
+TestEntry ::= SEQUENCE {
+    testIndex    Integer32,
+    testName     DisplayString,
+    testOctets   Counter64,
+    testStatus   INTEGER
+}
+
+testIndex OBJECT-TYPE
+    SYNTAX      Integer32 (1..2147483647)
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "The index."
+    ::= { testEntry 1 }
+
+testName OBJECT-TYPE
+    SYNTAX      DisplayString (SIZE (0..32))
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "The name."
+    ::= { testEntry 2 }
+
+testOctets OBJECT-TYPE
+    SYNTAX      Counter64
+    UNITS       "octets"
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "The octets."
+    DEFVAL      { 0 }
+    ::= { testEntry 3 }
+
+testEnabled OBJECT-TYPE
+    SYNTAX      TruthValue
+    MAX-ACCESS  read-write
+    STATUS      current
+    DESCRIPTION "Is it enabled."
+    ::= { testEntry 4 }
+
+testStatus OBJECT-TYPE
+    SYNTAX      INTEGER { up(1), down(2) }
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "The status."
+    ::= { iso org(3) dod(6) internet(1) private(4) enterprises(1) 12345 1 1 5 }
+
+testBroken OBJECT-TYPE
+    SYNTAX
+    ::= { testMIB 2 }
+
+testUnlinked OBJECT IDENTIFIER ::= { unknownParent 3 }
+testScalar OBJECT IDENTIFIER ::= { testMIB 3 }
+
+testFixed OBJECT-TYPE
+    SYNTAX      OCTET STRING (SIZE (6))
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "A fixed size string."
+    ::= { testMIB 4 }
+
+testStatusChange NOTIFICATION-TYPE
+    OBJECTS     { testName, testStatus }
+    STATUS      current
+    DESCRIPTION "The status changed."
+    ::= { testMIB 5 }
+
+testGroup OBJECT-GROUP
+    OBJECTS     { testName, testOctets }
+    STATUS      current
+    DESCRIPTION "A group."
+    ::= { testMIB 6 }
+
+testCompliance MODULE-COMPLIANCE
+    STATUS      current
+    DESCRIPTION "A compliance."
+    MODULE  -- this module
+        MANDATORY-GROUPS { testGroup }
+        GROUP       otherGroup
+        DESCRIPTION "Optional."
+        OBJECT      testEnabled
+        MIN-ACCESS  read-only
+        DESCRIPTION "Write access is not required."
+    ::= { testMIB 7 }
+END
+`
+
+func TestLoadMIBs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "TEST-MIB.txt"), []byte(testSMIModules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("Not a MIB."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, errors := loadMIBs([]string{dir, filepath.Join(dir, "missing")}, false)
+	file := filepath.Join(dir, "TEST-MIB.txt")
+	expectedErrors := []string{
+		"Error in TEST-MIB: Bad syntax \"::=\" (" + file + "): At line 102",
+		"Cannot find module (OTHER-MIB): At line 30 in " + file,
+		"Did not find 'Missing' in module SNMPv2-TC (" + file + ")",
+		"Unlinked OID in TEST-MIB: testUnlinked ::= { unknownParent 3 }",
+	}
+	if !reflect.DeepEqual(errors, expectedErrors) {
+		t.Errorf("Wrong errors: want %q, got %q", expectedErrors, errors)
+	}
+
+	nameToNode := map[string]*Node{}
+	walkNode(tree, func(n *Node) {
+		nameToNode[n.Label] = n
+	})
+	empty := map[int]string{}
+	cases := []*Node{
+		{Oid: "1.3.6.1.4.1.12345", Label: "testMIB", Type: "MODID", Access: "unknown", Description: "The test MIB.", Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.1", Label: "testTable", Type: "OTHER", Access: "ACCESS_NOACCESS", Status: "current", Description: "A table.", Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.1.1", Label: "testEntry", Type: "OTHER", Access: "ACCESS_NOACCESS", Status: "current", Description: "A \"row\".",
+			Indexes: []string{"testIndex", "testName"}, ImpliedIndex: true, Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.1.1.1", Label: "testIndex", Type: "INTEGER32", Access: "ACCESS_NOACCESS", Status: "current", Description: "The index.", Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.1.1.2", Label: "testName", Type: "OCTETSTR", Access: "ACCESS_READONLY", Status: "current", Description: "The name.", Hint: "255a",
+			TextualConvention: "DisplayString", Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.1.1.3", Label: "testOctets", Type: "COUNTER64", Access: "ACCESS_READONLY", Status: "current", Description: "The octets.", Units: "octets", Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.1.1.4", Label: "testEnabled", Type: "INTEGER", Access: "ACCESS_READWRITE", Status: "current", Description: "Is it enabled.",
+			TextualConvention: "TruthValue", Module: "TEST-MIB", EnumValues: map[int]string{1: "true", 2: "false"}},
+		{Oid: "1.3.6.1.4.1.12345.1.1.5", Label: "testStatus", Type: "INTEGER", Access: "ACCESS_READONLY", Status: "current", Description: "The status.",
+			Module: "TEST-MIB", EnumValues: map[int]string{1: "up", 2: "down"}},
+		{Oid: "1.3.6.1.4.1.12345.3", Label: "testScalar", Type: "OTHER", Access: "unknown", Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.4", Label: "testFixed", Type: "OCTETSTR", Access: "ACCESS_READONLY", Status: "current", Description: "A fixed size string.",
+			FixedSize: 6, Module: "TEST-MIB", EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.5", Label: "testStatusChange", Type: "NOTIFTYPE", Access: "unknown", Status: "current", Description: "The status changed.",
+			Module: "TEST-MIB", Varbinds: []string{"testName", "testStatus"}, EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.6", Label: "testGroup", Type: "OBJGROUP", Access: "unknown", Status: "current", Description: "A group.",
+			Module: "TEST-MIB", Members: []string{"testName", "testOctets"}, EnumValues: empty},
+		{Oid: "1.3.6.1.4.1.12345.7", Label: "testCompliance", Type: "MODCOMP", Access: "unknown", Status: "current", Description: "A compliance.",
+			Module: "TEST-MIB", Members: []string{"testGroup", "otherGroup"}, EnumValues: empty},
+	}
+	for _, c := range cases {
+		n, ok := nameToNode[c.Label]
+		if !ok {
+			t.Errorf("Node %s not found", c.Label)
+			continue
+		}
+		got := *n
+		got.Children = nil
+		if !reflect.DeepEqual(*c, got) {
+			t.Errorf("Wrong node for %s: want %+v, got %+v", c.Label, *c, got)
+		}
+	}
+	if _, ok := nameToNode["testBroken"]; ok {
+		t.Errorf("Broken node testBroken should not be in the tree")
+	}
+
+	children := []string{}
+	for _, c := range nameToNode["testEntry"].Children {
+		children = append(children, c.Label)
+	}
+	if want := []string{"testIndex", "testName", "testOctets", "testEnabled", "testStatus"}; !reflect.DeepEqual(children, want) {
+		t.Errorf("Wrong children: want %v, got %v", want, children)
+	}
+}
+
+const testLenientModule = `
+VENDOR-MIB DEFINITIONS ::= BEGIN
+IMPORTS
+    OBJECT-TYPE, Integer32, enterprises FROM SNMPv2-SMI;
+
+vendor OBJECT IDENTIFIER ::= { enterprises 99 }
+
+-- Comments run to the end of the line -- when lenient
+
+vendorTable OBJECT-TYPE
+    SYNTAX      INTEGER { ok(one) }
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A table."
+    ::= { vendor 1 }
+
+vendorEntry OBJECT-TYPE
+    SYNTAX      VendorEntry
+    MAX-ACCESS  not-accessible
+    STATUS      current
+    DESCRIPTION "A row."
+    INDEX       { vendorIndex }
+    ::= { vendorTable 1 }
+
+vendorIndex OBJECT-TYPE
+    SYNTAX      Integer32
+    MAX-ACCESS  read-only
+    STATUS      current
+    DESCRIPTION "The index."
+    ::= { vendorEntry 1 }
+END
+`
+
+func TestLoadMIBsLenient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "VENDOR-MIB")
+	if err := ioutil.WriteFile(file, []byte(testLenientModule), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		lenient bool
+		errors  []string
+		labels  []string
+	}{
+		{
+			lenient: false,
+			errors: []string{
+				"Error in VENDOR-MIB: Unexpected \"lenient\" after when (" + file + "): At line 8",
+				"Error in VENDOR-MIB: Bad value for enum ok (" + file + "): At line 11",
+				"Unlinked OID in VENDOR-MIB: vendorEntry ::= { vendorTable 1 }",
+				"Unlinked OID in VENDOR-MIB: vendorIndex ::= { vendorEntry 1 }",
+			},
+			labels: []string{"vendor"},
+		},
+		{
+			lenient: true,
+			errors: []string{
+				"Error in VENDOR-MIB: Bad value for enum ok (" + file + "): At line 11",
+				"Salvaged OID of vendorTable in VENDOR-MIB (" + file + "): At line 10",
+			},
+			labels: []string{"vendor", "vendorTable", "vendorEntry", "vendorIndex"},
+		},
+	}
+	for _, c := range cases {
+		tree, errors := loadMIBs([]string{dir}, c.lenient)
+		if !reflect.DeepEqual(errors, c.errors) {
+			t.Errorf("Wrong errors when lenient=%t: want %q, got %q", c.lenient, c.errors, errors)
+		}
+		labels := []string{}
+		walkNode(tree, func(n *Node) {
+			if strings.HasPrefix(n.Oid, "1.3.6.1.4.1.99") {
+				labels = append(labels, n.Label)
+			}
+		})
+		if !reflect.DeepEqual(labels, c.labels) {
+			t.Errorf("Wrong nodes when lenient=%t: want %v, got %v", c.lenient, c.labels, labels)
+		}
+	}
+}
+
+func TestParseSMITruncated(t *testing.T) {
+	// Definitions cut off at the end of the file are broken, not a crash.
+	for _, data := range []string{
+		"A DEFINITIONS ::= BEGIN\nf",
+		"A DEFINITIONS ::= BEGIN\nfoo OBJECT-TYPE",
+		"A DEFINITIONS ::= BEGIN\nfoo OBJECT-TYPE ::=",
+		"A DEFINITIONS ::= BEGIN\nFoo ::= OCTET STRING (SIZE ('FF",
+		"A DEFINITIONS ::= BEGIN\nFoo ::= INTEGER (0..')",
+	} {
+		for _, lenient := range []bool{false, true} {
+			if _, errors := parseSMI(data, "A", lenient); len(errors) == 0 {
+				t.Errorf("No errors parsing %q when lenient=%t", data, lenient)
+			}
+		}
+	}
+}
+
+func TestParseSMIValue(t *testing.T) {
+	cases := map[string]int64{
+		"42":     42,
+		"-1":     -1,
+		"MAX":    math.MaxInt64,
+		"'FF'H":  255,
+		"'101'B": 5,
+		// Unterminated hex and binary strings.
+		"'FF": 0,
+		"'":   0,
+	}
+	for text, want := range cases {
+		if got := parseSMIValue(text); got != want {
+			t.Errorf("Wrong value for %q: want %d, got %d", text, want, got)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// ModuleStats summarises the size of a generated module, to make it
+// obvious when a walk pulls in far more than intended.
+type ModuleStats struct {
+	Metrics int `json:"metrics"`
+	Walks   int `json:"walks"`
+	Gets    int `json:"gets"`
+	Lookups int `json:"lookups"`
+	// The number of requests a scrape needs if every table has one row.
+	// Each further row of a table adds to this.
+	EstimatedPDUs int `json:"estimated_pdus"`
+}
+
+func countLookups(lookups []*config.Lookup) int {
+	count := len(lookups)
+	for _, l := range lookups {
+		count += countLookups(l.Lookups)
+	}
+	return count
+}
+
+// Estimate how many requests walking a subtree with the given
+// number of objects takes, if each object has one instance.
+func estimateWalkPDUs(objects int, walkParams config.WalkParams) int {
+	if walkParams.Version == 1 || walkParams.MaxRepetitions <= 0 {
+		// One GETNEXT per object, plus one to find the end of the subtree.
+		return objects + 1
+	}
+	return objects/int(walkParams.MaxRepetitions) + 1
+}
+
+func moduleStats(m *config.Module) ModuleStats {
+	stats := ModuleStats{
+		Metrics: len(m.Metrics),
+		Walks:   len(m.Walk),
+		Gets:    len(m.Get),
+	}
+	for _, metric := range m.Metrics {
+		stats.Lookups += countLookups(metric.Lookups)
+	}
+
+	for _, subtree := range m.Walk {
+		objects := 0
+		for _, metric := range m.Metrics {
+			if metric.Oid == subtree || strings.HasPrefix(metric.Oid, subtree+".") {
+				objects++
+			}
+		}
+		stats.EstimatedPDUs += estimateWalkPDUs(objects, m.WalkParams)
+	}
+	// Filter OIDs are walked before the tables they filter.
+	filterOids := map[string]struct{}{}
+	for _, f := range m.Filters.Dynamic {
+		filterOids[f.Oid] = struct{}{}
+	}
+	stats.EstimatedPDUs += len(filterOids) * estimateWalkPDUs(1, m.WalkParams)
+	stats.EstimatedPDUs += (len(m.Get) + gosnmp.MaxOids - 1) / gosnmp.MaxOids
+	return stats
+}
+
+func configStats(c config.Config) map[string]ModuleStats {
+	stats := make(map[string]ModuleStats, len(c.Modules))
+	for name, m := range c.Modules {
+		stats[name] = moduleStats(m)
+	}
+	return stats
+}
+
+// Print the stats of each module as a table.
+func printStats(w io.Writer, stats map[string]ModuleStats) {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tMETRICS\tWALKS\tGETS\tLOOKUPS\tESTIMATED PDUS")
+	for _, name := range names {
+		s := stats[name]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", name, s.Metrics, s.Walks, s.Gets, s.Lookups, s.EstimatedPDUs)
+	}
+	tw.Flush()
+}
+
+func marshalStats(stats map[string]ModuleStats) ([]byte, error) {
+	out, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
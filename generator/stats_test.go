@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestModuleStats(t *testing.T) {
+	cases := []struct {
+		in  *config.Module
+		out ModuleStats
+	}{
+		// Nothing.
+		{
+			in:  &config.Module{},
+			out: ModuleStats{},
+		},
+		// Walks with GETBULK.
+		{
+			in: &config.Module{
+				Walk: []string{"1.1", "1.2"},
+				WalkParams: config.WalkParams{
+					Version:        2,
+					MaxRepetitions: 2,
+				},
+				Metrics: []*config.Metric{
+					{Oid: "1.1.1"},
+					{Oid: "1.1.2"},
+					{Oid: "1.1.3", Lookups: []*config.Lookup{
+						{Oid: "1.2.1", Lookups: []*config.Lookup{{Oid: "1.2.2"}}},
+					}},
+					{Oid: "1.2.1"},
+					{Oid: "1.2.2"},
+					{Oid: "1.20.1"},
+				},
+			},
+			out: ModuleStats{Metrics: 6, Walks: 2, Lookups: 2, EstimatedPDUs: 4},
+		},
+		// Walks with GETNEXT, filters and gets.
+		{
+			in: &config.Module{
+				Walk: []string{"1.1"},
+				Get:  []string{"1.3.0", "1.4.0"},
+				WalkParams: config.WalkParams{
+					Version:        1,
+					MaxRepetitions: 2,
+				},
+				Metrics: []*config.Metric{
+					{Oid: "1.1.1"},
+					{Oid: "1.1.2"},
+					{Oid: "1.3"},
+					{Oid: "1.4"},
+				},
+				Filters: config.Filters{
+					Dynamic: []config.DynamicFilter{
+						{Oid: "1.1.1", Targets: []string{"1.1.2"}},
+						{Oid: "1.1.1", Targets: []string{"1.1.3"}},
+					},
+				},
+			},
+			out: ModuleStats{Metrics: 4, Walks: 1, Gets: 2, EstimatedPDUs: 3 + 2 + 1},
+		},
+	}
+	for i, c := range cases {
+		got := moduleStats(c.in)
+		if !reflect.DeepEqual(got, c.out) {
+			t.Errorf("moduleStats: difference in case %d: want %+v, got %+v", i, c.out, got)
+		}
+	}
+}
+
+func TestPrintStats(t *testing.T) {
+	stats := map[string]ModuleStats{
+		"if_mib": {Metrics: 40, Walks: 2, Lookups: 3, EstimatedPDUs: 4},
+		"apcups": {Metrics: 5, Gets: 1, EstimatedPDUs: 1},
+	}
+	buf := &bytes.Buffer{}
+	printStats(buf, stats)
+	want := `MODULE  METRICS  WALKS  GETS  LOOKUPS  ESTIMATED PDUS
+apcups  5        0      1     0        1
+if_mib  40       2      0     3        4
+`
+	if buf.String() != want {
+		t.Errorf("printStats: want\n%s\ngot\n%s", want, buf.String())
+	}
+
+	out, err := marshalStats(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantJSON := `{
+  "apcups": {
+    "metrics": 5,
+    "walks": 0,
+    "gets": 1,
+    "lookups": 0,
+    "estimated_pdus": 1
+  },
+  "if_mib": {
+    "metrics": 40,
+    "walks": 2,
+    "gets": 0,
+    "lookups": 3,
+    "estimated_pdus": 4
+  }
+}
+`
+	if string(out) != wantJSON {
+		t.Errorf("marshalStats: want\n%s\ngot\n%s", wantJSON, out)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Suggest the names closest to one that can't be found, such as
+// ", did you mean ifHCInOctets?", for use in error messages.
+func didYouMean(name string, nameToNode map[string]*Node) string {
+	lower := strings.ToLower(name)
+	// Allow roughly one typo per three characters.
+	maxDistance := len(name) / 3
+	bestDistance := maxDistance + 1
+	best := []string{}
+	for label := range nameToNode {
+		if label == "" || label[0] >= '0' && label[0] <= '9' {
+			continue // OIDs.
+		}
+		d := editDistance(lower, strings.ToLower(label))
+		switch {
+		case d < bestDistance:
+			bestDistance = d
+			best = []string{label}
+		case d == bestDistance && d <= maxDistance:
+			best = append(best, label)
+		}
+	}
+	if len(best) == 0 {
+		return ""
+	}
+	sort.Strings(best)
+	if len(best) > 3 {
+		best = best[:3]
+	}
+	return fmt.Sprintf(", did you mean %s?", strings.Join(best, " or "))
+}
+
+// The Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cur[j] = prev[j-1]
+			if a[i-1] != b[j-1] {
+				cur[j]++
+			}
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
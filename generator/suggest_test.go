@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDidYouMean(t *testing.T) {
+	nameToNode := map[string]*Node{}
+	for _, name := range []string{"1.3.6.1.2.1.31.1.1.1.6", "ifHCInOctets", "ifHCOutOctets", "ifInOctets", "ifName", "ifAlias", "sysName"} {
+		nameToNode[name] = &Node{}
+	}
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{in: "ifHCInOctet", out: ", did you mean ifHCInOctets?"},
+		{in: "ifhcinoctets", out: ", did you mean ifHCInOctets?"},
+		{in: "ifNme", out: ", did you mean ifName?"},
+		{in: "ifHCOctets", out: ", did you mean ifHCInOctets or ifInOctets?"},
+		{in: "entPhysicalName", out: ""},
+		{in: "1.3.6.1.2.1.31.1.1.1.7", out: ""},
+	}
+	for _, c := range cases {
+		if got := didYouMean(c.in, nameToNode); got != c.out {
+			t.Errorf("Wrong suggestion for %s: want %q, got %q", c.in, c.out, got)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/prometheus/common/log"
@@ -10,6 +13,36 @@ import (
 	"github.com/prometheus/snmp_exporter/config"
 )
 
+// One entry in the tree of the MIB.
+type Node struct {
+	Oid               string
+	Label             string
+	Augments          string
+	Children          []*Node
+	Description       string
+	Type              string
+	Hint              string
+	TextualConvention string
+	Units             string
+	Access            string
+	Status            string
+	EnumValues        map[int]string
+	// The MIB module the object is defined in.
+	Module string
+	// The size of a fixed length OCTET STRING, such as SIZE (6).
+	FixedSize int
+
+	Indexes []string
+	// The last index is IMPLIED, so has no length in the OID.
+	ImpliedIndex bool
+	// The objects sent with a notification.
+	Varbinds []string
+	// The objects of an OBJECT-GROUP, or the groups of a MODULE-COMPLIANCE.
+	Members []string
+	// What to multiply the value by, for a DISPLAY-HINT such as d-2.
+	Scale float64
+}
+
 // Helper to walk MIB nodes.
 func walkNode(n *Node, f func(n *Node)) {
 	f(n)
@@ -27,10 +60,9 @@ func prepareTree(nodes *Node) map[string]*Node {
 		nameToNode[n.Label] = n
 	})
 
-	// Trim down description to first sentance, removing extra whitespace.
+	// Remove extra whitespace from descriptions.
 	walkNode(nodes, func(n *Node) {
-		s := strings.Join(strings.Fields(n.Description), " ")
-		n.Description = strings.Split(s, ". ")[0]
+		n.Description = strings.Join(strings.Fields(n.Description), " ")
 	})
 
 	// Fix indexes to "INTEGER" rather than an object name.
@@ -60,8 +92,10 @@ func prepareTree(nodes *Node) map[string]*Node {
 		}
 		for _, c := range n.Children {
 			c.Indexes = augmented.Indexes
+			c.ImpliedIndex = augmented.ImpliedIndex
 		}
 		n.Indexes = augmented.Indexes
+		n.ImpliedIndex = augmented.ImpliedIndex
 	})
 
 	// Copy indexes from table entries down to the entries.
@@ -69,11 +103,12 @@ func prepareTree(nodes *Node) map[string]*Node {
 		if len(n.Indexes) != 0 {
 			for _, c := range n.Children {
 				c.Indexes = n.Indexes
+				c.ImpliedIndex = n.ImpliedIndex
 			}
 		}
 	})
 
-	// Set type on MAC addresses and ASCII strings.
+	// Set type on MAC addresses, ASCII strings, dates, floats and IP addresses.
 	walkNode(nodes, func(n *Node) {
 		// RFC 2579
 		switch n.Hint {
@@ -81,6 +116,36 @@ func prepareTree(nodes *Node) map[string]*Node {
 			n.Type = "PhysAddress48"
 		case "255a":
 			n.Type = "DisplayString"
+		case "2d-1d-1d,1d:1d:1d.1d,1a1d:1d":
+			n.Type = "DateAndTime"
+		}
+		// Integers with implied decimal places, such as d-2 for hundredths.
+		if m := decimalHintRE.FindStringSubmatch(n.Hint); m != nil {
+			places, _ := strconv.Atoi(m[1])
+			if places > 0 {
+				n.Scale = math.Pow10(-places)
+			}
+		}
+		// BITS with named bits.
+		if n.Type == "BITSTRING" && len(n.EnumValues) > 0 {
+			n.Type = "Bits"
+		}
+		switch n.TextualConvention {
+		case "Float", "Double":
+			// Opaque wrapped floating point, from UCD-SNMP-MIB and others.
+			if n.Type == "OPAQUE" {
+				n.Type = n.TextualConvention
+			}
+		case "InetAddress", "InetAddressIPv4", "InetAddressIPv6":
+			// RFC 4001
+			if n.Type == "OCTETSTR" {
+				n.Type = n.TextualConvention
+			}
+		case "TruthValue":
+			// RFC 2579
+			if n.Type == "INTEGER" {
+				n.Type = "Bool"
+			}
 		}
 	})
 
@@ -100,7 +165,9 @@ func metricType(t string) (string, bool) {
 	case "NETADDR":
 		// TODO: Not sure about this one.
 		return "InetAddress", true
-	case "PhysAddress48", "DisplayString":
+	case "InetAddressIPv4":
+		return "IpAddr", true
+	case "PhysAddress48", "DisplayString", "DateAndTime", "Float", "Double", "InetAddress", "InetAddressIPv6", "Bits", "Bool":
 		return t, true
 	default:
 		// Unsupported type.
@@ -108,10 +175,37 @@ func metricType(t string) (string, bool) {
 	}
 }
 
-func metricAccess(a string) bool {
+// Types that a metric can be forced to with an override.
+func validOverrideType(t string) bool {
+	switch t {
+	case "gauge", "counter", "OctetString", "DisplayString", "PhysAddress48", "IpAddr", "InetAddress", "InetAddressIPv6", "EnumAsInfo", "EnumAsStateSet", "Bits", "DateAndTime", "Float", "Double", "Bool", "UptimeTimestamp":
+		return true
+	default:
+		return false
+	}
+}
+
+// Types that an index can be forced to with an override.
+func validIndexType(t string) bool {
+	switch t {
+	case "gauge", "counter", "OctetString", "DisplayString", "PhysAddress48", "IpAddr", "InetAddress", "InetAddressIPv6":
+		return true
+	default:
+		return false
+	}
+}
+
+// Whether objects with an access are metrics under an access policy.
+// The default policy also includes not-accessible objects, as they're
+// often index columns that agents return anyway.
+func metricAccess(a, policy string) bool {
 	switch a {
-	case "ACCESS_READONLY", "ACCESS_READWRITE", "ACCESS_CREATE", "ACCESS_NOACCESS":
+	case "ACCESS_READONLY", "ACCESS_READWRITE", "ACCESS_CREATE":
 		return true
+	case "ACCESS_NOACCESS":
+		return policy == "" || policy == "include_not_accessible" || policy == "all"
+	case "ACCESS_WRITEONLY":
+		return policy == "all"
 	default:
 		// the others are inaccessible metrics.
 		return false
@@ -132,81 +226,369 @@ func minimizeOids(oids []string) []string {
 	return minimized
 }
 
-func generateConfigModule(cfg *ModuleConfig, node *Node, nameToNode map[string]*Node) *config.Module {
-	out := &config.Module{}
+// The OIDs to walk to get all the objects of a MIB module, such as IF-MIB.
+// Columns are walked by their table, and scalars by the group holding them
+// if nothing in the group is from another module.
+func mibModuleOids(root *Node, module string, nameToNode map[string]*Node) []string {
+	oids := []string{}
+	walkNode(root, func(n *Node) {
+		if _, ok := metricType(n.Type); !ok || n.Module != module || !metricAccess(n.Access, "") {
+			return
+		}
+		p := parentNode(n, nameToNode)
+		switch {
+		case p == nil:
+			oids = append(oids, n.Oid)
+		case len(p.Indexes) > 0 || p.Augments != "":
+			if table := parentNode(p, nameToNode); table != nil {
+				oids = append(oids, table.Oid)
+			} else {
+				oids = append(oids, p.Oid)
+			}
+		default:
+			oid := p.Oid
+			for _, c := range p.Children {
+				if c.Module != module {
+					oid = n.Oid
+				}
+			}
+			oids = append(oids, oid)
+		}
+	})
+	return minimizeOids(oids)
+}
+
+// The OIDs to walk to get the objects of an OBJECT-GROUP, or of the groups
+// of a MODULE-COMPLIANCE. Columns are walked by their table if all of the
+// table's columns are in the group.
+func groupOids(group *Node, nameToNode map[string]*Node) []string {
+	objects := map[string]*Node{}
+	seen := map[*Node]bool{}
+	var addGroup func(g *Node)
+	addGroup = func(g *Node) {
+		if seen[g] {
+			return
+		}
+		seen[g] = true
+		for _, member := range g.Members {
+			n, ok := nameToNode[member]
+			if !ok {
+				log.Warnf("Can't find %s in group %s", member, g.Label)
+				continue
+			}
+			if n.Type == "OBJGROUP" || n.Type == "MODCOMP" {
+				addGroup(n)
+				continue
+			}
+			objects[n.Oid] = n
+		}
+	}
+	addGroup(group)
+
+	oids := []string{}
+	for oid, n := range objects {
+		entry := parentNode(n, nameToNode)
+		if entry == nil || len(entry.Indexes) == 0 {
+			oids = append(oids, oid)
+			continue
+		}
+		table := parentNode(entry, nameToNode)
+		complete := table != nil
+		for _, c := range entry.Children {
+			if _, ok := objects[c.Oid]; !ok && c.Access != "ACCESS_NOACCESS" {
+				complete = false
+			}
+		}
+		if complete {
+			oids = append(oids, table.Oid)
+		} else {
+			oids = append(oids, oid)
+		}
+	}
+	return minimizeOids(oids)
+}
+
+// The node one level up the tree.
+func parentNode(n *Node, nameToNode map[string]*Node) *Node {
+	i := strings.LastIndex(n.Oid, ".")
+	if i == -1 {
+		return nil
+	}
+	return nameToNode[n.Oid[:i]]
+}
+
+// Split the walk of a subtree into walks of its children,
+// leaving out those only containing ignored metrics.
+func pruneWalk(n *Node, ignored map[string]struct{}) []string {
+	if _, ok := ignored[n.Oid]; ok {
+		return []string{}
+	}
+	hasIgnored := false
+	walkNode(n, func(c *Node) {
+		if _, ok := ignored[c.Oid]; ok {
+			hasIgnored = true
+		}
+	})
+	if !hasIgnored {
+		return []string{n.Oid}
+	}
+	oids := []string{}
+	for _, c := range n.Children {
+		oids = append(oids, pruneWalk(c, ignored)...)
+	}
+	return oids
+}
+
+func generateConfigModule(cfg *ModuleConfig, node *Node, nameToNode map[string]*Node) (*config.Module, error) {
+	// The version, auth and other walk parameters are copied as-is.
+	out := &config.Module{WalkParams: cfg.WalkParams, StaticLabels: cfg.StaticLabels, RelabelConfigs: cfg.RelabelConfigs, MaxValueLength: cfg.MaxValueLength, MissingObjects: cfg.MissingObjects, MaxSeries: cfg.MaxSeries, PartialResults: cfg.PartialResults, WalkBudget: cfg.WalkBudget, AllowNonIncreasingOids: cfg.AllowNonIncreasingOids, ScrapeCacheTTL: cfg.ScrapeCacheTTL, DetectReboots: cfg.DetectReboots, InvalidUTF8: cfg.InvalidUTF8, MACAddressFormat: cfg.MACAddressFormat, MACAddressLowercase: cfg.MACAddressLowercase}
 	needToWalk := map[string]struct{}{}
 
 	// Remove redundant OIDs to be walked.
 	toWalk := []string{}
 	for _, oid := range cfg.Walk {
+		if n, ok := nameToNode[oid]; ok {
+			if n.Type != "OBJGROUP" && n.Type != "MODCOMP" {
+				toWalk = append(toWalk, n.Oid)
+				continue
+			}
+			groupOids := groupOids(n, nameToNode)
+			if len(groupOids) == 0 {
+				return nil, fmt.Errorf("Cannot find any objects in group '%s' to walk. NetSNMP doesn't keep the groups of a MODULE-COMPLIANCE, build the generator with CGO_ENABLED=0 to use them", oid)
+			}
+			toWalk = append(toWalk, groupOids...)
+			continue
+		}
+		// Not an object, so try it as a MIB module.
+		moduleOids := mibModuleOids(node, oid, nameToNode)
+		if len(moduleOids) == 0 {
+			return nil, fmt.Errorf("Cannot find oid '%s' to walk%s", oid, didYouMean(oid, nameToNode))
+		}
+		toWalk = append(toWalk, moduleOids...)
+	}
+	toWalk = minimizeOids(toWalk)
+
+	// Excluded subtrees are neither walked nor have metrics.
+	ignored := map[string]struct{}{}
+	excluded := []string{}
+	for _, oid := range cfg.Exclude {
 		node, ok := nameToNode[oid]
 		if !ok {
-			log.Fatalf("Cannot find oid '%s' to walk", oid)
+			return nil, fmt.Errorf("Cannot find oid '%s' to exclude%s", oid, didYouMean(oid, nameToNode))
 		}
-		toWalk = append(toWalk, node.Oid)
+		ignored[node.Oid] = struct{}{}
+		excluded = append(excluded, node.Oid+".")
+	}
+
+	for name, params := range cfg.Overrides {
+		if params.Type != "" && !validOverrideType(params.Type) {
+			return nil, fmt.Errorf("Invalid type '%s' in override for '%s'", params.Type, name)
+		}
+	}
+	// Type overrides also apply where the object is used as an index or lookup.
+	overrideType := func(n *Node) string {
+		if t := cfg.Overrides[sanitizeLabelName(n.Label)].Type; t != "" {
+			return t
+		}
+		return cfg.Overrides[n.Oid].Type
+	}
+	// As are replacements of label values.
+	overrideReplacements := func(n *Node) []config.RegexpReplacement {
+		if r := cfg.Overrides[sanitizeLabelName(n.Label)].RegexpReplacements; len(r) > 0 {
+			return r
+		}
+		return cfg.Overrides[n.Oid].RegexpReplacements
+	}
+	overrideEncoding := func(n *Node, typ string) string {
+		if typ != "OctetString" && typ != "DisplayString" {
+			return ""
+		}
+		if e := cfg.Overrides[sanitizeLabelName(n.Label)].Encoding; e != "" {
+			return e
+		}
+		return cfg.Overrides[n.Oid].Encoding
 	}
-	toWalk = minimizeOids(toWalk)
 
 	// Find all the usable metrics.
-	for _, oid := range toWalk {
-		node := nameToNode[oid]
-		needToWalk[node.Oid] = struct{}{}
-		walkNode(node, func(n *Node) {
-			t, ok := metricType(n.Type)
-			if !ok {
-				return // Unsupported type.
+	addMetrics := func(n *Node) {
+		for _, prefix := range excluded {
+			if strings.HasPrefix(n.Oid+".", prefix) {
+				return // Excluded subtree.
 			}
+		}
+		t, ok := metricType(n.Type)
+		if !ok {
+			return // Unsupported type.
+		}
 
-			if !metricAccess(n.Access) {
-				return // Inaccessible metrics.
-			}
+		if !metricAccess(n.Access, cfg.Access) {
+			return // Inaccessible metrics.
+		}
+		if cfg.SkipDeprecated != nil && *cfg.SkipDeprecated && (n.Status == "deprecated" || n.Status == "obsolete") {
+			ignored[n.Oid] = struct{}{}
+			return // No longer implemented by most devices.
+		}
 
-			metric := &config.Metric{
-				Name:    sanitizeLabelName(n.Label),
-				Oid:     n.Oid,
-				Type:    t,
-				Help:    n.Description + " - " + n.Oid,
-				Indexes: []*config.Index{},
-				Lookups: []*config.Lookup{},
+		metric := &config.Metric{
+			Name:    sanitizeLabelName(n.Label),
+			Oid:     n.Oid,
+			Type:    t,
+			Help:    metricHelp(n, cfg),
+			Indexes: []*config.Index{},
+			Lookups: []*config.Lookup{},
+		}
+		if t == "Bits" {
+			metric.EnumValues = n.EnumValues
+		}
+		if t == "gauge" || t == "counter" {
+			metric.Scale = n.Scale
+			if timeTicksSeconds(n, cfg) {
+				metric.Scale = 0.01
+			}
+		}
+		if cfg.Overrides[metric.Name].Ignore || cfg.Overrides[metric.Oid].Ignore {
+			ignored[n.Oid] = struct{}{}
+			return // Ignored metric.
+		}
+		for count, i := range n.Indexes {
+			index := &config.Index{Labelname: sanitizeLabelName(i)}
+			indexNode, ok := nameToNode[i]
+			if !ok {
+				log.Warnf("Error, can't find index %s for node %s%s", i, n.Label, didYouMean(i, nameToNode))
+				return
+			}
+			index.Type, ok = metricType(indexNode.Type)
+			if !ok {
+				log.Warnf("Error, can't handle index type %s for node %s", indexNode.Type, n.Label)
+				return
 			}
-			for _, i := range n.Indexes {
-				index := &config.Index{Labelname: i}
-				indexNode, ok := nameToNode[i]
-				if !ok {
-					log.Warnf("Error, can't find index %s for node %s", i, n.Label)
-					return
+			// Per RFC 4001, an InetAddress index is preceded by its InetAddressType.
+			switch {
+			case index.Type == "Bits":
+				index.Type = "OctetString"
+			case index.Type == "Bool":
+				index.Type = "gauge"
+			case indexNode.TextualConvention == "InetAddressType":
+				index.Type = "InetAddressType"
+			case indexNode.Type == "InetAddress":
+				if len(metric.Indexes) == 0 || metric.Indexes[len(metric.Indexes)-1].Type != "InetAddressType" {
+					index.Type = "OctetString"
 				}
-				index.Type, ok = metricType(indexNode.Type)
-				if !ok {
-					log.Warnf("Error, can't handle index type %s for node %s", indexNode.Type, n.Label)
-					return
+			}
+			// Overrides such as EnumAsInfo only make sense for the metric.
+			if t := overrideType(indexNode); validIndexType(t) {
+				index.Type = t
+			}
+			index.RegexpReplacements = overrideReplacements(indexNode)
+			index.Encoding = overrideEncoding(indexNode, index.Type)
+			// The exporter renders other strings by their DISPLAY-HINT.
+			if index.Type == "OctetString" && index.Encoding == "" {
+				index.DisplayHint = indexNode.Hint
+			}
+			// Only variable length indexes have a length to omit.
+			switch index.Type {
+			case "OctetString", "DisplayString":
+				index.FixedSize = indexNode.FixedSize
+			}
+			if n.ImpliedIndex && count == len(n.Indexes)-1 && index.FixedSize == 0 {
+				switch index.Type {
+				case "OctetString", "DisplayString", "InetAddress":
+					index.Implied = true
 				}
-				metric.Indexes = append(metric.Indexes, index)
 			}
-			out.Metrics = append(out.Metrics, metric)
-		})
+			metric.Indexes = append(metric.Indexes, index)
+		}
+		out.Metrics = append(out.Metrics, metric)
+	}
+	for _, oid := range toWalk {
+		node := nameToNode[oid]
+		walkNode(node, addMetrics)
+		// Avoid walking the parts of the subtree that are ignored.
+		for _, o := range pruneWalk(node, ignored) {
+			needToWalk[o] = struct{}{}
+		}
+	}
+
+	// Scalars to get, rather than walk.
+	toGet := map[string]struct{}{}
+	for _, name := range cfg.Get {
+		node, instance := getInstance(name, nameToNode)
+		if node == nil {
+			return nil, fmt.Errorf("Cannot find oid '%s' to get%s", name, didYouMean(name, nameToNode))
+		}
+		if len(node.Children) > 0 || (instance == node.Oid+".0" && len(node.Indexes) > 0) {
+			return nil, fmt.Errorf("Cannot get '%s', only object instances can be fetched with get", name)
+		}
+		if _, ok := toGet[instance]; ok {
+			continue
+		}
+		toGet[instance] = struct{}{}
+		walked := false
+		for _, oid := range toWalk {
+			if strings.HasPrefix(instance, oid+".") {
+				walked = true
+			}
+		}
+		if walked {
+			continue // Already fetched by a walk.
+		}
+		addMetrics(node)
+		if _, ok := ignored[node.Oid]; ok {
+			continue
+		}
+		out.Get = append(out.Get, instance)
 	}
 
 	// Apply lookups.
 	for _, lookup := range cfg.Lookups {
+		indexNode, ok := nameToNode[lookup.NewIndex]
+		if !ok {
+			return nil, fmt.Errorf("Unknown index '%s'%s", lookup.NewIndex, didYouMean(lookup.NewIndex, nameToNode))
+		}
+		typ, ok := metricType(indexNode.Type)
+		if t := overrideType(indexNode); t != "" {
+			if !validIndexType(t) {
+				return nil, fmt.Errorf("Invalid type '%s' in override for '%s', which is looked up as an index", t, lookup.NewIndex)
+			}
+			typ, ok = t, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("Unknown index type %s for %s", indexNode.Type, lookup.NewIndex)
+		}
+		oldIndex := sanitizeLabelName(lookup.OldIndex)
 		for _, metric := range out.Metrics {
+			if previous := findLookup(metric.Lookups, oldIndex); previous != nil {
+				// Chain onto the result of a previous lookup.
+				previous.Lookups = append(previous.Lookups, &config.Lookup{
+					Labelname:          sanitizeLabelName(indexNode.Label),
+					Type:               typ,
+					Oid:                indexNode.Oid,
+					RegexpReplacements: overrideReplacements(indexNode),
+					Encoding:           overrideEncoding(indexNode, typ),
+					StripPrefix:        lookup.StripPrefix,
+					IndexOffset:        lookup.IndexOffset,
+					CacheTTL:           lookup.CacheTTL,
+				})
+				needToWalk[indexNode.Oid] = struct{}{}
+				continue
+			}
 			for _, index := range metric.Indexes {
-				if index.Labelname == lookup.OldIndex {
-					if _, ok := nameToNode[lookup.NewIndex]; !ok {
-						log.Fatalf("Unknown index '%s'", lookup.NewIndex)
-					}
-					indexNode := nameToNode[lookup.NewIndex]
-					// Avoid leaving the old labelname around.
-					index.Labelname = sanitizeLabelName(indexNode.Label)
-					typ, ok := metricType(indexNode.Type)
-					if !ok {
-						log.Fatalf("Unknown index type %s for %s", indexNode.Type, lookup.NewIndex)
+				if index.Labelname == oldIndex {
+					if !lookup.KeepSourceIndexes {
+						// Avoid leaving the old labelname around.
+						index.Labelname = sanitizeLabelName(indexNode.Label)
+						renameLookupLabels(metric.Lookups, oldIndex, index.Labelname)
 					}
 					metric.Lookups = append(metric.Lookups, &config.Lookup{
-						Labels:    []string{sanitizeLabelName(indexNode.Label)},
-						Labelname: sanitizeLabelName(indexNode.Label),
-						Type:      typ,
-						Oid:       indexNode.Oid,
+						Labels:             []string{index.Labelname},
+						Labelname:          sanitizeLabelName(indexNode.Label),
+						Type:               typ,
+						Oid:                indexNode.Oid,
+						RegexpReplacements: overrideReplacements(indexNode),
+						Encoding:           overrideEncoding(indexNode, typ),
+						StripPrefix:        lookup.StripPrefix,
+						IndexOffset:        lookup.IndexOffset,
+						CacheTTL:           lookup.CacheTTL,
 					})
 					// Make sure we walk the lookup OID
 					needToWalk[indexNode.Oid] = struct{}{}
@@ -215,28 +597,435 @@ func generateConfigModule(cfg *ModuleConfig, node *Node, nameToNode map[string]*
 		}
 	}
 
+	// Join indexes, on every metric that has all of them.
+	for _, join := range cfg.JoinIndexes {
+		labels := []string{}
+		for _, index := range join.Indexes {
+			labels = append(labels, sanitizeLabelName(index))
+		}
+		joined := 0
+	MetricLoop:
+		for _, metric := range out.Metrics {
+			for _, label := range labels {
+				if !hasIndex(metric.Indexes, label) {
+					continue MetricLoop
+				}
+			}
+			metric.JoinIndexes = append(metric.JoinIndexes, &config.JoinIndexes{
+				Labels:    labels,
+				Labelname: sanitizeLabelName(join.Labelname),
+				Separator: join.Separator,
+			})
+			joined++
+		}
+		if joined == 0 {
+			log.Warnf("No metrics have all of the indexes %s to join", strings.Join(join.Indexes, ", "))
+		}
+	}
+
 	// Apply module config overrides to their corresponding metrics.
 	for name, params := range cfg.Overrides {
 		for _, metric := range out.Metrics {
 			if name == metric.Name || name == metric.Oid {
 				metric.RegexpExtracts = params.RegexpExtracts
+				metric.StaticLabels = params.StaticLabels
+				metric.DropLabels = params.DropLabels
+				metric.RenameLabels = params.RenameLabels
+				metric.KeepIfLabelMatches = params.KeepIfLabelMatches
+				metric.DropIfLabelMatches = params.DropIfLabelMatches
+				metric.MaxValueLength = params.MaxValueLength
+				metric.MissingObjects = params.MissingObjects
+				metric.MaxSeries = params.MaxSeries
+				if params.EnumRegexExtracts != "" {
+					enumValues := nameToNode[metric.Oid].EnumValues
+					if len(enumValues) == 0 {
+						log.Warnf("Metric %s has enum_regex_extracts, but no enum values in the MIB", metric.Name)
+					} else {
+						extracts := enumRegexExtracts(params.EnumRegexExtracts, enumValues)
+						// Hand written extracts take precedence.
+						for name, e := range params.RegexpExtracts {
+							extracts[name] = e
+						}
+						metric.RegexpExtracts = extracts
+						// Regex extracts only apply to string types.
+						metric.Type = "DisplayString"
+					}
+				}
+				if params.Type != "" {
+					metric.Type = params.Type
+				}
+				if params.Help != "" {
+					metric.Help = params.Help
+				}
+				if params.Scale != 0 {
+					metric.Scale = params.Scale
+				}
+				metric.Offset = params.Offset
+				metric.Counter64 = params.Counter64
+				if metric.Type == "OctetString" || metric.Type == "DisplayString" {
+					metric.Encoding = params.Encoding
+				}
+				switch metric.Type {
+				case "gauge", "counter", "Float", "Double":
+					metric.IgnoreValues = params.IgnoreValues
+					metric.Min = params.Min
+					metric.Max = params.Max
+				}
+				if metric.Type != "gauge" && metric.Type != "counter" {
+					metric.Scale = 0
+					metric.Offset = 0
+					metric.Counter64 = ""
+				}
+				switch metric.Type {
+				case "EnumAsInfo", "EnumAsStateSet", "Bits":
+					metric.EnumValues = nameToNode[metric.Oid].EnumValues
+					if len(metric.EnumValues) == 0 {
+						log.Warnf("Metric %s has type %s, but no enum values in the MIB", metric.Name, metric.Type)
+					}
+				default:
+					metric.EnumValues = nil
+				}
+			}
+		}
+	}
+
+	renameCollisions(out.Metrics, nameToNode)
+
+	// Resolve the names in the filters.
+	for _, filter := range cfg.Filters.Static {
+		staticFilter := config.StaticFilter{Indices: filter.Indices}
+		for _, target := range filter.Targets {
+			targetNode, ok := nameToNode[target]
+			if !ok {
+				return nil, fmt.Errorf("Cannot find filter target '%s'%s", target, didYouMean(target, nameToNode))
+			}
+			staticFilter.Targets = append(staticFilter.Targets, targetNode.Oid)
+		}
+		out.Filters.Static = append(out.Filters.Static, staticFilter)
+	}
+	for _, filter := range cfg.Filters.Dynamic {
+		filterNode, ok := nameToNode[filter.Oid]
+		if !ok {
+			return nil, fmt.Errorf("Cannot find oid '%s' to filter on%s", filter.Oid, didYouMean(filter.Oid, nameToNode))
+		}
+		dynamicFilter := config.DynamicFilter{
+			Oid:    filterNode.Oid,
+			Values: filter.Values,
+		}
+		for _, target := range filter.Targets {
+			targetNode, ok := nameToNode[target]
+			if !ok {
+				return nil, fmt.Errorf("Cannot find filter target '%s'%s", target, didYouMean(target, nameToNode))
 			}
+			dynamicFilter.Targets = append(dynamicFilter.Targets, targetNode.Oid)
+		}
+		out.Filters.Dynamic = append(out.Filters.Dynamic, dynamicFilter)
+	}
+
+	// Add units to names, now that overrides have been matched.
+	for _, metric := range out.Metrics {
+		if metric.Type != "gauge" && metric.Type != "counter" {
+			continue
+		}
+		suffix := ""
+		if timeTicksSeconds(nameToNode[metric.Oid], cfg) {
+			suffix = "seconds"
+		} else if cfg.UnitSuffixes {
+			suffix = unitSuffix(nameToNode[metric.Oid].Units)
+		}
+		if suffix != "" && !strings.HasSuffix(strings.ToLower(metric.Name), suffix) {
+			metric.Name += "_" + suffix
 		}
 	}
 
+	// Prefix names last, so that overrides match the names from the MIB.
+	for _, metric := range out.Metrics {
+		prefix := cfg.Prefix
+		if cfg.NamespaceByMIB {
+			prefix += mibNamespace(nameToNode[metric.Oid].Module)
+		}
+		metric.Name = prefix + metric.Name
+	}
+
 	oids := []string{}
 	for k, _ := range needToWalk {
 		oids = append(oids, k)
 	}
 	// Remove redundant OIDs to be walked.
 	out.Walk = minimizeOids(oids)
-	return out
+
+	// Lookups may have added walks that cover what was to be got.
+	var get []string
+	for _, instance := range out.Get {
+		walked := false
+		for _, oid := range out.Walk {
+			if strings.HasPrefix(instance, oid+".") {
+				walked = true
+			}
+		}
+		if !walked {
+			get = append(get, instance)
+		}
+	}
+	out.Get = get
+
+	// Subtrees of only scalars are fetched with GET of their instances,
+	// rather than walked. SNMP v1 fails the whole GET if any of them is
+	// missing, so there they're still walked. Modules without a version
+	// use the exporter's default of v2.
+	if cfg.WalkParams.Version != 1 {
+		metricOids := map[string]struct{}{}
+		for _, metric := range out.Metrics {
+			metricOids[metric.Oid] = struct{}{}
+		}
+		var walk []string
+		for _, oid := range out.Walk {
+			if instances := scalarInstances(nameToNode[oid], metricOids); len(instances) > 0 {
+				out.Get = append(out.Get, instances...)
+			} else {
+				walk = append(walk, oid)
+			}
+		}
+		out.Walk = walk
+	}
+
+	// Walk filter targets on their own, so only the allowed rows need to be fetched.
+	for _, filter := range out.Filters.Static {
+		for _, target := range filter.Targets {
+			out.Walk = splitWalk(out.Walk, target, nameToNode)
+		}
+	}
+	for _, filter := range out.Filters.Dynamic {
+		for _, target := range filter.Targets {
+			out.Walk = splitWalk(out.Walk, target, nameToNode)
+		}
+	}
+	notifications, err := generateNotifications(cfg, node, nameToNode)
+	if err != nil {
+		return nil, err
+	}
+	out.Notifications = notifications
+	return out, nil
+}
+
+// Rename metrics whose names collide after sanitization, such as foo-bar
+// and foo_bar, by suffixing the OID. A metric whose MIB name is unchanged
+// by sanitization keeps it, otherwise the first one does.
+func renameCollisions(metrics []*config.Metric, nameToNode map[string]*Node) {
+	byName := map[string][]*config.Metric{}
+	names := []string{}
+	for _, m := range metrics {
+		if _, ok := byName[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		byName[m.Name] = append(byName[m.Name], m)
+	}
+	for _, name := range names {
+		colliding := byName[name]
+		if len(colliding) < 2 {
+			continue
+		}
+		keep := 0
+		for i, m := range colliding {
+			if nameToNode[m.Oid].Label == name {
+				keep = i
+				break
+			}
+		}
+		for i, m := range colliding {
+			if i == keep {
+				continue
+			}
+			m.Name = name + "_" + strings.Replace(m.Oid, ".", "_", -1)
+			log.Warnf("Metric name %s for %s (%s) collides with %s (%s), renaming it to %s. Use ignore in an override to drop it instead",
+				name, nameToNode[m.Oid].Label, m.Oid, nameToNode[colliding[keep].Oid].Label, colliding[keep].Oid, m.Name)
+		}
+	}
+}
+
+// Find the node and OID instance for an entry in get.
+// Scalars may be given without their .0 instance.
+func getInstance(name string, nameToNode map[string]*Node) (*Node, string) {
+	if n, ok := nameToNode[name]; ok {
+		return n, n.Oid + ".0"
+	}
+	// An object name or OID, followed by the instance.
+	parts := strings.Split(name, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		if n, ok := nameToNode[strings.Join(parts[:i], ".")]; ok {
+			return n, n.Oid + "." + strings.Join(parts[i:], ".")
+		}
+	}
+	return nil, ""
+}
+
+// The instances of the metrics in a subtree, if it has no tables.
+func scalarInstances(n *Node, metricOids map[string]struct{}) []string {
+	if n == nil {
+		return nil
+	}
+	instances := []string{}
+	scalars := true
+	walkNode(n, func(c *Node) {
+		if len(c.Indexes) > 0 {
+			scalars = false
+		}
+		if _, ok := metricOids[c.Oid]; ok && len(c.Children) == 0 {
+			instances = append(instances, c.Oid+".0")
+		}
+	})
+	if !scalars {
+		return nil
+	}
+	return instances
+}
+
+// Rename a label used as the input of lookups, such as an index renamed
+// by a later lookup of it.
+func renameLookupLabels(lookups []*config.Lookup, from, to string) {
+	for _, lookup := range lookups {
+		for i, label := range lookup.Labels {
+			if label == from {
+				lookup.Labels[i] = to
+			}
+		}
+	}
+}
+
+// Find the lookup, possibly chained, that produces the given label.
+func findLookup(lookups []*config.Lookup, labelname string) *config.Lookup {
+	for _, lookup := range lookups {
+		if lookup.Labelname == labelname {
+			return lookup
+		}
+		if found := findLookup(lookup.Lookups, labelname); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func hasIndex(indexes []*config.Index, labelname string) bool {
+	for _, index := range indexes {
+		if index.Labelname == labelname {
+			return true
+		}
+	}
+	return false
+}
+
+// Split up any walk containing the given OID, so that it is walked on its own.
+func splitWalk(walk []string, oid string, nameToNode map[string]*Node) []string {
+	result := []string{}
+	for _, w := range walk {
+		if strings.HasPrefix(oid, w+".") {
+			result = append(result, pruneWalk(nameToNode[w], map[string]struct{}{oid: struct{}{}})...)
+			result = append(result, oid)
+		} else {
+			result = append(result, w)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func metricHelp(n *Node, cfg *ModuleConfig) string {
+	description := n.Description
+	if cfg.HelpDescription != "full" {
+		// Trim down description to first sentance.
+		description = strings.Split(description, ". ")[0]
+	}
+	if r := []rune(description); cfg.HelpMaxLength > 0 && len(r) > cfg.HelpMaxLength {
+		description = string(r[:cfg.HelpMaxLength]) + "..."
+	}
+	units := n.Units
+	if timeTicksSeconds(n, cfg) {
+		units = "seconds"
+	}
+	if units != "" {
+		return description + " (units: " + units + ") - " + n.Oid
+	}
+	return description + " - " + n.Oid
+}
+
+// Whether a node is TimeTicks to be exported in seconds.
+func timeTicksSeconds(n *Node, cfg *ModuleConfig) bool {
+	return cfg.TimeTicksSeconds && n.Type == "TIMETICKS"
+}
+
+// Get the Prometheus base unit for the UNITS of an object, if there is one.
+func unitSuffix(units string) string {
+	switch strings.ToLower(strings.TrimSpace(units)) {
+	case "seconds", "second", "secs":
+		return "seconds"
+	case "octets", "bytes":
+		return "bytes"
+	case "bits":
+		return "bits"
+	case "percent", "%":
+		return "percent"
+	case "celsius", "degrees celsius":
+		return "celsius"
+	case "watts", "watt":
+		return "watts"
+	case "volts", "volt":
+		return "volts"
+	case "amperes", "amps":
+		return "amperes"
+	case "hertz", "hz":
+		return "hertz"
+	default:
+		// No matching base unit, e.g. hundredths of a second.
+		return ""
+	}
+}
+
+// Regex extracts for an enum, matching either the number or the name of each
+// value. With "states" there's a metric per value that's 1 for that value and
+// 0 otherwise, such as ifOperStatusUp. With "values" there's one metric, such
+// as ifOperStatusValue, with the number of the value.
+func enumRegexExtracts(mode string, enumValues map[int]string) map[string][]config.RegexpExtract {
+	values := make([]int, 0, len(enumValues))
+	for v := range enumValues {
+		values = append(values, v)
+	}
+	sort.Ints(values)
+
+	extracts := map[string][]config.RegexpExtract{}
+	for _, v := range values {
+		name := enumValues[v]
+		if name == "" {
+			continue
+		}
+		re := config.Regexp{Regexp: regexp.MustCompile(fmt.Sprintf("^(%d|%s)$", v, regexp.QuoteMeta(name)))}
+		if mode == "values" {
+			extracts["Value"] = append(extracts["Value"], config.RegexpExtract{Regex: re, Value: strconv.Itoa(v)})
+			continue
+		}
+		key := sanitizeLabelName(strings.ToUpper(name[:1]) + name[1:])
+		extracts[key] = []config.RegexpExtract{
+			{Regex: re, Value: "1"},
+			{Regex: config.Regexp{Regexp: regexp.MustCompile(".*")}, Value: "0"},
+		}
+	}
+	return extracts
 }
 
 var (
 	invalidLabelCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+	decimalHintRE      = regexp.MustCompile(`^d-([0-9]+)$`)
 )
 
 func sanitizeLabelName(name string) string {
 	return invalidLabelCharRE.ReplaceAllString(name, "_")
 }
+
+// A metric name prefix for a MIB module, such as cisco_envmon_ for
+// CISCO-ENVMON-MIB.
+func mibNamespace(module string) string {
+	module = strings.TrimSuffix(module, "-MIB")
+	if module == "" {
+		return ""
+	}
+	return sanitizeLabelName(strings.ToLower(module)) + "_"
+}
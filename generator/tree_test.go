@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/prometheus/snmp_exporter/config"
 	yaml "gopkg.in/yaml.v2"
@@ -14,10 +15,10 @@ func TestTreePrepare(t *testing.T) {
 		in  *Node
 		out *Node
 	}{
-		// Descriptions trimmed.
+		// Descriptions whitespace trimmed.
 		{
 			in:  &Node{Oid: "1", Description: "A long   sentance.      Even more detail!"},
-			out: &Node{Oid: "1", Description: "A long sentance"},
+			out: &Node{Oid: "1", Description: "A long sentance. Even more detail!"},
 		},
 		// Indexes copied down.
 		{
@@ -77,6 +78,21 @@ func TestTreePrepare(t *testing.T) {
 			in:  &Node{Oid: "1", Label: "mac", Hint: "1x:"},
 			out: &Node{Oid: "1", Label: "mac", Hint: "1x:", Type: "PhysAddress48"},
 		},
+		// Float type set.
+		{
+			in:  &Node{Oid: "1", Label: "float", Type: "OPAQUE", TextualConvention: "Float"},
+			out: &Node{Oid: "1", Label: "float", Type: "Float", TextualConvention: "Float"},
+		},
+		// DateAndTime type set.
+		{
+			in:  &Node{Oid: "1", Label: "date", Hint: "2d-1d-1d,1d:1d:1d.1d,1a1d:1d"},
+			out: &Node{Oid: "1", Label: "date", Hint: "2d-1d-1d,1d:1d:1d.1d,1a1d:1d", Type: "DateAndTime"},
+		},
+		// TruthValue type set.
+		{
+			in:  &Node{Oid: "1", Label: "enabled", Type: "INTEGER", TextualConvention: "TruthValue"},
+			out: &Node{Oid: "1", Label: "enabled", Type: "Bool", TextualConvention: "TruthValue"},
+		},
 	}
 	for i, c := range cases {
 		// Indexes always end up initilized.
@@ -104,6 +120,9 @@ func TestTreePrepare(t *testing.T) {
 func TestGenerateConfigModule(t *testing.T) {
 	var regexpFooBar config.Regexp
 	regexpFooBar.Regexp, _ = regexp.Compile(".*")
+	min, max := -40.0, 125.0
+	uplinks := map[string]config.Regexp{"ifAlias": {Regexp: regexp.MustCompile("^(?:uplink.*)$")}}
+	maxLength, noMaxLength := config.Limit(256), config.Limit(0)
 
 	strMetrics := make(map[string][]config.RegexpExtract)
 	strMetrics["Status"] = []config.RegexpExtract{
@@ -119,6 +138,8 @@ func TestGenerateConfigModule(t *testing.T) {
 	}
 	overrides["root"] = metricOverrides
 
+	skipDeprecated := true
+
 	cases := []struct {
 		node *Node
 		cfg  *ModuleConfig  // SNMP generator config.
@@ -132,7 +153,7 @@ func TestGenerateConfigModule(t *testing.T) {
 				Overrides: overrides,
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Get: []string{"1.0"},
 				Metrics: []*config.Metric{
 					{
 						Name:           "root",
@@ -151,7 +172,7 @@ func TestGenerateConfigModule(t *testing.T) {
 				Walk: []string{"root"},
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Get: []string{"1.0"},
 				Metrics: []*config.Metric{
 					{
 						Name: "root",
@@ -162,576 +183,2575 @@ func TestGenerateConfigModule(t *testing.T) {
 				},
 			},
 		},
-		// Can also provide OIDs to walk.
+		// Replacements of index and lookup label values, and lookup cache TTLs.
 		{
-			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "root"},
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "ifTable",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER", Indexes: []string{"ifIndex"}},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR", Hint: "255a", Indexes: []string{"ifIndex"}},
+								}}}}}},
 			cfg: &ModuleConfig{
-				Walk: []string{"1"},
+				Walk:    []string{"ifIndex"},
+				Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDescr", KeepSourceIndexes: true, CacheTTL: time.Hour}},
+				Overrides: map[string]MetricOverrides{
+					"ifIndex": {RegexpReplacements: []config.RegexpReplacement{{Regex: regexpFooBar, Replacement: "if$0"}}},
+					"ifDescr": {RegexpReplacements: []config.RegexpReplacement{{Regex: regexpFooBar, Replacement: "x"}}},
+				},
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Walk: []string{"1.1.1.1", "1.1.1.2"},
 				Metrics: []*config.Metric{
 					{
-						Name: "root",
-						Oid:  "1",
+						Name: "ifIndex",
+						Oid:  "1.1.1.1",
 						Type: "gauge",
-						Help: " - 1",
+						Help: " - 1.1.1.1",
+						Indexes: []*config.Index{
+							{Labelname: "ifIndex", Type: "gauge", RegexpReplacements: []config.RegexpReplacement{{Regex: regexpFooBar, Replacement: "if$0"}}},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:             []string{"ifIndex"},
+								Labelname:          "ifDescr",
+								Type:               "DisplayString",
+								Oid:                "1.1.1.2",
+								RegexpReplacements: []config.RegexpReplacement{{Regex: regexpFooBar, Replacement: "x"}},
+								CacheTTL:           time.Hour,
+							},
+						},
 					},
 				},
 			},
 		},
-		// Duplicate walks handled gracefully.
+		// Encodings of strings, where used as indexes and lookups too.
 		{
-			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "root"},
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableAddr"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "tableAddr", Type: "OCTETSTR"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableName", Type: "OCTETSTR", Hint: "255a"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+								}}}}}},
 			cfg: &ModuleConfig{
-				Walk: []string{"1", "root"},
+				Walk:    []string{"tableFoo"},
+				Lookups: []*Lookup{{OldIndex: "tableAddr", NewIndex: "tableName", KeepSourceIndexes: true}},
+				Overrides: map[string]MetricOverrides{
+					"tableAddr": {Encoding: "base64"},
+					"tableName": {Encoding: "utf8"},
+					"tableFoo":  {Encoding: "hex"},
+				},
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Walk: []string{"1.1.1.2", "1.1.1.3"},
 				Metrics: []*config.Metric{
 					{
-						Name: "root",
-						Oid:  "1",
-						Type: "gauge",
-						Help: " - 1",
+						Name:    "tableFoo",
+						Oid:     "1.1.1.3",
+						Type:    "gauge",
+						Help:    " - 1.1.1.3",
+						Indexes: []*config.Index{{Labelname: "tableAddr", Type: "OctetString", Encoding: "base64"}},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"tableAddr"},
+								Labelname: "tableName",
+								Type:      "DisplayString",
+								Oid:       "1.1.1.2",
+								Encoding:  "utf8",
+							},
+						},
 					},
 				},
 			},
 		},
-		// Metric types.
+		// Scale and offset from overrides.
 		{
-			node: &Node{Oid: "1", Type: "OTHER", Label: "root",
+			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "OBJID", Type: "OBJID"},
-					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "OCTETSTR", Type: "OCTETSTR"},
-					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "INTEGER", Type: "INTEGER"},
-					{Oid: "1.4", Access: "ACCESS_READONLY", Label: "NETADDR", Type: "NETADDR"},
-					{Oid: "1.5", Access: "ACCESS_READONLY", Label: "IPADDR", Type: "IPADDR"},
-					{Oid: "1.6", Access: "ACCESS_READONLY", Label: "COUNTER", Type: "COUNTER"},
-					{Oid: "1.7", Access: "ACCESS_READONLY", Label: "GAUGE", Type: "GAUGE"},
-					{Oid: "1.8", Access: "ACCESS_READONLY", Label: "TIMETICKS", Type: "TIMETICKS"},
-					{Oid: "1.9", Access: "ACCESS_READONLY", Label: "OPAQUE", Type: "OPAQUE"},
-					{Oid: "1.10", Access: "ACCESS_READONLY", Label: "NULL", Type: "NULL"},
-					{Oid: "1.11", Access: "ACCESS_READONLY", Label: "COUNTER64", Type: "COUNTER64"},
-					{Oid: "1.12", Access: "ACCESS_READONLY", Label: "BITSTRING", Type: "BITSTRING"},
-					{Oid: "1.13", Access: "ACCESS_READONLY", Label: "NSAPADDRESS", Type: "NSAPADDRESS"},
-					{Oid: "1.14", Access: "ACCESS_READONLY", Label: "UINTEGER", Type: "UINTEGER"},
-					{Oid: "1.15", Access: "ACCESS_READONLY", Label: "UNSIGNED32", Type: "UNSIGNED32"},
-					{Oid: "1.16", Access: "ACCESS_READONLY", Label: "INTEGER32", Type: "INTEGER32"},
-					{Oid: "1.20", Access: "ACCESS_READONLY", Label: "TRAPTYPE", Type: "TRAPTYPE"},
-					{Oid: "1.21", Access: "ACCESS_READONLY", Label: "NOTIFTYPE", Type: "NOTIFTYPE"},
-					{Oid: "1.22", Access: "ACCESS_READONLY", Label: "OBJGROUP", Type: "OBJGROUP"},
-					{Oid: "1.23", Access: "ACCESS_READONLY", Label: "NOTIFGROUP", Type: "NOTIFGROUP"},
-					{Oid: "1.24", Access: "ACCESS_READONLY", Label: "MODID", Type: "MODID"},
-					{Oid: "1.25", Access: "ACCESS_READONLY", Label: "AGENTCAP", Type: "AGENTCAP"},
-					{Oid: "1.26", Access: "ACCESS_READONLY", Label: "MODCOMP", Type: "MODCOMP"},
-					{Oid: "1.27", Access: "ACCESS_READONLY", Label: "OBJIDENTITY", Type: "OBJIDENTITY"},
-					{Oid: "1.100", Access: "ACCESS_READONLY", Label: "MacAddress", Type: "OCTETSTR", Hint: "1x:"},
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature", Hint: "d-1"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "power"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "status"},
 				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"root", "1.3"},
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"temperature": {Scale: 0.5},
+					"power":       {Offset: -100},
+					"status":      {Offset: 1, Type: "EnumAsInfo"},
+				},
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Get: []string{"1.1.0", "1.2.0", "1.3.0"},
 				Metrics: []*config.Metric{
-					{
-						Name: "OCTETSTR",
-						Oid:  "1.2",
-						Type: "OctetString",
-						Help: " - 1.2",
-					},
-					{
-						Name: "INTEGER",
-						Oid:  "1.3",
-						Type: "gauge",
-						Help: " - 1.3",
-					},
-					{
-						Name: "NETADDR",
-						Oid:  "1.4",
-						Type: "InetAddress",
-						Help: " - 1.4",
-					},
-					{
-						Name: "IPADDR",
-						Oid:  "1.5",
-						Type: "IpAddr",
-						Help: " - 1.5",
-					},
-					{
-						Name: "COUNTER",
-						Oid:  "1.6",
-						Type: "counter",
-						Help: " - 1.6",
-					},
-					{
-						Name: "GAUGE",
-						Oid:  "1.7",
-						Type: "gauge",
-						Help: " - 1.7",
-					},
-					{
-						Name: "TIMETICKS",
-						Oid:  "1.8",
-						Type: "gauge",
-						Help: " - 1.8",
-					},
-					{
-						Name: "COUNTER64",
-						Oid:  "1.11",
-						Type: "counter",
-						Help: " - 1.11",
-					},
-					{
-						Name: "BITSTRING",
-						Oid:  "1.12",
-						Type: "OctetString",
-						Help: " - 1.12",
-					},
-					{
-						Name: "UINTEGER",
-						Oid:  "1.14",
-						Type: "gauge",
-						Help: " - 1.14",
-					},
-					{
-						Name: "UNSIGNED32",
-						Oid:  "1.15",
-						Type: "gauge",
-						Help: " - 1.15",
-					},
-					{
-						Name: "INTEGER32",
-						Oid:  "1.16",
-						Type: "gauge",
-						Help: " - 1.16",
-					},
-					{
-						Name: "MacAddress",
-						Oid:  "1.100",
-						Type: "PhysAddress48",
-						Help: " - 1.100",
-					},
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1", Scale: 0.5},
+					{Name: "power", Oid: "1.2", Type: "gauge", Help: " - 1.2", Offset: -100},
+					{Name: "status", Oid: "1.3", Type: "EnumAsInfo", Help: " - 1.3"},
 				},
 			},
 		},
-		// Tables with accessible & inaccessible.
+		// Counter64 handling from overrides.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Label: "table",
-						Children: []*Node{
-							{Oid: "1.1.1", Label: "tableEntry",
-								Children: []*Node{
-									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "tableNoAccess", Type: "INTEGER"},
-									{Oid: "1.1.1.2", Access: "ACCESS_CREATE", Label: "tableCreate", Type: "INTEGER"},
-									{Oid: "1.1.1.3", Access: "ACCESS_WRITEONLY", Label: "tableWriteOnly", Type: "INTEGER"},
-									{Oid: "1.1.1.4", Access: "ACCESS_READONLY", Label: "tableReadOnly", Type: "INTEGER"},
-									{Oid: "1.1.1.5", Access: "ACCESS_READWRITE", Label: "tableReadWrite", Type: "INTEGER"},
-									{Oid: "1.1.1.6", Access: "ACCESS_NOTIFY", Label: "tableNotify", Type: "INTEGER"},
-								}}}}}},
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "COUNTER64", Label: "octets"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "OCTETSTR", Label: "descr"},
+				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"1"},
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"octets": {Counter64: "split"},
+					"descr":  {Counter64: "info"},
+				},
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Get: []string{"1.1.0", "1.2.0"},
 				Metrics: []*config.Metric{
-					{
-						Name: "tableNoAccess",
-						Oid:  "1.1.1.1",
-						Type: "gauge",
-						Help: " - 1.1.1.1",
-					},
-					{
-						Name: "tableCreate",
-						Oid:  "1.1.1.2",
-						Type: "gauge",
-						Help: " - 1.1.1.2",
-					},
-					{
-						Name: "tableReadOnly",
-						Oid:  "1.1.1.4",
-						Type: "gauge",
-						Help: " - 1.1.1.4",
-					},
-					{
-						Name: "tableReadWrite",
-						Oid:  "1.1.1.5",
-						Type: "gauge",
-						Help: " - 1.1.1.5",
-					},
+					{Name: "octets", Oid: "1.1", Type: "counter", Help: " - 1.1", Counter64: "split"},
+					{Name: "descr", Oid: "1.2", Type: "OctetString", Help: " - 1.2"},
 				},
 			},
 		},
-		// Basic table with integer index.
+		// Sentinel values and clamping from overrides, only for numbers.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Label: "table",
-						Children: []*Node{
-							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
-								Children: []*Node{
-									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "tableIndex", Type: "INTEGER"},
-									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
-								}}}}}},
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "OCTETSTR", Label: "descr"},
+				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"1"},
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"temperature": {IgnoreValues: []float64{-1}, Min: &min, Max: &max},
+					"descr":       {IgnoreValues: []float64{-1}, Min: &min},
+				},
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Get: []string{"1.1.0", "1.2.0"},
 				Metrics: []*config.Metric{
-					{
-						Name: "tableIndex",
-						Oid:  "1.1.1.1",
-						Type: "gauge",
-						Help: " - 1.1.1.1",
-						Indexes: []*config.Index{
-							{
-								Labelname: "tableIndex",
-								Type:      "gauge",
-							},
-						},
-					},
-					{
-						Name: "tableFoo",
-						Oid:  "1.1.1.2",
-						Type: "gauge",
-						Help: " - 1.1.1.2",
-						Indexes: []*config.Index{
-							{
-								Labelname: "tableIndex",
-								Type:      "gauge",
-							},
-						},
-					},
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1", IgnoreValues: []float64{-1}, Min: &min, Max: &max},
+					{Name: "descr", Oid: "1.2", Type: "OctetString", Help: " - 1.2"},
 				},
 			},
 		},
-		// Tables with non-integer indexes.
+		// Max series for the module and from overrides.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Label: "octet",
-						Children: []*Node{
-							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
-								Children: []*Node{
-									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "OCTETSTR"},
-									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}},
-					{Oid: "1.2", Label: "bitstring",
-						Children: []*Node{
-							{Oid: "1.2.1", Label: "bitstringEntry", Indexes: []string{"bitstringIndex"},
-								Children: []*Node{
-									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "bitstringIndex", Type: "BITSTRING"},
-									{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "bitstringFoo", Type: "INTEGER"}}}}},
-					{Oid: "1.3", Label: "ipaddr",
-						Children: []*Node{
-							{Oid: "1.3.1", Label: "ipaddrEntry", Indexes: []string{"ipaddrIndex"},
-								Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "power"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:      []string{"root"},
+				MaxSeries: 1000,
+				Overrides: map[string]MetricOverrides{
+					"power": {MaxSeries: 10},
+				},
+			},
+			out: &config.Module{
+				Get:       []string{"1.1.0", "1.2.0"},
+				MaxSeries: 1000,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+					{Name: "power", Oid: "1.2", Type: "gauge", Help: " - 1.2", MaxSeries: 10},
+				},
+			},
+		},
+		// Partial results.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:           []string{"root"},
+				PartialResults: true,
+			},
+			out: &config.Module{
+				Get:            []string{"1.1.0"},
+				PartialResults: true,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// Walk budget.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:       []string{"root"},
+				WalkBudget: 10 * time.Second,
+			},
+			out: &config.Module{
+				Get:        []string{"1.1.0"},
+				WalkBudget: 10 * time.Second,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// Non-increasing OIDs allowed.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:                   []string{"root"},
+				AllowNonIncreasingOids: true,
+			},
+			out: &config.Module{
+				Get:                    []string{"1.1.0"},
+				AllowNonIncreasingOids: true,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// Scrape cache TTL.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:           []string{"root"},
+				ScrapeCacheTTL: 5 * time.Minute,
+			},
+			out: &config.Module{
+				Get:            []string{"1.1.0"},
+				ScrapeCacheTTL: 5 * time.Minute,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// Reboot detection.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:          []string{"root"},
+				DetectReboots: true,
+			},
+			out: &config.Module{
+				Get:           []string{"1.1.0"},
+				DetectReboots: true,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// Invalid UTF-8 handling.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:        []string{"root"},
+				InvalidUTF8: "replace",
+			},
+			out: &config.Module{
+				Get:         []string{"1.1.0"},
+				InvalidUTF8: "replace",
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// MAC address format.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:                []string{"root"},
+				MACAddressFormat:    "dot",
+				MACAddressLowercase: true,
+			},
+			out: &config.Module{
+				Get:                 []string{"1.1.0"},
+				MACAddressFormat:    "dot",
+				MACAddressLowercase: true,
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		// Missing objects policy for the module and from overrides.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "power"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:           []string{"root"},
+				MissingObjects: "metric",
+				Overrides: map[string]MetricOverrides{
+					"power": {MissingObjects: "fail"},
+				},
+			},
+			out: &config.Module{
+				Get:            []string{"1.1.0", "1.2.0"},
+				MissingObjects: "metric",
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+					{Name: "power", Oid: "1.2", Type: "gauge", Help: " - 1.2", MissingObjects: "fail"},
+				},
+			},
+		},
+		// TimeTicks in seconds.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "TIMETICKS", Label: "sysUpTime"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "TIMETICKS", Label: "lastChange", Units: "centiseconds"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Type: "TIMETICKS", Label: "timeoutSeconds"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:             []string{"root"},
+				TimeTicksSeconds: true,
+				Overrides: map[string]MetricOverrides{
+					"timeoutSeconds": {Scale: 0.001},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0", "1.3.0"},
+				Metrics: []*config.Metric{
+					{Name: "sysUpTime_seconds", Oid: "1.1", Type: "gauge", Help: " (units: seconds) - 1.1", Scale: 0.01},
+					{Name: "lastChange_seconds", Oid: "1.2", Type: "gauge", Help: " (units: seconds) - 1.2", Scale: 0.01},
+					{Name: "timeoutSeconds", Oid: "1.3", Type: "gauge", Help: " (units: seconds) - 1.3", Scale: 0.001},
+				},
+			},
+		},
+		// Help from overrides.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature", Description: "The temperature."},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "power", Description: "The power."},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"1.1": {Help: "Inlet temperature in degrees Celsius."},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0"},
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: "Inlet temperature in degrees Celsius."},
+					{Name: "power", Oid: "1.2", Type: "gauge", Help: "The power. - 1.2"},
+				},
+			},
+		},
+		// Uptime as a timestamp, without the scale for TimeTicks.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "TIMETICKS", Label: "sysUpTime", Description: "The uptime."},
+				}},
+			cfg: &ModuleConfig{
+				Walk:             []string{"root"},
+				TimeTicksSeconds: true,
+				Overrides: map[string]MetricOverrides{
+					"sysUpTime": {Type: "UptimeTimestamp"},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0"},
+				Metrics: []*config.Metric{
+					{Name: "sysUpTime", Oid: "1.1", Type: "UptimeTimestamp", Help: "The uptime. (units: seconds) - 1.1"},
+				},
+			},
+		},
+		// Static labels.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "voltage"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:           []string{"root"},
+				StaticLabels:   map[string]string{"vendor": "cisco"},
+				RelabelConfigs: []*config.RelabelConfig{{SourceLabels: []string{"rail"}, Action: "drop"}},
+				Overrides: map[string]MetricOverrides{
+					"voltage": {StaticLabels: map[string]string{"rail": "12v"}},
+				},
+			},
+			out: &config.Module{
+				Get:            []string{"1.1.0", "1.2.0"},
+				StaticLabels:   map[string]string{"vendor": "cisco"},
+				RelabelConfigs: []*config.RelabelConfig{{SourceLabels: []string{"rail"}, Action: "drop"}},
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+					{Name: "voltage", Oid: "1.2", Type: "gauge", Help: " - 1.2", StaticLabels: map[string]string{"rail": "12v"}},
+				},
+			},
+		},
+		// Dropped and renamed labels, label matches and maximum value lengths.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"temperature": {DropLabels: []string{"ifIndex"}, RenameLabels: map[string]string{"ifDescr": "interface"}, MaxValueLength: &noMaxLength, KeepIfLabelMatches: uplinks},
+				},
+				MaxValueLength: &maxLength,
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0"},
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1", DropLabels: []string{"ifIndex"}, RenameLabels: map[string]string{"ifDescr": "interface"}, MaxValueLength: &noMaxLength, KeepIfLabelMatches: uplinks},
+				},
+				MaxValueLength: &maxLength,
+			},
+		},
+		// Access policies.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "readOnly"},
+					{Oid: "1.2", Access: "ACCESS_NOACCESS", Type: "INTEGER", Label: "notAccessible"},
+					{Oid: "1.3", Access: "ACCESS_WRITEONLY", Type: "INTEGER", Label: "writeOnly"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:   []string{"root"},
+				Access: "readable_only",
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0"},
+				Metrics: []*config.Metric{
+					{Name: "readOnly", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+				},
+			},
+		},
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "readOnly"},
+					{Oid: "1.2", Access: "ACCESS_NOACCESS", Type: "INTEGER", Label: "notAccessible"},
+					{Oid: "1.3", Access: "ACCESS_WRITEONLY", Type: "INTEGER", Label: "writeOnly"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:   []string{"root"},
+				Access: "all",
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0", "1.3.0"},
+				Metrics: []*config.Metric{
+					{Name: "readOnly", Oid: "1.1", Type: "gauge", Help: " - 1.1"},
+					{Name: "notAccessible", Oid: "1.2", Type: "gauge", Help: " - 1.2"},
+					{Name: "writeOnly", Oid: "1.3", Type: "gauge", Help: " - 1.3"},
+				},
+			},
+		},
+		// Regex extracts generated from enums.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "ifOperStatus", EnumValues: map[int]string{1: "up", 2: "down"}},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "ifAdminStatus", EnumValues: map[int]string{1: "up", 2: "down"}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"ifOperStatus":  {EnumRegexExtracts: "states"},
+					"ifAdminStatus": {EnumRegexExtracts: "values"},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0"},
+				Metrics: []*config.Metric{
+					{Name: "ifOperStatus", Oid: "1.1", Type: "DisplayString", Help: " - 1.1",
+						RegexpExtracts: map[string][]config.RegexpExtract{
+							"Up": {
+								{Regex: config.Regexp{Regexp: regexp.MustCompile("^(1|up)$")}, Value: "1"},
+								{Regex: config.Regexp{Regexp: regexp.MustCompile(".*")}, Value: "0"},
+							},
+							"Down": {
+								{Regex: config.Regexp{Regexp: regexp.MustCompile("^(2|down)$")}, Value: "1"},
+								{Regex: config.Regexp{Regexp: regexp.MustCompile(".*")}, Value: "0"},
+							},
+						}},
+					{Name: "ifAdminStatus", Oid: "1.2", Type: "DisplayString", Help: " - 1.2",
+						RegexpExtracts: map[string][]config.RegexpExtract{
+							"Value": {
+								{Regex: config.Regexp{Regexp: regexp.MustCompile("^(1|up)$")}, Value: "1"},
+								{Regex: config.Regexp{Regexp: regexp.MustCompile("^(2|down)$")}, Value: "2"},
+							},
+						}},
+				},
+			},
+		},
+		// Decimal places in the DISPLAY-HINT scale the value.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "temperature", Hint: "d-1"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "voltage", Hint: "d-3"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "count", Hint: "d"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0", "1.3.0"},
+				Metrics: []*config.Metric{
+					{Name: "temperature", Oid: "1.1", Type: "gauge", Help: " - 1.1", Scale: 0.1},
+					{Name: "voltage", Oid: "1.2", Type: "gauge", Help: " - 1.2", Scale: 0.001},
+					{Name: "count", Oid: "1.3", Type: "gauge", Help: " - 1.3"},
+				},
+			},
+		},
+		// Can also provide OIDs to walk.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "root"},
+			cfg: &ModuleConfig{
+				Walk: []string{"1"},
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "root",
+						Oid:  "1",
+						Type: "gauge",
+						Help: " - 1",
+					},
+				},
+			},
+		},
+		// Duplicate walks handled gracefully.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Type: "INTEGER", Label: "root"},
+			cfg: &ModuleConfig{
+				Walk: []string{"1", "root"},
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "root",
+						Oid:  "1",
+						Type: "gauge",
+						Help: " - 1",
+					},
+				},
+			},
+		},
+		// Metric types.
+		{
+			node: &Node{Oid: "1", Type: "OTHER", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "OBJID", Type: "OBJID"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "OCTETSTR", Type: "OCTETSTR"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "INTEGER", Type: "INTEGER"},
+					{Oid: "1.4", Access: "ACCESS_READONLY", Label: "NETADDR", Type: "NETADDR"},
+					{Oid: "1.5", Access: "ACCESS_READONLY", Label: "IPADDR", Type: "IPADDR"},
+					{Oid: "1.6", Access: "ACCESS_READONLY", Label: "COUNTER", Type: "COUNTER"},
+					{Oid: "1.7", Access: "ACCESS_READONLY", Label: "GAUGE", Type: "GAUGE"},
+					{Oid: "1.8", Access: "ACCESS_READONLY", Label: "TIMETICKS", Type: "TIMETICKS"},
+					{Oid: "1.9", Access: "ACCESS_READONLY", Label: "OPAQUE", Type: "OPAQUE"},
+					{Oid: "1.10", Access: "ACCESS_READONLY", Label: "NULL", Type: "NULL"},
+					{Oid: "1.11", Access: "ACCESS_READONLY", Label: "COUNTER64", Type: "COUNTER64"},
+					{Oid: "1.12", Access: "ACCESS_READONLY", Label: "BITSTRING", Type: "BITSTRING"},
+					{Oid: "1.13", Access: "ACCESS_READONLY", Label: "NSAPADDRESS", Type: "NSAPADDRESS"},
+					{Oid: "1.14", Access: "ACCESS_READONLY", Label: "UINTEGER", Type: "UINTEGER"},
+					{Oid: "1.15", Access: "ACCESS_READONLY", Label: "UNSIGNED32", Type: "UNSIGNED32"},
+					{Oid: "1.16", Access: "ACCESS_READONLY", Label: "INTEGER32", Type: "INTEGER32"},
+					{Oid: "1.20", Access: "ACCESS_READONLY", Label: "TRAPTYPE", Type: "TRAPTYPE"},
+					{Oid: "1.21", Access: "ACCESS_READONLY", Label: "NOTIFTYPE", Type: "NOTIFTYPE"},
+					{Oid: "1.22", Access: "ACCESS_READONLY", Label: "OBJGROUP", Type: "OBJGROUP"},
+					{Oid: "1.23", Access: "ACCESS_READONLY", Label: "NOTIFGROUP", Type: "NOTIFGROUP"},
+					{Oid: "1.24", Access: "ACCESS_READONLY", Label: "MODID", Type: "MODID"},
+					{Oid: "1.25", Access: "ACCESS_READONLY", Label: "AGENTCAP", Type: "AGENTCAP"},
+					{Oid: "1.26", Access: "ACCESS_READONLY", Label: "MODCOMP", Type: "MODCOMP"},
+					{Oid: "1.27", Access: "ACCESS_READONLY", Label: "OBJIDENTITY", Type: "OBJIDENTITY"},
+					{Oid: "1.100", Access: "ACCESS_READONLY", Label: "MacAddress", Type: "OCTETSTR", Hint: "1x:"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root", "1.3"},
+			},
+			out: &config.Module{
+				Get: []string{"1.2.0", "1.3.0", "1.4.0", "1.5.0", "1.6.0", "1.7.0", "1.8.0", "1.11.0", "1.12.0", "1.14.0", "1.15.0", "1.16.0", "1.100.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "OCTETSTR",
+						Oid:  "1.2",
+						Type: "OctetString",
+						Help: " - 1.2",
+					},
+					{
+						Name: "INTEGER",
+						Oid:  "1.3",
+						Type: "gauge",
+						Help: " - 1.3",
+					},
+					{
+						Name: "NETADDR",
+						Oid:  "1.4",
+						Type: "InetAddress",
+						Help: " - 1.4",
+					},
+					{
+						Name: "IPADDR",
+						Oid:  "1.5",
+						Type: "IpAddr",
+						Help: " - 1.5",
+					},
+					{
+						Name: "COUNTER",
+						Oid:  "1.6",
+						Type: "counter",
+						Help: " - 1.6",
+					},
+					{
+						Name: "GAUGE",
+						Oid:  "1.7",
+						Type: "gauge",
+						Help: " - 1.7",
+					},
+					{
+						Name: "TIMETICKS",
+						Oid:  "1.8",
+						Type: "gauge",
+						Help: " - 1.8",
+					},
+					{
+						Name: "COUNTER64",
+						Oid:  "1.11",
+						Type: "counter",
+						Help: " - 1.11",
+					},
+					{
+						Name: "BITSTRING",
+						Oid:  "1.12",
+						Type: "OctetString",
+						Help: " - 1.12",
+					},
+					{
+						Name: "UINTEGER",
+						Oid:  "1.14",
+						Type: "gauge",
+						Help: " - 1.14",
+					},
+					{
+						Name: "UNSIGNED32",
+						Oid:  "1.15",
+						Type: "gauge",
+						Help: " - 1.15",
+					},
+					{
+						Name: "INTEGER32",
+						Oid:  "1.16",
+						Type: "gauge",
+						Help: " - 1.16",
+					},
+					{
+						Name: "MacAddress",
+						Oid:  "1.100",
+						Type: "PhysAddress48",
+						Help: " - 1.100",
+					},
+				},
+			},
+		},
+		// Tables with accessible & inaccessible.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry",
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "tableNoAccess", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_CREATE", Label: "tableCreate", Type: "INTEGER"},
+									{Oid: "1.1.1.3", Access: "ACCESS_WRITEONLY", Label: "tableWriteOnly", Type: "INTEGER"},
+									{Oid: "1.1.1.4", Access: "ACCESS_READONLY", Label: "tableReadOnly", Type: "INTEGER"},
+									{Oid: "1.1.1.5", Access: "ACCESS_READWRITE", Label: "tableReadWrite", Type: "INTEGER"},
+									{Oid: "1.1.1.6", Access: "ACCESS_NOTIFY", Label: "tableNotify", Type: "INTEGER"},
+								}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"1"},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.1.1.0", "1.1.1.2.0", "1.1.1.4.0", "1.1.1.5.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "tableNoAccess",
+						Oid:  "1.1.1.1",
+						Type: "gauge",
+						Help: " - 1.1.1.1",
+					},
+					{
+						Name: "tableCreate",
+						Oid:  "1.1.1.2",
+						Type: "gauge",
+						Help: " - 1.1.1.2",
+					},
+					{
+						Name: "tableReadOnly",
+						Oid:  "1.1.1.4",
+						Type: "gauge",
+						Help: " - 1.1.1.4",
+					},
+					{
+						Name: "tableReadWrite",
+						Oid:  "1.1.1.5",
+						Type: "gauge",
+						Help: " - 1.1.1.5",
+					},
+				},
+			},
+		},
+		// Basic table with integer index.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "tableIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+								}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"1"},
+			},
+			out: &config.Module{
+				Walk: []string{"1"},
+				Metrics: []*config.Metric{
+					{
+						Name: "tableIndex",
+						Oid:  "1.1.1.1",
+						Type: "gauge",
+						Help: " - 1.1.1.1",
+						Indexes: []*config.Index{
+							{
+								Labelname: "tableIndex",
+								Type:      "gauge",
+							},
+						},
+					},
+					{
+						Name: "tableFoo",
+						Oid:  "1.1.1.2",
+						Type: "gauge",
+						Help: " - 1.1.1.2",
+						Indexes: []*config.Index{
+							{
+								Labelname: "tableIndex",
+								Type:      "gauge",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Tables with non-integer indexes.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "octet",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "OCTETSTR"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}},
+					{Oid: "1.2", Label: "bitstring",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "bitstringEntry", Indexes: []string{"bitstringIndex"},
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "bitstringIndex", Type: "BITSTRING"},
+									{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "bitstringFoo", Type: "INTEGER"}}}}},
+					{Oid: "1.3", Label: "ipaddr",
+						Children: []*Node{
+							{Oid: "1.3.1", Label: "ipaddrEntry", Indexes: []string{"ipaddrIndex"},
+								Children: []*Node{
 									{Oid: "1.3.1.1", Access: "ACCESS_READONLY", Label: "ipaddrIndex", Type: "IPADDR"},
 									{Oid: "1.3.1.2", Access: "ACCESS_READONLY", Label: "ipaddrFoo", Type: "INTEGER"}}}}},
 					{Oid: "1.4", Label: "netaddr",
 						Children: []*Node{
-							{Oid: "1.4.1", Label: "netaddrEntry", Indexes: []string{"netaddrIndex"},
+							{Oid: "1.4.1", Label: "netaddrEntry", Indexes: []string{"netaddrIndex"},
+								Children: []*Node{
+									{Oid: "1.4.1.1", Access: "ACCESS_READONLY", Label: "netaddrIndex", Type: "NETADDR"},
+									{Oid: "1.4.1.2", Access: "ACCESS_READONLY", Label: "netaddrFoo", Type: "INTEGER"}}}}},
+					{Oid: "1.5", Label: "physaddress48",
+						Children: []*Node{
+							{Oid: "1.5.1", Label: "physaddress48Entry", Indexes: []string{"physaddress48Index"},
+								Children: []*Node{
+									{Oid: "1.5.1.1", Access: "ACCESS_READONLY", Label: "physaddress48Index", Type: "OCTETSTR", Hint: "1x:"},
+									{Oid: "1.5.1.2", Access: "ACCESS_READONLY", Label: "physaddress48Foo", Type: "INTEGER"}}}}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"1"},
+			},
+			out: &config.Module{
+				Walk: []string{"1"},
+				Metrics: []*config.Metric{
+					{
+						Name: "octetIndex",
+						Oid:  "1.1.1.1",
+						Help: " - 1.1.1.1",
+						Type: "OctetString",
+						Indexes: []*config.Index{
+							{
+								Labelname: "octetIndex",
+								Type:      "OctetString",
+							},
+						},
+					},
+					{
+						Name: "octetFoo",
+						Oid:  "1.1.1.2",
+						Help: " - 1.1.1.2",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "octetIndex",
+								Type:      "OctetString",
+							},
+						},
+					},
+					{
+						Name: "bitstringIndex",
+						Oid:  "1.2.1.1",
+						Help: " - 1.2.1.1",
+						Type: "OctetString",
+						Indexes: []*config.Index{
+							{
+								Labelname: "bitstringIndex",
+								Type:      "OctetString",
+							},
+						},
+					},
+					{
+						Name: "bitstringFoo",
+						Oid:  "1.2.1.2",
+						Help: " - 1.2.1.2",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "bitstringIndex",
+								Type:      "OctetString",
+							},
+						},
+					},
+					{
+						Name: "ipaddrIndex",
+						Oid:  "1.3.1.1",
+						Help: " - 1.3.1.1",
+						Type: "IpAddr",
+						Indexes: []*config.Index{
+							{
+								Labelname: "ipaddrIndex",
+								Type:      "IpAddr",
+							},
+						},
+					},
+					{
+						Name: "ipaddrFoo",
+						Oid:  "1.3.1.2",
+						Help: " - 1.3.1.2",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "ipaddrIndex",
+								Type:      "IpAddr",
+							},
+						},
+					},
+					{
+						Name: "netaddrIndex",
+						Oid:  "1.4.1.1",
+						Help: " - 1.4.1.1",
+						Type: "InetAddress",
+						Indexes: []*config.Index{
+							{
+								Labelname: "netaddrIndex",
+								Type:      "InetAddress",
+							},
+						},
+					},
+					{
+						Name: "netaddrFoo",
+						Oid:  "1.4.1.2",
+						Help: " - 1.4.1.2",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "netaddrIndex",
+								Type:      "InetAddress",
+							},
+						},
+					},
+					{
+						Name: "physaddress48Index",
+						Oid:  "1.5.1.1",
+						Help: " - 1.5.1.1",
+						Type: "PhysAddress48",
+						Indexes: []*config.Index{
+							{
+								Labelname: "physaddress48Index",
+								Type:      "PhysAddress48",
+							},
+						},
+					},
+					{
+						Name: "physaddress48Foo",
+						Oid:  "1.5.1.2",
+						Help: " - 1.5.1.2",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "physaddress48Index",
+								Type:      "PhysAddress48",
+							},
+						},
+					},
+				},
+			},
+		},
+		// One table lookup, lookup not walked.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "octet",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetDesc", Type: "OCTETSTR"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"octetFoo"},
+				Lookups: []*Lookup{
+					{
+						OldIndex: "octetIndex",
+						NewIndex: "octetDesc",
+					},
+				},
+			},
+			out: &config.Module{
+				// Walk is expanded to include the lookup OID.
+				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name: "octetFoo",
+						Oid:  "1.1.1.3",
+						Help: " - 1.1.1.3",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "octetDesc",
+								Type:      "gauge",
+							},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"octetDesc"},
+								Labelname: "octetDesc",
+								Type:      "OctetString",
+								Oid:       "1.1.1.2",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Type overrides apply to indexes and lookups.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "peer",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "peerEntry", Indexes: []string{"peerAddr", "peerKind"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "peerAddr", Type: "OCTETSTR"},
+									{Oid: "1.1.1.2", Access: "ACCESS_NOACCESS", Label: "peerKind", Type: "INTEGER", EnumValues: map[int]string{1: "a"}},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "peerFoo", Type: "INTEGER"}}}}},
+					{Oid: "1.2", Label: "session",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "sessionEntry", Indexes: []string{"sessionIndex"},
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "sessionIndex", Type: "INTEGER"},
+									{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "sessionPeer", Type: "OCTETSTR"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"peerFoo", "sessionIndex"},
+				Lookups: []*Lookup{
+					{
+						OldIndex:          "sessionIndex",
+						NewIndex:          "sessionPeer",
+						KeepSourceIndexes: true,
+					},
+				},
+				Overrides: map[string]MetricOverrides{
+					"peerAddr":    MetricOverrides{Type: "IpAddr"},
+					"1.2.1.2":     MetricOverrides{Type: "IpAddr"},
+					"peerKind":    MetricOverrides{Type: "EnumAsInfo"},
+					"sessionPeer": MetricOverrides{Ignore: true},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.3", "1.2.1.1", "1.2.1.2"},
+				Metrics: []*config.Metric{
+					{
+						Name: "peerFoo",
+						Oid:  "1.1.1.3",
+						Help: " - 1.1.1.3",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "peerAddr",
+								Type:      "IpAddr",
+							},
+							{
+								Labelname: "peerKind",
+								Type:      "gauge",
+							},
+						},
+					},
+					{
+						Name: "sessionIndex",
+						Oid:  "1.2.1.1",
+						Help: " - 1.2.1.1",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "sessionIndex",
+								Type:      "gauge",
+							},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"sessionIndex"},
+								Labelname: "sessionPeer",
+								Type:      "IpAddr",
+								Oid:       "1.2.1.2",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Lookup via OID.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "octet",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetDesc", Type: "OCTETSTR"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"octetFoo"},
+				Lookups: []*Lookup{
+					{
+						OldIndex: "octetIndex",
+						NewIndex: "1.1.1.2",
+					},
+				},
+			},
+			out: &config.Module{
+				// Walk is expanded to include the lookup OID.
+				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name: "octetFoo",
+						Oid:  "1.1.1.3",
+						Help: " - 1.1.1.3",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "octetDesc",
+								Type:      "gauge",
+							},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"octetDesc"},
+								Labelname: "octetDesc",
+								Type:      "OctetString",
+								Oid:       "1.1.1.2",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Lookup keeping the source index.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "octet",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetDesc", Type: "OCTETSTR"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"octetFoo"},
+				Lookups: []*Lookup{
+					{
+						OldIndex:          "octetIndex",
+						NewIndex:          "octetDesc",
+						KeepSourceIndexes: true,
+					},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name: "octetFoo",
+						Oid:  "1.1.1.3",
+						Help: " - 1.1.1.3",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "octetIndex",
+								Type:      "gauge",
+							},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"octetIndex"},
+								Labelname: "octetDesc",
+								Type:      "OctetString",
+								Oid:       "1.1.1.2",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Several lookups of the same index, dropping it after the last.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "ifTable",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "ifName", Type: "OCTETSTR"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR"},
+									{Oid: "1.1.1.4", Access: "ACCESS_READONLY", Label: "ifInOctets", Type: "COUNTER"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"ifInOctets"},
+				Lookups: []*Lookup{
+					{OldIndex: "ifIndex", NewIndex: "ifName", KeepSourceIndexes: true},
+					{OldIndex: "ifIndex", NewIndex: "ifDescr"},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.2", "1.1.1.3", "1.1.1.4"},
+				Metrics: []*config.Metric{
+					{
+						Name:    "ifInOctets",
+						Oid:     "1.1.1.4",
+						Help:    " - 1.1.1.4",
+						Type:    "counter",
+						Indexes: []*config.Index{{Labelname: "ifDescr", Type: "gauge"}},
+						Lookups: []*config.Lookup{
+							{Labels: []string{"ifDescr"}, Labelname: "ifName", Type: "OctetString", Oid: "1.1.1.2"},
+							{Labels: []string{"ifDescr"}, Labelname: "ifDescr", Type: "OctetString", Oid: "1.1.1.3"},
+						},
+					},
+				},
+			},
+		},
+		// Lookup into a table with a differently numbered index.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+						Children: []*Node{
+							{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+							{Oid: "1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR"}}},
+					{Oid: "1.2", Label: "statsEntry", Indexes: []string{"statsIndex"},
+						Children: []*Node{
+							{Oid: "1.2.1", Access: "ACCESS_READONLY", Label: "statsIndex", Type: "INTEGER"},
+							{Oid: "1.2.2", Access: "ACCESS_READONLY", Label: "statsErrors", Type: "COUNTER"}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"statsErrors"},
+				Lookups: []*Lookup{
+					{
+						OldIndex:    "statsIndex",
+						NewIndex:    "ifDescr",
+						IndexOffset: -1000000,
+					},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.2", "1.2.2"},
+				Metrics: []*config.Metric{
+					{
+						Name:    "statsErrors",
+						Oid:     "1.2.2",
+						Help:    " - 1.2.2",
+						Type:    "counter",
+						Indexes: []*config.Index{{Labelname: "ifDescr", Type: "gauge"}},
+						Lookups: []*config.Lookup{
+							{
+								Labels:      []string{"ifDescr"},
+								Labelname:   "ifDescr",
+								Type:        "OctetString",
+								Oid:         "1.1.2",
+								IndexOffset: -1000000,
+							},
+						},
+					},
+				},
+			},
+		},
+		// Validate metric names.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "digital-sen1-1", Hint: "1x:"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name:    "digital_sen1_1",
+						Oid:     "1.1",
+						Type:    "PhysAddress48",
+						Help:    " - 1.1",
+						Indexes: []*config.Index{},
+						Lookups: []*config.Lookup{},
+					},
+				},
+			},
+		},
+		// Validate label names.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "octet",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "octet-Entry", Indexes: []string{"octet&Index"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octet&Index", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octet*Desc", Type: "OCTETSTR"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octet^Foo", Type: "INTEGER"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"octet^Foo"},
+				Lookups: []*Lookup{
+					{
+						OldIndex: "octet&Index",
+						NewIndex: "1.1.1.2",
+					},
+				},
+			},
+			out: &config.Module{
+				// Walk is expanded to include the lookup OID.
+				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name: "octet_Foo",
+						Oid:  "1.1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.1.3",
+						Indexes: []*config.Index{
+							{
+								Labelname: "octet_Desc",
+								Type:      "gauge",
+							},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"octet_Desc"},
+								Labelname: "octet_Desc",
+								Type:      "OctetString",
+								Oid:       "1.1.1.2",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Enum type overrides.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "enumInfo", Type: "INTEGER", EnumValues: map[int]string{1: "up", 2: "down"}},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "enumStateSet", Type: "INTEGER", EnumValues: map[int]string{1: "on", 2: "off"}},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "enumGauge", Type: "INTEGER", EnumValues: map[int]string{1: "a", 2: "b"}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"enumInfo":     MetricOverrides{Type: "EnumAsInfo"},
+					"enumStateSet": MetricOverrides{Type: "EnumAsStateSet"},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0", "1.3.0"},
+				Metrics: []*config.Metric{
+					{
+						Name:       "enumInfo",
+						Oid:        "1.1",
+						Type:       "EnumAsInfo",
+						Help:       " - 1.1",
+						EnumValues: map[int]string{1: "up", 2: "down"},
+					},
+					{
+						Name:       "enumStateSet",
+						Oid:        "1.2",
+						Type:       "EnumAsStateSet",
+						Help:       " - 1.2",
+						EnumValues: map[int]string{1: "on", 2: "off"},
+					},
+					{
+						Name: "enumGauge",
+						Oid:  "1.3",
+						Type: "gauge",
+						Help: " - 1.3",
+					},
+				},
+			},
+		},
+		// Metric type overrides.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "stringNumber", Type: "OCTETSTR"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "gaugeCounter", Type: "GAUGE"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"stringNumber": MetricOverrides{Type: "gauge"},
+					"1.2":          MetricOverrides{Type: "counter"},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "stringNumber",
+						Oid:  "1.1",
+						Type: "gauge",
+						Help: " - 1.1",
+					},
+					{
+						Name: "gaugeCounter",
+						Oid:  "1.2",
+						Type: "counter",
+						Help: " - 1.2",
+					},
+				},
+			},
+		},
+		// Ignored metrics are dropped and not walked.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry",
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "tableA", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableB", Type: "INTEGER"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "tableC", Type: "INTEGER"},
+									{Oid: "1.1.1.4", Access: "ACCESS_READONLY", Label: "tableD", Type: "INTEGER"},
+								}}}},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "scalar", Type: "INTEGER"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Overrides: map[string]MetricOverrides{
+					"tableB":  MetricOverrides{Ignore: true},
+					"1.1.1.3": MetricOverrides{Ignore: true},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.1.1.0", "1.1.1.4.0", "1.2.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "tableA",
+						Oid:  "1.1.1.1",
+						Type: "gauge",
+						Help: " - 1.1.1.1",
+					},
+					{
+						Name: "tableD",
+						Oid:  "1.1.1.4",
+						Type: "gauge",
+						Help: " - 1.1.1.4",
+					},
+					{
+						Name: "scalar",
+						Oid:  "1.2",
+						Type: "gauge",
+						Help: " - 1.2",
+					},
+				},
+			},
+		},
+		// Static filters are resolved, and their targets walked on their own.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "tableIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+								}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Filters: config.Filters{
+					Static: []config.StaticFilter{
+						{
+							Targets: []string{"tableFoo"},
+							Indices: []string{"1", "5"},
+						},
+					},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.1", "1.1.1.2"},
+				Metrics: []*config.Metric{
+					{
+						Name:    "tableIndex",
+						Oid:     "1.1.1.1",
+						Type:    "gauge",
+						Help:    " - 1.1.1.1",
+						Indexes: []*config.Index{{Labelname: "tableIndex", Type: "gauge"}},
+					},
+					{
+						Name:    "tableFoo",
+						Oid:     "1.1.1.2",
+						Type:    "gauge",
+						Help:    " - 1.1.1.2",
+						Indexes: []*config.Index{{Labelname: "tableIndex", Type: "gauge"}},
+					},
+				},
+				Filters: config.Filters{
+					Static: []config.StaticFilter{
+						{
+							Targets: []string{"1.1.1.2"},
+							Indices: []string{"1", "5"},
+						},
+					},
+				},
+			},
+		},
+		// Dynamic filters are resolved, and their targets walked on their own.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "tableIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "tableStatus", Type: "INTEGER"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+								}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				Filters: config.Filters{
+					Dynamic: []config.DynamicFilter{
+						{
+							Oid:     "tableStatus",
+							Targets: []string{"tableFoo"},
+							Values:  []string{"1"},
+						},
+					},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.1", "1.1.1.2", "1.1.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name:    "tableIndex",
+						Oid:     "1.1.1.1",
+						Type:    "gauge",
+						Help:    " - 1.1.1.1",
+						Indexes: []*config.Index{{Labelname: "tableIndex", Type: "gauge"}},
+					},
+					{
+						Name:    "tableStatus",
+						Oid:     "1.1.1.2",
+						Type:    "gauge",
+						Help:    " - 1.1.1.2",
+						Indexes: []*config.Index{{Labelname: "tableIndex", Type: "gauge"}},
+					},
+					{
+						Name:    "tableFoo",
+						Oid:     "1.1.1.3",
+						Type:    "gauge",
+						Help:    " - 1.1.1.3",
+						Indexes: []*config.Index{{Labelname: "tableIndex", Type: "gauge"}},
+					},
+				},
+				Filters: config.Filters{
+					Dynamic: []config.DynamicFilter{
+						{
+							Oid:     "1.1.1.2",
+							Targets: []string{"1.1.1.3"},
+							Values:  []string{"1"},
+						},
+					},
+				},
+			},
+		},
+		// Chained lookups.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "entity",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "entityEntry", Indexes: []string{"entityIndex"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "entityIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "entityContainedIn", Type: "INTEGER"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "entityName", Type: "OCTETSTR", Hint: "255a"},
+									{Oid: "1.1.1.4", Access: "ACCESS_READONLY", Label: "entityFoo", Type: "INTEGER"}}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"entityFoo"},
+				Lookups: []*Lookup{
+					{
+						OldIndex: "entityIndex",
+						NewIndex: "entityContainedIn",
+					},
+					{
+						OldIndex: "entityContainedIn",
+						NewIndex: "entityName",
+					},
+				},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.2", "1.1.1.3", "1.1.1.4"},
+				Metrics: []*config.Metric{
+					{
+						Name: "entityFoo",
+						Oid:  "1.1.1.4",
+						Help: " - 1.1.1.4",
+						Type: "gauge",
+						Indexes: []*config.Index{
+							{
+								Labelname: "entityContainedIn",
+								Type:      "gauge",
+							},
+						},
+						Lookups: []*config.Lookup{
+							{
+								Labels:    []string{"entityContainedIn"},
+								Labelname: "entityContainedIn",
+								Type:      "gauge",
+								Oid:       "1.1.1.2",
+								Lookups: []*config.Lookup{
+									{
+										Labelname: "entityName",
+										Type:      "DisplayString",
+										Oid:       "1.1.1.3",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		// Units in help and names.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "uptime", Type: "GAUGE", Units: "seconds"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "inOctets", Type: "COUNTER", Units: "octets"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "runTimeSeconds", Type: "GAUGE", Units: "seconds"},
+					{Oid: "1.4", Access: "ACCESS_READONLY", Label: "delay", Type: "GAUGE", Units: "hundredths of a second"},
+					{Oid: "1.5", Access: "ACCESS_READONLY", Label: "name", Type: "OCTETSTR", Units: "bytes"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:         []string{"root"},
+				UnitSuffixes: true,
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0", "1.3.0", "1.4.0", "1.5.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "uptime_seconds",
+						Oid:  "1.1",
+						Type: "gauge",
+						Help: " (units: seconds) - 1.1",
+					},
+					{
+						Name: "inOctets_bytes",
+						Oid:  "1.2",
+						Type: "counter",
+						Help: " (units: octets) - 1.2",
+					},
+					{
+						Name: "runTimeSeconds",
+						Oid:  "1.3",
+						Type: "gauge",
+						Help: " (units: seconds) - 1.3",
+					},
+					{
+						Name: "delay",
+						Oid:  "1.4",
+						Type: "gauge",
+						Help: " (units: hundredths of a second) - 1.4",
+					},
+					{
+						Name: "name",
+						Oid:  "1.5",
+						Type: "OctetString",
+						Help: " (units: bytes) - 1.5",
+					},
+				},
+			},
+		},
+		// Units only in help with unit suffixes off.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Label: "uptime", Type: "GAUGE", Units: "seconds"},
+			cfg: &ModuleConfig{
+				Walk: []string{"uptime"},
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "uptime",
+						Oid:  "1",
+						Type: "gauge",
+						Help: " (units: seconds) - 1",
+					},
+				},
+			},
+		},
+		// Descriptions trimmed to the first sentence by default.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Label: "root", Type: "INTEGER", Description: "A long   sentance.      Even more detail!"},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "root",
+						Oid:  "1",
+						Type: "gauge",
+						Help: "A long sentance - 1",
+					},
+				},
+			},
+		},
+		// Full descriptions.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Label: "root", Type: "INTEGER", Description: "A long   sentance.      Even more detail!"},
+			cfg: &ModuleConfig{
+				Walk:            []string{"root"},
+				HelpDescription: "full",
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "root",
+						Oid:  "1",
+						Type: "gauge",
+						Help: "A long sentance. Even more detail! - 1",
+					},
+				},
+			},
+		},
+		// Version and auth are copied over.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Label: "root", Type: "INTEGER"},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+				WalkParams: config.WalkParams{
+					Version:        3,
+					MaxRepetitions: 10,
+					Retries:        1,
+					Timeout:        time.Second,
+					Auth: config.Auth{
+						SecurityLevel: "authPriv",
+						Username:      "user",
+						Password:      "CHANGEME",
+						AuthProtocol:  "SHA",
+						PrivProtocol:  "AES256",
+						PrivPassword:  "CHANGEME",
+					},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				WalkParams: config.WalkParams{
+					Version:        3,
+					MaxRepetitions: 10,
+					Retries:        1,
+					Timeout:        time.Second,
+					Auth: config.Auth{
+						SecurityLevel: "authPriv",
+						Username:      "user",
+						Password:      "CHANGEME",
+						AuthProtocol:  "SHA",
+						PrivProtocol:  "AES256",
+						PrivPassword:  "CHANGEME",
+					},
+				},
+				Metrics: []*config.Metric{
+					{
+						Name: "root",
+						Oid:  "1",
+						Type: "gauge",
+						Help: " - 1",
+					},
+				},
+			},
+		},
+		// Excluded subtrees are not walked.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "foo", Type: "INTEGER"},
+					{Oid: "1.2", Label: "table",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "tableEntry", Indexes: []string{"tableIndex"},
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "tableIndex", Type: "INTEGER"},
+								}}}},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "bar", Type: "INTEGER"},
+				}},
+			cfg: &ModuleConfig{
+				Walk:    []string{"root"},
+				Exclude: []string{"table", "1.3"},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "foo",
+						Oid:  "1.1",
+						Type: "gauge",
+						Help: " - 1.1",
+					},
+				},
+			},
+		},
+		// Scalars to get.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "system",
+						Children: []*Node{
+							{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "DisplayString"},
+							{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS"},
+						}},
+					{Oid: "1.2", Label: "other",
+						Children: []*Node{
+							{Oid: "1.2.1", Access: "ACCESS_READONLY", Label: "otherScalar", Type: "INTEGER"},
+							{Oid: "1.2.2", Access: "ACCESS_READONLY", Label: "otherFoo", Type: "INTEGER"},
+						}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"other"},
+				Get:  []string{"sysUpTime", "1.1.1.0", "sysUpTime.0", "otherScalar"},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.3.0", "1.1.1.0", "1.2.1.0", "1.2.2.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "otherScalar",
+						Oid:  "1.2.1",
+						Type: "gauge",
+						Help: " - 1.2.1",
+					},
+					{
+						Name: "otherFoo",
+						Oid:  "1.2.2",
+						Type: "gauge",
+						Help: " - 1.2.2",
+					},
+					{
+						Name: "sysUpTime",
+						Oid:  "1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.3",
+					},
+					{
+						Name: "sysDescr",
+						Oid:  "1.1.1",
+						Type: "DisplayString",
+						Help: " - 1.1.1",
+					},
+				},
+			},
+		},
+		// Walked scalars are got instead with SNMP v2 and v3.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "system",
+						Children: []*Node{
+							{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "DisplayString"},
+							{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS"},
+						}},
+					{Oid: "1.2", Label: "ifTable",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
 								Children: []*Node{
-									{Oid: "1.4.1.1", Access: "ACCESS_READONLY", Label: "netaddrIndex", Type: "NETADDR"},
-									{Oid: "1.4.1.2", Access: "ACCESS_READONLY", Label: "netaddrFoo", Type: "INTEGER"}}}}},
-					{Oid: "1.5", Label: "physaddress48",
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER", Indexes: []string{"ifIndex"}},
+								}},
+						}},
+				}},
+			cfg: &ModuleConfig{
+				Walk:       []string{"system", "ifTable"},
+				WalkParams: config.WalkParams{Version: 2},
+			},
+			out: &config.Module{
+				Walk:       []string{"1.2"},
+				Get:        []string{"1.1.1.0", "1.1.3.0"},
+				WalkParams: config.WalkParams{Version: 2},
+				Metrics: []*config.Metric{
+					{
+						Name: "sysDescr",
+						Oid:  "1.1.1",
+						Type: "DisplayString",
+						Help: " - 1.1.1",
+					},
+					{
+						Name: "sysUpTime",
+						Oid:  "1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.3",
+					},
+					{
+						Name: "ifIndex",
+						Oid:  "1.2.1.1",
+						Type: "gauge",
+						Help: " - 1.2.1.1",
+						Indexes: []*config.Index{
+							{
+								Labelname: "ifIndex",
+								Type:      "gauge",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Walked scalars are also got without a version, as the default is v2.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "system",
 						Children: []*Node{
-							{Oid: "1.5.1", Label: "physaddress48Entry", Indexes: []string{"physaddress48Index"},
+							{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "DisplayString"},
+							{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS"},
+						}},
+					{Oid: "1.2", Label: "ifTable",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
 								Children: []*Node{
-									{Oid: "1.5.1.1", Access: "ACCESS_READONLY", Label: "physaddress48Index", Type: "OCTETSTR", Hint: "1x:"},
-									{Oid: "1.5.1.2", Access: "ACCESS_READONLY", Label: "physaddress48Foo", Type: "INTEGER"}}}}},
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER", Indexes: []string{"ifIndex"}},
+								}},
+						}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"system", "ifTable"},
+			},
+			out: &config.Module{
+				Walk: []string{"1.2"},
+				Get:  []string{"1.1.1.0", "1.1.3.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "sysDescr",
+						Oid:  "1.1.1",
+						Type: "DisplayString",
+						Help: " - 1.1.1",
+					},
+					{
+						Name: "sysUpTime",
+						Oid:  "1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.3",
+					},
+					{
+						Name: "ifIndex",
+						Oid:  "1.2.1.1",
+						Type: "gauge",
+						Help: " - 1.2.1.1",
+						Indexes: []*config.Index{
+							{
+								Labelname: "ifIndex",
+								Type:      "gauge",
+							},
+						},
+					},
+				},
+			},
+		},
+		// Walked scalars are still walked with SNMP v1.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "system",
+						Children: []*Node{
+							{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "sysDescr", Type: "DisplayString"},
+							{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "sysUpTime", Type: "TIMETICKS"},
+						}},
+					{Oid: "1.2", Label: "ifTable",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER", Indexes: []string{"ifIndex"}},
+								}},
+						}},
+				}},
+			cfg: &ModuleConfig{
+				Walk:       []string{"system", "ifTable"},
+				WalkParams: config.WalkParams{Version: 1},
+			},
+			out: &config.Module{
+				Walk:       []string{"1.1", "1.2"},
+				WalkParams: config.WalkParams{Version: 1},
+				Metrics: []*config.Metric{
+					{
+						Name: "sysDescr",
+						Oid:  "1.1.1",
+						Type: "DisplayString",
+						Help: " - 1.1.1",
+					},
+					{
+						Name: "sysUpTime",
+						Oid:  "1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.3",
+					},
+					{
+						Name: "ifIndex",
+						Oid:  "1.2.1.1",
+						Type: "gauge",
+						Help: " - 1.2.1.1",
+						Indexes: []*config.Index{
+							{
+								Labelname: "ifIndex",
+								Type:      "gauge",
+							},
+						},
+					},
+				},
+			},
+		},
+		// BITS with named bits.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "bits", Type: "BITSTRING", EnumValues: map[int]string{0: "a", 1: "b"}},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "unnamed", Type: "BITSTRING", EnumValues: map[int]string{}},
 				}},
 			cfg: &ModuleConfig{
 				Walk: []string{"1"},
 			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0"},
+				Metrics: []*config.Metric{
+					{
+						Name:       "bits",
+						Oid:        "1.1",
+						Type:       "Bits",
+						Help:       " - 1.1",
+						EnumValues: map[int]string{0: "a", 1: "b"},
+					},
+					{
+						Name: "unnamed",
+						Oid:  "1.2",
+						Type: "OctetString",
+						Help: " - 1.2",
+					},
+				},
+			},
+		},
+		// RFC 4001 InetAddress indexes.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableAddrType", "tableAddr"},
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "tableAddrType", Type: "INTEGER", TextualConvention: "InetAddressType"},
+									{Oid: "1.1.1.2", Access: "ACCESS_NOACCESS", Label: "tableAddr", Type: "OCTETSTR", TextualConvention: "InetAddress"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+								}}}},
+					{Oid: "1.2", Label: "other",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "otherEntry", Indexes: []string{"otherAddr"},
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_NOACCESS", Label: "otherAddr", Type: "OCTETSTR", TextualConvention: "InetAddress"},
+									{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "otherV6", Type: "OCTETSTR", TextualConvention: "InetAddressIPv6"},
+								}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"1"},
+			},
 			out: &config.Module{
 				Walk: []string{"1"},
 				Metrics: []*config.Metric{
 					{
-						Name: "octetIndex",
+						Name: "tableAddrType",
 						Oid:  "1.1.1.1",
+						Type: "gauge",
 						Help: " - 1.1.1.1",
-						Type: "OctetString",
 						Indexes: []*config.Index{
 							{
-								Labelname: "octetIndex",
-								Type:      "OctetString",
+								Labelname: "tableAddrType",
+								Type:      "InetAddressType",
+							},
+							{
+								Labelname: "tableAddr",
+								Type:      "InetAddress",
 							},
 						},
 					},
 					{
-						Name: "octetFoo",
+						Name: "tableAddr",
 						Oid:  "1.1.1.2",
+						Type: "InetAddress",
 						Help: " - 1.1.1.2",
+						Indexes: []*config.Index{
+							{
+								Labelname: "tableAddrType",
+								Type:      "InetAddressType",
+							},
+							{
+								Labelname: "tableAddr",
+								Type:      "InetAddress",
+							},
+						},
+					},
+					{
+						Name: "tableFoo",
+						Oid:  "1.1.1.3",
 						Type: "gauge",
+						Help: " - 1.1.1.3",
 						Indexes: []*config.Index{
 							{
-								Labelname: "octetIndex",
-								Type:      "OctetString",
+								Labelname: "tableAddrType",
+								Type:      "InetAddressType",
+							},
+							{
+								Labelname: "tableAddr",
+								Type:      "InetAddress",
 							},
 						},
 					},
 					{
-						Name: "bitstringIndex",
+						Name: "otherAddr",
 						Oid:  "1.2.1.1",
+						Type: "InetAddress",
 						Help: " - 1.2.1.1",
-						Type: "OctetString",
 						Indexes: []*config.Index{
 							{
-								Labelname: "bitstringIndex",
+								Labelname: "otherAddr",
 								Type:      "OctetString",
 							},
 						},
 					},
 					{
-						Name: "bitstringFoo",
+						Name: "otherV6",
 						Oid:  "1.2.1.2",
+						Type: "InetAddressIPv6",
 						Help: " - 1.2.1.2",
-						Type: "gauge",
 						Indexes: []*config.Index{
 							{
-								Labelname: "bitstringIndex",
+								Labelname: "otherAddr",
 								Type:      "OctetString",
 							},
 						},
 					},
+				},
+			},
+		},
+		// IMPLIED and fixed size indexes.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "table",
+						Children: []*Node{
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"tableId", "tableName"}, ImpliedIndex: true,
+								Children: []*Node{
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "tableId", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_NOACCESS", Label: "tableName", Type: "DisplayString"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "tableFoo", Type: "INTEGER"},
+								}}}},
+					{Oid: "1.2", Label: "other",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "otherEntry", Indexes: []string{"otherId"}, ImpliedIndex: true,
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_READONLY", Label: "otherId", Type: "INTEGER"},
+								}}}},
+					{Oid: "1.3", Label: "fixed",
+						Children: []*Node{
+							{Oid: "1.3.1", Label: "fixedEntry", Indexes: []string{"fixedName", "fixedId"}, ImpliedIndex: true,
+								Children: []*Node{
+									{Oid: "1.3.1.1", Access: "ACCESS_NOACCESS", Label: "fixedName", Type: "OCTETSTR", FixedSize: 8},
+									{Oid: "1.3.1.2", Access: "ACCESS_NOACCESS", Label: "fixedId", Type: "OCTETSTR", FixedSize: 4},
+									{Oid: "1.3.1.3", Access: "ACCESS_READONLY", Label: "fixedFoo", Type: "INTEGER"},
+								}}}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"tableFoo", "otherId", "fixedFoo"},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.1.3", "1.2.1.1", "1.3.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name: "tableFoo",
+						Oid:  "1.1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.1.3",
+						Indexes: []*config.Index{
+							{
+								Labelname: "tableId",
+								Type:      "gauge",
+							},
+							{
+								Labelname: "tableName",
+								Type:      "DisplayString",
+								Implied:   true,
+							},
+						},
+					},
 					{
-						Name: "ipaddrIndex",
-						Oid:  "1.3.1.1",
-						Help: " - 1.3.1.1",
-						Type: "IpAddr",
+						Name: "otherId",
+						Oid:  "1.2.1.1",
+						Type: "gauge",
+						Help: " - 1.2.1.1",
 						Indexes: []*config.Index{
 							{
-								Labelname: "ipaddrIndex",
-								Type:      "IpAddr",
+								Labelname: "otherId",
+								Type:      "gauge",
 							},
 						},
 					},
 					{
-						Name: "ipaddrFoo",
-						Oid:  "1.3.1.2",
-						Help: " - 1.3.1.2",
+						Name: "fixedFoo",
+						Oid:  "1.3.1.3",
+						Type: "gauge",
+						Help: " - 1.3.1.3",
+						Indexes: []*config.Index{
+							{
+								Labelname: "fixedName",
+								Type:      "OctetString",
+								FixedSize: 8,
+							},
+							{
+								Labelname: "fixedId",
+								Type:      "OctetString",
+								FixedSize: 4,
+							},
+						},
+					},
+				},
+			},
+		},
+		// DISPLAY-HINTs of string indexes.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "peerEntry", Indexes: []string{"peerAddr", "peerName"},
+						Children: []*Node{
+							{Oid: "1.1.1", Access: "ACCESS_NOACCESS", Label: "peerAddr", Type: "OCTETSTR", Hint: "1d.1d.1d.1d", FixedSize: 4},
+							{Oid: "1.1.2", Access: "ACCESS_NOACCESS", Label: "peerName", Type: "OCTETSTR", Hint: "255a"},
+							{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "peerState", Type: "INTEGER"},
+						}}}},
+			cfg: &ModuleConfig{
+				Walk: []string{"peerState"},
+			},
+			out: &config.Module{
+				Walk: []string{"1.1.3"},
+				Metrics: []*config.Metric{
+					{
+						Name: "peerState",
+						Oid:  "1.1.3",
+						Type: "gauge",
+						Help: " - 1.1.3",
+						Indexes: []*config.Index{
+							{Labelname: "peerAddr", Type: "OctetString", FixedSize: 4, DisplayHint: "1d.1d.1d.1d"},
+							{Labelname: "peerName", Type: "DisplayString"},
+						},
+					},
+				},
+			},
+		},
+		// Metric names colliding after sanitization.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "foo-bar", Type: "INTEGER"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "foo_bar", Type: "INTEGER"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "baz-qux", Type: "INTEGER"},
+					{Oid: "1.4", Access: "ACCESS_READONLY", Label: "baz.qux", Type: "INTEGER"},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"root"},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0", "1.3.0", "1.4.0"},
+				Metrics: []*config.Metric{
+					{
+						Name: "foo_bar_1_1",
+						Oid:  "1.1",
 						Type: "gauge",
-						Indexes: []*config.Index{
-							{
-								Labelname: "ipaddrIndex",
-								Type:      "IpAddr",
-							},
-						},
+						Help: " - 1.1",
 					},
 					{
-						Name: "netaddrIndex",
-						Oid:  "1.4.1.1",
-						Help: " - 1.4.1.1",
-						Type: "InetAddress",
-						Indexes: []*config.Index{
-							{
-								Labelname: "netaddrIndex",
-								Type:      "InetAddress",
-							},
-						},
+						Name: "foo_bar",
+						Oid:  "1.2",
+						Type: "gauge",
+						Help: " - 1.2",
 					},
 					{
-						Name: "netaddrFoo",
-						Oid:  "1.4.1.2",
-						Help: " - 1.4.1.2",
+						Name: "baz_qux",
+						Oid:  "1.3",
 						Type: "gauge",
-						Indexes: []*config.Index{
-							{
-								Labelname: "netaddrIndex",
-								Type:      "InetAddress",
-							},
-						},
+						Help: " - 1.3",
 					},
 					{
-						Name: "physaddress48Index",
-						Oid:  "1.5.1.1",
-						Help: " - 1.5.1.1",
-						Type: "PhysAddress48",
-						Indexes: []*config.Index{
-							{
-								Labelname: "physaddress48Index",
-								Type:      "PhysAddress48",
-							},
-						},
+						Name: "baz_qux_1_4",
+						Oid:  "1.4",
+						Type: "gauge",
+						Help: " - 1.4",
 					},
+				},
+			},
+		},
+		// Descriptions limited in length.
+		{
+			node: &Node{Oid: "1", Access: "ACCESS_READONLY", Label: "root", Type: "INTEGER", Description: "A long   sentance.      Even more detail!"},
+			cfg: &ModuleConfig{
+				Walk:            []string{"root"},
+				HelpDescription: "full",
+				HelpMaxLength:   20,
+			},
+			out: &config.Module{
+				Get: []string{"1.0"},
+				Metrics: []*config.Metric{
 					{
-						Name: "physaddress48Foo",
-						Oid:  "1.5.1.2",
-						Help: " - 1.5.1.2",
+						Name: "root",
+						Oid:  "1",
 						Type: "gauge",
-						Indexes: []*config.Index{
-							{
-								Labelname: "physaddress48Index",
-								Type:      "PhysAddress48",
-							},
-						},
+						Help: "A long sentance. Eve... - 1",
 					},
 				},
 			},
 		},
-		// One table lookup, lookup not walked.
+		// Indexes joined into one label.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Label: "octet",
+					{Oid: "1.1", Label: "table",
 						Children: []*Node{
-							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
+							{Oid: "1.1.1", Label: "tableEntry", Indexes: []string{"ifIndex", "address"},
 								Children: []*Node{
-									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "INTEGER"},
-									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetDesc", Type: "OCTETSTR"},
-									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}}}},
+									{Oid: "1.1.1.1", Access: "ACCESS_NOACCESS", Label: "ifIndex", Type: "INTEGER"},
+									{Oid: "1.1.1.2", Access: "ACCESS_NOACCESS", Label: "address", Type: "IPADDR"},
+									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "value", Type: "INTEGER"},
+								}},
+						}},
+				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"octetFoo"},
-				Lookups: []*Lookup{
-					{
-						OldIndex: "octetIndex",
-						NewIndex: "octetDesc",
-					},
+				Walk: []string{"value"},
+				JoinIndexes: []*JoinIndexes{
+					{Indexes: []string{"ifIndex", "address"}, Labelname: "if_address", Separator: "/"},
 				},
 			},
 			out: &config.Module{
-				// Walk is expanded to include the lookup OID.
-				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Walk: []string{"1.1.1.3"},
 				Metrics: []*config.Metric{
 					{
-						Name: "octetFoo",
+						Name: "value",
 						Oid:  "1.1.1.3",
-						Help: " - 1.1.1.3",
 						Type: "gauge",
+						Help: " - 1.1.1.3",
 						Indexes: []*config.Index{
 							{
-								Labelname: "octetDesc",
+								Labelname: "ifIndex",
 								Type:      "gauge",
 							},
+							{
+								Labelname: "address",
+								Type:      "IpAddr",
+							},
 						},
-						Lookups: []*config.Lookup{
+						JoinIndexes: []*config.JoinIndexes{
 							{
-								Labels:    []string{"octetDesc"},
-								Labelname: "octetDesc",
-								Type:      "OctetString",
-								Oid:       "1.1.1.2",
+								Labels:    []string{"ifIndex", "address"},
+								Labelname: "if_address",
+								Separator: "/",
 							},
 						},
 					},
 				},
 			},
 		},
-		// Lookup via OID.
+		// Metric names can be prefixed.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Label: "octet",
-						Children: []*Node{
-							{Oid: "1.1.1", Label: "octetEntry", Indexes: []string{"octetIndex"},
-								Children: []*Node{
-									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octetIndex", Type: "INTEGER"},
-									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octetDesc", Type: "OCTETSTR"},
-									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octetFoo", Type: "INTEGER"}}}}}}},
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "temperature", Type: "INTEGER", Module: "CISCO-ENVMON-MIB"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "uptime", Type: "INTEGER", Units: "seconds", Module: "VENDOR"},
+				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"octetFoo"},
-				Lookups: []*Lookup{
+				Walk:           []string{"root"},
+				Prefix:         "dc1_",
+				NamespaceByMIB: true,
+				UnitSuffixes:   true,
+				Overrides: map[string]MetricOverrides{
+					"temperature": MetricOverrides{Type: "counter"},
+				},
+			},
+			out: &config.Module{
+				Get: []string{"1.1.0", "1.2.0"},
+				Metrics: []*config.Metric{
 					{
-						OldIndex: "octetIndex",
-						NewIndex: "1.1.1.2",
+						Name: "dc1_cisco_envmon_temperature",
+						Oid:  "1.1",
+						Type: "counter",
+						Help: " - 1.1",
+					},
+					{
+						Name: "dc1_vendor_uptime_seconds",
+						Oid:  "1.2",
+						Type: "gauge",
+						Help: " (units: seconds) - 1.2",
 					},
 				},
 			},
+		},
+		// Walking the groups of a MODULE-COMPLIANCE.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "scalar", Type: "INTEGER"},
+					{Oid: "1.2", Label: "fullTable",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "fullEntry", Indexes: []string{"fullIndex"},
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_NOACCESS", Label: "fullIndex", Type: "INTEGER"},
+									{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "fullColumn", Type: "INTEGER"},
+								}},
+						}},
+					{Oid: "1.3", Label: "partTable",
+						Children: []*Node{
+							{Oid: "1.3.1", Label: "partEntry", Indexes: []string{"partIndex"},
+								Children: []*Node{
+									{Oid: "1.3.1.1", Access: "ACCESS_READONLY", Label: "partIndex", Type: "INTEGER"},
+									{Oid: "1.3.1.2", Access: "ACCESS_READONLY", Label: "partColumn", Type: "INTEGER"},
+								}},
+						}},
+					{Oid: "1.4", Label: "scalarGroup", Type: "OBJGROUP", Members: []string{"scalar", "fullColumn"}},
+					{Oid: "1.5", Label: "partGroup", Type: "OBJGROUP", Members: []string{"partColumn"}},
+					{Oid: "1.6", Label: "compliance", Type: "MODCOMP", Members: []string{"scalarGroup", "partGroup"}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"compliance"},
+			},
 			out: &config.Module{
-				// Walk is expanded to include the lookup OID.
-				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Walk: []string{"1.2", "1.3.1.2"},
+				Get:  []string{"1.1.0"},
 				Metrics: []*config.Metric{
 					{
-						Name: "octetFoo",
-						Oid:  "1.1.1.3",
-						Help: " - 1.1.1.3",
+						Name: "scalar",
+						Oid:  "1.1",
+						Type: "gauge",
+						Help: " - 1.1",
+					},
+					{
+						Name: "fullIndex",
+						Oid:  "1.2.1.1",
 						Type: "gauge",
+						Help: " - 1.2.1.1",
 						Indexes: []*config.Index{
 							{
-								Labelname: "octetDesc",
+								Labelname: "fullIndex",
 								Type:      "gauge",
 							},
 						},
-						Lookups: []*config.Lookup{
+					},
+					{
+						Name: "fullColumn",
+						Oid:  "1.2.1.2",
+						Type: "gauge",
+						Help: " - 1.2.1.2",
+						Indexes: []*config.Index{
 							{
-								Labels:    []string{"octetDesc"},
-								Labelname: "octetDesc",
-								Type:      "OctetString",
-								Oid:       "1.1.1.2",
+								Labelname: "fullIndex",
+								Type:      "gauge",
+							},
+						},
+					},
+					{
+						Name: "partColumn",
+						Oid:  "1.3.1.2",
+						Type: "gauge",
+						Help: " - 1.3.1.2",
+						Indexes: []*config.Index{
+							{
+								Labelname: "partIndex",
+								Type:      "gauge",
 							},
 						},
 					},
 				},
 			},
 		},
-		// Validate metric names.
+		// Deprecated and obsolete objects can be skipped.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "digital-sen1-1", Hint: "1x:"},
+					{Oid: "1.1", Access: "ACCESS_READONLY", Label: "current", Type: "INTEGER", Status: "current"},
+					{Oid: "1.2", Access: "ACCESS_READONLY", Label: "deprecated", Type: "INTEGER", Status: "deprecated"},
+					{Oid: "1.3", Access: "ACCESS_READONLY", Label: "obsolete", Type: "INTEGER", Status: "obsolete"},
 				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"root"},
+				Walk:           []string{"root"},
+				SkipDeprecated: &skipDeprecated,
 			},
 			out: &config.Module{
-				Walk: []string{"1"},
+				Get: []string{"1.1.0"},
 				Metrics: []*config.Metric{
 					{
-						Name:    "digital_sen1_1",
-						Oid:     "1.1",
-						Type:    "PhysAddress48",
-						Help:    " - 1.1",
-						Indexes: []*config.Index{},
-						Lookups: []*config.Lookup{},
+						Name: "current",
+						Oid:  "1.1",
+						Type: "gauge",
+						Help: " - 1.1",
 					},
 				},
 			},
 		},
-		// Validate label names.
+		// Notifications are described for decoding traps.
 		{
 			node: &Node{Oid: "1", Label: "root",
 				Children: []*Node{
-					{Oid: "1.1", Label: "octet",
-						Children: []*Node{
-							{Oid: "1.1.1", Label: "octet-Entry", Indexes: []string{"octet&Index"},
-								Children: []*Node{
-									{Oid: "1.1.1.1", Access: "ACCESS_READONLY", Label: "octet&Index", Type: "INTEGER"},
-									{Oid: "1.1.1.2", Access: "ACCESS_READONLY", Label: "octet*Desc", Type: "OCTETSTR"},
-									{Oid: "1.1.1.3", Access: "ACCESS_READONLY", Label: "octet^Foo", Type: "INTEGER"}}}}}}},
+					{Oid: "1.1", Access: "ACCESS_NOTIFY", Label: "ifIndex", Type: "INTEGER"},
+					{Oid: "1.2", Access: "ACCESS_NOTIFY", Label: "ifOperStatus", Type: "INTEGER", EnumValues: map[int]string{1: "up", 2: "down"}},
+					{Oid: "1.3", Label: "linkDown", Type: "NOTIFTYPE", Description: "A link went down.", Module: "IF-MIB",
+						Varbinds: []string{"ifIndex", "ifOperStatus"}},
+					{Oid: "1.4", Label: "linkUp", Type: "NOTIFTYPE", Module: "IF-MIB"},
+				}},
 			cfg: &ModuleConfig{
-				Walk: []string{"octet^Foo"},
-				Lookups: []*Lookup{
+				Notifications:      []string{"linkDown", "IF-MIB"},
+				NotificationLabels: []string{"ifIndex"},
+			},
+			out: &config.Module{
+				Notifications: []*config.Notification{
 					{
-						OldIndex: "octet&Index",
-						NewIndex: "1.1.1.2",
+						Name: "linkDown",
+						Oid:  "1.3",
+						Help: "A link went down. - 1.3",
+						Varbinds: []*config.Varbind{
+							{Name: "ifIndex", Oid: "1.1", Type: "gauge", Label: true},
+							{Name: "ifOperStatus", Oid: "1.2", Type: "gauge", EnumValues: map[int]string{1: "up", 2: "down"}},
+						},
+					},
+					{
+						Name: "linkUp",
+						Oid:  "1.4",
+						Help: " - 1.4",
 					},
 				},
 			},
+		},
+		// Walking a MIB module by name.
+		{
+			node: &Node{Oid: "1", Label: "root",
+				Children: []*Node{
+					{Oid: "1.1", Label: "testGroup", Module: "TEST-MIB",
+						Children: []*Node{
+							{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "testScalar", Type: "INTEGER", Module: "TEST-MIB"},
+						}},
+					{Oid: "1.2", Label: "testTable", Module: "TEST-MIB",
+						Children: []*Node{
+							{Oid: "1.2.1", Label: "testEntry", Indexes: []string{"testIndex"}, Module: "TEST-MIB",
+								Children: []*Node{
+									{Oid: "1.2.1.1", Access: "ACCESS_NOACCESS", Label: "testIndex", Type: "INTEGER", Module: "TEST-MIB"},
+									{Oid: "1.2.1.2", Access: "ACCESS_READONLY", Label: "testColumn", Type: "INTEGER", Module: "TEST-MIB"},
+								}},
+						}},
+					{Oid: "1.3", Label: "mixedGroup", Module: "TEST-MIB",
+						Children: []*Node{
+							{Oid: "1.3.1", Access: "ACCESS_READONLY", Label: "mixedScalar", Type: "INTEGER", Module: "TEST-MIB"},
+							{Oid: "1.3.2", Access: "ACCESS_READONLY", Label: "otherScalar", Type: "INTEGER", Module: "OTHER-MIB"},
+						}},
+				}},
+			cfg: &ModuleConfig{
+				Walk: []string{"TEST-MIB"},
+			},
 			out: &config.Module{
-				// Walk is expanded to include the lookup OID.
-				Walk: []string{"1.1.1.2", "1.1.1.3"},
+				Walk: []string{"1.2"},
+				Get:  []string{"1.1.1.0", "1.3.1.0"},
 				Metrics: []*config.Metric{
 					{
-						Name: "octet_Foo",
-						Oid:  "1.1.1.3",
+						Name: "testScalar",
+						Oid:  "1.1.1",
 						Type: "gauge",
-						Help: " - 1.1.1.3",
+						Help: " - 1.1.1",
+					},
+					{
+						Name: "testIndex",
+						Oid:  "1.2.1.1",
+						Type: "gauge",
+						Help: " - 1.2.1.1",
 						Indexes: []*config.Index{
 							{
-								Labelname: "octet_Desc",
+								Labelname: "testIndex",
 								Type:      "gauge",
 							},
 						},
-						Lookups: []*config.Lookup{
+					},
+					{
+						Name: "testColumn",
+						Oid:  "1.2.1.2",
+						Type: "gauge",
+						Help: " - 1.2.1.2",
+						Indexes: []*config.Index{
 							{
-								Labels:    []string{"octet_Desc"},
-								Labelname: "octet_Desc",
-								Type:      "OctetString",
-								Oid:       "1.1.1.2",
+								Labelname: "testIndex",
+								Type:      "gauge",
 							},
 						},
 					},
+					{
+						Name: "mixedScalar",
+						Oid:  "1.3.1",
+						Type: "gauge",
+						Help: " - 1.3.1",
+					},
 				},
 			},
 		},
@@ -748,7 +2768,11 @@ func TestGenerateConfigModule(t *testing.T) {
 		}
 
 		nameToNode := prepareTree(c.node)
-		got := generateConfigModule(c.cfg, c.node, nameToNode)
+		got, err := generateConfigModule(c.cfg, c.node, nameToNode)
+		if err != nil {
+			t.Errorf("GenerateConfigModule: error in case %d: %s", i, err)
+			continue
+		}
 		if !reflect.DeepEqual(got, c.out) {
 			t.Errorf("GenerateConfigModule: difference in case %d", i)
 			out, _ := yaml.Marshal(got)
@@ -758,3 +2782,66 @@ func TestGenerateConfigModule(t *testing.T) {
 		}
 	}
 }
+
+func TestGenerateConfigModuleErrors(t *testing.T) {
+	node := &Node{Oid: "1", Label: "root",
+		Children: []*Node{
+			{Oid: "1.1", Label: "ifEntry", Indexes: []string{"ifIndex"},
+				Children: []*Node{
+					{Oid: "1.1.1", Access: "ACCESS_READONLY", Label: "ifIndex", Type: "INTEGER"},
+					{Oid: "1.1.2", Access: "ACCESS_READONLY", Label: "ifDescr", Type: "OCTETSTR"},
+					{Oid: "1.1.3", Access: "ACCESS_READONLY", Label: "ifType", Type: "INTEGER"}}},
+			{Oid: "1.2", Access: "ACCESS_READONLY", Label: "sysName", Type: "OCTETSTR"}}}
+	nameToNode := prepareTree(node)
+	cases := []struct {
+		cfg *ModuleConfig
+		err string
+	}{
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntri"}},
+			err: "Cannot find oid 'ifEntri' to walk, did you mean ifEntry?",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, Exclude: []string{"missing"}},
+			err: "Cannot find oid 'missing' to exclude",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, Overrides: map[string]MetricOverrides{"ifType": {Type: "Integer"}}},
+			err: "Invalid type 'Integer' in override for 'ifType'",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, Get: []string{"ifEntry"}},
+			err: "Cannot get 'ifEntry', only object instances can be fetched with get",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, Lookups: []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifDesc"}}},
+			err: "Unknown index 'ifDesc', did you mean ifDescr?",
+		},
+		{
+			cfg: &ModuleConfig{
+				Walk:      []string{"ifEntry"},
+				Lookups:   []*Lookup{{OldIndex: "ifIndex", NewIndex: "ifType"}},
+				Overrides: map[string]MetricOverrides{"ifType": {Type: "EnumAsInfo"}},
+			},
+			err: "Invalid type 'EnumAsInfo' in override for 'ifType', which is looked up as an index",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, Filters: config.Filters{Dynamic: []config.DynamicFilter{{Oid: "ifStatus"}}}},
+			err: "Cannot find oid 'ifStatus' to filter on",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, Notifications: []string{"linkDown"}},
+			err: "Cannot find notifications in 'linkDown'",
+		},
+		{
+			cfg: &ModuleConfig{Walk: []string{"ifEntry"}, NotificationLabels: []string{"ifIndex"}},
+			err: "Cannot find varbind 'ifIndex' of the notifications to label traps by",
+		},
+	}
+	for _, c := range cases {
+		_, err := generateConfigModule(c.cfg, node, nameToNode)
+		if err == nil || err.Error() != c.err {
+			t.Errorf("Wrong error: want %q, got %v", c.err, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/log"
+)
+
+// Regenerate whenever the generator configs or the MIBs change. Each
+// generation runs in a child process, so that the MIBs are loaded afresh and
+// a broken config doesn't stop the watch.
+func watch(inputPaths []string, interval time.Duration, reloadURL string) {
+	args := watchChildArgs(os.Args[1:])
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Error finding the generator executable: %s", err)
+	}
+	mibs := mibDirs()
+	log.Infof("Watching %s for changes", strings.Join(append(watchPaths(inputPaths), mibs...), ", "))
+
+	state := ""
+	for {
+		if s := watchState(append(watchPaths(inputPaths), mibs...)); s != state {
+			if state != "" {
+				log.Infof("Change detected, regenerating")
+			}
+			state = s
+			cmd := exec.Command(executable, args...)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				log.Errorf("Error generating config: %s", err)
+			} else if reloadURL != "" {
+				if err := reloadExporter(reloadURL); err != nil {
+					log.Errorf("Error reloading the exporter: %s", err)
+				} else {
+					log.Infof("Reloaded the exporter at %s", reloadURL)
+				}
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+// The generator configs and the files they include. Includes are found
+// afresh each time, as the configs may change them.
+func watchPaths(inputPaths []string) []string {
+	paths := []string{}
+	for _, p := range inputPaths {
+		paths = append(paths, p)
+		// Includes up to an error are still watched, for it to be fixed.
+		includeFiles(p, nil, &paths)
+	}
+	return paths
+}
+
+// Drop the watch flags from the arguments, so the child generates once.
+func watchChildArgs(args []string) []string {
+	result := []string{}
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--watch.interval" || a == "--watch.reload-url" {
+			i++
+			continue
+		}
+		if a == "--watch" || a == "--no-watch" || strings.HasPrefix(a, "--watch.") {
+			continue
+		}
+		result = append(result, a)
+	}
+	return result
+}
+
+// Summarise the modification times and sizes of the given files, and of the
+// files in the given directories. Missing paths are skipped, as not all the
+// MIB directories usually exist.
+func watchState(paths []string) string {
+	state := []string{}
+	add := func(path string, fi os.FileInfo) {
+		state = append(state, fmt.Sprintf("%s %d %d", path, fi.ModTime().UnixNano(), fi.Size()))
+	}
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		add(p, fi)
+		if !fi.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			add(p+"/"+f.Name(), f)
+		}
+	}
+	sort.Strings(state)
+	return strings.Join(state, "\n")
+}
+
+// Ask a running exporter to reload its config.
+func reloadExporter(url string) error {
+	resp, err := http.Post(url, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWatchChildArgs(t *testing.T) {
+	in := []string{"generate", "--watch", "-i", "a.yml", "--watch.interval", "5s", "--watch.reload-url=http://localhost:9116/-/reload", "--strict"}
+	want := []string{"generate", "-i", "a.yml", "--strict"}
+	if got := watchChildArgs(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong args: want %v, got %v", want, got)
+	}
+}
+
+func TestWatchState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mib := filepath.Join(dir, "TEST-MIB")
+	if err := ioutil.WriteFile(mib, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{dir, filepath.Join(dir, "missing")}
+	before := watchState(paths)
+	if got := watchState(paths); got != before {
+		t.Errorf("State changed without changes: %q then %q", before, got)
+	}
+	if err := ioutil.WriteFile(mib, []byte("ab"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := watchState(paths); got == before {
+		t.Errorf("State didn't change after a MIB changed: %q", got)
+	}
+}
+
+func TestWatchPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "modules"), 0755)
+	files := map[string]string{
+		"generator.yml":      "modules:\n  if_mib: !include modules/if_mib.yml\n",
+		"modules/if_mib.yml": "walk: !include walk.yml\nlookups: !include missing.yml\n",
+		"modules/walk.yml":   "- ifTable\n",
+		"other.yml":          "modules: {}\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := watchPaths([]string{filepath.Join(dir, "generator.yml"), filepath.Join(dir, "other.yml")})
+	want := []string{
+		filepath.Join(dir, "generator.yml"),
+		filepath.Join(dir, "modules/if_mib.yml"),
+		filepath.Join(dir, "modules/walk.yml"),
+		// Watched so that the generator runs once it's created.
+		filepath.Join(dir, "modules/missing.yml"),
+		filepath.Join(dir, "other.yml"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong paths: want %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Limits concurrent scrapes of each target, set from the flags.
+var targetLimits = newTargetLimiter(0, 0)
+
+// A targetLimiter limits how many scrapes poll a target at once, as the
+// CPUs of small devices can't keep up with several Prometheus servers
+// scraping them together. Further scrapes of the target queue, for at most
+// the timeout. With no limit scrapes aren't queued.
+type targetLimiter struct {
+	max     int
+	timeout time.Duration
+
+	mtx     sync.Mutex
+	targets map[string]*targetSlots
+}
+
+type targetSlots struct {
+	// Holds a value for each scrape polling the target.
+	slots chan struct{}
+	// Scrapes polling or queued, so that it's removed once unused.
+	users int
+}
+
+func newTargetLimiter(max int, timeout time.Duration) *targetLimiter {
+	return &targetLimiter{
+		max:     max,
+		timeout: timeout,
+		targets: map[string]*targetSlots{},
+	}
+}
+
+// Wait for a slot to scrape the target. The returned func releases it.
+func (l *targetLimiter) acquire(target string) (func(), error) {
+	if l.max <= 0 {
+		return func() {}, nil
+	}
+	l.mtx.Lock()
+	t, ok := l.targets[target]
+	if !ok {
+		t = &targetSlots{slots: make(chan struct{}, l.max)}
+		l.targets[target] = t
+	}
+	t.users++
+	l.mtx.Unlock()
+	done := func() {
+		l.mtx.Lock()
+		defer l.mtx.Unlock()
+		t.users--
+		if t.users == 0 {
+			delete(l.targets, target)
+		}
+	}
+	release := func() {
+		<-t.slots
+		done()
+	}
+
+	if !acquireSlot(t.slots, l.timeout, snmpRequestQueueLength) {
+		done()
+		return nil, fmt.Errorf("timed out after %s waiting for other scrapes of the target", l.timeout)
+	}
+	return release, nil
+}
+
+// Take one of the slots, waiting for at most the timeout for one to come
+// free, or forever with none. Returns false if none did. Waiting scrapes are
+// counted by the gauge.
+func acquireSlot(slots chan struct{}, timeout time.Duration, queued prometheus.Gauge) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	queued.Inc()
+	defer queued.Dec()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-expired:
+		return false
+	}
+}
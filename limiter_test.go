@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_model/go"
+)
+
+func TestTargetLimiter(t *testing.T) {
+	l := newTargetLimiter(1, 50*time.Millisecond)
+	release, err := l.acquire("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Other targets aren't limited by it.
+	other, err := l.acquire("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	// A second scrape of the target times out while the first polls it.
+	if _, err := l.acquire("a"); err == nil {
+		t.Errorf("Expected a timeout waiting for the target")
+	}
+
+	// And gets the target once the first is done.
+	acquired := make(chan error)
+	go func() {
+		release, err := l.acquire("a")
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("Queued scrape failed: %s", err)
+	}
+	if len(l.targets) != 0 {
+		t.Errorf("Unused targets not removed: %v", l.targets)
+	}
+
+	// No limit.
+	l = newTargetLimiter(0, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := l.acquire("a"); err != nil {
+			t.Errorf("Scrape limited with no limit: %s", err)
+		}
+	}
+}
+
+func TestAcquireSlot(t *testing.T) {
+	slots := make(chan struct{}, 2)
+	queued := prometheus.NewGauge(prometheus.GaugeOpts{Name: "queued"})
+	for i := 0; i < 2; i++ {
+		if !acquireSlot(slots, 10*time.Millisecond, queued) {
+			t.Fatalf("Slot %d not free", i)
+		}
+	}
+	if acquireSlot(slots, 10*time.Millisecond, queued) {
+		t.Errorf("Slot taken beyond the limit")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- acquireSlot(slots, time.Second, queued)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	m := &io_prometheus_client.Metric{}
+	queued.Write(m)
+	if m.GetGauge().GetValue() != 1 {
+		t.Errorf("Waiting scrape not queued: %v", m)
+	}
+	<-slots
+	if !<-acquired {
+		t.Errorf("Freed slot not taken")
+	}
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -22,8 +30,26 @@ import (
 )
 
 var (
-	configFile    = kingpin.Flag("config.file", "Path to configuration file.").Default("snmp.yml").String()
-	listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9116").String()
+	configFile     = kingpin.Flag("config.file", "Path to configuration file. A glob such as conf.d/*.yml loads the modules of all the matching files.").Default("snmp.yml").String()
+	lazyModules    = kingpin.Flag("config.lazy-modules", "Only decode modules when first used, keeping this many decoded. This saves memory when few of the modules in the config are used. 0 decodes all modules on load.").Default("0").Int()
+	configStrict   = kingpin.Flag("config.strict", "Fail to load the configuration file if it has unknown fields. If false they're logged and ignored, so that a config can be shared by different versions of the exporter.").Default("true").Bool()
+	compileFile    = kingpin.Flag("config.compile", "Compile the configuration file to this path and exit. A compiled file can be used as the --config.file, and loads much faster.").String()
+	listenAddress  = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Default(":9116").String()
+	sessionIdle    = kingpin.Flag("snmp.session-idle-timeout", "How long to keep SNMP sessions open between scrapes of a target, so that they reuse the socket and SNMP v3 engine discovery. 0 opens a new session for every scrape.").Default("5m").Duration()
+	targetLimit    = kingpin.Flag("snmp.max-concurrent-scrapes-per-target", "How many scrapes may poll a target at once, queueing any more. 0 for no limit.").Default("1").Int()
+	queueTimeout   = kingpin.Flag("snmp.queue-timeout", "How long a scrape may wait in the queue for a target, or for --snmp.max-concurrent-scrapes, before failing. 0 waits until there's room.").Default("10s").Duration()
+	maxScrapes     = kingpin.Flag("snmp.max-concurrent-scrapes", "How many scrapes the exporter runs at once, queueing any more and answering 503 to those that time out. 0 for no limit.").Default("0").Int()
+	maxValueLength = kingpin.Flag("snmp.max-value-length", "Truncate string values and lookups longer than this many characters, for modules and metrics without a max_value_length. 0 for no limit.").Default("0").Int()
+	vaultCacheTTL  = kingpin.Flag("vault.cache-ttl", "How long to cache secrets read from Vault that have no lease. Vault is used for vault: credentials when VAULT_ADDR is set.").Default("5m").Duration()
+	credsKeyFile   = kingpin.Flag("web.credentials-key-file", "File with the key for signing credentials POSTed to /snmp. Credentials can't be sent with a scrape unless this is set.").String()
+	trapAddress    = kingpin.Flag("trap.listen-address", "UDP address to receive SNMP traps on, such as :162. Traps aren't received if empty.").Default("").String()
+	trapAuth       = kingpin.Flag("trap.auth", "Name of the auth in the config to check the community of SNMP v1 and v2c traps against, and to authenticate and decrypt SNMP v3 traps with. Traps with any community are accepted if empty.").Default("").String()
+	trapMaxSeries  = kingpin.Flag("trap.max-series", "Most series of the varbinds of each notification to count traps in. Traps that would add more are counted in snmp_traps_dropped_total. 0 for no limit.").Default("1000").Int()
+
+	runCommand     = kingpin.Command("run", "Run the exporter. This is the default.").Default()
+	migrateCommand = kingpin.Command("migrate-config", "Rewrite a config file in the current schema version.")
+	migrateFile    = migrateCommand.Arg("file", "Config file to migrate.").Required().String()
+	migrateWrite   = migrateCommand.Flag("write", "Write the migrated config back to the file, rather than printing it.").Short('w').Bool()
 
 	// Metrics about the SNMP exporter itself.
 	snmpDuration = prometheus.NewSummaryVec(
@@ -39,15 +65,125 @@ var (
 			Help: "Errors in requests to the SNMP exporter",
 		},
 	)
+	snmpCardinalityExceeded = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_cardinality_limit_exceeded_total",
+			Help: "Scrapes where a metric had more rows than its max_series",
+		},
+		[]string{"metric"},
+	)
+	snmpOidsNotIncreasing = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "snmp_oids_not_increasing_total",
+			Help: "Varbinds skipped in walks as their OID wasn't after the previous",
+		},
+	)
+	snmpPacketsSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_packets_sent_total",
+			Help: "SNMP packets sent to targets, including retries",
+		},
+		[]string{"module"},
+	)
+	snmpPacketsReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_packets_received_total",
+			Help: "SNMP packets received from targets",
+		},
+		[]string{"module"},
+	)
+	snmpRetries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_packets_retried_total",
+			Help: "SNMP packets sent after a target didn't answer the previous in time",
+		},
+		[]string{"module"},
+	)
+	snmpVarbinds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_varbinds_received_total",
+			Help: "Varbinds in the responses of targets",
+		},
+		[]string{"module"},
+	)
+	snmpWalkDuration = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name: "snmp_subtree_walk_duration_seconds",
+			Help: "Duration of walks of each subtree of modules",
+		},
+		[]string{"module", "oid"},
+	)
+	snmpErrorStatuses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_error_responses_total",
+			Help: "Responses from targets with an error status",
+		},
+		[]string{"module", "status"},
+	)
+	snmpRequestQueueLength = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "snmp_request_queue_length",
+			Help: "Scrapes waiting for others of the same target to finish",
+		},
+	)
+	snmpScrapesQueued = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "snmp_scrapes_queued",
+			Help: "Scrapes waiting for --snmp.max-concurrent-scrapes",
+		},
+	)
+	snmpScrapesRejected = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "snmp_scrapes_rejected_total",
+			Help: "Scrapes answered with 503 as the exporter was running --snmp.max-concurrent-scrapes",
+		},
+	)
+	snmpTrapsDropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "snmp_traps_dropped_total",
+			Help: "Traps received that weren't counted, by the reason",
+		},
+		[]string{"reason"},
+	)
+	configReloadSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "snmp_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		},
+	)
+	configReloadSeconds = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "snmp_config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload",
+		},
+	)
 	sc = &SafeConfig{
 		C: &config.Config{},
 	}
 	reloadCh chan chan error
+	// The key for signatures of credentials sent with a scrape, if allowed.
+	credsKey []byte
+	// Holds a value for each scrape running, if they're limited.
+	scrapeSlots chan struct{}
 )
 
 func init() {
 	prometheus.MustRegister(snmpDuration)
 	prometheus.MustRegister(snmpRequestErrors)
+	prometheus.MustRegister(snmpCardinalityExceeded)
+	prometheus.MustRegister(snmpOidsNotIncreasing)
+	prometheus.MustRegister(snmpPacketsSent)
+	prometheus.MustRegister(snmpPacketsReceived)
+	prometheus.MustRegister(snmpRetries)
+	prometheus.MustRegister(snmpVarbinds)
+	prometheus.MustRegister(snmpWalkDuration)
+	prometheus.MustRegister(snmpErrorStatuses)
+	prometheus.MustRegister(snmpRequestQueueLength)
+	prometheus.MustRegister(snmpScrapesQueued)
+	prometheus.MustRegister(snmpScrapesRejected)
+	prometheus.MustRegister(snmpTrapsDropped)
+	prometheus.MustRegister(configReloadSuccess)
+	prometheus.MustRegister(configReloadSeconds)
 	prometheus.MustRegister(version.NewCollector("snmp_exporter"))
 }
 
@@ -58,23 +194,68 @@ func handler(w http.ResponseWriter, r *http.Request) {
 		snmpRequestErrors.Inc()
 		return
 	}
+	if scrapeSlots != nil {
+		// So that the exporter slows down rather than running out of file
+		// descriptors when scraping a great many targets.
+		if !acquireSlot(scrapeSlots, *queueTimeout, snmpScrapesQueued) {
+			http.Error(w, "Too many concurrent scrapes", http.StatusServiceUnavailable)
+			snmpScrapesRejected.Inc()
+			return
+		}
+		defer func() { <-scrapeSlots }()
+	}
 	moduleName := r.URL.Query().Get("module")
 	if moduleName == "" {
 		moduleName = "default"
 	}
+	authName := r.URL.Query().Get("auth")
 	sc.RLock()
-	module, ok := (*(sc.C))[moduleName]
+	module, err := sc.C.Module(moduleName)
+	auth, authOk := sc.C.Auths[authName]
 	sc.RUnlock()
-	if !ok {
+	if err != nil {
+		log.Errorf("Error loading module '%s': %s", moduleName, err)
+		http.Error(w, fmt.Sprintf("Error loading module '%s': %s", moduleName, err), http.StatusInternalServerError)
+		snmpRequestErrors.Inc()
+		return
+	}
+	if module == nil {
 		http.Error(w, fmt.Sprintf("Unkown module '%s'", moduleName), 400)
 		snmpRequestErrors.Inc()
 		return
 	}
+	if authName != "" {
+		if !authOk {
+			http.Error(w, fmt.Sprintf("Unknown auth '%s'", authName), 400)
+			snmpRequestErrors.Inc()
+			return
+		}
+		module = moduleWithAuth(module, auth)
+	}
+	if r.Method == "POST" {
+		auth, err := requestAuth(r, credsKey)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid credentials in request: %s", err), 400)
+			snmpRequestErrors.Inc()
+			return
+		}
+		module = moduleWithAuth(module, auth)
+	}
+	if context := r.URL.Query().Get("context"); context != "" {
+		module = moduleWithContext(module, context)
+	}
+	module, err = resolveModuleAuth(module)
+	if err != nil {
+		log.Errorf("Error resolving credentials of module '%s': %s", moduleName, err)
+		http.Error(w, fmt.Sprintf("Error resolving credentials: %s", err), http.StatusInternalServerError)
+		snmpRequestErrors.Inc()
+		return
+	}
 	log.Debugf("Scraping target '%s' with module '%s'", target, moduleName)
 
 	start := time.Now()
 	registry := prometheus.NewRegistry()
-	collector := collector{target: target, module: module}
+	collector := collector{target: target, name: moduleName, module: module}
 	registry.MustRegister(collector)
 	// Delegate http serving to Promethues client library, which will call collector.Collect.
 	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
@@ -84,6 +265,106 @@ func handler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Scrape of target '%s' with module '%s' took %f seconds", target, moduleName, duration)
 }
 
+// A copy of the module that scrapes with the version and credentials of the auth.
+func moduleWithAuth(module *config.Module, auth *config.NamedAuth) *config.Module {
+	withAuth := *module
+	withAuth.WalkParams.Version = auth.Version
+	withAuth.WalkParams.Auth = auth.Auth
+	return &withAuth
+}
+
+// A copy of the module using the SNMPv3 context, such as for a VRF or VLAN.
+func moduleWithContext(module *config.Module, context string) *config.Module {
+	withContext := *module
+	withContext.WalkParams.Auth.ContextName = context
+	return &withContext
+}
+
+// How far ahead the expiry of signed credentials may be, which bounds how
+// long signatures have to be remembered to stop them being replayed.
+const maxCredentialsLifetime = 5 * time.Minute
+
+// The signatures of credentials already used, until they expire.
+var usedSignatures = newSignatureCache()
+
+type signatureCache struct {
+	mtx     sync.Mutex
+	expires map[string]time.Time
+}
+
+func newSignatureCache() *signatureCache {
+	return &signatureCache{expires: map[string]time.Time{}}
+}
+
+// Record the use of a signature, returning false if it was already used.
+func (c *signatureCache) use(signature string, expires, now time.Time) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for s, e := range c.expires {
+		if now.After(e) {
+			delete(c.expires, s)
+		}
+	}
+	if _, ok := c.expires[signature]; ok {
+		return false
+	}
+	c.expires[signature] = expires
+	return true
+}
+
+// Read credentials for a single scrape from the body of a POST, as the
+// version and auth of a named auth. The target and module parameters, the
+// Unix time the credentials expire in the X-SNMP-Auth-Expires header, and the
+// body must be signed with the key, as the hex HMAC-SHA256 of them separated
+// by newlines in the X-SNMP-Auth-Signature header. Each signature can only be
+// used once.
+func requestAuth(r *http.Request, key []byte) (*config.NamedAuth, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("credentials can't be sent with a scrape without --web.credentials-key-file")
+	}
+	// Longer bodies are truncated, so fail the signature check.
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	expiresHeader := r.Header.Get("X-SNMP-Auth-Expires")
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", r.URL.Query().Get("target"), r.URL.Query().Get("module"), expiresHeader)
+	mac.Write(body)
+	signature, err := hex.DecodeString(r.Header.Get("X-SNMP-Auth-Signature"))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("bad signature")
+	}
+	seconds, err := strconv.ParseInt(expiresHeader, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad X-SNMP-Auth-Expires")
+	}
+	now, expires := time.Now(), time.Unix(seconds, 0)
+	if now.After(expires) {
+		return nil, fmt.Errorf("credentials expired")
+	}
+	if expires.Sub(now) > maxCredentialsLifetime {
+		return nil, fmt.Errorf("credentials must expire within %s", maxCredentialsLifetime)
+	}
+	if !usedSignatures.use(hex.EncodeToString(signature), expires, now) {
+		return nil, fmt.Errorf("credentials already used")
+	}
+	auth := &config.NamedAuth{}
+	if err := yaml.Unmarshal(body, auth); err != nil {
+		return nil, err
+	}
+	// Files and secret stores are only for the config, the sender can't read ours.
+	if auth.Auth.CommunityFile != "" || auth.Auth.PasswordFile != "" || auth.Auth.PrivPasswordFile != "" {
+		return nil, fmt.Errorf("credentials from files can't be sent with a scrape")
+	}
+	for _, s := range []config.Secret{auth.Auth.Community, auth.Auth.Password, auth.Auth.PrivPassword} {
+		if provider, _, err := secretReference(s); provider != nil || err != nil {
+			return nil, fmt.Errorf("references to secrets can't be sent with a scrape")
+		}
+	}
+	return auth, nil
+}
+
 func updateConfiguration(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "POST":
@@ -103,40 +384,158 @@ type SafeConfig struct {
 	C *config.Config
 }
 
+// Load the config file, and swap it in if it's valid. On error the current
+// config is kept.
 func (sc *SafeConfig) ReloadConfig(configFile string) (err error) {
-	conf, err := config.LoadFile(configFile)
+	var conf *config.Config
+	if *lazyModules > 0 {
+		conf, err = config.LoadFileLazy(configFile, *lazyModules)
+	} else {
+		conf, err = config.LoadFile(configFile)
+	}
 	if err != nil {
 		log.Errorf("Error parsing config file: %s", err)
+		configReloadSuccess.Set(0)
+		return err
+	}
+	if err := checkSecretReferences(conf); err != nil {
+		log.Errorf("Error in config file: %s", err)
+		configReloadSuccess.Set(0)
 		return err
 	}
+	if traps != nil {
+		if err := traps.setConfig(conf, *trapAuth); err != nil {
+			log.Errorf("Error loading config for traps: %s", err)
+			configReloadSuccess.Set(0)
+			return err
+		}
+	}
 	sc.Lock()
 	sc.C = conf
 	sc.Unlock()
+	// Initilise metrics.
+	for _, module := range conf.ModuleNames() {
+		snmpDuration.WithLabelValues(module)
+	}
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
 	log.Infoln("Loaded config file")
 	return nil
 }
 
+// Compile the config, writing it to a temporary file first so that an
+// exporter loading the output never sees a partial file.
+func compileConfig(configFile, output string) error {
+	return writeFileAtomically(output, func(w io.Writer) error {
+		return config.Compile(configFile, w)
+	})
+}
+
+// Migrate a config file to the current version, writing it back to the file
+// or to out.
+func migrateConfig(path string, write bool, out io.Writer) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	migrated, err := config.Migrate(content)
+	if err != nil {
+		return err
+	}
+	if !write {
+		_, err := out.Write(migrated)
+		return err
+	}
+	return writeFileAtomically(path, func(w io.Writer) error {
+		_, err := w.Write(migrated)
+		return err
+	})
+}
+
+func writeFileAtomically(path string, write func(io.Writer) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".snmp_exporter")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
 func main() {
 	log.AddFlags(kingpin.CommandLine)
 	kingpin.Version(version.Print("snmp_exporter"))
 	kingpin.HelpFlag.Short('h')
-	kingpin.Parse()
+	command := kingpin.Parse()
+	config.Strict = *configStrict
+	if command == migrateCommand.FullCommand() {
+		if err := migrateConfig(*migrateFile, *migrateWrite, os.Stdout); err != nil {
+			log.Fatalf("Error migrating config file: %s", err)
+		}
+		return
+	}
+
+	if *compileFile != "" {
+		if err := compileConfig(*configFile, *compileFile); err != nil {
+			log.Fatalf("Error compiling config file: %s", err)
+		}
+		log.Infof("Compiled %s to %s", *configFile, *compileFile)
+		return
+	}
 
 	log.Infoln("Starting snmp exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
+	// Before the config is loaded, as it's checked for references to vault.
+	registerVaultProvider(*vaultCacheTTL)
 	// Bail early if the config is bad.
-	var err error
-	sc.C, err = config.LoadFile(*configFile)
-	if err != nil {
+	if err := sc.ReloadConfig(*configFile); err != nil {
 		log.Fatalf("Error parsing config file: %s", err)
 	}
-	// Initilise metrics.
-	for module, _ := range *sc.C {
-		snmpDuration.WithLabelValues(module)
+	if *sessionIdle > 0 {
+		sessions = newSessionPool(*sessionIdle)
+		go sessions.run()
+	}
+	targetLimits = newTargetLimiter(*targetLimit, *queueTimeout)
+	if *maxScrapes > 0 {
+		scrapeSlots = make(chan struct{}, *maxScrapes)
+	}
+	if *trapAddress != "" {
+		receiver := newTrapReceiver(*trapMaxSeries)
+		if err := receiver.setConfig(sc.C, *trapAuth); err != nil {
+			log.Fatalf("Error loading config for traps: %s", err)
+		}
+		prometheus.MustRegister(receiver)
+		traps = receiver
+		// The SNMP v3 user of the auth is only read at startup.
+		auth := sc.C.Auths[*trapAuth]
+		listener, err := newTrapListener(auth, receiver)
+		if err != nil {
+			log.Fatalf("Error receiving traps: %s", err)
+		}
+		log.Infof("Listening for traps on %s", *trapAddress)
+		go func() {
+			log.Fatalf("Error receiving traps: %s", listener.Listen(*trapAddress))
+		}()
+	}
+	if *credsKeyFile != "" {
+		key, err := ioutil.ReadFile(*credsKeyFile)
+		if err != nil {
+			log.Fatalf("Error reading credentials key: %s", err)
+		}
+		credsKey = []byte(strings.TrimSpace(string(key)))
+		if len(credsKey) == 0 {
+			log.Fatalf("Credentials key file %s is empty", *credsKeyFile)
+		}
 	}
 
-	hup := make(chan os.Signal)
+	hup := make(chan os.Signal, 1)
 	reloadCh = make(chan chan error)
 	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
@@ -183,6 +582,7 @@ func main() {
             <form action="/snmp">
             <label>Target:</label> <input type="text" name="target" placeholder="X.X.X.X" value="1.2.3.4"><br>
             <label>Module:</label> <input type="text" name="module" placeholder="module" value="default"><br>
+            <label>Auth:</label> <input type="text" name="auth" placeholder="auth"><br>
             <input type="submit" value="Submit">
             </form>
 						<p><a href="/config">Config</a></p>
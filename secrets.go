@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// A credentialProvider looks up secrets in an external store, so that they
+// don't have to be in snmp.yml. Auths refer to them as scheme:reference,
+// such as vault:kv/network/switch#community.
+type credentialProvider interface {
+	Secret(ref string) (string, error)
+}
+
+// Providers by the scheme of their references.
+var credentialProviders = map[string]credentialProvider{}
+
+// The settings that configure each provider, by scheme. References to a
+// provider that isn't configured are an error, rather than being used as
+// the secret itself.
+var credentialProviderSettings = map[string]string{
+	"vault": "VAULT_ADDR",
+}
+
+// The provider and reference of a secret that refers to one, or nil.
+func secretReference(s config.Secret) (credentialProvider, string, error) {
+	i := strings.Index(string(s), ":")
+	if i < 0 {
+		return nil, "", nil
+	}
+	scheme := string(s)[:i]
+	provider, ok := credentialProviders[scheme]
+	if !ok {
+		if setting, known := credentialProviderSettings[scheme]; known {
+			return nil, "", fmt.Errorf("secret refers to %s, but %s isn't set", scheme, setting)
+		}
+		return nil, "", nil
+	}
+	return provider, string(s)[i+1:], nil
+}
+
+// Check that the providers of the secrets the config refers to are
+// configured. Lazily decoded modules are only checked when used.
+func checkSecretReferences(conf *config.Config) error {
+	check := func(auth config.Auth) error {
+		for _, s := range []config.Secret{auth.Community, auth.Password, auth.PrivPassword} {
+			if _, _, err := secretReference(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for name, module := range conf.Modules {
+		if err := check(module.WalkParams.Auth); err != nil {
+			return fmt.Errorf("module %s: %s", name, err)
+		}
+	}
+	for name, auth := range conf.Auths {
+		if err := check(auth.Auth); err != nil {
+			return fmt.Errorf("auth %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// A copy of the module with any references to secrets in its auth resolved.
+func resolveModuleAuth(module *config.Module) (*config.Module, error) {
+	auth := module.WalkParams.Auth
+	changed := false
+	for _, s := range []*config.Secret{&auth.Community, &auth.Password, &auth.PrivPassword} {
+		provider, ref, err := secretReference(*s)
+		if err != nil {
+			return nil, err
+		}
+		if provider == nil {
+			continue
+		}
+		value, err := provider.Secret(ref)
+		if err != nil {
+			return nil, err
+		}
+		*s = config.Secret(value)
+		changed = true
+	}
+	if !changed {
+		return module, nil
+	}
+	resolved := *module
+	resolved.WalkParams.Auth = auth
+	return &resolved, nil
+}
+
+// vaultProvider reads secrets from the KV secrets engine of HashiCorp Vault,
+// version 1 or 2. A reference is the path of the secret, and the key within
+// it after a #. Secrets are cached for their lease, or the TTL if they have
+// none, and then renewed if their lease is renewable or read again. If that
+// fails, the cached value is used, and the read retried after a backoff
+// until it succeeds. Only one read of a
+// path is made at a time, without holding up scrapes using other secrets.
+type vaultProvider struct {
+	addr   string
+	token  string
+	ttl    time.Duration
+	client *http.Client
+	now    func() time.Time
+
+	mtx   sync.Mutex
+	cache map[string]*vaultSecret
+	// Reads in progress, by path.
+	reads map[string]*vaultRead
+}
+
+type vaultSecret struct {
+	data      map[string]string
+	expires   time.Time
+	leaseID   string
+	renewable bool
+	// Refreshes that have failed in a row.
+	failures int
+}
+
+type vaultRead struct {
+	secret *vaultSecret
+	err    error
+	done   chan struct{}
+}
+
+// How long to wait before renewing the token again after failing to.
+const vaultRetryInterval = time.Minute
+
+// How long to wait before refreshing a secret again after failing to,
+// doubling with each failure up to vaultRetryInterval.
+const vaultSecretBackoff = 5 * time.Second
+
+func newVaultProvider(addr, token string, ttl time.Duration) *vaultProvider {
+	return &vaultProvider{
+		addr:   strings.TrimRight(addr, "/"),
+		token:  token,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+		cache:  map[string]*vaultSecret{},
+		reads:  map[string]*vaultRead{},
+	}
+}
+
+// Set up Vault from the usual VAULT_ADDR and VAULT_TOKEN, if configured.
+func registerVaultProvider(ttl time.Duration) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return
+	}
+	v := newVaultProvider(addr, os.Getenv("VAULT_TOKEN"), ttl)
+	credentialProviders["vault"] = v
+	go v.run()
+	log.Infof("Reading vault: credentials from %s", addr)
+}
+
+// Renew the token before it expires, for as long as it can be.
+func (v *vaultProvider) run() {
+	for {
+		wait, err := v.renewToken()
+		if err != nil {
+			log.Warnf("Error renewing vault token: %s", err)
+			wait = vaultRetryInterval
+		}
+		if wait == 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+// Renew the token, returning when to renew it next, or 0 if it doesn't need
+// to be renewed.
+func (v *vaultProvider) renewToken() (time.Duration, error) {
+	var lookup struct {
+		Data struct {
+			TTL       int  `json:"ttl"`
+			Renewable bool `json:"renewable"`
+		} `json:"data"`
+	}
+	if err := v.request("GET", "auth/token/lookup-self", nil, &lookup); err != nil {
+		return 0, err
+	}
+	if lookup.Data.TTL == 0 {
+		return 0, nil // Never expires.
+	}
+	if !lookup.Data.Renewable {
+		log.Warnf("The vault token isn't renewable, and expires in %ds", lookup.Data.TTL)
+		return 0, nil
+	}
+	var renewed struct {
+		Auth struct {
+			LeaseDuration int `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	if err := v.request("POST", "auth/token/renew-self", nil, &renewed); err != nil {
+		return 0, err
+	}
+	// Renewed at half its TTL, to leave time to retry.
+	return time.Duration(renewed.Auth.LeaseDuration) * time.Second / 2, nil
+}
+
+func (v *vaultProvider) Secret(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", fmt.Errorf("vault reference %q has no #key", ref)
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	v.mtx.Lock()
+	secret, cached := v.cache[path]
+	read, reading := v.reads[path]
+	if !cached || !v.now().Before(secret.expires) {
+		if reading {
+			if !cached {
+				// Wait for the read in progress.
+				v.mtx.Unlock()
+				<-read.done
+				if read.err != nil {
+					return "", read.err
+				}
+				return read.secret.value(path, key)
+			}
+			// Use the cached secret until the read in progress is done.
+		} else {
+			read = &vaultRead{done: make(chan struct{})}
+			v.reads[path] = read
+			v.mtx.Unlock()
+			fresh, err := v.refresh(path, secret)
+			v.mtx.Lock()
+			delete(v.reads, path)
+			if err != nil && !cached {
+				read.err = fmt.Errorf("error reading %s from vault: %s", path, err)
+				close(read.done)
+				v.mtx.Unlock()
+				return "", read.err
+			}
+			if err != nil {
+				backoff := vaultSecretBackoff << uint(secret.failures)
+				if backoff > vaultRetryInterval || backoff <= 0 {
+					backoff = vaultRetryInterval
+				}
+				log.Warnf("Error reading %s from vault, using the cached secret for %s: %s", path, backoff, err)
+				retry := *secret
+				retry.expires = v.now().Add(backoff)
+				retry.failures++
+				secret = &retry
+				v.cache[path] = secret
+			} else {
+				secret = fresh
+				v.cache[path] = secret
+			}
+			read.secret = secret
+			close(read.done)
+		}
+	}
+	v.mtx.Unlock()
+	return secret.value(path, key)
+}
+
+func (s *vaultSecret) value(path, key string) (string, error) {
+	value, ok := s.data[key]
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	return value, nil
+}
+
+// Renew the lease of the expired secret, if it can be, or read it again.
+func (v *vaultProvider) refresh(path string, expired *vaultSecret) (*vaultSecret, error) {
+	if expired != nil && expired.renewable && expired.leaseID != "" {
+		var renewed struct {
+			LeaseDuration int  `json:"lease_duration"`
+			Renewable     bool `json:"renewable"`
+		}
+		err := v.request("PUT", "sys/leases/renew", map[string]string{"lease_id": expired.leaseID}, &renewed)
+		if err == nil && renewed.LeaseDuration > 0 {
+			secret := *expired
+			secret.expires = v.now().Add(time.Duration(renewed.LeaseDuration) * time.Second)
+			secret.renewable = renewed.Renewable
+			return &secret, nil
+		}
+		log.Debugf("Couldn't renew the lease of %s in vault, reading it again: %v", path, err)
+	}
+	return v.read(path)
+}
+
+func (v *vaultProvider) read(path string) (*vaultSecret, error) {
+	var body struct {
+		LeaseID       string                 `json:"lease_id"`
+		LeaseDuration int                    `json:"lease_duration"`
+		Renewable     bool                   `json:"renewable"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := v.request("GET", path, nil, &body); err != nil {
+		return nil, err
+	}
+	data := body.Data
+	// Version 2 of the KV engine nests the secret, alongside its metadata.
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = inner
+		}
+	}
+	secret := &vaultSecret{data: map[string]string{}, expires: v.now().Add(v.ttl), leaseID: body.LeaseID, renewable: body.Renewable}
+	if body.LeaseDuration > 0 {
+		secret.expires = v.now().Add(time.Duration(body.LeaseDuration) * time.Second)
+	}
+	for k, value := range data {
+		if s, ok := value.(string); ok {
+			secret.data[k] = s
+		}
+	}
+	return secret, nil
+}
+
+// Make a request of the Vault API, decoding the JSON response into out.
+func (v *vaultProvider) request(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, v.addr+"/v1/"+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
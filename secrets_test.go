@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestVaultProvider(t *testing.T) {
+	requests := 0
+	fail := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if fail || r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/data/network/switch":
+			fmt.Fprintf(w, `{"data": {"data": {"community": "c%d"}, "metadata": {"version": 1}}}`, requests)
+		case "/v1/secret/leased":
+			fmt.Fprint(w, `{"lease_duration": 3600, "data": {"password": "p"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Unix(0, 0)
+	v := newVaultProvider(server.URL, "token", time.Minute)
+	v.now = func() time.Time { return now }
+	credentialProviders["vault"] = v
+	defer delete(credentialProviders, "vault")
+
+	module := &config.Module{}
+	module.WalkParams.Auth.Community = "vault:kv/data/network/switch#community"
+	module.WalkParams.Auth.Password = "vault:secret/leased#password"
+	module.WalkParams.Auth.Username = "vault:not/a/secret"
+	got, err := resolveModuleAuth(module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.WalkParams.Auth.Community != "c1" || got.WalkParams.Auth.Password != "p" || got.WalkParams.Auth.Username != "vault:not/a/secret" {
+		t.Errorf("Wrong auth: %+v", got.WalkParams.Auth)
+	}
+	if module.WalkParams.Auth.Community != "vault:kv/data/network/switch#community" {
+		t.Errorf("Module was changed: %+v", module.WalkParams.Auth)
+	}
+
+	// Cached until the TTL passes.
+	if s, _ := v.Secret("kv/data/network/switch#community"); s != "c1" || requests != 2 {
+		t.Errorf("Secret not cached: %q after %d requests", s, requests)
+	}
+	now = now.Add(2 * time.Minute)
+	if s, _ := v.Secret("kv/data/network/switch#community"); s != "c3" {
+		t.Errorf("Secret not read again: %q", s)
+	}
+	// Secrets with a lease are cached for it.
+	if s, _ := v.Secret("secret/leased#password"); s != "p" || requests != 3 {
+		t.Errorf("Leased secret not cached: %q after %d requests", s, requests)
+	}
+
+	// The cached secret is kept if Vault fails.
+	fail = true
+	now = now.Add(2 * time.Minute)
+	if s, err := v.Secret("kv/data/network/switch#community"); err != nil || s != "c3" {
+		t.Errorf("Cached secret not used: %q, %v", s, err)
+	}
+	// And not read again until the backoff passes.
+	failed := requests
+	if s, _ := v.Secret("kv/data/network/switch#community"); s != "c3" || requests != failed {
+		t.Errorf("Secret read again during the backoff: %q after %d requests", s, requests)
+	}
+	now = now.Add(vaultSecretBackoff)
+	if s, _ := v.Secret("kv/data/network/switch#community"); s != "c3" || requests != failed+1 {
+		t.Errorf("Secret not read again after the backoff: %q after %d requests", s, requests)
+	}
+	if _, err := v.Secret("kv/data/other#community"); err == nil {
+		t.Errorf("Expected an error for an unreadable secret")
+	}
+	fail = false
+	if _, err := v.Secret("kv/data/network/switch#missing"); err == nil {
+		t.Errorf("Expected an error for a missing key")
+	}
+	if _, err := v.Secret("kv/data/network/switch"); err == nil {
+		t.Errorf("Expected an error for a reference without a key")
+	}
+	// Once Vault is back the secret is read again after the longer backoff.
+	now = now.Add(2 * vaultSecretBackoff)
+	if s, err := v.Secret("kv/data/network/switch#community"); err != nil || s == "c3" {
+		t.Errorf("Secret not read again: %q, %v", s, err)
+	}
+}
+
+func TestSecretReferencesWithoutProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snmp.yml")
+	for _, content := range []string{
+		"if_mib:\n  walk: [1]\n  auth:\n    community: vault:kv/network/switch#community\n",
+		"auths:\n  v2:\n    version: 2\n    auth:\n      community: vault:kv/network/switch#community\n",
+	} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sc := &SafeConfig{}
+		if err := sc.ReloadConfig(path); err == nil || !strings.Contains(err.Error(), "secret refers to vault, but VAULT_ADDR isn't set") {
+			t.Errorf("Wrong error loading %q: %v", content, err)
+		}
+		if _, err := resolveModuleAuth(&config.Module{WalkParams: config.WalkParams{Auth: config.Auth{Community: "vault:kv/network/switch#community"}}}); err == nil {
+			t.Errorf("Expected an error resolving a reference without a provider")
+		}
+
+		credentialProviders["vault"] = newVaultProvider("http://localhost", "", time.Minute)
+		if err := sc.ReloadConfig(path); err != nil {
+			t.Errorf("Error loading %q with a provider: %s", content, err)
+		}
+		delete(credentialProviders, "vault")
+	}
+
+	// Other schemes are taken as the secret itself.
+	if err := ioutil.WriteFile(path, []byte("if_mib:\n  walk: [1]\n  auth:\n    community: public:ro\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&SafeConfig{}).ReloadConfig(path); err != nil {
+		t.Errorf("Error loading a community with a colon: %s", err)
+	}
+}
+
+func TestVaultProviderLeases(t *testing.T) {
+	reads, renewals := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/database/creds/snmp":
+			reads++
+			fmt.Fprintf(w, `{"lease_id": "database/creds/snmp/abc", "lease_duration": 60, "renewable": true, "data": {"password": "p%d"}}`, reads)
+		case "/v1/sys/leases/renew":
+			var body struct {
+				LeaseID string `json:"lease_id"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			if r.Method != "PUT" || body.LeaseID != "database/creds/snmp/abc" {
+				http.Error(w, "bad lease", http.StatusBadRequest)
+				return
+			}
+			renewals++
+			fmt.Fprint(w, `{"lease_duration": 60, "renewable": false}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	now := time.Unix(0, 0)
+	v := newVaultProvider(server.URL, "token", time.Minute)
+	v.now = func() time.Time { return now }
+	if s, err := v.Secret("database/creds/snmp#password"); err != nil || s != "p1" {
+		t.Fatalf("Wrong secret: %q, %v", s, err)
+	}
+	// The lease is renewed rather than the secret read again.
+	now = now.Add(2 * time.Minute)
+	if s, _ := v.Secret("database/creds/snmp#password"); s != "p1" || reads != 1 || renewals != 1 {
+		t.Errorf("Lease not renewed: %q after %d reads and %d renewals", s, reads, renewals)
+	}
+	// Until it can't be.
+	now = now.Add(2 * time.Minute)
+	if s, _ := v.Secret("database/creds/snmp#password"); s != "p2" || reads != 2 || renewals != 1 {
+		t.Errorf("Secret not read again: %q after %d reads and %d renewals", s, reads, renewals)
+	}
+}
+
+func TestVaultProviderConcurrentReads(t *testing.T) {
+	var mtx sync.Mutex
+	requests := map[string]int{}
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		requests[r.URL.Path]++
+		mtx.Unlock()
+		if r.URL.Path == "/v1/kv/slow" {
+			<-release
+		}
+		fmt.Fprint(w, `{"data": {"community": "c"}}`)
+	}))
+	defer server.Close()
+
+	v := newVaultProvider(server.URL, "token", time.Minute)
+	if _, err := v.Secret("kv/fast#community"); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s, err := v.Secret("kv/slow#community"); err != nil || s != "c" {
+				t.Errorf("Wrong secret: %q, %v", s, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	// Other secrets aren't held up by a slow read.
+	done := make(chan struct{})
+	go func() {
+		v.Secret("kv/fast#community")
+		v.Secret("kv/other#community")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("Secrets held up by a slow read of another")
+	}
+	close(release)
+	wg.Wait()
+	mtx.Lock()
+	defer mtx.Unlock()
+	if requests["/v1/kv/slow"] != 1 {
+		t.Errorf("Expected 1 read of the slow secret, got %d", requests["/v1/kv/slow"])
+	}
+}
+
+func TestVaultProviderRenewToken(t *testing.T) {
+	lookup := `{"data": {"ttl": 3600, "renewable": true}}`
+	renewals := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/auth/token/lookup-self":
+			fmt.Fprint(w, lookup)
+		case "/v1/auth/token/renew-self":
+			renewals++
+			fmt.Fprint(w, `{"auth": {"lease_duration": 7200, "renewable": true}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	v := newVaultProvider(server.URL, "token", time.Minute)
+	if wait, err := v.renewToken(); err != nil || wait != time.Hour || renewals != 1 {
+		t.Errorf("Token not renewed: %s, %v after %d renewals", wait, err, renewals)
+	}
+	// Tokens that don't expire, or can't be renewed, aren't.
+	for _, lookup = range []string{`{"data": {"ttl": 0, "renewable": false}}`, `{"data": {"ttl": 60, "renewable": false}}`} {
+		if wait, err := v.renewToken(); err != nil || wait != 0 || renewals != 1 {
+			t.Errorf("Wrong renewal for %s: %s, %v after %d renewals", lookup, wait, err, renewals)
+		}
+	}
+	v.token = "expired"
+	if _, err := v.renewToken(); err == nil {
+		t.Errorf("Expected an error renewing an expired token")
+	}
+}
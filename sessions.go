@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+// Sessions for scrapes to reuse, closed after being idle for the timeout.
+var sessions = newSessionPool(0)
+
+// How long what's learnt about a target is remembered without a scrape.
+const targetStateTTL = time.Hour
+
+// A sessionPool keeps SNMP sessions open between scrapes, so that scrapes of
+// the same target with the same walk parameters reuse the socket and, for
+// SNMP v3, the engine discovered by the first. A session is only used by
+// one scrape at a time, so concurrent scrapes of a target each have their
+// own. With no idle timeout sessions aren't kept.
+//
+// The SNMP v3 engine of a target is also remembered after a successful
+// scrape, so that new sessions to it skip engine discovery, and the keys
+// localised to the engine don't have to be computed again. It's forgotten
+// once the agent reports that it's out of date, such as when the agent
+// restarted with a new engine, but not on other errors like timeouts.
+// New sessions also start with the max_repetitions previous scrapes
+// learnt the agent can answer, and walks of lookups with a cache_ttl are
+// reused until it expires.
+type sessionPool struct {
+	idleTimeout time.Duration
+	now         func() time.Time
+
+	mtx     sync.Mutex
+	idle    map[string][]*idleSession
+	targets map[string]*targetState
+}
+
+type idleSession struct {
+	snmp     *gosnmp.GoSNMP
+	lastUsed time.Time
+}
+
+// What's been learnt about a target from scrapes of it.
+type targetState struct {
+	// The SNMP v3 engine, if known.
+	usm             *gosnmp.UsmSecurityParameters
+	contextEngineID string
+	// The max_repetitions the agent can answer.
+	maxRepetitions uint8
+	// Walks of lookups, by OID, to reuse until they expire.
+	walks    map[string]cachedWalk
+	lastUsed time.Time
+}
+
+type cachedWalk struct {
+	pdus    []gosnmp.SnmpPDU
+	expires time.Time
+}
+
+func newSessionPool(idleTimeout time.Duration) *sessionPool {
+	return &sessionPool{
+		idleTimeout: idleTimeout,
+		now:         time.Now,
+		idle:        map[string][]*idleSession{},
+		targets:     map[string]*targetState{},
+	}
+}
+
+// Get a session for the target, connecting a new one if there's none idle.
+// It's returned to the pool with put, using the returned key.
+func (p *sessionPool) get(target string, module *config.Module) (*gosnmp.GoSNMP, string, error) {
+	// Sessions are only shared by the same auth and walk parameters.
+	key := sessionKey(target, module.WalkParams)
+	p.expire()
+	p.mtx.Lock()
+	if idle := p.idle[key]; len(idle) > 0 {
+		s := idle[len(idle)-1]
+		p.idle[key] = idle[:len(idle)-1]
+		if len(p.idle[key]) == 0 {
+			delete(p.idle, key)
+		}
+		p.mtx.Unlock()
+		return s.snmp, key, nil
+	}
+	var state *targetState
+	if s, ok := p.targets[key]; ok {
+		state = &targetState{contextEngineID: s.contextEngineID, maxRepetitions: s.maxRepetitions}
+		if s.usm != nil {
+			// The engine's time has moved on since it was cached.
+			state.usm = s.usm.Copy().(*gosnmp.UsmSecurityParameters)
+			state.usm.AuthoritativeEngineTime += uint32(p.now().Sub(s.lastUsed) / time.Second)
+		}
+	}
+	p.mtx.Unlock()
+	snmp, err := newSession(target, module, state)
+	return snmp, key, err
+}
+
+// A key of the target and walk parameters. The parameters are hashed, so
+// that the credentials in them aren't kept in the clear.
+func sessionKey(target string, params config.WalkParams) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%#v", params)))
+	return target + " " + hex.EncodeToString(hash[:])
+}
+
+// Return a session to the pool, with the error of the scrape that used it.
+// Sessions which had an error other than being cut off by the walk budget
+// are closed rather than reused, as the agent may have restarted.
+func (p *sessionPool) put(key string, snmp *gosnmp.GoSNMP, err error) {
+	_, cutOff := err.(walkBudgetError)
+	_, staleEngine := err.(engineReportError)
+	ok := err == nil || cutOff
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	state := p.target(key)
+	state.lastUsed = p.now()
+	state.maxRepetitions = snmp.MaxRepetitions
+	usm, isUsm := snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if snmp.Version == gosnmp.Version3 && isUsm {
+		if ok && usm.AuthoritativeEngineID != "" {
+			state.usm = usm.Copy().(*gosnmp.UsmSecurityParameters)
+			state.contextEngineID = snmp.ContextEngineID
+		} else if staleEngine {
+			state.usm = nil
+			state.contextEngineID = ""
+		}
+	}
+	if !ok || p.idleTimeout <= 0 {
+		snmp.Conn.Close()
+		return
+	}
+	p.idle[key] = append(p.idle[key], &idleSession{snmp: snmp, lastUsed: p.now()})
+}
+
+// What's known about the target with the key, added if there's nothing yet.
+// The lock must be held.
+func (p *sessionPool) target(key string) *targetState {
+	state, ok := p.targets[key]
+	if !ok {
+		state = &targetState{walks: map[string]cachedWalk{}, lastUsed: p.now()}
+		p.targets[key] = state
+	}
+	return state
+}
+
+// The PDUs of a walk of the target with the key, if one was cached with
+// cacheWalk and hasn't expired.
+func (p *sessionPool) cachedWalk(key, oid string) ([]gosnmp.SnmpPDU, bool) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	state, ok := p.targets[key]
+	if !ok {
+		return nil, false
+	}
+	walk, ok := state.walks[oid]
+	if !ok || !p.now().Before(walk.expires) {
+		return nil, false
+	}
+	return walk.pdus, true
+}
+
+// Cache a walk of the target with the key, for the TTL.
+func (p *sessionPool) cacheWalk(key, oid string, pdus []gosnmp.SnmpPDU, ttl time.Duration) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.target(key).walks[oid] = cachedWalk{pdus: pdus, expires: p.now().Add(ttl)}
+}
+
+// Close the sessions which have been idle for longer than the timeout.
+func (p *sessionPool) expire() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for key, idle := range p.idle {
+		kept := idle[:0]
+		for _, s := range idle {
+			if p.now().Sub(s.lastUsed) > p.idleTimeout {
+				log.Debugf("Closing idle SNMP session to %s", s.snmp.Target)
+				s.snmp.Conn.Close()
+				continue
+			}
+			kept = append(kept, s)
+		}
+		if len(kept) == 0 {
+			delete(p.idle, key)
+		} else {
+			p.idle[key] = kept
+		}
+	}
+	for key, s := range p.targets {
+		if p.now().Sub(s.lastUsed) > targetStateTTL {
+			delete(p.targets, key)
+		}
+	}
+}
+
+// Close idle sessions as they time out, even if there are no more scrapes.
+func (p *sessionPool) run() {
+	for range time.Tick(p.idleTimeout) {
+		p.expire()
+	}
+}
+
+// Connect a new session to the target, using what's known about it.
+func newSession(target string, module *config.Module, state *targetState) (*gosnmp.GoSNMP, error) {
+	// Set the options.
+	snmp := &gosnmp.GoSNMP{}
+	snmp.MaxRepetitions = module.WalkParams.MaxRepetitions
+	// User specifies timeout of each retry attempt but GoSNMP expects total timeout for all attemtps,
+	// which is the first attempt plus the retries.
+	snmp.Retries = module.WalkParams.Retries
+	snmp.Timeout = module.WalkParams.Timeout * time.Duration(snmp.Retries+1)
+
+	transport := module.WalkParams.Transport
+	if i := strings.Index(target, "://"); i >= 0 {
+		transport = target[:i]
+		target = target[i+3:]
+	}
+	if transport != "" && transport != "udp" && transport != "tcp" {
+		return nil, fmt.Errorf("Unknown transport %s for target %s", transport, target)
+	}
+
+	snmp.Target = target
+	snmp.Port = 161
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		snmp.Target = host
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("Error converting port number to int for target %s: %s", target, err)
+		}
+		snmp.Port = uint16(p)
+	}
+
+	// Configure auth.
+	module.WalkParams.ConfigureSNMP(snmp)
+	if state != nil && state.maxRepetitions > 0 {
+		snmp.MaxRepetitions = state.maxRepetitions
+	}
+	if state != nil && state.usm != nil && snmp.Version == gosnmp.Version3 {
+		// This is before connecting, so that the session has its own salts.
+		snmp.SecurityParameters = state.usm
+		snmp.ContextEngineID = state.contextEngineID
+	}
+
+	// Over TCP, per RFC 3430, if asked for.
+	snmp.Transport = transport
+	if err := snmp.Connect(); err != nil {
+		return nil, fmt.Errorf("Error connecting to target %s: %s", target, err)
+	}
+	snmp.Conn = &statsConn{Conn: snmp.Conn}
+	if transport != "tcp" && module.WalkParams.RetryBackoff != "" {
+		// Retries are then made by the connection.
+		snmp.Conn = &retryConn{Conn: snmp.Conn, params: module.WalkParams}
+		snmp.Retries = 0
+	}
+	return snmp, nil
+}
+
+// A retryConn sends requests again when they time out, waiting for the
+// retry backoff first, as gosnmp retries at once.
+type retryConn struct {
+	net.Conn
+	params  config.WalkParams
+	request []byte
+	retry   int
+}
+
+func (c *retryConn) Write(b []byte) (int, error) {
+	c.request = append(c.request[:0], b...)
+	c.retry = 0
+	return c.Conn.Write(b)
+}
+
+// The deadline gosnmp sets doesn't allow for the backoff, so Read sets
+// that of each attempt instead.
+func (c *retryConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *retryConn) Read(b []byte) (int, error) {
+	for {
+		c.Conn.SetReadDeadline(time.Now().Add(c.params.Timeout))
+		n, err := c.Conn.Read(b)
+		if e, ok := err.(net.Error); !ok || !e.Timeout() || c.retry >= c.params.Retries {
+			return n, err
+		}
+		time.Sleep(retryDelay(c.params, c.retry))
+		c.retry++
+		log.Debugf("Retrying request, retry %d of %d", c.retry, c.params.Retries)
+		if _, err := c.Conn.Write(c.request); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// How long to wait before a retry, counting from 0.
+func retryDelay(params config.WalkParams, retry int) time.Duration {
+	delay := params.RetryBackoffDelay
+	if delay == 0 {
+		delay = params.Timeout
+	}
+	if params.RetryBackoff == "exponential" {
+		delay <<= uint(retry)
+		// Half of it is random, so that scrapes of an agent spread out.
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+	return delay
+}
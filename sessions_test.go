@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestSessionPool(t *testing.T) {
+	now := time.Unix(0, 0)
+	p := newSessionPool(time.Minute)
+	p.now = func() time.Time { return now }
+
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+	other := &config.Module{WalkParams: config.DefaultWalkParams}
+	other.WalkParams.Auth.Community = "private"
+
+	first, key, err := p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Concurrent scrapes don't share a session.
+	second, _, err := p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("Session used by two scrapes at once")
+	}
+	p.put(key, first, nil)
+	p.put(key, second, errors.New("request timeout"))
+
+	s, _, err := p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != first {
+		t.Errorf("Idle session not reused")
+	}
+	p.put(key, s, nil)
+	s, otherKey, err := p.get("127.0.0.1:1161", other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s == first || otherKey == key {
+		t.Errorf("Session reused for a different auth")
+	}
+	p.put(otherKey, s, nil)
+
+	// Sessions are closed once idle for the timeout.
+	now = now.Add(2 * time.Minute)
+	p.expire()
+	if len(p.idle) != 0 {
+		t.Errorf("Idle sessions not expired: %v", p.idle)
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// The fake agent answers over TCP rather than its UDP socket.
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100})
+	defer a.close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			conn.Write(a.respond(buf[:n]))
+		}
+	}()
+
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+	snmp, err := newSession("tcp://"+listener.Addr().String(), module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snmp.Conn.Close()
+	if snmp.Target != "127.0.0.1" || snmp.Transport != "tcp" {
+		t.Errorf("Wrong target %s or transport %s", snmp.Target, snmp.Transport)
+	}
+	packet, err := snmp.Get([]string{"1.3.6.1.2.1.1.3.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet.Variables) != 1 || packet.Variables[0].Value != 100 {
+		t.Errorf("Wrong response over TCP: %v", packet.Variables)
+	}
+
+	if _, err := newSession("sctp://127.0.0.1", module, nil); err == nil {
+		t.Errorf("Expected an error for an unknown transport")
+	}
+}
+
+func TestSessionKey(t *testing.T) {
+	params := config.DefaultWalkParams
+	params.Auth.Community = "s3cret"
+	key := sessionKey("192.0.2.1", params)
+	if !strings.HasPrefix(key, "192.0.2.1 ") || strings.Contains(key, "s3cret") {
+		t.Errorf("Wrong key: %s", key)
+	}
+	if other := sessionKey("192.0.2.1", params); other != key {
+		t.Errorf("Keys of the same parameters differ: %s, %s", key, other)
+	}
+	params.Auth.Community = "other"
+	if other := sessionKey("192.0.2.1", params); other == key {
+		t.Errorf("Keys of different auths are the same: %s", key)
+	}
+}
+
+func TestSessionPoolEngineCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	p := newSessionPool(0)
+	p.now = func() time.Time { return now }
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+	module.WalkParams.Version = 3
+	module.WalkParams.Auth.Username = "monitor"
+	module.WalkParams.Auth.SecurityLevel = "noAuthNoPriv"
+
+	snmp, key, err := p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usm := snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters); usm.AuthoritativeEngineID != "" {
+		t.Errorf("Engine known before discovery: %+v", usm)
+	}
+	// As discovered by a scrape.
+	usm := snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	usm.AuthoritativeEngineID = "engine"
+	usm.AuthoritativeEngineBoots = 3
+	usm.AuthoritativeEngineTime = 100
+	snmp.ContextEngineID = "engine"
+	p.put(key, snmp, nil)
+
+	now = now.Add(50 * time.Second)
+	snmp, key, err = p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usm = snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters)
+	if usm.AuthoritativeEngineID != "engine" || usm.AuthoritativeEngineBoots != 3 || usm.AuthoritativeEngineTime != 150 || snmp.ContextEngineID != "engine" {
+		t.Errorf("Cached engine not used: %+v", usm)
+	}
+
+	// Kept after an error like a timeout.
+	p.put(key, snmp, errors.New("request timeout"))
+	snmp, key, err = p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usm := snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters); usm.AuthoritativeEngineID != "engine" {
+		t.Errorf("Engine forgotten after a timeout: %+v", usm)
+	}
+
+	// Forgotten once the agent reports it's out of date.
+	p.put(key, snmp, engineReportError{target: "127.0.0.1:1161", oid: usmStatsUnknownEngineIDsOid})
+	snmp, key, err = p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usm := snmp.SecurityParameters.(*gosnmp.UsmSecurityParameters); usm.AuthoritativeEngineID != "" || snmp.ContextEngineID != "" {
+		t.Errorf("Engine not forgotten after an unknown engine report: %+v", usm)
+	}
+	p.put(key, snmp, errors.New("request timeout"))
+}
+
+func TestCheckEngineReport(t *testing.T) {
+	snmp := &gosnmp.GoSNMP{Target: "127.0.0.1"}
+	cases := []struct {
+		packet *gosnmp.SnmpPacket
+		report bool
+	}{
+		{
+			packet: &gosnmp.SnmpPacket{PDUType: gosnmp.GetResponse, Variables: []gosnmp.SnmpPDU{{Name: "1.3.6.1.2.1.1.3.0"}}},
+		},
+		{
+			packet: &gosnmp.SnmpPacket{PDUType: gosnmp.Report, Variables: []gosnmp.SnmpPDU{{Name: usmStatsNotInTimeWindowsOid}}},
+			report: true,
+		},
+		{
+			packet: &gosnmp.SnmpPacket{PDUType: gosnmp.Report, Variables: []gosnmp.SnmpPDU{{Name: usmStatsUnknownEngineIDsOid}}},
+			report: true,
+		},
+		// Unknown user names don't mean the engine changed.
+		{
+			packet: &gosnmp.SnmpPacket{PDUType: gosnmp.Report, Variables: []gosnmp.SnmpPDU{{Name: ".1.3.6.1.6.3.15.1.1.3.0"}}},
+		},
+	}
+	for i, c := range cases {
+		err := checkEngineReport(snmp, c.packet)
+		if _, ok := err.(engineReportError); ok != c.report {
+			t.Errorf("Case %d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestSessionPoolMaxRepetitions(t *testing.T) {
+	p := newSessionPool(0)
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+
+	snmp, key, err := p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snmp.MaxRepetitions != module.WalkParams.MaxRepetitions {
+		t.Errorf("Wrong max repetitions: %d", snmp.MaxRepetitions)
+	}
+	// As reduced by a walk.
+	snmp.MaxRepetitions = 5
+	p.put(key, snmp, errors.New("request timeout"))
+
+	snmp, key, err = p.get("127.0.0.1:1161", module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snmp.MaxRepetitions != 5 {
+		t.Errorf("Learnt max repetitions not used: %d", snmp.MaxRepetitions)
+	}
+	p.put(key, snmp, nil)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100})
+	defer a.close()
+	module := &config.Module{WalkParams: config.DefaultWalkParams}
+	module.WalkParams.Retries = 2
+	module.WalkParams.Timeout = 20 * time.Millisecond
+	module.WalkParams.RetryBackoff = "fixed"
+	module.WalkParams.RetryBackoffDelay = 50 * time.Millisecond
+
+	snmp, err := newSession(a.addr(), module, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snmp.Conn.Close()
+	a.mtx.Lock()
+	a.lose = 2
+	a.mtx.Unlock()
+	start := time.Now()
+	packet, err := snmp.Get([]string{"1.3.6.1.2.1.1.3.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packet.Variables) != 1 || packet.Variables[0].Value != 100 {
+		t.Errorf("Wrong response: %v", packet.Variables)
+	}
+	// Two timeouts, each followed by the backoff.
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("Retried without waiting, after %s", elapsed)
+	}
+	a.mtx.Lock()
+	if a.requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", a.requests)
+	}
+	a.mtx.Unlock()
+
+	// Out of retries.
+	a.mtx.Lock()
+	a.lose = 3
+	a.mtx.Unlock()
+	if _, err := snmp.Get([]string{"1.3.6.1.2.1.1.3.0"}); err == nil {
+		t.Errorf("Expected a timeout")
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	params := config.DefaultWalkParams
+	params.RetryBackoff = "fixed"
+	if d := retryDelay(params, 2); d != params.Timeout {
+		t.Errorf("Fixed delay not the timeout by default: %s", d)
+	}
+	params.RetryBackoff = "exponential"
+	params.RetryBackoffDelay = time.Second
+	for retry, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if d := retryDelay(params, retry); d < max/2 || d > max {
+			t.Errorf("Wrong delay for retry %d: %s", retry, d)
+		}
+	}
+}
+
+func TestSessionPoolWalkCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	p := newSessionPool(0)
+	p.now = func() time.Time { return now }
+	pdus := []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.31.1.1.1.1.1", Type: gosnmp.OctetString, Value: []byte("eth0")}}
+
+	if _, ok := p.cachedWalk("key", "1.3.6.1.2.1.31.1.1.1.1"); ok {
+		t.Errorf("Walk cached before it was walked")
+	}
+	p.cacheWalk("key", "1.3.6.1.2.1.31.1.1.1.1", pdus, time.Minute)
+	now = now.Add(30 * time.Second)
+	if got, ok := p.cachedWalk("key", "1.3.6.1.2.1.31.1.1.1.1"); !ok || len(got) != 1 {
+		t.Errorf("Cached walk not used: %v", got)
+	}
+	if _, ok := p.cachedWalk("other", "1.3.6.1.2.1.31.1.1.1.1"); ok {
+		t.Errorf("Cached walk used for another target")
+	}
+	now = now.Add(time.Minute)
+	if _, ok := p.cachedWalk("key", "1.3.6.1.2.1.31.1.1.1.1"); ok {
+		t.Errorf("Cached walk used after it expired")
+	}
+}
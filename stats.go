@@ -0,0 +1,149 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/soniah/gosnmp"
+)
+
+// Names of the error statuses of responses, from RFC 3416.
+var errorStatusNames = []string{
+	"noError", "tooBig", "noSuchName", "badValue", "readOnly", "genErr",
+	"noAccess", "wrongType", "wrongLength", "wrongEncoding", "wrongValue",
+	"noCreation", "inconsistentValue", "resourceUnavailable", "commitFailed",
+	"undoFailed", "authorizationError", "notWritable", "inconsistentName",
+}
+
+func errorStatusName(status gosnmp.SNMPError) string {
+	if int(status) < len(errorStatusNames) {
+		return errorStatusNames[status]
+	}
+	return strconv.Itoa(int(status))
+}
+
+// What a scrape sent to and received from the target, for debugging why a
+// device is slow to scrape.
+type scrapeStats struct {
+	packetsSent     int
+	packetsReceived int
+	retries         int
+	varbinds        int
+	// How long the walk of each subtree took.
+	walkDurations map[string]time.Duration
+	// How many responses had each error status, other than noError.
+	errorStatuses map[gosnmp.SNMPError]int
+}
+
+func newScrapeStats() *scrapeStats {
+	return &scrapeStats{
+		walkDurations: map[string]time.Duration{},
+		errorStatuses: map[gosnmp.SNMPError]int{},
+	}
+}
+
+// The statsConn of the session, or nil if it has none.
+func sessionStatsConn(snmp *gosnmp.GoSNMP) *statsConn {
+	conn := snmp.Conn
+	if r, ok := conn.(*retryConn); ok {
+		conn = r.Conn
+	}
+	s, _ := conn.(*statsConn)
+	return s
+}
+
+// The stats of the scrape using the session, if it's counting them.
+func sessionStats(snmp *gosnmp.GoSNMP) *scrapeStats {
+	if s := sessionStatsConn(snmp); s != nil && s.stats != nil {
+		return s.stats
+	}
+	// Not counted, such as in tests.
+	return newScrapeStats()
+}
+
+// Count the varbinds and error status of a response.
+func (s *scrapeStats) response(packet *gosnmp.SnmpPacket) {
+	s.varbinds += len(packet.Variables)
+	if packet.Error != gosnmp.NoError {
+		s.errorStatuses[packet.Error]++
+	}
+}
+
+// Add the stats to the exporter's own metrics, for the module.
+func (s *scrapeStats) record(module string) {
+	snmpPacketsSent.WithLabelValues(module).Add(float64(s.packetsSent))
+	snmpPacketsReceived.WithLabelValues(module).Add(float64(s.packetsReceived))
+	snmpRetries.WithLabelValues(module).Add(float64(s.retries))
+	snmpVarbinds.WithLabelValues(module).Add(float64(s.varbinds))
+	for subtree, d := range s.walkDurations {
+		snmpWalkDuration.WithLabelValues(module, subtree).Observe(d.Seconds())
+	}
+	for status, n := range s.errorStatuses {
+		snmpErrorStatuses.WithLabelValues(module, errorStatusName(status)).Add(float64(n))
+	}
+}
+
+// Send the stats as metrics of the scrape.
+func (s *scrapeStats) collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("snmp_scrape_packets_sent", "SNMP packets sent to the target by the scrape, including retries.", nil, nil),
+		prometheus.GaugeValue,
+		float64(s.packetsSent))
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("snmp_scrape_packets_received", "SNMP packets received from the target by the scrape.", nil, nil),
+		prometheus.GaugeValue,
+		float64(s.packetsReceived))
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("snmp_scrape_packets_retried", "SNMP packets sent after the target didn't answer the previous in time, as retries.", nil, nil),
+		prometheus.GaugeValue,
+		float64(s.retries))
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc("snmp_scrape_varbinds_received", "Varbinds in the responses to the scrape.", nil, nil),
+		prometheus.GaugeValue,
+		float64(s.varbinds))
+	for subtree, d := range s.walkDurations {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_subtree_walk_duration_seconds", "How long the walk of each subtree took.", []string{"oid"}, nil),
+			prometheus.GaugeValue,
+			d.Seconds(), subtree)
+	}
+	for status, n := range s.errorStatuses {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_scrape_error_responses", "Responses from the target with an error status.", []string{"status"}, nil),
+			prometheus.GaugeValue,
+			float64(n), errorStatusName(status))
+	}
+}
+
+// A statsConn counts the SNMP messages sent and received over a session's
+// connection, into the stats of the scrape using the session. It's under
+// any retryConn, so that the retries it makes are counted too.
+type statsConn struct {
+	net.Conn
+	stats *scrapeStats
+	// Whether the last read timed out, so that the next write is a retry.
+	timedOut bool
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	if c.stats != nil {
+		c.stats.packetsSent++
+		if c.timedOut {
+			c.stats.retries++
+		}
+	}
+	c.timedOut = false
+	return c.Conn.Write(b)
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		c.timedOut = true
+	} else if err == nil && c.stats != nil {
+		c.stats.packetsReceived++
+	}
+	return n, err
+}
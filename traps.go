@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+const (
+	// The varbind of SNMPv2 traps with the OID of the notification.
+	snmpTrapOid = ".1.3.6.1.6.3.1.1.4.1.0"
+	// The generic traps of SNMPv1 are those under snmpTraps, per RFC 3584.
+	snmpTrapsOid = "1.3.6.1.6.3.1.1.5"
+	// How long the series of a trap are kept without receiving it again.
+	trapSeriesTTL = 24 * time.Hour
+)
+
+// The trap receiver, if traps are being received.
+var traps *trapReceiver
+
+var (
+	trapTotalDesc = prometheus.NewDesc("snmp_trap_total", "Traps received, by source and notification.", []string{"source", "trap_oid", "trap"}, nil)
+	trapLastDesc  = prometheus.NewDesc("snmp_trap_last_received_timestamp_seconds", "When a trap was last received, by source and notification.", []string{"source", "trap_oid", "trap"}, nil)
+)
+
+// A trapReceiver counts the traps received, so that signals only sent as
+// traps can be alerted on. Traps of the notifications described by the
+// modules of the config are decoded, and also counted by their varbinds.
+type trapReceiver struct {
+	now func() time.Time
+	// The most series of the varbinds of each notification, 0 for no limit.
+	maxSeries int
+
+	mtx sync.Mutex
+	// The notifications of all the modules, by OID.
+	notifications map[string]*config.Notification
+	// SNMP v1 and v2c traps with another community are dropped, if set.
+	community string
+	series    map[string]*trapSeries
+}
+
+type trapSeries struct {
+	desc        *prometheus.Desc
+	labelvalues []string
+	count       float64
+	last        time.Time
+	// The notification of series by varbinds, empty for snmp_trap_total.
+	notification string
+}
+
+func newTrapReceiver(maxSeries int) *trapReceiver {
+	return &trapReceiver{
+		now:           time.Now,
+		maxSeries:     maxSeries,
+		notifications: map[string]*config.Notification{},
+		series:        map[string]*trapSeries{},
+	}
+}
+
+// Use the notifications of the config to decode traps, and the community
+// of the named auth, if any.
+func (r *trapReceiver) setConfig(conf *config.Config, authName string) error {
+	notifications := map[string]*config.Notification{}
+	for _, name := range conf.ModuleNames() {
+		module, err := conf.Module(name)
+		if err != nil {
+			return err
+		}
+		for _, n := range module.Notifications {
+			notifications[n.Oid] = n
+		}
+	}
+	community := ""
+	if authName != "" {
+		auth, ok := conf.Auths[authName]
+		if !ok {
+			return fmt.Errorf("unknown auth %q for traps", authName)
+		}
+		module, err := resolveModuleAuth(&config.Module{WalkParams: config.WalkParams{Version: auth.Version, Auth: auth.Auth}})
+		if err != nil {
+			return err
+		}
+		community = string(module.WalkParams.Auth.Community)
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.notifications = notifications
+	r.community = community
+	// The varbinds of notifications may have changed, so their series can't
+	// be continued.
+	for key, s := range r.series {
+		if s.desc != trapTotalDesc {
+			delete(r.series, key)
+		}
+	}
+	return nil
+}
+
+// The OID of the notification of a trap, without a leading period. SNMPv1
+// traps are mapped to it as in RFC 3584.
+func trapOidOf(packet *gosnmp.SnmpPacket) string {
+	if packet.PDUType == gosnmp.Trap {
+		if packet.GenericTrap != 6 {
+			return fmt.Sprintf("%s.%d", snmpTrapsOid, packet.GenericTrap+1)
+		}
+		return fmt.Sprintf("%s.0.%d", strings.TrimPrefix(packet.Enterprise, "."), packet.SpecificTrap)
+	}
+	for _, pdu := range packet.Variables {
+		if pdu.Name == snmpTrapOid {
+			if oid, ok := pdu.Value.(string); ok {
+				return strings.TrimPrefix(oid, ".")
+			}
+		}
+	}
+	return ""
+}
+
+// Count a trap received from the source.
+func (r *trapReceiver) handle(packet *gosnmp.SnmpPacket, source net.IP) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if packet.Version != gosnmp.Version3 && r.community != "" && packet.Community != r.community {
+		log.Debugf("Dropping trap from %s with the wrong community", source)
+		snmpTrapsDropped.WithLabelValues("community").Inc()
+		return
+	}
+	oid := trapOidOf(packet)
+	if oid == "" {
+		log.Debugf("Dropping trap from %s without snmpTrapOID.0", source)
+		snmpTrapsDropped.WithLabelValues("no_trap_oid").Inc()
+		return
+	}
+	now := r.now()
+	notification, ok := r.notifications[oid]
+	name := ""
+	if ok {
+		name = notification.Name
+	}
+	r.add(trapTotalDesc, []string{source.String(), oid, name}, now, "")
+	if ok && hasVarbindLabels(notification) {
+		desc, labelvalues := trapVarbindSeries(notification, packet, source)
+		if !r.add(desc, labelvalues, now, notification.Name) {
+			log.Debugf("Not counting trap %s from %s by its varbinds, as it has %d series", notification.Name, source, r.maxSeries)
+			snmpTrapsDropped.WithLabelValues("max_series").Inc()
+		}
+	}
+	for key, s := range r.series {
+		if now.Sub(s.last) > trapSeriesTTL {
+			delete(r.series, key)
+		}
+	}
+}
+
+// Count a trap in the series with the labels, unless that would be more
+// series of the notification than allowed. The lock must be held.
+func (r *trapReceiver) add(desc *prometheus.Desc, labelvalues []string, now time.Time, notification string) bool {
+	key := desc.String() + "\xff" + strings.Join(labelvalues, "\xff")
+	s, ok := r.series[key]
+	if !ok {
+		if notification != "" && r.maxSeries > 0 {
+			count := 0
+			for _, s := range r.series {
+				if s.notification == notification {
+					count++
+				}
+			}
+			if count >= r.maxSeries {
+				return false
+			}
+		}
+		s = &trapSeries{desc: desc, labelvalues: labelvalues, notification: notification}
+		r.series[key] = s
+	}
+	s.count++
+	s.last = now
+	return true
+}
+
+// Whether traps of the notification are counted by any of its varbinds.
+func hasVarbindLabels(notification *config.Notification) bool {
+	for _, varbind := range notification.Varbinds {
+		if isVarbindLabel(varbind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Only enums, and varbinds configured as labels, have few enough values to
+// be labels.
+func isVarbindLabel(varbind *config.Varbind) bool {
+	return (varbind.Label || len(varbind.EnumValues) > 0) && varbind.Name != "source"
+}
+
+// The series of a decoded trap, labelled with the values of the enums and
+// labels of its notification. Enums are rendered as their names.
+func trapVarbindSeries(notification *config.Notification, packet *gosnmp.SnmpPacket, source net.IP) (*prometheus.Desc, []string) {
+	labelnames := []string{"source"}
+	labelvalues := []string{source.String()}
+	for _, varbind := range notification.Varbinds {
+		if !isVarbindLabel(varbind) {
+			continue
+		}
+		value := ""
+		for _, pdu := range packet.Variables {
+			if pdu.Name != "."+varbind.Oid && !strings.HasPrefix(pdu.Name, "."+varbind.Oid+".") {
+				continue
+			}
+			value = pduValueAsString(&pdu, varbind.Type)
+			if pdu.Type == gosnmp.Integer {
+				if name, ok := varbind.EnumValues[pdu.Value.(int)]; ok {
+					value = name
+				}
+			}
+			break
+		}
+		labelnames = append(labelnames, varbind.Name)
+		labelvalues = append(labelvalues, value)
+	}
+	help := notification.Help
+	if help == "" {
+		help = "Traps received of the " + notification.Name + " notification, by its varbinds."
+	}
+	desc := prometheus.NewDesc("snmp_trap_"+notification.Name+"_total", help, labelnames, nil)
+	return desc, labelvalues
+}
+
+func (r *trapReceiver) Describe(ch chan<- *prometheus.Desc) {
+	ch <- trapTotalDesc
+	ch <- trapLastDesc
+}
+
+func (r *trapReceiver) Collect(ch chan<- prometheus.Metric) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	keys := make([]string, 0, len(r.series))
+	for key := range r.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := r.series[key]
+		ch <- prometheus.MustNewConstMetric(s.desc, prometheus.CounterValue, s.count, s.labelvalues...)
+		if s.desc == trapTotalDesc {
+			ch <- prometheus.MustNewConstMetric(trapLastDesc, prometheus.GaugeValue, float64(s.last.UnixNano())/1e9, s.labelvalues...)
+		}
+	}
+}
+
+// A listener passing traps to the receiver, once it's listening. SNMP v3
+// traps are authenticated and decrypted with the user of the auth, if it
+// has one.
+func newTrapListener(auth *config.NamedAuth, receiver *trapReceiver) (*gosnmp.TrapListener, error) {
+	params := &gosnmp.GoSNMP{}
+	walkParams := config.WalkParams{}
+	if auth != nil {
+		module, err := resolveModuleAuth(&config.Module{WalkParams: config.WalkParams{Version: auth.Version, Auth: auth.Auth}})
+		if err != nil {
+			return nil, err
+		}
+		walkParams = module.WalkParams
+	}
+	walkParams.ConfigureSNMP(params)
+	listener := gosnmp.NewTrapListener()
+	listener.Params = params
+	listener.OnNewTrap = func(packet *gosnmp.SnmpPacket, addr *net.UDPAddr) {
+		receiver.handle(packet, addr.IP)
+	}
+	return listener, nil
+}
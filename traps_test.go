@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_model/go"
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+var trapTestConfig = &config.Config{
+	Auths: map[string]*config.NamedAuth{
+		"traps": {Version: 2, Auth: config.Auth{Community: "secret"}},
+	},
+	Modules: map[string]*config.Module{
+		"if_mib": {
+			Notifications: []*config.Notification{
+				{
+					Name: "linkDown",
+					Oid:  "1.3.6.1.6.3.1.1.5.3",
+					Help: "A link went down.",
+					Varbinds: []*config.Varbind{
+						{Name: "ifIndex", Oid: "1.3.6.1.2.1.2.2.1.1", Type: "gauge", Label: true},
+						{Name: "ifOperStatus", Oid: "1.3.6.1.2.1.2.2.1.8", Type: "gauge", EnumValues: map[int]string{1: "up", 2: "down"}},
+						// Neither an enum nor a label, so not counted by.
+						{Name: "ifDescr", Oid: "1.3.6.1.2.1.2.2.1.2", Type: "DisplayString"},
+					},
+				},
+			},
+		},
+	},
+}
+
+// The metrics of the receiver, as strings, with their values.
+func collectTraps(r *trapReceiver) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	r.Collect(ch)
+	close(ch)
+	got := map[string]float64{}
+	for m := range ch {
+		pb := &io_prometheus_client.Metric{}
+		m.Write(pb)
+		key := m.Desc().String()
+		for _, l := range pb.Label {
+			key += " " + l.GetName() + "=" + l.GetValue()
+		}
+		if pb.Counter != nil {
+			got[key] = pb.Counter.GetValue()
+		} else {
+			got[key] = pb.Gauge.GetValue()
+		}
+	}
+	return got
+}
+
+func TestTrapReceiver(t *testing.T) {
+	now := time.Unix(100, 0)
+	r := newTrapReceiver(0)
+	r.now = func() time.Time { return now }
+	if err := r.setConfig(trapTestConfig, "traps"); err != nil {
+		t.Fatal(err)
+	}
+	source := net.IPv4(192, 0, 2, 1)
+	linkDown := &gosnmp.SnmpPacket{
+		Version:   gosnmp.Version2c,
+		Community: "secret",
+		PDUType:   gosnmp.SNMPv2Trap,
+		Variables: []gosnmp.SnmpPDU{
+			{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(100)},
+			{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.3"},
+			{Name: ".1.3.6.1.2.1.2.2.1.1.2", Type: gosnmp.Integer, Value: 2},
+			{Name: ".1.3.6.1.2.1.2.2.1.8.2", Type: gosnmp.Integer, Value: 2},
+			{Name: ".1.3.6.1.2.1.2.2.1.2.2", Type: gosnmp.OctetString, Value: []byte("eth1")},
+		},
+	}
+	r.handle(linkDown, source)
+	r.handle(linkDown, source)
+	// The same as a v1 trap, without any varbinds.
+	r.handle(&gosnmp.SnmpPacket{
+		Version:     gosnmp.Version1,
+		Community:   "secret",
+		PDUType:     gosnmp.Trap,
+		Enterprise:  ".1.3.6.1.4.1.8072",
+		GenericTrap: 2,
+	}, source)
+	// Enterprise specific v1 traps, which aren't in the config.
+	r.handle(&gosnmp.SnmpPacket{
+		Version:      gosnmp.Version1,
+		Community:    "secret",
+		PDUType:      gosnmp.Trap,
+		Enterprise:   ".1.3.6.1.4.1.8072",
+		GenericTrap:  6,
+		SpecificTrap: 3,
+	}, source)
+	// Dropped.
+	wrongCommunity := *linkDown
+	wrongCommunity.Community = "public"
+	r.handle(&wrongCommunity, source)
+	r.handle(&gosnmp.SnmpPacket{Version: gosnmp.Version2c, Community: "secret", PDUType: gosnmp.SNMPv2Trap}, source)
+
+	total := trapTotalDesc.String() + " source=192.0.2.1"
+	last := trapLastDesc.String() + " source=192.0.2.1"
+	varbinds := prometheus.NewDesc("snmp_trap_linkDown_total", "A link went down.", []string{"source", "ifIndex", "ifOperStatus"}, nil).String()
+	expected := map[string]float64{
+		total + " trap=linkDown trap_oid=1.3.6.1.6.3.1.1.5.3":      3,
+		last + " trap=linkDown trap_oid=1.3.6.1.6.3.1.1.5.3":       100,
+		total + " trap= trap_oid=1.3.6.1.4.1.8072.0.3":             1,
+		last + " trap= trap_oid=1.3.6.1.4.1.8072.0.3":              100,
+		varbinds + " ifIndex=2 ifOperStatus=down source=192.0.2.1": 2,
+		varbinds + " ifIndex= ifOperStatus= source=192.0.2.1":      1,
+	}
+	got := collectTraps(r)
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d metrics, got %d: %v", len(expected), len(got), got)
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("Wrong value for %s, expected %v got %v", k, v, got[k])
+		}
+	}
+
+	// Forgotten once not received for a while.
+	now = now.Add(2 * trapSeriesTTL)
+	r.handle(&gosnmp.SnmpPacket{Version: gosnmp.Version1, Community: "secret", PDUType: gosnmp.Trap}, source)
+	if got := collectTraps(r); len(got) != 2 {
+		t.Errorf("Old traps not forgotten: %v", got)
+	}
+
+	if err := r.setConfig(trapTestConfig, "missing"); err == nil {
+		t.Errorf("Unknown auth accepted")
+	}
+}
+
+func TestTrapReceiverMaxSeries(t *testing.T) {
+	r := newTrapReceiver(2)
+	r.now = func() time.Time { return time.Unix(100, 0) }
+	if err := r.setConfig(trapTestConfig, ""); err != nil {
+		t.Fatal(err)
+	}
+	dropped := func() float64 {
+		m := &io_prometheus_client.Metric{}
+		snmpTrapsDropped.WithLabelValues("max_series").Write(m)
+		return m.Counter.GetValue()
+	}
+	before := dropped()
+	for _, ifIndex := range []int{1, 2, 3, 1} {
+		r.handle(&gosnmp.SnmpPacket{
+			Version: gosnmp.Version2c,
+			PDUType: gosnmp.SNMPv2Trap,
+			Variables: []gosnmp.SnmpPDU{
+				{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.3"},
+				{Name: fmt.Sprintf(".1.3.6.1.2.1.2.2.1.1.%d", ifIndex), Type: gosnmp.Integer, Value: ifIndex},
+			},
+		}, net.IPv4(192, 0, 2, 1))
+	}
+
+	total := trapTotalDesc.String() + " source=192.0.2.1 trap=linkDown trap_oid=1.3.6.1.6.3.1.1.5.3"
+	varbinds := prometheus.NewDesc("snmp_trap_linkDown_total", "A link went down.", []string{"source", "ifIndex", "ifOperStatus"}, nil).String()
+	got := collectTraps(r)
+	// The trap of ifIndex 3 would be a third series.
+	expected := map[string]float64{
+		total: 4,
+		trapLastDesc.String() + " source=192.0.2.1 trap=linkDown trap_oid=1.3.6.1.6.3.1.1.5.3": 100,
+		varbinds + " ifIndex=1 ifOperStatus= source=192.0.2.1":                                 2,
+		varbinds + " ifIndex=2 ifOperStatus= source=192.0.2.1":                                 1,
+	}
+	if len(got) != len(expected) {
+		t.Errorf("Expected %d metrics, got %d: %v", len(expected), len(got), got)
+	}
+	for k, v := range expected {
+		if got[k] != v {
+			t.Errorf("Wrong value for %s, expected %v got %v", k, v, got[k])
+		}
+	}
+	if d := dropped() - before; d != 1 {
+		t.Errorf("Expected 1 trap dropped for max series, got %v", d)
+	}
+}
+
+func TestListenTraps(t *testing.T) {
+	// The address of the listener isn't exposed, so find a free port for it.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().(*net.UDPAddr)
+	conn.Close()
+	r := newTrapReceiver(0)
+	if err := r.setConfig(trapTestConfig, ""); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := newTrapListener(nil, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- listener.Listen(addr.String())
+	}()
+	select {
+	case <-listener.Listening():
+	case err := <-errs:
+		t.Fatalf("Error listening for traps: %s", err)
+	}
+	defer listener.Close()
+
+	sender := &gosnmp.GoSNMP{
+		Target:    addr.IP.String(),
+		Port:      uint16(addr.Port),
+		Version:   gosnmp.Version2c,
+		Community: "public",
+		Timeout:   time.Second,
+	}
+	if err := sender.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Conn.Close()
+	if _, err := sender.SendTrap(gosnmp.SnmpTrap{Variables: []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.6.3.1.1.4.1.0", Type: gosnmp.ObjectIdentifier, Value: ".1.3.6.1.6.3.1.1.5.3"},
+		{Name: ".1.3.6.1.2.1.2.2.1.8.7", Type: gosnmp.Integer, Value: 1},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	// The trap is handled after it's been read.
+	for i := 0; i < 100 && len(collectTraps(r)) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := collectTraps(r)
+	varbinds := prometheus.NewDesc("snmp_trap_linkDown_total", "A link went down.", []string{"source", "ifIndex", "ifOperStatus"}, nil).String()
+	key := varbinds + " ifIndex= ifOperStatus=up source=127.0.0.1"
+	if got[key] == 0 {
+		t.Errorf("Trap not received: %v", got)
+	}
+}
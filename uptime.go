@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/soniah/gosnmp"
+)
+
+// The OID got by modules with detect_reboots.
+const sysUpTimeOid = "1.3.6.1.2.1.1.3.0"
+
+// The uptimes of targets, for modules with detect_reboots.
+var uptimes = newUptimeTracker()
+
+// An uptimeTracker remembers the sysUpTime of each target from its last
+// scrape, so that a reboot can be seen by its uptime going backwards, and
+// counts the reboots seen. This explains counters that reset, without every
+// module walking sysUpTime.
+type uptimeTracker struct {
+	now func() time.Time
+
+	mtx     sync.Mutex
+	targets map[string]*lastUptime
+}
+
+type lastUptime struct {
+	ticks   uint64
+	reboots uint64
+	seen    time.Time
+}
+
+func newUptimeTracker() *uptimeTracker {
+	return &uptimeTracker{
+		now:     time.Now,
+		targets: map[string]*lastUptime{},
+	}
+}
+
+// Record the uptime of the target with the key, in TimeTicks, returning
+// how many times it went backwards since the target was first seen.
+// sysUpTime also wraps after 497 days, which looks the same.
+func (u *uptimeTracker) update(key string, ticks uint64) uint64 {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	now := u.now()
+	for k, t := range u.targets {
+		if now.Sub(t.seen) > targetStateTTL {
+			delete(u.targets, k)
+		}
+	}
+	last, ok := u.targets[key]
+	if !ok {
+		u.targets[key] = &lastUptime{ticks: ticks, seen: now}
+		return 0
+	}
+	if ticks < last.ticks {
+		log.Infof("Target %s rebooted, its uptime went backwards", key)
+		last.reboots++
+	}
+	last.ticks = ticks
+	last.seen = now
+	return last.reboots
+}
+
+// Export the uptime of the target with the key from the sysUpTime.0 in the
+// PDUs, and how many times it rebooted.
+func (u *uptimeTracker) collect(ch chan<- prometheus.Metric, key string, pdus []gosnmp.SnmpPDU) {
+	for _, pdu := range pdus {
+		if pdu.Name[1:] != sysUpTimeOid || pdu.Type != gosnmp.TimeTicks {
+			continue
+		}
+		ticks := gosnmp.ToBigInt(pdu.Value).Uint64()
+		reboots := u.update(key, ticks)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_device_uptime_seconds", "How long the device has been up, from sysUpTime.", nil, nil),
+			prometheus.GaugeValue,
+			float64(ticks)/100)
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc("snmp_device_reboots_total", "Times the uptime of the device went backwards between scrapes.", nil, nil),
+			prometheus.CounterValue,
+			float64(reboots))
+		return
+	}
+	log.Debugf("No sysUpTime from target %s to detect reboots with", key)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_model/go"
+	"github.com/soniah/gosnmp"
+
+	"github.com/prometheus/snmp_exporter/config"
+)
+
+func TestUptimeTracker(t *testing.T) {
+	now := time.Unix(0, 0)
+	u := newUptimeTracker()
+	u.now = func() time.Time { return now }
+	collect := func(ticks uint32) map[string]float64 {
+		ch := make(chan prometheus.Metric, 10)
+		u.collect(ch, "target module", []gosnmp.SnmpPDU{{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: ticks}})
+		close(ch)
+		got := map[string]float64{}
+		for m := range ch {
+			pb := &io_prometheus_client.Metric{}
+			m.Write(pb)
+			got[m.Desc().String()] = pb.GetGauge().GetValue() + pb.GetCounter().GetValue()
+		}
+		return got
+	}
+	uptime := prometheus.NewDesc("snmp_device_uptime_seconds", "How long the device has been up, from sysUpTime.", nil, nil).String()
+	reboots := prometheus.NewDesc("snmp_device_reboots_total", "Times the uptime of the device went backwards between scrapes.", nil, nil).String()
+
+	got := collect(100000)
+	if got[uptime] != 1000 || got[reboots] != 0 {
+		t.Errorf("Wrong metrics of the first scrape: %v", got)
+	}
+	got = collect(106000)
+	if got[uptime] != 1060 || got[reboots] != 0 {
+		t.Errorf("Wrong metrics after a minute: %v", got)
+	}
+	got = collect(500)
+	if got[uptime] != 5 || got[reboots] != 1 {
+		t.Errorf("Reboot not detected: %v", got)
+	}
+	// The count stays, rather than dropping back once the next scrape is in.
+	got = collect(6500)
+	if got[reboots] != 1 {
+		t.Errorf("Reboot not kept: %v", got)
+	}
+	got = collect(200)
+	if got[reboots] != 2 {
+		t.Errorf("Second reboot not counted: %v", got)
+	}
+
+	// Forgotten once not scraped for a while.
+	now = now.Add(2 * targetStateTTL)
+	if u.update("other", 100) != 0 || len(u.targets) != 1 {
+		t.Errorf("Uptime of target not forgotten: %v", u.targets)
+	}
+}
+
+func TestDetectReboots(t *testing.T) {
+	a := newFakeAgent(t, map[string]int{"1.3.6.1.2.1.1.3.0": 100, "1.3.6.1.2.1.2.2.1.1.1": 1})
+	defer a.close()
+	module := &config.Module{
+		Walk:          []string{"1.3.6.1.2.1.2"},
+		WalkParams:    config.DefaultWalkParams,
+		DetectReboots: true,
+	}
+	pdus, err := ScrapeTarget(a.addr(), module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdus) != 2 || pdus[1].Name != ".1.3.6.1.2.1.1.3.0" {
+		t.Errorf("sysUpTime not got: %v", pdus)
+	}
+	// Not got twice when the module already gets it.
+	module.Get = []string{"1.3.6.1.2.1.1.3.0"}
+	pdus, err = ScrapeTarget(a.addr(), module)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pdus) != 2 {
+		t.Errorf("Wrong PDUs: %v", pdus)
+	}
+}